@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/maintenance"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/ory/viper"
+	"github.com/spf13/pflag"
+)
+
+// runDelete removes traces given by ID or found by a search, e.g. for data protection requests
+func runDelete(ctx context.Context, logger hclog.Logger, args []string) error {
+	flags := pflag.NewFlagSet("delete", pflag.ContinueOnError)
+	configPath := flags.String("config", "", "A path to the dynamodb plugin's configuration file")
+	tenant := flags.String("tenant", "", "Tenant to delete traces of when tenancy is enabled")
+	traceIDs := flags.StringSlice("trace-id", nil, "IDs of the traces to delete, instead of searching them")
+	service := flags.String("service", "", "Service to search traces of")
+	operation := flags.String("operation", "", "Operation to search traces of")
+	tags := flags.StringToString("tag", nil, "Tags to search traces by as key=value")
+	start := flags.String("start", "", "Start of the search time range in RFC 3339 format, required for searches")
+	end := flags.String("end", "", "End of the search time range in RFC 3339 format, defaults to now")
+	auditLogPath := flags.String("audit-log", "-", "File the removed items are appended to as JSON lines, - writes to stdout")
+	reason := flags.String("reason", "", "Reason recorded in the audit log, e.g. a ticket reference")
+	dryRun := flags.Bool("dry-run", false, "Only record what would be removed")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if len(*traceIDs) == 0 && *service == "" {
+		return errors.New("either --trace-id or --service is required")
+	}
+	// A search without a start would remove all traces of a service by accident
+	if len(*traceIDs) == 0 && *start == "" {
+		return errors.New("--start is required when searching traces")
+	}
+
+	configuration, err := readConfiguration(viper.New(), *configPath)
+	if err != nil {
+		return err
+	}
+	svc, err := newDynamoDBClient(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		return err
+	}
+	if *tenant != "" {
+		ctx = tenancy.WithTenant(ctx, *tenant)
+	}
+
+	var auditLog io.Writer = os.Stdout
+	if *auditLogPath != "-" {
+		f, err := os.OpenFile(*auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log, %v", err)
+		}
+		defer f.Close()
+		auditLog = f
+	}
+
+	deleter := maintenance.NewDeleter(logger, svc, &maintenance.DeleteOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+		Tenancy:         tenancyManager,
+		AuditLog:        auditLog,
+		Reason:          *reason,
+		DryRun:          *dryRun,
+	})
+
+	report := &maintenance.DeleteReport{}
+	if len(*traceIDs) > 0 {
+		for _, id := range *traceIDs {
+			traceID, err := model.TraceIDFromString(id)
+			if err != nil {
+				return fmt.Errorf("invalid trace ID %q, %v", id, err)
+			}
+
+			traceReport, err := deleter.DeleteTrace(ctx, traceID)
+			if err != nil {
+				return fmt.Errorf("failed to delete trace %s, %v", id, err)
+			}
+			report.Traces += traceReport.Traces
+			report.Spans += traceReport.Spans
+			report.Operations += traceReport.Operations
+			report.Services += traceReport.Services
+		}
+	} else {
+		query := &spanstore.TraceQueryParameters{
+			ServiceName:   *service,
+			OperationName: *operation,
+			Tags:          *tags,
+			StartTimeMax:  time.Now(),
+		}
+		if query.StartTimeMin, err = time.Parse(time.RFC3339, *start); err != nil {
+			return fmt.Errorf("invalid start, %v", err)
+		}
+		if *end != "" {
+			if query.StartTimeMax, err = time.Parse(time.RFC3339, *end); err != nil {
+				return fmt.Errorf("invalid end, %v", err)
+			}
+		}
+
+		if report, err = deleter.DeleteTraces(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	action := "Deleted"
+	if *dryRun {
+		action = "Would delete"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d traces with %d spans, %d operations and %d services\n",
+		action, report.Traces, report.Spans, report.Operations, report.Services)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/dependencystream"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/awsconfig"
+	"github.com/ory/viper"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/pflag"
+	"github.com/uber/jaeger-lib/metrics"
+	jlprom "github.com/uber/jaeger-lib/metrics/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+// runDependencies reads the stream of the spans table and aggregates the dependencies like the dependency lambda,
+// until it's interrupted
+func runDependencies(ctx context.Context, logger hclog.Logger, args []string) error {
+	flags := pflag.NewFlagSet("dependencies", pflag.ContinueOnError)
+	configPath := flags.String("config", "", "A path to the dynamodb plugin's configuration file")
+	tenant := flags.String("tenant", "", "Tenant to read the stream of in the table mode, defaults to all configured tenants")
+	checkpointTable := flags.String("checkpoint-table", "", "Table the progress of every shard is stored in, defaults to the dependencies table")
+	startPosition := flags.String("start-position", dependencystream.StartPositionLatest, "Position shards without checkpoint are read from, either latest or trim-horizon")
+	pollInterval := flags.Duration("poll-interval", time.Second, "Interval between reads of shards without new records")
+	shardRefreshInterval := flags.Duration("shard-refresh-interval", 30*time.Second, "Interval between the discovery of new shards")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	configuration, err := readConfiguration(viper.New(), *configPath)
+	if err != nil {
+		return err
+	}
+	svc, err := newDynamoDBClient(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	streams, err := awsconfig.NewDynamoDBStreamsClient(ctx, &configuration.DynamoDB)
+	if err != nil {
+		return err
+	}
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		return err
+	}
+
+	tenants, err := selectTenants(tenancyManager, *tenant)
+	if err != nil {
+		return err
+	}
+
+	metricsFactory := jlprom.New().Namespace(metrics.NSOptions{Name: "jaeger_dynamodb"})
+	if configuration.Admin.HTTPAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			if err := http.ListenAndServe(configuration.Admin.HTTPAddress, mux); err != nil {
+				logger.Error("unable to serve admin http", "err", err)
+			}
+		}()
+	}
+
+	consumers := make([]*dependencystream.Consumer, len(tenants))
+	for i, t := range tenants {
+		consumers[i], err = dependencystream.NewConsumer(logger, svc, streams, metricsFactory, &dependencystream.ConsumerOptions{
+			SpansTable:           tenancyManager.Table(t, spansTable),
+			DependenciesTable:    tenancyManager.Table(t, dependenciesTable),
+			CheckpointTable:      *checkpointTable,
+			BucketSize:           configuration.Dependencies.BucketSize,
+			StartPosition:        *startPosition,
+			PollInterval:         *pollInterval,
+			ShardRefreshInterval: *shardRefreshInterval,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create consumer, %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	g, ctx := errgroup.WithContext(ctx)
+	for _, consumer := range consumers {
+		consumer := consumer
+		g.Go(func() error {
+			return consumer.Run(ctx)
+		})
+	}
+	return g.Wait()
+}
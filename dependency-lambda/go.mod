@@ -1,35 +1,35 @@
 module github.com/johanneswuerbach/jaeger-dynamodb/dependency-lambda
 
-go 1.17
+go 1.18
 
 require (
-	github.com/aws/aws-lambda-go v1.27.0
-	github.com/aws/aws-sdk-go-v2/config v1.10.2
+	github.com/aws/aws-lambda-go v1.38.0
+	github.com/aws/aws-sdk-go-v2 v1.11.1
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.8.1
 	github.com/johanneswuerbach/jaeger-dynamodb v0.0.10
 	github.com/prozz/aws-embedded-metrics-golang v1.2.0
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.7.2
 )
 
 require (
 	github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression v1.3.2 // indirect
 	github.com/fatih/color v1.13.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
-	github.com/hashicorp/go-hclog v1.0.0 // indirect
-	github.com/jaegertracing/jaeger v1.28.0 // indirect
-	github.com/mattn/go-colorable v0.1.11 // indirect
+	github.com/hashicorp/go-hclog v1.2.0
+	github.com/jaegertracing/jaeger v1.34.0 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
-	github.com/uber/jaeger-client-go v2.29.1+incompatible // indirect
+	github.com/uber/jaeger-client-go v2.30.0+incompatible // indirect
 	github.com/uber/jaeger-lib v2.4.1+incompatible // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect
-	go.uber.org/zap v1.19.1 // indirect
-	golang.org/x/sys v0.0.0-20211117180635-dee7805ff2e1 // indirect
+	go.uber.org/zap v1.21.0 // indirect
+	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
 )
 
 require (
-	github.com/aws/aws-sdk-go-v2 v1.11.1 // indirect
+	github.com/aws/aws-sdk-go-v2/config v1.10.2 // indirect
 	github.com/aws/aws-sdk-go-v2/credentials v1.6.2 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.4.2 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.8.1 // indirect
@@ -44,10 +44,15 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/sts v1.10.1 // indirect
 	github.com/aws/smithy-go v1.9.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.opentelemetry.io/otel v1.7.0 // indirect
+	go.opentelemetry.io/otel/trace v1.7.0 // indirect
+	google.golang.org/grpc v1.46.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
-replace github.com/johanneswuerbach/jaeger-dynamodb v0.0.0 => ../
+replace github.com/johanneswuerbach/jaeger-dynamodb => ../
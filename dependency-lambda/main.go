@@ -4,152 +4,182 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
+	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
 	"github.com/prozz/aws-embedded-metrics-golang/emf"
 
-	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/dependencystream"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/awsconfig"
+	pConfig "github.com/johanneswuerbach/jaeger-dynamodb/plugin/config"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/health"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
 )
 
-type SpanItemReference struct {
-	TraceID string
-	SpanID  string
-}
+var svc *dynamodb.Client
 
-func (s *SpanItemReference) Key() string {
-	return fmt.Sprintf("%s/%s", s.TraceID, s.SpanID)
-}
+// Read from the environment on cold starts, the bucket size has to match the plugin reading the dependencies
+var configuration = pConfig.NewDependencyLambdaConfiguration()
 
-// Subset of the full type
-type SpanItem struct {
-	TraceID     string
-	SpanID      string
-	References  []*SpanItemReference
-	ServiceName string
-}
+type DynamoDBAPI = dependencystream.DynamoDBAPI
 
-func (s *SpanItem) Key() string {
-	return fmt.Sprintf("%s/%s", s.TraceID, s.SpanID)
+// toRecords converts the records of the event, records which can't be converted are skipped when writing
+func toRecords(e events.DynamoDBEvent) []dependencystream.Record {
+	records := make([]dependencystream.Record, len(e.Records))
+	for i, record := range e.Records {
+		records[i] = dependencystream.Record{
+			EventID:                     record.EventID,
+			SequenceNumber:              record.Change.SequenceNumber,
+			ApproximateCreationDateTime: record.Change.ApproximateCreationDateTime.Time,
+		}
+		records[i].NewImage, records[i].Err = toImage(record.Change.NewImage)
+	}
+	return records
 }
 
-type DependencyCallCounts map[string]map[string]uint64
-
-var svc *dynamodb.Client
-
-const (
-	tableName = "jaeger.dependencies" // TODO: Move to an environment variable
-)
-
-func init() {
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+func toImage(image map[string]events.DynamoDBAttributeValue) (map[string]types.AttributeValue, error) {
+	newImage := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		attributeValue, err := toAttributeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert attribute %s, %v", name, err)
+		}
+		newImage[name] = attributeValue
 	}
-	svc = dynamodb.NewFromConfig(cfg)
+	return newImage, nil
 }
 
-type DynamoDBAPI interface {
-	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+// dependenciesTable returns the dependencies table belonging to the spans table whose stream emitted the event. In the
+// table tenancy mode the function consumes the stream of every tenant, whose tables are named "<tenant>.<table>"
+func dependenciesTable(e events.DynamoDBEvent) string {
+	if len(e.Records) == 0 {
+		return configuration.DependenciesTable
+	}
+	// All records of an event belong to the same stream, e.g.
+	// arn:aws:dynamodb:eu-west-1:123456789012:table/acme.jaeger.spans/stream/2021-11-06T15:24:04.849
+	parts := strings.Split(e.Records[0].EventSourceArn, "/")
+	if len(parts) < 2 || parts[1] == configuration.SpansTable {
+		return configuration.DependenciesTable
+	}
+	tablePrefix, table := tenancy.SplitTable(parts[1])
+	if table != configuration.SpansTable {
+		return configuration.DependenciesTable
+	}
+	return tablePrefix + configuration.DependenciesTable
 }
 
-func calculateDependencyCallsInBatch(ctx context.Context, e events.DynamoDBEvent, m *emf.Logger) (*dynamodependencystore.DependencyCallCounts, error) {
-	idsToService := map[string]string{}
-	// Build a map of all (trace id, span id) ~> service name in the batch
-
-	totalRecords := len(e.Records)
-	fmt.Println("Received records", totalRecords)
-	m.Metric("totalRecords", totalRecords)
-
-	spans := make([]*SpanItem, totalRecords)
-	for i, record := range e.Records {
-
-		element := record.Change.NewImage
-		elementReferences := element["References"].List()
-		references := make([]*SpanItemReference, len(elementReferences))
-		for i, elementReference := range elementReferences {
-			ref := elementReference.Map()
-			references[i] = &SpanItemReference{
-				TraceID: ref["TraceID"].String(),
-				SpanID:  ref["SpanID"].String(),
+func toAttributeValue(value events.DynamoDBAttributeValue) (types.AttributeValue, error) {
+	switch value.DataType() {
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: value.Binary()}, nil
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: value.BinarySet()}, nil
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: value.Boolean()}, nil
+	case events.DataTypeNull:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: value.Number()}, nil
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: value.NumberSet()}, nil
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: value.String()}, nil
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: value.StringSet()}, nil
+	case events.DataTypeList:
+		list := value.List()
+		values := make([]types.AttributeValue, len(list))
+		for i, element := range list {
+			attributeValue, err := toAttributeValue(element)
+			if err != nil {
+				return nil, err
 			}
+			values[i] = attributeValue
 		}
-
-		spanItem := &SpanItem{
-			TraceID:     element["TraceID"].String(),
-			SpanID:      element["SpanID"].String(),
-			References:  references,
-			ServiceName: element["ServiceName"].String(),
-		}
-
-		spans[i] = spanItem
-		idsToService[spanItem.Key()] = spanItem.ServiceName
-	}
-
-	// Resolve all dependencies, lookup missing dependencies, ignore not found errors
-	includedSpans := 0
-	fetchedSpans := 0
-	dependencyCallCounts := dynamodependencystore.NewDependencyCallCounts()
-	for _, span := range spans {
-		for _, reference := range span.References {
-			val, ok := idsToService[reference.Key()]
-			if ok {
-				includedSpans += 1
-				dependencyCallCounts.CountRequest(val, span.ServiceName, 1)
-			} else {
-				fetchedSpans += 1
-				// TODO: Fetch span
+		return &types.AttributeValueMemberL{Value: values}, nil
+	case events.DataTypeMap:
+		values := map[string]types.AttributeValue{}
+		for name, element := range value.Map() {
+			attributeValue, err := toAttributeValue(element)
+			if err != nil {
+				return nil, err
 			}
+			values[name] = attributeValue
 		}
+		return &types.AttributeValueMemberM{Value: values}, nil
 	}
-
-	m.Metric("includedSpans", includedSpans)
-	m.Metric("fetchedSpans", fetchedSpans)
-
-	return dependencyCallCounts, nil
+	return nil, fmt.Errorf("unsupported data type %v", value.DataType())
 }
 
-func updateDependencyCalls(ctx context.Context, e events.DynamoDBEvent, m *emf.Logger, svc DynamoDBAPI) error {
-	dependencyCallCounts, err := calculateDependencyCallsInBatch(ctx, e, m)
+// updateDependencyCalls writes the dependency calls of the batch and reports the first record that wasn't written,
+// Lambda retries the batch starting from that record
+func updateDependencyCalls(ctx context.Context, e events.DynamoDBEvent, m *emf.Logger, svc DynamoDBAPI) events.DynamoDBEventResponse {
+	m.Metric("totalRecords", len(e.Records))
+
+	writer := dependencystream.NewWriter(svc, dependenciesTable(e), configuration.Dependencies.BucketSize)
+	result, err := writer.WriteRecords(ctx, toRecords(e))
+	m.Metric("includedSpans", result.IncludedSpans)
+	m.Metric("fetchedSpans", result.FetchedSpans)
+	m.Metric("skippedRecords", result.SkippedRecords)
+	m.Metric("droppedSpans", result.DroppedSpans)
+	m.Metric("invalidRecords", len(result.InvalidRecords))
+	for _, invalidErr := range result.InvalidRecords {
+		fmt.Printf("skipping invalid record, %s\n", invalidErr)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to calculate dependency call count: %w", err)
+		fmt.Printf("failed to write dependency calls, %s\n", err)
+		m.Metric("failedRecords", len(e.Records)-result.Written)
+		return batchItemFailures(e.Records, result.Written)
 	}
 
-	// Write results to current hour
-	for parent, children := range dependencyCallCounts.CallCounts {
-		for child, callCount := range children {
-			if err := dynamodependencystore.WriteDependencyItem(ctx, svc, tableName, &dynamodependencystore.DependencyItem{
-				Key:            fmt.Sprintf("%s/%s", parent, child),
-				Parent:         parent,
-				Child:          child,
-				CallCount:      callCount,
-				CallTimeBucket: dynamodependencystore.TimeToBucket(time.Now()),
-			}); err != nil {
-				return fmt.Errorf("failed to write dependency item: %w", err)
-			}
-		}
-	}
+	return events.DynamoDBEventResponse{}
+}
 
-	return nil
+func batchItemFailures(records []events.DynamoDBEventRecord, failed int) events.DynamoDBEventResponse {
+	if failed >= len(records) {
+		return events.DynamoDBEventResponse{}
+	}
+	return events.DynamoDBEventResponse{
+		BatchItemFailures: []events.DynamoDBBatchItemFailure{{ItemIdentifier: records[failed].Change.SequenceNumber}},
+	}
 }
 
-func handleRequest(ctx context.Context, e events.DynamoDBEvent) error {
+func handleRequest(ctx context.Context, e events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
 	m := emf.New()
 	defer m.Log()
 
-	if err := updateDependencyCalls(ctx, e, m, svc); err != nil {
-		fmt.Printf("error occured during lambda process, %s", err)
-		panic(err)
+	return updateDependencyCalls(ctx, e, m, svc), nil
+}
+
+// setup runs once per cold start, tests use their own client
+func setup() {
+	ctx := context.Background()
+	var err error
+	configuration, err = pConfig.LoadDependencyLambdaConfiguration(os.LookupEnv)
+	if err != nil {
+		log.Fatalf("unable to load configuration, %v", err)
+	}
+	svc, err = awsconfig.NewDynamoDBClient(ctx, &configuration.DynamoDB)
+	if err != nil {
+		log.Fatalf("unable to create DynamoDB client, %v", err)
 	}
 
-	return nil
+	// Lambda functions can't serve readiness checks, so problems with the tables are logged on every cold start
+	logger := hclog.New(&hclog.LoggerOptions{Name: "jaeger-dynamodb-dependencies", JSONFormat: true})
+	health.NewChecker(logger, svc, &health.Options{Tables: []health.Table{
+		{Name: configuration.SpansTable, Stream: true},
+		{Name: configuration.DependenciesTable},
+	}}).Check(ctx)
 }
 
 func main() {
+	setup()
+
 	// Make the handler available for Remote Procedure Call by AWS Lambda
 	lambda.Start(handleRequest)
 }
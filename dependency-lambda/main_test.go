@@ -3,30 +3,198 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/johanneswuerbach/jaeger-dynamodb/dependencystream"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
 	"github.com/prozz/aws-embedded-metrics-golang/emf"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestCalculateDependencyCallsInBatch(t *testing.T) {
-	assert := assert.New(t)
-
-	ctx := context.Background()
-
+func readEventFixture(assert *assert.Assertions) *events.DynamoDBEvent {
 	event := &events.DynamoDBEvent{}
 	fixture, err := ioutil.ReadFile("./fixtures/event.json")
 	assert.NoError(err)
 	assert.NoError(json.Unmarshal(fixture, event))
-	m := emf.New()
+	return event
+}
 
-	dependencyCallCounts, err := calculateDependencyCallsInBatch(ctx, *event, m)
+func countDependencyCalls(assert *assert.Assertions, event *events.DynamoDBEvent, bucketSize time.Duration) dependencystream.CallCounts {
+	spans, err := dependencystream.ParseSpans(toRecords(*event))
 	assert.NoError(err)
-	assert.Equal(dependencyCallCounts.CallCounts, map[string]map[string]uint64{
+	callCounts, _ := dependencystream.CountDependencyCalls(spans, bucketSize)
+	return callCounts
+}
+
+func TestCountDependencyCalls(t *testing.T) {
+	assert := assert.New(t)
+
+	event := readEventFixture(assert)
+
+	tenantDependencyCallCounts := countDependencyCalls(assert, event, time.Hour)
+	assert.Len(tenantDependencyCallCounts, 1)
+	// The spans started at 2021-11-07T13:29:54Z
+	bucket := dependencystream.Bucket{CallTimeBucket: 1636290000}
+	assert.Equal(tenantDependencyCallCounts[bucket].CallCounts, map[string]map[string]uint64{
 		"thanos-query": {
 			"thanos-sidecar": 1,
 		},
 	})
+
+	// The sidecar span took 35µs
+	statistics := &dynamodependencystore.CallStatistics{}
+	statistics.AddCall(35*time.Microsecond, false)
+	assert.Equal(map[dynamodependencystore.ServiceDependency]*dynamodependencystore.CallStatistics{
+		{Parent: "thanos-query", Child: "thanos-sidecar"}: statistics,
+	}, tenantDependencyCallCounts[bucket].Services)
+	assert.Equal(map[dynamodependencystore.OperationDependency]*dynamodependencystore.CallStatistics{
+		{Parent: "thanos-query", ParentOperation: "/thanos.Store/Info", Child: "thanos-sidecar", ChildOperation: "/thanos.Store/Info"}: statistics,
+	}, tenantDependencyCallCounts[bucket].Operations)
+}
+
+func TestCountDependencyCallsBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	event := readEventFixture(assert)
+	tenantDependencyCallCounts := countDependencyCalls(assert, event, time.Minute)
+	assert.Contains(tenantDependencyCallCounts, dependencystream.Bucket{CallTimeBucket: 1636291740})
+
+	// A replayed call is counted in the bucket it started in
+	event.Records[0].Change.NewImage["StartTime"] = events.NewNumberAttribute("1636200000000000000")
+	tenantDependencyCallCounts = countDependencyCalls(assert, event, time.Hour)
+	assert.Len(tenantDependencyCallCounts, 1)
+	assert.Contains(tenantDependencyCallCounts, dependencystream.Bucket{CallTimeBucket: 1636200000})
+
+	// Without a start time, the time of the change is used
+	delete(event.Records[0].Change.NewImage, "StartTime")
+	event.Records[0].Change.ApproximateCreationDateTime = events.SecondsEpochTime{Time: time.Unix(1636300800, 0)}
+	tenantDependencyCallCounts = countDependencyCalls(assert, event, time.Hour)
+	assert.Contains(tenantDependencyCallCounts, dependencystream.Bucket{CallTimeBucket: 1636300800})
+}
+
+func newDependenciesTable(assert *assert.Assertions, ctx context.Context) *dynamodbfake.Client {
+	svc := dynamodbfake.New(nil)
+	_, err := svc.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(configuration.DependenciesTable),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("Key"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("CallTimeBucket"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("Key"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("CallTimeBucket"), KeyType: types.KeyTypeRange},
+		},
+	})
+	assert.NoError(err)
+	return svc
+}
+
+func getCallCount(assert *assert.Assertions, ctx context.Context, svc DynamoDBAPI) string {
+	output, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(configuration.DependenciesTable),
+		Key: map[string]types.AttributeValue{
+			"Key":            &types.AttributeValueMemberS{Value: "thanos-query/thanos-sidecar"},
+			"CallTimeBucket": &types.AttributeValueMemberN{Value: "1636290000"},
+		},
+	})
+	assert.NoError(err)
+	if output.Item == nil {
+		return ""
+	}
+	return output.Item["CallCount"].(*types.AttributeValueMemberN).Value
+}
+
+type failingTransactions struct {
+	*dynamodbfake.Client
+}
+
+func (f *failingTransactions) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return nil, errors.New("throttled")
+}
+
+func TestUpdateDependencyCallsRetries(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	event := readEventFixture(assert)
+
+	// Failed writes are retried starting from the first record not written
+	response := updateDependencyCalls(ctx, *event, emf.New(), &failingTransactions{svc})
+	assert.Equal([]events.DynamoDBBatchItemFailure{{ItemIdentifier: "763396300000000015646703203"}}, response.BatchItemFailures)
+	assert.Equal("", getCallCount(assert, ctx, svc))
+
+	response = updateDependencyCalls(ctx, *event, emf.New(), svc)
+	assert.Empty(response.BatchItemFailures)
+	assert.Equal("1", getCallCount(assert, ctx, svc))
+
+	// Records already written aren't counted again
+	response = updateDependencyCalls(ctx, *event, emf.New(), svc)
+	assert.Empty(response.BatchItemFailures)
+	assert.Equal("1", getCallCount(assert, ctx, svc))
+
+	// Retries can contain more records than the failed batch
+	record := event.Records[0]
+	record.EventID = "e3d0c85d54d4ee1ecd9b5b38a7bbd5a0"
+	record.Change.SequenceNumber = "763396700000000015646703600"
+	record.Change.NewImage = map[string]events.DynamoDBAttributeValue{}
+	for key, value := range event.Records[0].Change.NewImage {
+		record.Change.NewImage[key] = value
+	}
+	record.Change.NewImage["SpanID"] = events.NewStringAttribute("7c2d0b8f5e1a4b36")
+	event.Records = append(event.Records, record)
+
+	response = updateDependencyCalls(ctx, *event, emf.New(), svc)
+	assert.Empty(response.BatchItemFailures)
+	assert.Equal("2", getCallCount(assert, ctx, svc))
+}
+
+func TestUpdateDependencyCallsSkipsInvalidRecords(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	event := readEventFixture(assert)
+
+	// Records which can't be parsed fail on every retry, so they are skipped
+	record := event.Records[0]
+	record.EventID = "0b6b1f3c9a1e4f5d8c2a7e6d5f4c3b2a"
+	record.Change.SequenceNumber = "763396200000000015646703100"
+	record.Change.NewImage = map[string]events.DynamoDBAttributeValue{}
+	for key, value := range event.Records[0].Change.NewImage {
+		record.Change.NewImage[key] = value
+	}
+	record.Change.NewImage["Duration"] = events.NewStringAttribute("35ms")
+	event.Records = append([]events.DynamoDBEventRecord{record}, event.Records...)
+
+	// Retries skip it again
+	response := updateDependencyCalls(ctx, *event, emf.New(), &failingTransactions{svc})
+	assert.Equal([]events.DynamoDBBatchItemFailure{{ItemIdentifier: "763396200000000015646703100"}}, response.BatchItemFailures)
+
+	response = updateDependencyCalls(ctx, *event, emf.New(), svc)
+	assert.Empty(response.BatchItemFailures)
+	assert.Equal("1", getCallCount(assert, ctx, svc))
+}
+
+func TestDependenciesTable(t *testing.T) {
+	assert := assert.New(t)
+
+	event := readEventFixture(assert)
+	assert.Equal("jaeger.dependencies", dependenciesTable(*event))
+
+	// Streams of the tenant tables are written to the dependencies table of the tenant
+	for i := range event.Records {
+		event.Records[i].EventSourceArn = "arn:aws:dynamodb:eu-west-1:XYZ:table/acme.jaeger.spans/stream/2021-11-06T15:24:04.849"
+	}
+	assert.Equal("acme.jaeger.dependencies", dependenciesTable(*event))
+
+	assert.Equal("jaeger.dependencies", dependenciesTable(events.DynamoDBEvent{}))
 }
@@ -0,0 +1,527 @@
+package dependencystream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamstypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+const (
+	StartPositionLatest      = "latest"
+	StartPositionTrimHorizon = "trim-horizon"
+
+	shardCheckpointKeyPrefix    = "shard|"
+	defaultPollInterval         = time.Second
+	defaultShardRefreshInterval = 30 * time.Second
+	defaultRetryInterval        = 5 * time.Second
+	// Maximum of GetRecords
+	defaultRecordsLimit = 1000
+)
+
+// ConsumerDynamoDBAPI is the subset of the DynamoDB API used by the consumer
+type ConsumerDynamoDBAPI interface {
+	DynamoDBAPI
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBStreamsAPI is the subset of the DynamoDB Streams API used by the consumer
+type DynamoDBStreamsAPI interface {
+	DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+type ConsumerOptions struct {
+	SpansTable        string
+	DependenciesTable string
+	// Table the progress of every shard is stored in, defaults to the dependencies table
+	CheckpointTable string
+	BucketSize      time.Duration
+	// Position shards without checkpoints are read from, either StartPositionLatest (default) or
+	// StartPositionTrimHorizon. Children of read shards are always read from their start.
+	StartPosition string
+	// Interval between reads of shards without new records, defaults to a second
+	PollInterval time.Duration
+	// Interval between the discovery of new shards, defaults to 30 seconds
+	ShardRefreshInterval time.Duration
+	// Interval between retries of failed reads and writes, defaults to 5 seconds
+	RetryInterval time.Duration
+}
+
+type consumerMetrics struct {
+	Records        metrics.Counter `metric:"records_read"`
+	SkippedRecords metrics.Counter `metric:"records_skipped"`
+	InvalidRecords metrics.Counter `metric:"records_invalid"`
+	IncludedSpans  metrics.Counter `metric:"spans_included"`
+	FetchedSpans   metrics.Counter `metric:"spans_unresolved"`
+	DroppedSpans   metrics.Counter `metric:"spans_dropped"`
+	Errors         metrics.Counter `metric:"errors"`
+	ActiveShards   metrics.Gauge   `metric:"shards_active"`
+}
+
+// ShardCheckpointItem stores the last written record of a shard
+type ShardCheckpointItem struct {
+	Key            string
+	CallTimeBucket int64
+	SequenceNumber string
+	// Set once all records of the closed shard were written
+	Finished bool
+	// TTL attribute of the dependencies table
+	ExpireTime int64
+}
+
+// Consumer reads the stream of the spans table and adds the dependency calls of its records, like the dependency lambda.
+// Shards are read in parallel, children after their parents, and the progress of every shard is checkpointed, so a
+// restarted consumer continues where it stopped. Only one consumer should read a stream at a time.
+type Consumer struct {
+	logger  hclog.Logger
+	svc     ConsumerDynamoDBAPI
+	streams DynamoDBStreamsAPI
+	writer  *Writer
+	options ConsumerOptions
+	metrics *consumerMetrics
+
+	mu sync.Mutex
+	// Shards being read
+	running map[string]bool
+	// Shards finished by ID, true when the shard was read and false when it was skipped
+	finished map[string]bool
+}
+
+func NewConsumer(logger hclog.Logger, svc ConsumerDynamoDBAPI, streams DynamoDBStreamsAPI, metricsFactory metrics.Factory, options *ConsumerOptions) (*Consumer, error) {
+	consumerOptions := *options
+	if consumerOptions.SpansTable == "" || consumerOptions.DependenciesTable == "" {
+		return nil, errors.New("spans and dependencies tables are required")
+	}
+	if consumerOptions.CheckpointTable == "" {
+		consumerOptions.CheckpointTable = consumerOptions.DependenciesTable
+	}
+	switch consumerOptions.StartPosition {
+	case "":
+		consumerOptions.StartPosition = StartPositionLatest
+	case StartPositionLatest, StartPositionTrimHorizon:
+	default:
+		return nil, fmt.Errorf("unknown start position %s, expected %s or %s", consumerOptions.StartPosition, StartPositionLatest, StartPositionTrimHorizon)
+	}
+	if consumerOptions.PollInterval == 0 {
+		consumerOptions.PollInterval = defaultPollInterval
+	}
+	if consumerOptions.ShardRefreshInterval == 0 {
+		consumerOptions.ShardRefreshInterval = defaultShardRefreshInterval
+	}
+	if consumerOptions.RetryInterval == 0 {
+		consumerOptions.RetryInterval = defaultRetryInterval
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	consumerMetrics := &consumerMetrics{}
+	if err := metrics.Init(consumerMetrics, metricsFactory.Namespace(metrics.NSOptions{Name: "dependency_stream"}), nil); err != nil {
+		return nil, fmt.Errorf("failed to init metrics, %v", err)
+	}
+
+	return &Consumer{
+		logger:   logger,
+		svc:      svc,
+		streams:  streams,
+		writer:   NewWriter(svc, consumerOptions.DependenciesTable, consumerOptions.BucketSize),
+		options:  consumerOptions,
+		metrics:  consumerMetrics,
+		running:  map[string]bool{},
+		finished: map[string]bool{},
+	}, nil
+}
+
+// Run reads the stream until the context is canceled
+func (c *Consumer) Run(ctx context.Context) error {
+	streamArn, err := c.streamArn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Finished shards trigger a refresh, so their children are read without delay
+	refresh := make(chan struct{}, 1)
+	ticker := time.NewTicker(c.options.ShardRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.startShards(ctx, &wg, streamArn, refresh); err != nil {
+			c.metrics.Errors.Inc(1)
+			c.logger.Warn("failed to refresh shards", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-refresh:
+		}
+	}
+}
+
+func (c *Consumer) streamArn(ctx context.Context) (string, error) {
+	output, err := c.svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(c.options.SpansTable)})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe table %s, %v", c.options.SpansTable, err)
+	}
+	if output.Table.LatestStreamArn == nil {
+		return "", fmt.Errorf("table %s has no stream", c.options.SpansTable)
+	}
+	return aws.ToString(output.Table.LatestStreamArn), nil
+}
+
+func (c *Consumer) describeShards(ctx context.Context, streamArn string) ([]streamstypes.Shard, error) {
+	shards := []streamstypes.Shard{}
+	var exclusiveStartShardID *string
+	for {
+		output, err := c.streams.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(streamArn),
+			ExclusiveStartShardId: exclusiveStartShardID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe stream, %v", err)
+		}
+		shards = append(shards, output.StreamDescription.Shards...)
+
+		exclusiveStartShardID = output.StreamDescription.LastEvaluatedShardId
+		if exclusiveStartShardID == nil {
+			return shards, nil
+		}
+	}
+}
+
+// startShards starts reading every shard whose parent is finished or was removed from the stream
+func (c *Consumer) startShards(ctx context.Context, wg *sync.WaitGroup, streamArn string, refresh chan struct{}) error {
+	shards, err := c.describeShards(ctx, streamArn)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		listed[aws.ToString(shard.ShardId)] = true
+	}
+
+	// Shards are listed in order, so parents are handled before their children
+	for _, shard := range shards {
+		shardID := aws.ToString(shard.ShardId)
+		parentID := aws.ToString(shard.ParentShardId)
+
+		c.mu.Lock()
+		_, finished := c.finished[shardID]
+		running := c.running[shardID]
+		parentRead, parentFinished := c.finished[parentID]
+		c.mu.Unlock()
+		if finished || running || (listed[parentID] && !parentFinished) {
+			continue
+		}
+
+		checkpoint, err := c.getShardCheckpoint(ctx, shardID)
+		if err != nil {
+			return err
+		}
+
+		iterator := &dynamodbstreams.GetShardIteratorInput{
+			StreamArn: aws.String(streamArn),
+			ShardId:   shard.ShardId,
+		}
+		switch {
+		case checkpoint != nil && checkpoint.Finished:
+			c.finish(shardID, true)
+			continue
+		case checkpoint != nil:
+			iterator.ShardIteratorType = streamstypes.ShardIteratorTypeAfterSequenceNumber
+			iterator.SequenceNumber = aws.String(checkpoint.SequenceNumber)
+		case parentRead || c.options.StartPosition == StartPositionTrimHorizon:
+			iterator.ShardIteratorType = streamstypes.ShardIteratorTypeTrimHorizon
+		case shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil:
+			// Closed shards only contain records from before the start
+			c.finish(shardID, false)
+			continue
+		default:
+			iterator.ShardIteratorType = streamstypes.ShardIteratorTypeLatest
+		}
+
+		c.mu.Lock()
+		c.running[shardID] = true
+		c.metrics.ActiveShards.Update(int64(len(c.running)))
+		c.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.readShard(ctx, iterator) {
+				c.finish(aws.ToString(iterator.ShardId), true)
+				select {
+				case refresh <- struct{}{}:
+				default:
+				}
+			}
+
+			c.mu.Lock()
+			delete(c.running, aws.ToString(iterator.ShardId))
+			c.metrics.ActiveShards.Update(int64(len(c.running)))
+			c.mu.Unlock()
+		}()
+	}
+	return nil
+}
+
+func (c *Consumer) finish(shardID string, read bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished[shardID] = read
+}
+
+// readShard reads the shard until it's closed and all records are written, it returns false when the context was
+// canceled before
+func (c *Consumer) readShard(ctx context.Context, start *dynamodbstreams.GetShardIteratorInput) bool {
+	shardID := aws.ToString(start.ShardId)
+	logger := c.logger.With("shard", shardID)
+
+	var iterator *string
+	for iterator == nil {
+		var err error
+		iterator, err = c.getShardIterator(ctx, start)
+		if err == nil {
+			break
+		}
+		c.metrics.Errors.Inc(1)
+		logger.Warn("failed to get shard iterator", "err", err)
+		if !sleep(ctx, c.options.RetryInterval) {
+			return false
+		}
+	}
+
+	for {
+		output, err := c.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+			Limit:         aws.Int32(defaultRecordsLimit),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			var expired *streamstypes.ExpiredIteratorException
+			var trimmed *streamstypes.TrimmedDataAccessException
+			switch {
+			case errors.As(err, &expired):
+				// Iterators expire after 15 minutes, continue from the last position
+				logger.Debug("shard iterator expired")
+			case errors.As(err, &trimmed):
+				// The records after the last position were removed from the stream after 24 hours
+				logger.Warn("records were removed from the stream before they were read, continuing with the oldest record")
+				c.metrics.Errors.Inc(1)
+				start = &dynamodbstreams.GetShardIteratorInput{
+					StreamArn:         start.StreamArn,
+					ShardId:           start.ShardId,
+					ShardIteratorType: streamstypes.ShardIteratorTypeTrimHorizon,
+				}
+			default:
+				c.metrics.Errors.Inc(1)
+				logger.Warn("failed to get records", "err", err)
+				if !sleep(ctx, c.options.RetryInterval) {
+					return false
+				}
+			}
+
+			next, err := c.getShardIterator(ctx, start)
+			if err != nil {
+				c.metrics.Errors.Inc(1)
+				logger.Warn("failed to get shard iterator", "err", err)
+				if !sleep(ctx, c.options.RetryInterval) {
+					return false
+				}
+				continue
+			}
+			iterator = next
+			continue
+		}
+
+		if records := convertRecords(output.Records); len(records) > 0 {
+			c.metrics.Records.Inc(int64(len(records)))
+			written, ok := c.writeRecords(ctx, logger, shardID, records)
+			if !ok {
+				return false
+			}
+			if written < len(records) {
+				// Continue with the first record which wasn't written
+				start = &dynamodbstreams.GetShardIteratorInput{
+					StreamArn:         start.StreamArn,
+					ShardId:           start.ShardId,
+					ShardIteratorType: streamstypes.ShardIteratorTypeAtSequenceNumber,
+					SequenceNumber:    aws.String(records[written].SequenceNumber),
+				}
+				if !sleep(ctx, c.options.RetryInterval) {
+					return false
+				}
+				next, err := c.getShardIterator(ctx, start)
+				if err != nil {
+					c.metrics.Errors.Inc(1)
+					logger.Warn("failed to get shard iterator", "err", err)
+					continue
+				}
+				iterator = next
+				continue
+			}
+			start = &dynamodbstreams.GetShardIteratorInput{
+				StreamArn:         start.StreamArn,
+				ShardId:           start.ShardId,
+				ShardIteratorType: streamstypes.ShardIteratorTypeAfterSequenceNumber,
+				SequenceNumber:    aws.String(records[len(records)-1].SequenceNumber),
+			}
+		}
+
+		// Closed shards return no further iterator once all records were read
+		if output.NextShardIterator == nil {
+			for {
+				err := c.putShardCheckpoint(ctx, &ShardCheckpointItem{Key: c.shardCheckpointKey(shardID), Finished: true})
+				if err == nil {
+					return true
+				}
+				c.metrics.Errors.Inc(1)
+				logger.Warn("failed to checkpoint finished shard", "err", err)
+				if !sleep(ctx, c.options.RetryInterval) {
+					return false
+				}
+			}
+		}
+		iterator = output.NextShardIterator
+
+		if len(output.Records) == 0 && !sleep(ctx, c.options.PollInterval) {
+			return false
+		}
+	}
+}
+
+// writeRecords writes the records and checkpoints the last written one, it returns the number of written records and
+// false when the context was canceled
+func (c *Consumer) writeRecords(ctx context.Context, logger hclog.Logger, shardID string, records []Record) (int, bool) {
+	result, writeErr := c.writer.WriteRecords(ctx, records)
+	c.metrics.SkippedRecords.Inc(int64(result.SkippedRecords))
+	c.metrics.IncludedSpans.Inc(int64(result.IncludedSpans))
+	c.metrics.FetchedSpans.Inc(int64(result.FetchedSpans))
+	c.metrics.DroppedSpans.Inc(int64(result.DroppedSpans))
+	c.metrics.InvalidRecords.Inc(int64(len(result.InvalidRecords)))
+	for _, err := range result.InvalidRecords {
+		logger.Error("skipping invalid record", "err", err)
+	}
+	if writeErr != nil {
+		if ctx.Err() != nil {
+			return 0, false
+		}
+		c.metrics.Errors.Inc(1)
+		logger.Warn("failed to write dependency calls, retrying", "err", writeErr, "written", result.Written, "records", len(records))
+	}
+	if result.Written == 0 {
+		return 0, true
+	}
+
+	// Records written without checkpoint are skipped by the checkpoints of their chunks
+	if err := c.putShardCheckpoint(ctx, &ShardCheckpointItem{
+		Key:            c.shardCheckpointKey(shardID),
+		SequenceNumber: records[result.Written-1].SequenceNumber,
+	}); err != nil {
+		if ctx.Err() != nil {
+			return 0, false
+		}
+		c.metrics.Errors.Inc(1)
+		logger.Warn("failed to checkpoint shard", "err", err)
+	}
+	return result.Written, true
+}
+
+func (c *Consumer) getShardIterator(ctx context.Context, input *dynamodbstreams.GetShardIteratorInput) (*string, error) {
+	output, err := c.streams.GetShardIterator(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shard iterator, %v", err)
+	}
+	return output.ShardIterator, nil
+}
+
+// shardCheckpointKey includes the table, so the checkpoints of several tables can share a table
+func (c *Consumer) shardCheckpointKey(shardID string) string {
+	return fmt.Sprintf("%s%s|%s", shardCheckpointKeyPrefix, c.options.SpansTable, shardID)
+}
+
+func (c *Consumer) getShardCheckpoint(ctx context.Context, shardID string) (*ShardCheckpointItem, error) {
+	output, err := c.svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(c.options.CheckpointTable),
+		Key: map[string]types.AttributeValue{
+			"Key":            &types.AttributeValueMemberS{Value: c.shardCheckpointKey(shardID)},
+			"CallTimeBucket": &types.AttributeValueMemberN{Value: "0"},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shard checkpoint, %v", err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	checkpoint := &ShardCheckpointItem{}
+	if err := attributevalue.UnmarshalMap(output.Item, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal shard checkpoint, %v", err)
+	}
+	return checkpoint, nil
+}
+
+// putShardCheckpoint stores the checkpoint, which expires after the records of the shard were removed from the stream
+func (c *Consumer) putShardCheckpoint(ctx context.Context, checkpoint *ShardCheckpointItem) error {
+	checkpoint.ExpireTime = time.Now().Add(checkpointTimeToLive).Unix()
+	item, err := attributevalue.MarshalMap(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shard checkpoint, %v", err)
+	}
+	if _, err := c.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.options.CheckpointTable),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("failed to put shard checkpoint, %v", err)
+	}
+	return nil
+}
+
+// convertRecords converts the stream records, records without new image like removed spans have no calls
+func convertRecords(streamRecords []streamstypes.Record) []Record {
+	records := make([]Record, len(streamRecords))
+	for i, streamRecord := range streamRecords {
+		record := Record{EventID: aws.ToString(streamRecord.EventID)}
+		if streamRecord.Dynamodb != nil {
+			record.SequenceNumber = aws.ToString(streamRecord.Dynamodb.SequenceNumber)
+			record.ApproximateCreationDateTime = aws.ToTime(streamRecord.Dynamodb.ApproximateCreationDateTime)
+			record.NewImage, record.Err = attributevalue.FromDynamoDBStreamsMap(streamRecord.Dynamodb.NewImage)
+		}
+		records[i] = record
+	}
+	return records
+}
+
+// sleep returns false when the context was canceled before the duration passed
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,376 @@
+package dependencystream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamstypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/assert"
+)
+
+const spansTable = "jaeger.spans"
+
+type fakeShard struct {
+	id       string
+	parentID string
+	records  []streamstypes.Record
+	closed   bool
+}
+
+// fakeStreams is an in-memory stream, iterators are the shard ID and the index of the next record
+type fakeStreams struct {
+	mu             sync.Mutex
+	shards         []*fakeShard
+	sequenceNumber int
+}
+
+func (f *fakeStreams) shard(id string) *fakeShard {
+	for _, shard := range f.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+func (f *fakeStreams) addShard(id string, parentID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shards = append(f.shards, &fakeShard{id: id, parentID: parentID})
+}
+
+func (f *fakeStreams) closeShard(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shard(id).closed = true
+}
+
+func (f *fakeStreams) addSpan(assert *assert.Assertions, shardID string, span *model.Span) {
+	image, err := attributevalue.MarshalMap(dynamospanstore.NewSpanItemFromSpan(span))
+	assert.NoError(err)
+	f.addImage(shardID, image)
+}
+
+func (f *fakeStreams) addImage(shardID string, image map[string]types.AttributeValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sequenceNumber++
+	shard := f.shard(shardID)
+	shard.records = append(shard.records, streamstypes.Record{
+		EventID:   aws.String(fmt.Sprintf("event-%d", f.sequenceNumber)),
+		EventName: streamstypes.OperationTypeInsert,
+		Dynamodb: &streamstypes.StreamRecord{
+			ApproximateCreationDateTime: aws.Time(time.Now()),
+			NewImage:                    toStreamsMap(image),
+			SequenceNumber:              aws.String(fmt.Sprintf("%021d", f.sequenceNumber)),
+		},
+	})
+}
+
+func (f *fakeStreams) DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Pages of a single shard
+	start := 0
+	if params.ExclusiveStartShardId != nil {
+		for i, shard := range f.shards {
+			if shard.id == aws.ToString(params.ExclusiveStartShardId) {
+				start = i + 1
+			}
+		}
+	}
+	description := &streamstypes.StreamDescription{StreamArn: params.StreamArn}
+	if start < len(f.shards) {
+		shard := f.shards[start]
+		sequenceNumberRange := &streamstypes.SequenceNumberRange{}
+		if shard.closed {
+			sequenceNumberRange.EndingSequenceNumber = aws.String("0")
+		}
+		description.Shards = []streamstypes.Shard{{
+			ShardId:             aws.String(shard.id),
+			SequenceNumberRange: sequenceNumberRange,
+		}}
+		if shard.parentID != "" {
+			description.Shards[0].ParentShardId = aws.String(shard.parentID)
+		}
+		if start < len(f.shards)-1 {
+			description.LastEvaluatedShardId = aws.String(shard.id)
+		}
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: description}, nil
+}
+
+func (f *fakeStreams) GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	shard := f.shard(aws.ToString(params.ShardId))
+	position := 0
+	switch params.ShardIteratorType {
+	case streamstypes.ShardIteratorTypeLatest:
+		position = len(shard.records)
+	case streamstypes.ShardIteratorTypeAtSequenceNumber, streamstypes.ShardIteratorTypeAfterSequenceNumber:
+		for i, record := range shard.records {
+			if aws.ToString(record.Dynamodb.SequenceNumber) == aws.ToString(params.SequenceNumber) {
+				position = i
+			}
+		}
+		if params.ShardIteratorType == streamstypes.ShardIteratorTypeAfterSequenceNumber {
+			position++
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%s|%d", shard.id, position))}, nil
+}
+
+func (f *fakeStreams) GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(aws.ToString(params.ShardIterator), "|")
+	shard := f.shard(parts[0])
+	position, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodbstreams.GetRecordsOutput{Records: shard.records[position:]}
+	if !shard.closed || position < len(shard.records) {
+		output.NextShardIterator = aws.String(fmt.Sprintf("%s|%d", shard.id, len(shard.records)))
+	}
+	return output, nil
+}
+
+func toStreamsMap(image map[string]types.AttributeValue) map[string]streamstypes.AttributeValue {
+	values := make(map[string]streamstypes.AttributeValue, len(image))
+	for name, value := range image {
+		values[name] = toStreamsAttributeValue(value)
+	}
+	return values
+}
+
+func toStreamsAttributeValue(value types.AttributeValue) streamstypes.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberB:
+		return &streamstypes.AttributeValueMemberB{Value: v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &streamstypes.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &streamstypes.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &streamstypes.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberS:
+		return &streamstypes.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &streamstypes.AttributeValueMemberSS{Value: v.Value}
+	case *types.AttributeValueMemberL:
+		values := make([]streamstypes.AttributeValue, len(v.Value))
+		for i, element := range v.Value {
+			values[i] = toStreamsAttributeValue(element)
+		}
+		return &streamstypes.AttributeValueMemberL{Value: values}
+	case *types.AttributeValueMemberM:
+		return &streamstypes.AttributeValueMemberM{Value: toStreamsMap(v.Value)}
+	}
+	panic(fmt.Sprintf("unsupported attribute value %T", value))
+}
+
+// newSpansTable recreates the spans table with a stream, only its key and stream are needed
+func newSpansTable(assert *assert.Assertions, ctx context.Context, svc setup.DynamoDBAPI) {
+	// The table doesn't exist on the first run
+	_, _ = svc.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(spansTable)})
+	_, err := svc.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(spansTable),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("TraceID"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("SpanID"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("TraceID"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("SpanID"), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: types.StreamViewTypeNewImage,
+		},
+	})
+	assert.NoError(err)
+}
+
+func runConsumer(assert *assert.Assertions, svc ConsumerDynamoDBAPI, streams DynamoDBStreamsAPI, startPosition string) func() {
+	logger := hclog.New(&hclog.LoggerOptions{Level: hclog.Warn, Name: "jaeger-dynamodb"})
+	consumer, err := NewConsumer(logger, svc, streams, nil, &ConsumerOptions{
+		SpansTable:           spansTable,
+		DependenciesTable:    dependenciesTable,
+		BucketSize:           time.Hour,
+		StartPosition:        startPosition,
+		PollInterval:         10 * time.Millisecond,
+		ShardRefreshInterval: 50 * time.Millisecond,
+		RetryInterval:        10 * time.Millisecond,
+	})
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(consumer.Run(ctx))
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	newSpansTable(assert, ctx, svc)
+
+	streams := &fakeStreams{}
+	streams.addShard("shard-1", "")
+	streams.addSpan(assert, "shard-1", newSpan(1, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-1", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan"))
+	streams.closeShard("shard-1")
+	streams.addShard("shard-2", "shard-1")
+	streams.addSpan(assert, "shard-2", newSpan(2, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-2", newSpan(2, 2, 1, "dynamodb-plugin", "WriteSpan"))
+
+	stop := runConsumer(assert, svc, streams, StartPositionTrimHorizon)
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "2" }, 5*time.Second, 10*time.Millisecond)
+	stop()
+
+	// Restarted consumers continue after the checkpoints of the shards
+	streams.addSpan(assert, "shard-2", newSpan(3, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-2", newSpan(3, 2, 1, "dynamodb-plugin", "WriteSpan"))
+	streams.closeShard("shard-2")
+	streams.addShard("shard-3", "shard-2")
+
+	stop = runConsumer(assert, svc, streams, StartPositionTrimHorizon)
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "3" }, 5*time.Second, 10*time.Millisecond)
+
+	// Children of read shards are read from their start
+	streams.addSpan(assert, "shard-3", newSpan(4, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-3", newSpan(4, 2, 1, "dynamodb-plugin", "WriteSpan"))
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "4" }, 5*time.Second, 10*time.Millisecond)
+	stop()
+	assert.Equal("4", getCallCount(assert, ctx, svc))
+}
+
+func TestConsumerInvalidRecords(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	newSpansTable(assert, ctx, svc)
+
+	streams := &fakeStreams{}
+	streams.addShard("shard-1", "")
+	streams.addSpan(assert, "shard-1", newSpan(1, 1, 0, "jaeger", "Export"))
+	// Records which can't be parsed are skipped instead of blocking the shard
+	streams.addImage("shard-1", map[string]types.AttributeValue{
+		"TraceID":  &types.AttributeValueMemberS{Value: "1"},
+		"SpanID":   &types.AttributeValueMemberS{Value: "3"},
+		"Duration": &types.AttributeValueMemberS{Value: "35ms"},
+	})
+	streams.addSpan(assert, "shard-1", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan"))
+
+	stop := runConsumer(assert, svc, streams, StartPositionTrimHorizon)
+	defer stop()
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "1" }, 5*time.Second, 10*time.Millisecond)
+
+	// Records after the invalid one are read
+	streams.addSpan(assert, "shard-1", newSpan(2, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-1", newSpan(2, 2, 1, "dynamodb-plugin", "WriteSpan"))
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "2" }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConsumerStartPositionLatest(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	newSpansTable(assert, ctx, svc)
+
+	streams := &fakeStreams{}
+	streams.addShard("shard-1", "")
+	streams.addSpan(assert, "shard-1", newSpan(1, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-1", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan"))
+	streams.closeShard("shard-1")
+	streams.addShard("shard-2", "shard-1")
+	streams.addSpan(assert, "shard-2", newSpan(2, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-2", newSpan(2, 2, 1, "dynamodb-plugin", "WriteSpan"))
+
+	stop := runConsumer(assert, svc, streams, StartPositionLatest)
+	defer stop()
+
+	// Only records added after the start are read
+	time.Sleep(100 * time.Millisecond)
+	streams.addSpan(assert, "shard-2", newSpan(3, 1, 0, "jaeger", "Export"))
+	streams.addSpan(assert, "shard-2", newSpan(3, 2, 1, "dynamodb-plugin", "WriteSpan"))
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "1" }, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestConsumerDynamoDBLocal reads the stream of DynamoDB Local at DYNAMODB_URL
+func TestConsumerDynamoDBLocal(t *testing.T) {
+	dynamodbURL := os.Getenv("DYNAMODB_URL")
+	if dynamodbURL == "" {
+		t.Skip("DYNAMODB_URL isn't set")
+	}
+
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
+		lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
+		lo.Region = "us-east-1"
+		lo.EndpointResolver = aws.EndpointResolverFunc(
+			func(service, region string) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
+			})
+		return nil
+	})
+	assert.NoError(err)
+	svc := dynamodb.NewFromConfig(cfg)
+	streams := dynamodbstreams.NewFromConfig(cfg)
+
+	assert.NoError(setup.PollUntilReady(ctx, svc))
+	assert.NoError(setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
+		DependenciesTable: dependenciesTable,
+	}))
+	newSpansTable(assert, ctx, svc)
+
+	for _, span := range []*model.Span{
+		newSpan(1, 1, 0, "jaeger", "Export"),
+		newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan"),
+	} {
+		item, err := attributevalue.MarshalMap(dynamospanstore.NewSpanItemFromSpan(span))
+		assert.NoError(err)
+		_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(spansTable), Item: item})
+		assert.NoError(err)
+	}
+
+	stop := runConsumer(assert, svc, streams, StartPositionTrimHorizon)
+	defer stop()
+	assert.Eventually(func() bool { return getCallCount(assert, ctx, svc) == "1" }, 30*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,244 @@
+// Package dependencystream counts the calls between services in the records of the spans table stream. It's shared
+// by the dependency lambda and the dependencies command, which reads the stream directly.
+package dependencystream
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+)
+
+// Record is a change of the spans table, records of a shard are ordered by their sequence numbers
+type Record struct {
+	EventID                     string
+	SequenceNumber              string
+	ApproximateCreationDateTime time.Time
+	NewImage                    map[string]types.AttributeValue
+	// Set when the record couldn't be converted from the stream, the record is skipped when writing
+	Err error
+}
+
+type SpanReference struct {
+	TraceID string
+	SpanID  string
+}
+
+func (s *SpanReference) Key() string {
+	return fmt.Sprintf("%s/%s", s.TraceID, s.SpanID)
+}
+
+// Span is the subset of a span item needed to count its calls
+type Span struct {
+	TraceID       string
+	SpanID        string
+	References    []*SpanReference
+	ServiceName   string
+	OperationName string
+	StartTime     time.Time
+	Duration      time.Duration
+	// Error tags stay searchable when the span is encrypted
+	Error bool
+}
+
+func (s *Span) Key() string {
+	return fmt.Sprintf("%s/%s", s.TraceID, s.SpanID)
+}
+
+type spanImage struct {
+	TraceID        string
+	SpanID         string
+	References     []*SpanReference
+	ServiceName    string
+	OperationName  string
+	StartTime      int64
+	Duration       int64
+	SearchableTags map[string]string
+}
+
+// ParseSpans returns the span of every record
+func ParseSpans(records []Record) ([]*Span, error) {
+	spans := make([]*Span, len(records))
+	for i, record := range records {
+		span, err := ParseRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		spans[i] = span
+	}
+	return spans, nil
+}
+
+// ParseRecord returns the span of the record
+func ParseRecord(record Record) (*Span, error) {
+	if record.Err != nil {
+		return nil, fmt.Errorf("failed to convert record %s, %v", record.EventID, record.Err)
+	}
+	span, err := ParseSpanItem(record.NewImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse span of record %s, %v", record.EventID, err)
+	}
+	// Spans written before the start time was available fall back to the time of the change
+	if span.StartTime.IsZero() {
+		span.StartTime = record.ApproximateCreationDateTime
+	}
+	return span, nil
+}
+
+// ParseSpanItem returns the span of an item of the spans table, the start time is zero when the item has none
+func ParseSpanItem(item map[string]types.AttributeValue) (*Span, error) {
+	image := &spanImage{}
+	if err := attributevalue.UnmarshalMap(item, image); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal span, %v", err)
+	}
+
+	span := &Span{
+		TraceID:       image.TraceID,
+		SpanID:        image.SpanID,
+		References:    image.References,
+		ServiceName:   image.ServiceName,
+		OperationName: image.OperationName,
+		Duration:      time.Duration(image.Duration),
+		Error:         isError(image.SearchableTags),
+	}
+	if image.StartTime != 0 {
+		span.StartTime = time.Unix(0, image.StartTime)
+	}
+	return span, nil
+}
+
+// isError follows the error tag of Jaeger and the status of OpenTelemetry spans
+func isError(tags map[string]string) bool {
+	for key, value := range tags {
+		if dynamodependencystore.IsErrorTag(key, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bucket identifies the dependencies of a tenant in a time bucket
+type Bucket struct {
+	KeyPrefix      string
+	CallTimeBucket int64
+}
+
+// CallCounts are the dependency call counts per tenant key prefix and bucket of the span start time, so delayed or
+// replayed records are counted in the bucket of the call
+type CallCounts map[Bucket]*dynamodependencystore.DependencyCallCounts
+
+// CountStatistics reports how many references of the spans were resolved
+type CountStatistics struct {
+	// References to parents in the same batch
+	IncludedSpans int
+	// References to parents outside of the batch, which aren't counted
+	FetchedSpans int
+}
+
+func (c *CountStatistics) add(other CountStatistics) {
+	c.IncludedSpans += other.IncludedSpans
+	c.FetchedSpans += other.FetchedSpans
+}
+
+// CountDependencyCalls counts the calls of the parents in the batch to the spans
+func CountDependencyCalls(spans []*Span, bucketSize time.Duration) (CallCounts, CountStatistics) {
+	idsToSpan := spansByKey(spans)
+	callCounts := CallCounts{}
+	statistics := CountStatistics{}
+	for _, span := range spans {
+		statistics.add(countSpanCalls(span, idsToSpan, bucketSize, callCounts))
+	}
+	return callCounts, statistics
+}
+
+// spansByKey builds a map of all (trace id, span id) ~> span in the batch, skipping the spans of invalid records
+func spansByKey(spans []*Span) map[string]*Span {
+	idsToSpan := make(map[string]*Span, len(spans))
+	for _, span := range spans {
+		if span != nil {
+			idsToSpan[span.Key()] = span
+		}
+	}
+	return idsToSpan
+}
+
+// countSpanCalls counts the calls of the parents in the batch to the span
+func countSpanCalls(span *Span, idsToSpan map[string]*Span, bucketSize time.Duration, callCounts CallCounts) CountStatistics {
+	// Spans of tenants stored with key prefixes share the prefix with their references
+	keyPrefix, _ := tenancy.SplitKey(span.TraceID)
+	bucket := Bucket{
+		KeyPrefix:      keyPrefix,
+		CallTimeBucket: dynamodependencystore.TimeToBucketOfSize(span.StartTime, bucketSize),
+	}
+
+	statistics := CountStatistics{}
+	for _, reference := range span.References {
+		parent, ok := idsToSpan[reference.Key()]
+		if !ok {
+			statistics.FetchedSpans += 1
+			// TODO: Fetch span
+			continue
+		}
+
+		statistics.IncludedSpans += 1
+		callCounts.bucket(bucket).CountCall(dynamodependencystore.OperationDependency{
+			Parent:          parent.ServiceName,
+			ParentOperation: parent.OperationName,
+			Child:           span.ServiceName,
+			ChildOperation:  span.OperationName,
+		}, span.Duration, span.Error)
+	}
+	return statistics
+}
+
+func (c CallCounts) bucket(bucket Bucket) *dynamodependencystore.DependencyCallCounts {
+	dependencyCallCounts, ok := c[bucket]
+	if !ok {
+		dependencyCallCounts = dynamodependencystore.NewDependencyCallCounts()
+		c[bucket] = dependencyCallCounts
+	}
+	return dependencyCallCounts
+}
+
+// items returns the number of dependency items the calls are written to
+func (c CallCounts) items() int {
+	items := 0
+	for _, dependencyCallCounts := range c {
+		items += len(dependencyCallCounts.Services) + len(dependencyCallCounts.Operations)
+	}
+	return items
+}
+
+// newItems returns the number of dependency items of other, which aren't in c
+func (c CallCounts) newItems(other CallCounts) int {
+	newItems := 0
+	for bucket, otherCallCounts := range other {
+		dependencyCallCounts := c[bucket]
+		for dependency := range otherCallCounts.Services {
+			if dependencyCallCounts == nil || dependencyCallCounts.Services[dependency] == nil {
+				newItems++
+			}
+		}
+		for dependency := range otherCallCounts.Operations {
+			if dependencyCallCounts == nil || dependencyCallCounts.Operations[dependency] == nil {
+				newItems++
+			}
+		}
+	}
+	return newItems
+}
+
+func (c CallCounts) add(other CallCounts) {
+	for bucket, otherCallCounts := range other {
+		dependencyCallCounts := c.bucket(bucket)
+		for dependency, statistics := range otherCallCounts.Services {
+			dependencyCallCounts.AddServiceStatistics(dependency.Parent, dependency.Child, statistics)
+		}
+		for dependency, statistics := range otherCallCounts.Operations {
+			dependencyCallCounts.AddOperationStatistics(dependency, statistics)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package dependencystream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/stretchr/testify/assert"
+)
+
+// The spans start at 2021-11-07T13:29:54Z
+var spanStartTime = time.Unix(1636291794, 0)
+
+func newSpan(traceID uint64, spanID model.SpanID, parentID model.SpanID, service string, operation string, tags ...model.KeyValue) *model.Span {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, traceID),
+		SpanID:        spanID,
+		OperationName: operation,
+		StartTime:     spanStartTime,
+		Duration:      20 * time.Millisecond,
+		Process:       model.NewProcess(service, nil),
+		Tags:          tags,
+	}
+	if parentID != 0 {
+		span.References = []model.SpanRef{model.NewChildOfRef(span.TraceID, parentID)}
+	}
+	return span
+}
+
+func newRecord(assert *assert.Assertions, eventID string, sequenceNumber string, span *model.Span) Record {
+	image, err := attributevalue.MarshalMap(dynamospanstore.NewSpanItemFromSpan(span))
+	assert.NoError(err)
+	return Record{
+		EventID:                     eventID,
+		SequenceNumber:              sequenceNumber,
+		ApproximateCreationDateTime: time.Now(),
+		NewImage:                    image,
+	}
+}
+
+func TestParseSpans(t *testing.T) {
+	assert := assert.New(t)
+
+	records := []Record{
+		newRecord(assert, "1", "100", newSpan(1, 1, 0, "jaeger", "Export")),
+		newRecord(assert, "2", "200", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan", model.Bool("error", true))),
+	}
+	spans, err := ParseSpans(records)
+	assert.NoError(err)
+	assert.Len(spans, 2)
+
+	assert.Equal(&Span{
+		TraceID:       model.NewTraceID(0, 1).String(),
+		SpanID:        model.SpanID(2).String(),
+		References:    []*SpanReference{{TraceID: model.NewTraceID(0, 1).String(), SpanID: model.SpanID(1).String()}},
+		ServiceName:   "dynamodb-plugin",
+		OperationName: "WriteSpan",
+		StartTime:     spanStartTime,
+		Duration:      20 * time.Millisecond,
+		Error:         true,
+	}, spans[1])
+
+	// Without a start time, the time of the change is used
+	delete(records[0].NewImage, "StartTime")
+	records[0].ApproximateCreationDateTime = time.Unix(1636300800, 0)
+	spans, err = ParseSpans(records)
+	assert.NoError(err)
+	assert.Equal(time.Unix(1636300800, 0), spans[0].StartTime)
+}
+
+func TestCountDependencyCalls(t *testing.T) {
+	assert := assert.New(t)
+
+	records := []Record{
+		newRecord(assert, "1", "100", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan", model.String("otel.status_code", "ERROR"))),
+		newRecord(assert, "2", "200", newSpan(1, 1, 0, "jaeger", "Export")),
+		// The parent isn't part of the batch
+		newRecord(assert, "3", "300", newSpan(2, 2, 1, "dynamodb-plugin", "WriteSpan")),
+	}
+	spans, err := ParseSpans(records)
+	assert.NoError(err)
+
+	callCounts, statistics := CountDependencyCalls(spans, time.Hour)
+	assert.Equal(CountStatistics{IncludedSpans: 1, FetchedSpans: 1}, statistics)
+
+	callStatistics := &dynamodependencystore.CallStatistics{}
+	callStatistics.AddCall(20*time.Millisecond, true)
+	assert.Equal(CallCounts{
+		{CallTimeBucket: 1636290000}: {
+			CallCounts: map[string]map[string]uint64{"jaeger": {"dynamodb-plugin": 1}},
+			Services: map[dynamodependencystore.ServiceDependency]*dynamodependencystore.CallStatistics{
+				{Parent: "jaeger", Child: "dynamodb-plugin"}: callStatistics,
+			},
+			Operations: map[dynamodependencystore.OperationDependency]*dynamodependencystore.CallStatistics{
+				{Parent: "jaeger", ParentOperation: "Export", Child: "dynamodb-plugin", ChildOperation: "WriteSpan"}: callStatistics,
+			},
+		},
+	}, callCounts)
+
+	// Calls are counted in the bucket their span started in
+	callCounts, _ = CountDependencyCalls(spans, time.Minute)
+	assert.Contains(callCounts, Bucket{CallTimeBucket: 1636291740})
+}
+
+func TestIsError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isError(map[string]string{"error": "true"}))
+	assert.True(isError(map[string]string{"otel.status_code": "ERROR"}))
+	assert.False(isError(map[string]string{"error": "false"}))
+	assert.False(isError(map[string]string{}))
+}
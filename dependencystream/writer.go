@@ -0,0 +1,245 @@
+package dependencystream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+)
+
+// Stream records are retried after failures, either by Lambda or by the consumer, and a retry can contain more records
+// than the failed batch. Dependency calls are added to the stored counts, so records are written in chunks, each in one
+// transaction with a checkpoint. The checkpoint is keyed by the first record of the chunk and stores the last one,
+// retries skip the records already written.
+
+const (
+	// Transactions are limited to 100 items, one is needed for the checkpoint
+	maxTransactionItems = 100
+	checkpointKeyPrefix = "checkpoint|"
+	// Records are removed from the stream after 24 hours, so they can't be retried afterwards
+	checkpointTimeToLive = 48 * time.Hour
+)
+
+// DynamoDBAPI is the subset of the DynamoDB API used to write the dependency calls
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+type CheckpointItem struct {
+	Key                string
+	CallTimeBucket     int64
+	LastSequenceNumber string
+	// TTL attribute of the dependencies table
+	ExpireTime int64
+}
+
+// WriteResult reports the progress of WriteRecords, also when it failed
+type WriteResult struct {
+	CountStatistics
+	// Records written, the records after them have to be retried
+	Written int
+	// Records skipped as they were written before
+	SkippedRecords int
+	// Spans skipped as their calls don't fit into a transaction
+	DroppedSpans int
+	// Errors of the records skipped as they can't be converted or parsed
+	InvalidRecords []error
+}
+
+// Writer adds the dependency calls of stream records to the dependencies table
+type Writer struct {
+	svc               DynamoDBAPI
+	dependenciesTable string
+	bucketSize        time.Duration
+}
+
+func NewWriter(svc DynamoDBAPI, dependenciesTable string, bucketSize time.Duration) *Writer {
+	if bucketSize == 0 {
+		bucketSize = dynamodependencystore.DefaultBucketSize
+	}
+	return &Writer{
+		svc:               svc,
+		dependenciesTable: dependenciesTable,
+		bucketSize:        bucketSize,
+	}
+}
+
+func (w *Writer) checkpointKey(record Record) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"Key":            &types.AttributeValueMemberS{Value: checkpointKeyPrefix + record.EventID},
+		"CallTimeBucket": &types.AttributeValueMemberN{Value: "0"},
+	}
+}
+
+// getCheckpoint returns the checkpoint of the chunk starting with the record, nil when the chunk wasn't written
+func (w *Writer) getCheckpoint(ctx context.Context, record Record) (*CheckpointItem, error) {
+	output, err := w.svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(w.dependenciesTable),
+		Key:            w.checkpointKey(record),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint, %v", err)
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	checkpoint := &CheckpointItem{}
+	if err := attributevalue.UnmarshalMap(output.Item, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint, %v", err)
+	}
+	return checkpoint, nil
+}
+
+// WriteRecords writes the dependency calls of the records, which have to be ordered records of one shard. Only calls
+// between spans of the records are counted. Records which can't be converted or parsed would fail every retry, so
+// they are skipped and reported in the result.
+func (w *Writer) WriteRecords(ctx context.Context, records []Record) (WriteResult, error) {
+	result := WriteResult{}
+
+	spans := make([]*Span, len(records))
+	for i, record := range records {
+		span, err := ParseRecord(record)
+		if err != nil {
+			result.InvalidRecords = append(result.InvalidRecords, err)
+			continue
+		}
+		spans[i] = span
+	}
+	idsToSpan := spansByKey(spans)
+
+	for start := 0; start < len(records); {
+		checkpoint, err := w.getCheckpoint(ctx, records[start])
+		if err != nil {
+			return result, err
+		}
+		if checkpoint != nil {
+			next := skipRecords(records, start, checkpoint.LastSequenceNumber)
+			result.SkippedRecords += next - start
+			result.Written = next
+			start = next
+			continue
+		}
+
+		// Grow the chunk until the next span would exceed the transaction
+		callCounts := CallCounts{}
+		items := 0
+		end := start
+		for ; end < len(spans); end++ {
+			if spans[end] == nil {
+				continue
+			}
+			spanCallCounts := CallCounts{}
+			statistics := countSpanCalls(spans[end], idsToSpan, w.bucketSize, spanCallCounts)
+			newItems := callCounts.newItems(spanCallCounts)
+			if items+newItems >= maxTransactionItems {
+				if end > start {
+					break
+				}
+				result.DroppedSpans++
+				continue
+			}
+
+			result.add(statistics)
+			items += newItems
+			callCounts.add(spanCallCounts)
+		}
+
+		// Records without dependency calls can't be double counted
+		if items > 0 {
+			err := w.writeChunk(ctx, records[start], records[end-1], callCounts)
+			var canceled *types.TransactionCanceledException
+			if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 && aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				// Another invocation wrote the chunk, continue after its checkpoint
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+		}
+		result.Written = end
+		start = end
+	}
+
+	return result, nil
+}
+
+// writeChunk adds the dependency calls and writes the checkpoint of the chunk in one transaction, the transaction is
+// canceled when the checkpoint already exists
+func (w *Writer) writeChunk(ctx context.Context, first Record, last Record, callCounts CallCounts) error {
+	checkpoint, err := attributevalue.MarshalMap(&CheckpointItem{
+		Key:                checkpointKeyPrefix + first.EventID,
+		LastSequenceNumber: last.SequenceNumber,
+		ExpireTime:         time.Now().Add(checkpointTimeToLive).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint, %v", err)
+	}
+	condition, err := expression.NewBuilder().WithCondition(expression.Name("Key").AttributeNotExists()).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build condition expression, %v", err)
+	}
+
+	transactItems := []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName:                 aws.String(w.dependenciesTable),
+			Item:                      checkpoint,
+			ConditionExpression:       condition.Condition(),
+			ExpressionAttributeNames:  condition.Names(),
+			ExpressionAttributeValues: condition.Values(),
+		},
+	}}
+	addUpdate := func(item *dynamodependencystore.DependencyItem) error {
+		update, err := dynamodependencystore.NewDependencyItemUpdate(w.dependenciesTable, item)
+		if err != nil {
+			return err
+		}
+		transactItems = append(transactItems, types.TransactWriteItem{Update: update})
+		return nil
+	}
+	for bucket, dependencyCallCounts := range callCounts {
+		for dependency, statistics := range dependencyCallCounts.Services {
+			if err := addUpdate(dynamodependencystore.NewServiceDependencyItem(bucket.KeyPrefix, dependency, bucket.CallTimeBucket, statistics)); err != nil {
+				return err
+			}
+		}
+		for dependency, statistics := range dependencyCallCounts.Operations {
+			if err := addUpdate(dynamodependencystore.NewOperationDependencyItem(bucket.KeyPrefix, dependency, bucket.CallTimeBucket, statistics)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := w.svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transactItems}); err != nil {
+		return fmt.Errorf("failed to write dependency items: %w", err)
+	}
+	return nil
+}
+
+// skipRecords returns the index of the first record after the checkpoint, the record starting the checkpoint is
+// always skipped
+func skipRecords(records []Record, start int, lastSequenceNumber string) int {
+	next := start + 1
+	for next < len(records) && !SequenceNumberAfter(records[next].SequenceNumber, lastSequenceNumber) {
+		next++
+	}
+	return next
+}
+
+// SequenceNumberAfter compares sequence numbers of the same shard, which are increasing decimal numbers of up to
+// 40 digits
+func SequenceNumberAfter(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
@@ -0,0 +1,139 @@
+package dependencystream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/assert"
+)
+
+const dependenciesTable = "jaeger.dependencies"
+
+func newDependenciesTable(assert *assert.Assertions, ctx context.Context) *dynamodbfake.Client {
+	svc := dynamodbfake.New(nil)
+	assert.NoError(setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
+		DependenciesTable: dependenciesTable,
+	}))
+	return svc
+}
+
+// getCallCount returns the stored calls from jaeger to the plugin
+func getCallCount(assert *assert.Assertions, ctx context.Context, svc DynamoDBAPI) string {
+	output, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(dependenciesTable),
+		Key: map[string]types.AttributeValue{
+			"Key":            &types.AttributeValueMemberS{Value: "jaeger/dynamodb-plugin"},
+			"CallTimeBucket": &types.AttributeValueMemberN{Value: "1636290000"},
+		},
+	})
+	assert.NoError(err)
+	if output.Item == nil {
+		return ""
+	}
+	return output.Item["CallCount"].(*types.AttributeValueMemberN).Value
+}
+
+type failingTransactions struct {
+	*dynamodbfake.Client
+}
+
+func (f *failingTransactions) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return nil, errors.New("throttled")
+}
+
+func TestWriteRecords(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	records := []Record{
+		newRecord(assert, "1", "100", newSpan(1, 1, 0, "jaeger", "Export")),
+		newRecord(assert, "2", "200", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan")),
+	}
+
+	// Failed writes report the records written before
+	result, err := NewWriter(&failingTransactions{svc}, dependenciesTable, time.Hour).WriteRecords(ctx, records)
+	assert.Error(err)
+	assert.Equal(0, result.Written)
+	assert.Equal("", getCallCount(assert, ctx, svc))
+
+	writer := NewWriter(svc, dependenciesTable, time.Hour)
+	result, err = writer.WriteRecords(ctx, records)
+	assert.NoError(err)
+	assert.Equal(WriteResult{CountStatistics: CountStatistics{IncludedSpans: 1}, Written: 2}, result)
+	assert.Equal("1", getCallCount(assert, ctx, svc))
+
+	// Records already written aren't counted again
+	result, err = writer.WriteRecords(ctx, records)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Written: 2, SkippedRecords: 2}, result)
+	assert.Equal("1", getCallCount(assert, ctx, svc))
+
+	// Retries can contain more records than the failed batch
+	records = append(records, newRecord(assert, "3", "300", newSpan(1, 3, 1, "dynamodb-plugin", "WriteSpan")))
+	result, err = writer.WriteRecords(ctx, records)
+	assert.NoError(err)
+	assert.Equal(3, result.Written)
+	assert.Equal(2, result.SkippedRecords)
+	assert.Equal("2", getCallCount(assert, ctx, svc))
+}
+
+func TestWriteRecordsInvalid(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+	unparsable := newRecord(assert, "2", "200", newSpan(1, 3, 1, "dynamodb-plugin", "WriteSpan"))
+	unparsable.NewImage["Duration"] = &types.AttributeValueMemberS{Value: "35ms"}
+	records := []Record{
+		newRecord(assert, "1", "100", newSpan(1, 1, 0, "jaeger", "Export")),
+		unparsable,
+		{EventID: "3", SequenceNumber: "300", Err: errors.New("unsupported attribute value")},
+		newRecord(assert, "4", "400", newSpan(1, 2, 1, "dynamodb-plugin", "WriteSpan")),
+	}
+
+	// Invalid records would fail every retry, so they are skipped
+	result, err := NewWriter(svc, dependenciesTable, time.Hour).WriteRecords(ctx, records)
+	assert.NoError(err)
+	assert.Equal(4, result.Written)
+	assert.Len(result.InvalidRecords, 2)
+	assert.Equal("1", getCallCount(assert, ctx, svc))
+}
+
+func TestWriteRecordsChunks(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	svc := newDependenciesTable(assert, ctx)
+
+	// Every child calls the parent with another operation, which needs more items than fit into a transaction
+	records := []Record{newRecord(assert, "1", "00001", newSpan(1, 1, 0, "jaeger", "Export"))}
+	for i := 2; i <= 120; i++ {
+		span := newSpan(1, model.SpanID(i), 1, "dynamodb-plugin", fmt.Sprintf("WriteSpan%d", i))
+		records = append(records, newRecord(assert, fmt.Sprintf("%d", i), fmt.Sprintf("%05d", i), span))
+	}
+
+	result, err := NewWriter(svc, dependenciesTable, time.Hour).WriteRecords(ctx, records)
+	assert.NoError(err)
+	assert.Equal(len(records), result.Written)
+	assert.Equal(119, result.IncludedSpans)
+	assert.Equal("119", getCallCount(assert, ctx, svc))
+}
+
+func TestSequenceNumberAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(SequenceNumberAfter("763396600000000015646703589", "763396300000000015646703203"))
+	assert.True(SequenceNumberAfter("1000", "999"))
+	assert.False(SequenceNumberAfter("999", "1000"))
+	assert.False(SequenceNumberAfter("1000", "1000"))
+}
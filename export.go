@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/traceio"
+	"github.com/ory/viper"
+	"github.com/spf13/pflag"
+)
+
+// runExport writes traces given by ID or found by a search to a file
+func runExport(ctx context.Context, logger hclog.Logger, args []string) error {
+	flags := pflag.NewFlagSet("export", pflag.ContinueOnError)
+	configPath := flags.String("config", "", "A path to the dynamodb plugin's configuration file")
+	tenant := flags.String("tenant", "", "Tenant to export traces of when tenancy is enabled")
+	traceIDs := flags.StringSlice("trace-id", nil, "IDs of the traces to export, instead of searching them")
+	service := flags.String("service", "", "Service to search traces of")
+	operation := flags.String("operation", "", "Operation to search traces of")
+	tags := flags.StringToString("tag", nil, "Tags to search traces by as key=value")
+	start := flags.String("start", "", "Start of the search time range in RFC 3339 format, defaults to one hour before the end")
+	end := flags.String("end", "", "End of the search time range in RFC 3339 format, defaults to now")
+	minDuration := flags.Duration("min-duration", 0, "Minimum span duration to search traces by")
+	maxDuration := flags.Duration("max-duration", 0, "Maximum span duration to search traces by")
+	limit := flags.Int("limit", 20, "Maximum number of traces to search")
+	format := flags.String("format", traceio.FormatJaeger, fmt.Sprintf("Either %q or %q", traceio.FormatJaeger, traceio.FormatOTLP))
+	output := flags.String("output", "-", "File to write the traces to, - writes to stdout")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *format != traceio.FormatJaeger && *format != traceio.FormatOTLP {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if len(*traceIDs) == 0 && *service == "" {
+		return errors.New("either --trace-id or --service is required")
+	}
+
+	configuration, err := readConfiguration(viper.New(), *configPath)
+	if err != nil {
+		return err
+	}
+	svc, err := newDynamoDBClient(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		return err
+	}
+	if *tenant != "" {
+		ctx = tenancy.WithTenant(ctx, *tenant)
+	}
+
+	encryptor, err := newEncryptor(ctx, configuration)
+	if err != nil {
+		return fmt.Errorf("failed to configure encryption, %v", err)
+	}
+
+	reader := dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable,
+		dynamospanstore.WithReaderTenancy(tenancyManager), dynamospanstore.WithReaderEncryption(encryptor))
+
+	var traces []*model.Trace
+	if len(*traceIDs) > 0 {
+		for _, id := range *traceIDs {
+			traceID, err := model.TraceIDFromString(id)
+			if err != nil {
+				return fmt.Errorf("invalid trace ID %q, %v", id, err)
+			}
+
+			trace, err := reader.GetTrace(ctx, traceID)
+			if err != nil {
+				return fmt.Errorf("failed to get trace %s, %v", id, err)
+			}
+			traces = append(traces, trace)
+		}
+	} else {
+		query := &spanstore.TraceQueryParameters{
+			ServiceName:   *service,
+			OperationName: *operation,
+			Tags:          *tags,
+			DurationMin:   *minDuration,
+			DurationMax:   *maxDuration,
+			NumTraces:     *limit,
+			StartTimeMax:  time.Now(),
+		}
+		if *end != "" {
+			if query.StartTimeMax, err = time.Parse(time.RFC3339, *end); err != nil {
+				return fmt.Errorf("invalid end, %v", err)
+			}
+		}
+		query.StartTimeMin = query.StartTimeMax.Add(-time.Hour)
+		if *start != "" {
+			if query.StartTimeMin, err = time.Parse(time.RFC3339, *start); err != nil {
+				return fmt.Errorf("invalid start, %v", err)
+			}
+		}
+
+		if traces, err = reader.FindTraces(ctx, query); err != nil {
+			return fmt.Errorf("failed to find traces, %v", err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file, %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := traceio.Write(w, *format, traces); err != nil {
+		return err
+	}
+
+	logger.Debug("Exported traces.", "count", len(traces))
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/importer"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/traceio"
+	"github.com/ory/viper"
+	"github.com/spf13/pflag"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+// runImport writes the spans of trace files using the span writer
+func runImport(ctx context.Context, logger hclog.Logger, args []string) error {
+	flags := pflag.NewFlagSet("import", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: jaeger-dynamodb import [flags] FILE...\n")
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "A path to the dynamodb plugin's configuration file")
+	tenant := flags.String("tenant", "", "Tenant to import traces for when tenancy is enabled")
+	format := flags.String("format", traceio.FormatJaeger, fmt.Sprintf("One of %q, %q, %q or %q", traceio.FormatJaeger, traceio.FormatOTLP, traceio.FormatOTLPProto, traceio.FormatZipkin))
+	concurrency := flags.Int("concurrency", 8, "Number of spans written concurrently")
+	checkpointFile := flags.String("checkpoint", "", "File recording the progress, an interrupted import resumes from it")
+	dryRun := flags.Bool("dry-run", false, "Only report the item sizes and estimated write capacity units")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		return errors.New("no files to import")
+	}
+
+	configuration, err := readConfiguration(viper.New(), *configPath)
+	if err != nil {
+		return err
+	}
+	svc, err := newDynamoDBClient(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		return err
+	}
+	if *tenant != "" {
+		ctx = tenancy.WithTenant(ctx, *tenant)
+	}
+
+	writerOptions := []dynamospanstore.WriterOption{dynamospanstore.WithWriterTenancy(tenancyManager)}
+	if rateLimiterOptions := newRateLimiterOptions(configuration); rateLimiterOptions != nil {
+		rateLimiter, err := dynamospanstore.NewRateLimiter(metrics.NullFactory, rateLimiterOptions)
+		if err != nil {
+			return fmt.Errorf("failed to create rate limiter, %v", err)
+		}
+		writerOptions = append(writerOptions, dynamospanstore.WithRateLimiter(rateLimiter))
+	}
+	// Imported spans are scrubbed like the spans received by the plugin
+	if scrubberOptions := newScrubberOptions(configuration); scrubberOptions != nil {
+		scrubber, err := dynamospanstore.NewScrubber(metrics.NullFactory, scrubberOptions)
+		if err != nil {
+			return fmt.Errorf("failed to create scrubber, %v", err)
+		}
+		writerOptions = append(writerOptions, dynamospanstore.WithScrubber(scrubber))
+	}
+	encryptor, err := newEncryptor(ctx, configuration)
+	if err != nil {
+		return fmt.Errorf("failed to configure encryption, %v", err)
+	}
+	if encryptor != nil {
+		writerOptions = append(writerOptions, dynamospanstore.WithEncryption(encryptor, configuration.Encryption.SearchableTags))
+	}
+	writer, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable, writerOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to create span writer, %v", err)
+	}
+
+	spanImporter, err := importer.NewImporter(logger, writer, &importer.Options{
+		Format:         *format,
+		Concurrency:    *concurrency,
+		CheckpointFile: *checkpointFile,
+		DryRun:         *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	report, err := spanImporter.Import(ctx, files)
+	if report != nil {
+		fmt.Printf("files: %d\nspans: %d\n", report.Files, report.Spans)
+		if *dryRun {
+			fmt.Printf("span item bytes: %d\nmax span item size: %d\noversized spans: %d\nestimated write units: %d\n",
+				report.SpanItemBytes, report.MaxSpanItemSize, report.OversizedSpans, report.WriteUnits)
+		} else {
+			fmt.Printf("skipped spans: %d\n", report.SkippedSpans)
+		}
+	}
+	return err
+}
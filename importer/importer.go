@@ -0,0 +1,317 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/traceio"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultConcurrency = 8
+	checkpointInterval = 5 * time.Second
+)
+
+type Options struct {
+	// Format of all files, see the traceio formats
+	Format string
+	// Number of spans written concurrently
+	Concurrency int
+	// File the progress is recorded in, an interrupted import resumes from it when set
+	CheckpointFile string
+	// Only reports the sizes of the items which would be written
+	DryRun bool
+}
+
+type Report struct {
+	Files int
+	Spans int
+	// Spans which were already imported according to the checkpoint
+	SkippedSpans int
+
+	// Sizes and write capacity units of all items, only calculated in dry runs
+	SpanItemBytes   int64
+	MaxSpanItemSize int
+	// Spans exceeding the item size limit, which can't be written
+	OversizedSpans int
+	WriteUnits     int64
+}
+
+type fileProgress struct {
+	// Number of spans at the start of the file which were written
+	Spans     int
+	Completed bool
+}
+
+type checkpoint struct {
+	Files map[string]*fileProgress
+}
+
+type Importer struct {
+	logger  hclog.Logger
+	writer  spanstore.Writer
+	options Options
+
+	mu         sync.Mutex
+	checkpoint *checkpoint
+}
+
+func NewImporter(logger hclog.Logger, writer spanstore.Writer, options *Options) (*Importer, error) {
+	importer := &Importer{
+		logger:     logger,
+		writer:     writer,
+		checkpoint: &checkpoint{Files: map[string]*fileProgress{}},
+	}
+	if options != nil {
+		importer.options = *options
+	}
+	if importer.options.Concurrency <= 0 {
+		importer.options.Concurrency = defaultConcurrency
+	}
+
+	if importer.options.CheckpointFile != "" && !importer.options.DryRun {
+		b, err := os.ReadFile(importer.options.CheckpointFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read checkpoint, %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, importer.checkpoint); err != nil {
+				return nil, fmt.Errorf("failed to decode checkpoint, %v", err)
+			}
+		}
+	}
+
+	return importer, nil
+}
+
+// Import writes all spans of the files, skipping spans recorded in the checkpoint
+func (i *Importer) Import(ctx context.Context, files []string) (*Report, error) {
+	report := &Report{}
+	estimate := newEstimate()
+
+	for _, file := range files {
+		progress := i.progress(file)
+		if progress.Completed {
+			i.logger.Debug("Skipping imported file.", "file", file)
+			report.Files++
+			report.Spans += progress.Spans
+			report.SkippedSpans += progress.Spans
+			continue
+		}
+
+		spans, err := readFile(file, i.options.Format)
+		if err != nil {
+			return report, err
+		}
+		if progress.Spans > len(spans) {
+			return report, fmt.Errorf("checkpoint of %s doesn't match the file", file)
+		}
+		report.Files++
+		report.Spans += len(spans)
+
+		if i.options.DryRun {
+			if err := estimate.add(spans, report); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		report.SkippedSpans += progress.Spans
+
+		err = i.importSpans(ctx, file, spans[progress.Spans:], progress.Spans)
+		if saveErr := i.saveCheckpoint(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func readFile(file, format string) ([]*model.Span, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s, %v", file, err)
+	}
+	defer f.Close()
+
+	spans, err := traceio.Read(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s, %v", file, err)
+	}
+	return spans, nil
+}
+
+func (i *Importer) progress(file string) *fileProgress {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	progress, ok := i.checkpoint.Files[file]
+	if !ok {
+		progress = &fileProgress{}
+		i.checkpoint.Files[file] = progress
+	}
+	return &fileProgress{Spans: progress.Spans, Completed: progress.Completed}
+}
+
+// importSpans writes spans concurrently, the checkpoint only advances over spans which were all written
+func (i *Importer) importSpans(ctx context.Context, file string, spans []*model.Span, offset int) error {
+	written := make([]bool, len(spans))
+	next := 0
+	lastSave := time.Now()
+
+	done := func(index int) error {
+		i.mu.Lock()
+		written[index] = true
+		for next < len(written) && written[next] {
+			next++
+		}
+		progress := i.checkpoint.Files[file]
+		progress.Spans = offset + next
+		progress.Completed = next == len(written)
+		save := time.Since(lastSave) > checkpointInterval
+		if save {
+			lastSave = time.Now()
+		}
+		i.mu.Unlock()
+
+		if save {
+			return i.saveCheckpoint()
+		}
+		return nil
+	}
+
+	indices := make(chan int)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(indices)
+		for index := range spans {
+			select {
+			case indices <- index:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	for w := 0; w < i.options.Concurrency; w++ {
+		g.Go(func() error {
+			for index := range indices {
+				if err := i.writer.WriteSpan(ctx, spans[index]); err != nil {
+					return fmt.Errorf("failed to write span %s of %s, %v", spans[index].SpanID, file, err)
+				}
+				if err := done(index); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if len(spans) == 0 {
+		i.mu.Lock()
+		i.checkpoint.Files[file].Completed = true
+		i.mu.Unlock()
+	}
+
+	return g.Wait()
+}
+
+func (i *Importer) saveCheckpoint() error {
+	if i.options.CheckpointFile == "" {
+		return nil
+	}
+
+	i.mu.Lock()
+	b, err := json.Marshal(i.checkpoint)
+	i.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint, %v", err)
+	}
+
+	// Replace the checkpoint atomically, so an interrupted write doesn't lose the progress
+	tmpFile := i.options.CheckpointFile + ".tmp"
+	if err := os.WriteFile(tmpFile, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoint, %v", err)
+	}
+	if err := os.Rename(tmpFile, i.options.CheckpointFile); err != nil {
+		return fmt.Errorf("failed to write checkpoint, %v", err)
+	}
+	return nil
+}
+
+// estimate sums the sizes of the items the writer would write, services and operations are written once
+type estimate struct {
+	services   map[string]struct{}
+	operations map[string]struct{}
+}
+
+func newEstimate() *estimate {
+	return &estimate{
+		services:   map[string]struct{}{},
+		operations: map[string]struct{}{},
+	}
+}
+
+func (e *estimate) add(spans []*model.Span, report *Report) error {
+	for _, span := range spans {
+		size, err := marshaledSize(dynamospanstore.NewSpanItemFromSpan(span))
+		if err != nil {
+			return err
+		}
+		report.SpanItemBytes += int64(size)
+		report.WriteUnits += int64(dynamospanstore.WriteUnits(size))
+		if size > report.MaxSpanItemSize {
+			report.MaxSpanItemSize = size
+		}
+		if size > dynamospanstore.MaxItemSize {
+			report.OversizedSpans++
+		}
+
+		serviceName := span.Process.ServiceName
+		if _, ok := e.services[serviceName]; !ok && serviceName != "" {
+			e.services[serviceName] = struct{}{}
+			if err := e.addItem(dynamospanstore.NewServiceItemFromSpan(span), report); err != nil {
+				return err
+			}
+		}
+		operationKey := fmt.Sprintf("%s__%s", serviceName, span.OperationName)
+		if _, ok := e.operations[operationKey]; !ok && serviceName != "" && span.OperationName != "" {
+			e.operations[operationKey] = struct{}{}
+			if err := e.addItem(dynamospanstore.NewOperationItemFromSpan(span), report); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *estimate) addItem(item interface{}, report *Report) error {
+	size, err := marshaledSize(item)
+	if err != nil {
+		return err
+	}
+	report.WriteUnits += int64(dynamospanstore.WriteUnits(size))
+	return nil
+}
+
+func marshaledSize(item interface{}) (int, error) {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal item, %v", err)
+	}
+	return dynamospanstore.ItemSize(av), nil
+}
@@ -0,0 +1,112 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/traceio"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingWriter struct {
+	mu      sync.Mutex
+	spanIDs []model.SpanID
+	// Fails all writes after this number of writes when positive
+	failAfter int
+}
+
+func (w *recordingWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failAfter > 0 && len(w.spanIDs) >= w.failAfter {
+		return errors.New("write failed")
+	}
+	w.spanIDs = append(w.spanIDs, span.SpanID)
+	return nil
+}
+
+func writeZipkinFile(assert *assert.Assertions, dir, name string, spans int) string {
+	items := []string{}
+	for i := 1; i <= spans; i++ {
+		items = append(items, fmt.Sprintf(`{"traceId":"%016x","id":"%016x","name":"op-%d","timestamp":1647261015000000,"duration":1000,"localEndpoint":{"serviceName":"frontend"}}`, len(name), i, i%2))
+	}
+
+	file := filepath.Join(dir, name)
+	assert.NoError(os.WriteFile(file, []byte("["+strings.Join(items, ",")+"]"), 0o644))
+	return file
+}
+
+func TestImportResume(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	dir := t.TempDir()
+	logger := hclog.NewNullLogger()
+
+	files := []string{writeZipkinFile(assert, dir, "a.json", 3), writeZipkinFile(assert, dir, "b.json", 4)}
+	options := &Options{Format: traceio.FormatZipkin, Concurrency: 1, CheckpointFile: filepath.Join(dir, "checkpoint.json")}
+
+	writer := &recordingWriter{failAfter: 5}
+	importer, err := NewImporter(logger, writer, options)
+	assert.NoError(err)
+	_, err = importer.Import(ctx, files)
+	assert.Error(err)
+	assert.Len(writer.spanIDs, 5)
+
+	// The second run continues with the third span of the second file
+	writer.failAfter = 0
+	importer, err = NewImporter(logger, writer, options)
+	assert.NoError(err)
+	report, err := importer.Import(ctx, files)
+	assert.NoError(err)
+	assert.Equal(&Report{Files: 2, Spans: 7, SkippedSpans: 5}, report)
+	assert.Equal([]model.SpanID{1, 2, 3, 1, 2, 3, 4}, writer.spanIDs)
+
+	importer, err = NewImporter(logger, writer, options)
+	assert.NoError(err)
+	report, err = importer.Import(ctx, files)
+	assert.NoError(err)
+	assert.Equal(&Report{Files: 2, Spans: 7, SkippedSpans: 7}, report)
+	assert.Len(writer.spanIDs, 7)
+}
+
+func TestImportConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	writer := &recordingWriter{}
+	importer, err := NewImporter(hclog.NewNullLogger(), writer, &Options{Format: traceio.FormatZipkin, Concurrency: 4})
+	assert.NoError(err)
+
+	report, err := importer.Import(context.TODO(), []string{writeZipkinFile(assert, dir, "a.json", 100)})
+	assert.NoError(err)
+	assert.Equal(100, report.Spans)
+	assert.Len(writer.spanIDs, 100)
+}
+
+func TestImportDryRun(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	writer := &recordingWriter{}
+	importer, err := NewImporter(hclog.NewNullLogger(), writer, &Options{Format: traceio.FormatZipkin, DryRun: true})
+	assert.NoError(err)
+
+	report, err := importer.Import(context.TODO(), []string{writeZipkinFile(assert, dir, "a.json", 10)})
+	assert.NoError(err)
+	assert.Empty(writer.spanIDs)
+	assert.Equal(10, report.Spans)
+	assert.Greater(report.SpanItemBytes, int64(0))
+	assert.Greater(report.MaxSpanItemSize, 0)
+	assert.Zero(report.OversizedSpans)
+	// 10 spans, 1 service and 2 operations, each small enough for a single unit
+	assert.Equal(int64(13), report.WriteUnits)
+}
@@ -2,32 +2,50 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/johanneswuerbach/jaeger-dynamodb/plugin"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/awsconfig"
 	pConfig "github.com/johanneswuerbach/jaeger-dynamodb/plugin/config"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/encryption"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/health"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/telemetry"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/wal"
 	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
 	"github.com/ory/viper"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
+	"github.com/uber/jaeger-lib/metrics"
+	jlprom "github.com/uber/jaeger-lib/metrics/prometheus"
+	"go.opentelemetry.io/otel"
 
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 )
 
 const (
 	loggerName = "jaeger-dynamodb"
 
-	spansTable        = "jaeger.spans"
+	spansTable        = pConfig.DefaultSpansTable
 	servicesTable     = "jaeger.services"
 	operationsTable   = "jaeger.operations"
-	dependenciesTable = "jaeger.dependencies"
+	dependenciesTable = pConfig.DefaultDependenciesTable
+
+	spanSearchIndex = "SpanSearchIndex"
 )
 
 func main() {
@@ -42,6 +60,43 @@ func main() {
 		JSONFormat: true,
 	})
 
+	ctx := context.TODO()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			if err := runExport(ctx, logger, os.Args[2:]); err != nil {
+				log.Fatalf("unable to export traces, %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(ctx, logger, os.Args[2:]); err != nil {
+				log.Fatalf("unable to import traces, %v", err)
+			}
+			return
+		case "rebuild-indexes":
+			if err := runRebuildIndexes(ctx, logger, os.Args[2:]); err != nil {
+				log.Fatalf("unable to rebuild indexes, %v", err)
+			}
+			return
+		case "dependencies":
+			if err := runDependencies(ctx, logger, os.Args[2:]); err != nil {
+				log.Fatalf("unable to aggregate dependencies, %v", err)
+			}
+			return
+		case "recompute-dependencies":
+			if err := runRecomputeDependencies(ctx, logger, os.Args[2:]); err != nil {
+				log.Fatalf("unable to recompute dependencies, %v", err)
+			}
+			return
+		case "delete":
+			if err := runDelete(ctx, logger, os.Args[2:]); err != nil {
+				log.Fatalf("unable to delete traces, %v", err)
+			}
+			return
+		}
+	}
+
 	var configPath string
 	pflag.StringVar(&configPath, "config", "", "A path to the dynamodb plugin's configuration file")
 	pflag.Bool("create-tables", false, "(Re)create dynamodb table")
@@ -51,40 +106,45 @@ func main() {
 		log.Fatalf("unable bind flags, %v", err)
 	}
 
-	if configPath != "" {
-		viper.SetConfigFile(configPath)
-
-		if err := viper.ReadInConfig(); err != nil {
-			log.Fatalf("error reading config file, %v", err)
-		}
-	}
-
-	var configuration pConfig.Configuration
-	err := viper.Unmarshal(&configuration)
+	configuration, err := readConfiguration(viper.GetViper(), configPath)
 	if err != nil {
-		log.Fatalf("unable to decode into struct, %v", err)
+		log.Fatal(err)
 	}
 
 	logger.Debug("plugin starting ...", configuration)
 
-	ctx := context.TODO()
-
-	cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
-		if configuration.DynamoDB.Endpoint != "" {
-			lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
-			lo.Region = "us-east-1"
-			lo.EndpointResolver = aws.EndpointResolverFunc(
-				func(service, region string) (aws.Endpoint, error) {
-					return aws.Endpoint{URL: configuration.DynamoDB.Endpoint, Source: aws.EndpointSourceCustom}, nil
-				})
+	// Flushes the traces of the plugin on exit
+	shutdownTracing := func() {}
+	var dynamodbOptions []func(*dynamodb.Options)
+	if configuration.Tracing.Exporter != "" {
+		tracerProvider, err := telemetry.NewTracerProvider(ctx, &telemetry.Options{
+			Exporter:    configuration.Tracing.Exporter,
+			Endpoint:    configuration.Tracing.Endpoint,
+			Insecure:    configuration.Tracing.Insecure,
+			SampleRatio: configuration.Tracing.SampleRatio,
+		})
+		if err != nil {
+			log.Fatalf("unable to configure tracing, %v", err)
 		}
-		return nil
-	})
+		shutdownTracing = func() {
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				logger.Warn("failed to flush traces", "err", err)
+			}
+		}
+
+		otel.SetTracerProvider(tracerProvider)
+		dynamodbOptions = append(dynamodbOptions, telemetry.WithDynamoDBTracing(tracerProvider))
+	}
+
+	svc, err := newDynamoDBClient(ctx, configuration, dynamodbOptions...)
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		log.Fatal(err)
 	}
 
-	svc := dynamodb.NewFromConfig(cfg)
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	logger.Debug("plugin configured")
 
@@ -93,35 +153,309 @@ func main() {
 			log.Fatalf("unable to poll until ready, %v", err)
 		}
 
-		logger.Debug("Creating tables.")
-		if err := setup.RecreateSpanStoreTables(ctx, svc, &setup.SetupSpanOptions{
-			SpansTable:      spansTable,
-			ServicesTable:   servicesTable,
-			OperationsTable: operationsTable,
-		}); err != nil {
-			log.Fatalf("unable to create tables, %v", err)
-		}
+		for _, tenant := range tableTenants(tenancyManager) {
+			logger.Debug("Creating tables.", "tenant", tenant)
+			if err := setup.RecreateSpanStoreTables(ctx, svc, &setup.SetupSpanOptions{
+				SpansTable:      tenancyManager.Table(tenant, spansTable),
+				ServicesTable:   tenancyManager.Table(tenant, servicesTable),
+				OperationsTable: tenancyManager.Table(tenant, operationsTable),
+			}); err != nil {
+				log.Fatalf("unable to create tables, %v", err)
+			}
 
-		if err := setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
-			DependenciesTable: dependenciesTable,
-		}); err != nil {
-			log.Fatalf("unable to create tables, %v", err)
+			if err := setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
+				DependenciesTable: tenancyManager.Table(tenant, dependenciesTable),
+			}); err != nil {
+				log.Fatalf("unable to create tables, %v", err)
+			}
 		}
 	}
 
 	if viper.GetBool("only-create-tables") {
 		logger.Debug("Exiting after tables created.")
+		shutdownTracing()
 		return
 	}
 
-	dynamodbPlugin, err := plugin.NewDynamoDBPlugin(logger, svc, spansTable, servicesTable, operationsTable, dependenciesTable)
+	metricsFactory := jlprom.New().Namespace(metrics.NSOptions{Name: "jaeger_dynamodb"})
+	var checker *health.Checker
+	if configuration.Admin.HTTPAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+
+		checker = health.NewChecker(logger, svc, &health.Options{
+			Tables:   newHealthTables(configuration, tenancyManager),
+			Interval: configuration.Health.Interval,
+			Timeout:  configuration.Health.Timeout,
+		})
+		checker.RegisterHandlers(mux)
+		checker.Start()
+
+		go func() {
+			if err := http.ListenAndServe(configuration.Admin.HTTPAddress, mux); err != nil {
+				log.Fatalf("unable to serve admin http, %v", err)
+			}
+		}()
+	}
+
+	pluginOptions := &plugin.Options{
+		Tenancy:        tenancyManager,
+		MetricsFactory: metricsFactory,
+	}
+	if configuration.StreamingSpanWriter.Enabled {
+		pluginOptions.StreamingSpanWriter = &dynamospanstore.StreamingWriterOptions{
+			QueueSize:  configuration.StreamingSpanWriter.QueueSize,
+			Workers:    configuration.StreamingSpanWriter.Workers,
+			DropPolicy: configuration.StreamingSpanWriter.DropPolicy,
+		}
+	}
+
+	if configuration.WriteAheadLog.Enabled {
+		pluginOptions.WriteAheadLog = &wal.Options{
+			Directory:   configuration.WriteAheadLog.Directory,
+			SegmentSize: configuration.WriteAheadLog.SegmentSize,
+			MaxSize:     configuration.WriteAheadLog.MaxSize,
+			SyncWrites:  configuration.WriteAheadLog.SyncWrites,
+		}
+		pluginOptions.BufferedWriter = &dynamospanstore.BufferedWriterOptions{
+			ReplayInterval: configuration.WriteAheadLog.ReplayInterval,
+		}
+	}
+
+	pluginOptions.RateLimiter = newRateLimiterOptions(configuration)
+	pluginOptions.Scrubber = newScrubberOptions(configuration)
+	pluginOptions.Encryptor, err = newEncryptor(ctx, configuration)
+	if err != nil {
+		log.Fatalf("unable to configure encryption, %v", err)
+	}
+	// A SIGHUP replaces the data key, e.g. after the KMS alias was pointed to a new key
+	if encryptor := pluginOptions.Encryptor; encryptor != nil {
+		rotations := make(chan os.Signal, 1)
+		signal.Notify(rotations, syscall.SIGHUP)
+		go func() {
+			for range rotations {
+				logger.Info("rotating data key")
+				encryptor.Rotate()
+			}
+		}()
+	}
+	pluginOptions.SearchableTags = configuration.Encryption.SearchableTags
+	pluginOptions.DependenciesBucketSize = configuration.Dependencies.BucketSize
+	if aggregator := configuration.Dependencies.Aggregator; aggregator.Enabled {
+		pluginOptions.DependencyAggregator = &dynamodependencystore.AggregatorOptions{
+			FlushInterval: aggregator.FlushInterval,
+			SpanTTL:       aggregator.SpanTTL,
+			CacheSize:     aggregator.CacheSize,
+		}
+	}
+	if configuration.Sampling.Enabled {
+		// Spans matching no rule are kept unless a default rate is configured
+		defaultRate := 1.0
+		if configuration.Sampling.DefaultRate != nil {
+			defaultRate = *configuration.Sampling.DefaultRate
+		}
+		pluginOptions.Sampler = &dynamospanstore.SamplerOptions{
+			DefaultRate:       defaultRate,
+			LatencyThreshold:  configuration.Sampling.LatencyThreshold,
+			DecisionTTL:       configuration.Sampling.DecisionTTL,
+			DecisionCacheSize: configuration.Sampling.DecisionCacheSize,
+		}
+		for _, rule := range configuration.Sampling.Rules {
+			pluginOptions.Sampler.Rules = append(pluginOptions.Sampler.Rules, dynamospanstore.SamplingRule{
+				Service:   rule.Service,
+				Operation: rule.Operation,
+				Rate:      rule.Rate,
+			})
+		}
+	}
+	dynamodbPlugin, err := plugin.NewDynamoDBPlugin(logger, svc, spansTable, servicesTable, operationsTable, dependenciesTable, pluginOptions)
 	if err != nil {
 		log.Fatalf("unable to create plugin, %v", err)
 	}
 
-	logger.Debug("plugin created")
-	grpc.Serve(&shared.PluginServices{
+	pluginServices := &shared.PluginServices{
 		Store:        dynamodbPlugin,
 		ArchiveStore: dynamodbPlugin,
+	}
+	if configuration.StreamingSpanWriter.Enabled {
+		pluginServices.StreamingSpanWriter = dynamodbPlugin
+	}
+
+	// The host stops the plugin after its last requests, but a SIGTERM, e.g. of the container, or a SIGINT
+	// would skip writing the queued spans
+	var shutdownOnce sync.Once
+	shutdown := func() {
+		shutdownOnce.Do(func() {
+			if err := dynamodbPlugin.Close(); err != nil {
+				logger.Error("failed to close plugin", "err", err)
+			}
+			if checker != nil {
+				if err := checker.Close(); err != nil {
+					logger.Error("failed to close health checker", "err", err)
+				}
+			}
+			shutdownTracing()
+		})
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Info("plugin stopping", "signal", sig.String())
+		shutdown()
+		os.Exit(0)
+	}()
+
+	logger.Debug("plugin created")
+	grpc.Serve(pluginServices)
+	shutdown()
+}
+
+func readConfiguration(v *viper.Viper, configPath string) (*pConfig.Configuration, error) {
+	if configPath != "" {
+		v.SetConfigFile(configPath)
+
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("error reading config file, %v", err)
+		}
+	}
+
+	var configuration pConfig.Configuration
+	if err := v.Unmarshal(&configuration); err != nil {
+		return nil, fmt.Errorf("unable to decode into struct, %v", err)
+	}
+	return &configuration, nil
+}
+
+func newDynamoDBClient(ctx context.Context, configuration *pConfig.Configuration, optFns ...func(*dynamodb.Options)) (*dynamodb.Client, error) {
+	return awsconfig.NewDynamoDBClient(ctx, &configuration.DynamoDB, optFns...)
+}
+
+func newTenancyManager(configuration *pConfig.Configuration) (*tenancy.Manager, error) {
+	tenancyManager, err := tenancy.NewManager(&tenancy.Options{
+		Enabled: configuration.Tenancy.Enabled,
+		Header:  configuration.Tenancy.Header,
+		Mode:    configuration.Tenancy.Mode,
+		Tenants: configuration.Tenancy.Tenants,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure tenancy, %v", err)
+	}
+	return tenancyManager, nil
+}
+
+// tableTenants returns the tenants with their own tables. Every tenant has its own tables in the table mode,
+// otherwise all tenants share the default tables of the empty tenant.
+func tableTenants(tenancyManager *tenancy.Manager) []string {
+	if tenancyManager.Mode() == tenancy.ModeTable {
+		return tenancyManager.Tenants()
+	}
+	return []string{""}
+}
+
+// selectTenants returns the tenants whose tables commands work on, all of them unless the tenant flag is set. The
+// flag is rejected without the table mode, as the tables are shared by all tenants then.
+func selectTenants(tenancyManager *tenancy.Manager, tenant string) ([]string, error) {
+	if tenant == "" {
+		return tableTenants(tenancyManager), nil
+	}
+	if tenancyManager.Mode() != tenancy.ModeTable {
+		return nil, fmt.Errorf("--tenant is only supported in the table tenancy mode, other modes share the tables of all tenants")
+	}
+	for _, t := range tenancyManager.Tenants() {
+		if t == tenant {
+			return []string{tenant}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q, it isn't configured", tenancy.ErrUnknownTenant, tenant)
+}
+
+// newHealthTables returns the tables of all tenants, which share the default tables unless the table mode is used
+func newHealthTables(configuration *pConfig.Configuration, tenancyManager *tenancy.Manager) []health.Table {
+	tables := []health.Table{}
+	for _, tenant := range tableTenants(tenancyManager) {
+		tables = append(tables,
+			health.Table{
+				Name:         tenancyManager.Table(tenant, spansTable),
+				TTLAttribute: setup.SpansTimeToLiveAttribute,
+				Stream:       configuration.Health.RequireSpansStream,
+				Indexes:      []string{spanSearchIndex},
+			},
+			health.Table{Name: tenancyManager.Table(tenant, servicesTable), TTLAttribute: setup.SpansTimeToLiveAttribute},
+			health.Table{Name: tenancyManager.Table(tenant, operationsTable), TTLAttribute: setup.SpansTimeToLiveAttribute},
+			health.Table{Name: tenancyManager.Table(tenant, dependenciesTable)},
+		)
+	}
+	return tables
+}
+
+// newRateLimiterOptions returns nil when rate limiting is disabled
+func newRateLimiterOptions(configuration *pConfig.Configuration) *dynamospanstore.RateLimiterOptions {
+	if !configuration.RateLimit.Enabled {
+		return nil
+	}
+	return &dynamospanstore.RateLimiterOptions{
+		InitialRate:    configuration.RateLimit.InitialRate,
+		MinRate:        configuration.RateLimit.MinRate,
+		MaxRate:        configuration.RateLimit.MaxRate,
+		IncreaseStep:   configuration.RateLimit.IncreaseStep,
+		DecreaseFactor: configuration.RateLimit.DecreaseFactor,
+	}
+}
+
+func newScrubberOptions(configuration *pConfig.Configuration) *dynamospanstore.ScrubberOptions {
+	if !configuration.Scrubbing.Enabled {
+		return nil
+	}
+	options := &dynamospanstore.ScrubberOptions{
+		HashKey: configuration.Scrubbing.HashKey,
+	}
+	for _, rule := range configuration.Scrubbing.Rules {
+		options.Rules = append(options.Rules, dynamospanstore.ScrubRule{
+			Keys:         rule.Keys,
+			KeyPattern:   rule.KeyPattern,
+			ValuePattern: rule.ValuePattern,
+			Action:       rule.Action,
+			MaxLength:    rule.MaxLength,
+		})
+	}
+	return options
+}
+
+// newEncryptor returns nil when encryption is disabled
+func newEncryptor(ctx context.Context, configuration *pConfig.Configuration) (*encryption.Encryptor, error) {
+	if !configuration.Encryption.Enabled {
+		return nil, nil
+	}
+
+	var provider encryption.KeyProvider
+	switch configuration.Encryption.KeyProvider {
+	case "kms":
+		// KMS doesn't use the custom DynamoDB endpoint
+		cfg, err := awsconfig.Load(ctx, &configuration.DynamoDB)
+		if err != nil {
+			return nil, err
+		}
+		provider = encryption.NewKMSKeyProvider(kms.NewFromConfig(cfg), configuration.Encryption.KMSKeyID)
+	case "local":
+		keys := map[string][]byte{}
+		for _, key := range configuration.Encryption.LocalKeys {
+			b, err := base64.StdEncoding.DecodeString(key.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid local key %s, %v", key.ID, err)
+			}
+			keys[key.ID] = b
+		}
+		localProvider, err := encryption.NewLocalKeyProvider(keys, configuration.Encryption.ActiveLocalKeyID)
+		if err != nil {
+			return nil, err
+		}
+		provider = localProvider
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", configuration.Encryption.KeyProvider)
+	}
+
+	return encryption.NewEncryptor(provider, &encryption.Options{
+		DataKeyTTL: configuration.Encryption.DataKeyTTL,
 	})
 }
@@ -0,0 +1,391 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+)
+
+const (
+	// Maximum number of requests in a single BatchWriteItem call
+	maxBatchWriteItems = 25
+	maxBatchAttempts   = 8
+	batchRetryDelay    = 50 * time.Millisecond
+	// Upper bound of traces deleted by a single search
+	maxDeletedTraces = 100000
+)
+
+// Actions recorded in the audit log
+const (
+	AuditActionDeleteSpan      = "delete_span"
+	AuditActionDeleteOperation = "delete_operation"
+	AuditActionDeleteService   = "delete_service"
+)
+
+type DeleteAPI interface {
+	dynamospanstore.DynamoDBReaderAPI
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+type DeleteOptions struct {
+	// Table names before the tenant table prefix is applied
+	SpansTable      string
+	ServicesTable   string
+	OperationsTable string
+	Tenancy         *tenancy.Manager
+	// Receives a JSON line per removed item
+	AuditLog io.Writer
+	// Recorded in the audit log, e.g. the ticket of the data protection request
+	Reason string
+	// Only records what would be removed
+	DryRun bool
+}
+
+// AuditEntry is a line of the audit log
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	DryRun    bool      `json:"dryRun,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	TraceID   string    `json:"traceID,omitempty"`
+	SpanID    string    `json:"spanID,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+type DeleteReport struct {
+	Traces     int
+	Spans      int
+	Operations int
+	Services   int
+}
+
+// deletedSpanItem contains the attributes of a span item needed to delete it and its derived items
+type deletedSpanItem struct {
+	TraceID       string
+	SpanID        string
+	ServiceName   string
+	OperationName string
+}
+
+type traceIDSet map[model.TraceID]struct{}
+
+// affectedService contains the deleted traces of a service and its operations
+type affectedService struct {
+	traceIDs   traceIDSet
+	operations map[string]traceIDSet
+}
+
+type Deleter struct {
+	logger  hclog.Logger
+	svc     DeleteAPI
+	reader  *dynamospanstore.Reader
+	options DeleteOptions
+
+	auditMu sync.Mutex
+}
+
+func NewDeleter(logger hclog.Logger, svc DeleteAPI, options *DeleteOptions) *Deleter {
+	return &Deleter{
+		logger:  logger,
+		svc:     svc,
+		options: *options,
+		reader: dynamospanstore.NewReader(logger, svc, options.SpansTable, options.ServicesTable, options.OperationsTable,
+			dynamospanstore.WithReaderTenancy(options.Tenancy)),
+	}
+}
+
+// DeleteTrace removes all spans of a trace of the tenant in the context, and the services and operations without remaining spans
+func (d *Deleter) DeleteTrace(ctx context.Context, traceID model.TraceID) (*DeleteReport, error) {
+	return d.deleteTraces(ctx, []model.TraceID{traceID})
+}
+
+// DeleteTraces removes all traces with spans matching the query, NumTraces is ignored
+func (d *Deleter) DeleteTraces(ctx context.Context, query *spanstore.TraceQueryParameters) (*DeleteReport, error) {
+	q := *query
+	q.NumTraces = maxDeletedTraces
+	traceIDs, err := d.reader.FindTraceIDs(ctx, &q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find traces, %v", err)
+	}
+	if len(traceIDs) == maxDeletedTraces {
+		return nil, fmt.Errorf("more than %d traces match, narrow the time range", maxDeletedTraces)
+	}
+
+	return d.deleteTraces(ctx, traceIDs)
+}
+
+func (d *Deleter) deleteTraces(ctx context.Context, traceIDs []model.TraceID) (*DeleteReport, error) {
+	tenant, err := d.options.Tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant, %v", err)
+	}
+
+	report := &DeleteReport{}
+	affected := map[string]*affectedService{}
+	for _, traceID := range traceIDs {
+		spans, err := d.deleteTrace(ctx, tenant, traceID.String())
+		if err != nil {
+			return report, err
+		}
+		if len(spans) > 0 {
+			report.Traces++
+		}
+		report.Spans += len(spans)
+
+		for _, span := range spans {
+			if span.ServiceName == "" {
+				continue
+			}
+			service, ok := affected[span.ServiceName]
+			if !ok {
+				service = &affectedService{traceIDs: traceIDSet{}, operations: map[string]traceIDSet{}}
+				affected[span.ServiceName] = service
+			}
+			service.traceIDs[traceID] = struct{}{}
+			if span.OperationName != "" {
+				if _, ok := service.operations[span.OperationName]; !ok {
+					service.operations[span.OperationName] = traceIDSet{}
+				}
+				service.operations[span.OperationName][traceID] = struct{}{}
+			}
+		}
+	}
+
+	if err := d.deleteUnusedIndexItems(ctx, tenant, affected, report); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// deleteTrace removes all span items of a trace and returns them
+func (d *Deleter) deleteTrace(ctx context.Context, tenant, traceID string) ([]*deletedSpanItem, error) {
+	keyPrefix := d.options.Tenancy.KeyPrefix(tenant)
+	spansTable := d.options.Tenancy.Table(tenant, d.options.SpansTable)
+
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("TraceID").Equal(expression.Value(keyPrefix + traceID))).
+		WithProjection(expression.NamesList(expression.Name("TraceID"), expression.Name("SpanID"), expression.Name("ServiceName"), expression.Name("OperationName"))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query expression, %v", err)
+	}
+
+	paginator := dynamodb.NewQueryPaginator(d.svc, &dynamodb.QueryInput{
+		TableName:                 aws.String(spansTable),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+
+	spans := []*deletedSpanItem{}
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query spans of trace %s, %v", traceID, err)
+		}
+		for _, av := range output.Items {
+			span := &deletedSpanItem{}
+			if err := attributevalue.UnmarshalMap(av, span); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal span, %v", err)
+			}
+			spans = append(spans, span)
+		}
+	}
+
+	for start := 0; start < len(spans); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(spans) {
+			end = len(spans)
+		}
+
+		requests := []types.WriteRequest{}
+		for _, span := range spans[start:end] {
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: map[string]types.AttributeValue{
+				"TraceID": &types.AttributeValueMemberS{Value: span.TraceID},
+				"SpanID":  &types.AttributeValueMemberS{Value: span.SpanID},
+			}}})
+		}
+		if err := d.batchDelete(ctx, spansTable, requests); err != nil {
+			return nil, fmt.Errorf("failed to delete spans of trace %s, %v", traceID, err)
+		}
+
+		for _, span := range spans[start:end] {
+			if err := d.audit(AuditEntry{Action: AuditActionDeleteSpan, Tenant: tenant, TraceID: traceID, SpanID: span.SpanID, Service: span.ServiceName, Operation: span.OperationName}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	d.logger.Debug("Deleted trace.", "traceID", traceID, "spans", len(spans))
+	return spans, nil
+}
+
+// batchDelete retries unprocessed items with an exponential backoff
+func (d *Deleter) batchDelete(ctx context.Context, table string, requests []types.WriteRequest) error {
+	if d.options.DryRun {
+		return nil
+	}
+
+	delay := batchRetryDelay
+	for attempt := 0; ; attempt++ {
+		output, err := d.svc.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{table: requests},
+		})
+		if err != nil {
+			return err
+		}
+
+		requests = output.UnprocessedItems[table]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt+1 >= maxBatchAttempts {
+			return fmt.Errorf("%d items remain unprocessed", len(requests))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// deleteUnusedIndexItems removes operations and services of the deleted spans without any remaining spans
+func (d *Deleter) deleteUnusedIndexItems(ctx context.Context, tenant string, affected map[string]*affectedService, report *DeleteReport) error {
+	keyPrefix := d.options.Tenancy.KeyPrefix(tenant)
+
+	serviceNames := make([]string, 0, len(affected))
+	for serviceName := range affected {
+		serviceNames = append(serviceNames, serviceName)
+	}
+	sort.Strings(serviceNames)
+
+	for _, serviceName := range serviceNames {
+		service := affected[serviceName]
+		operationNames := make([]string, 0, len(service.operations))
+		for operationName := range service.operations {
+			operationNames = append(operationNames, operationName)
+		}
+		sort.Strings(operationNames)
+
+		for _, operationName := range operationNames {
+			used, err := d.hasOtherTraces(ctx, serviceName, operationName, service.operations[operationName])
+			if err != nil {
+				return err
+			}
+			if used {
+				continue
+			}
+
+			if err := d.deleteItem(ctx, d.options.Tenancy.Table(tenant, d.options.OperationsTable), map[string]types.AttributeValue{
+				"ServiceName": &types.AttributeValueMemberS{Value: keyPrefix + serviceName},
+				"Name":        &types.AttributeValueMemberS{Value: operationName},
+			}); err != nil {
+				return fmt.Errorf("failed to delete operation %s of %s, %v", operationName, serviceName, err)
+			}
+			report.Operations++
+			if err := d.audit(AuditEntry{Action: AuditActionDeleteOperation, Tenant: tenant, Service: serviceName, Operation: operationName}); err != nil {
+				return err
+			}
+		}
+
+		used, err := d.hasOtherTraces(ctx, serviceName, "", service.traceIDs)
+		if err != nil {
+			return err
+		}
+		if used {
+			continue
+		}
+
+		if err := d.deleteItem(ctx, d.options.Tenancy.Table(tenant, d.options.ServicesTable), map[string]types.AttributeValue{
+			"Name": &types.AttributeValueMemberS{Value: keyPrefix + serviceName},
+		}); err != nil {
+			return fmt.Errorf("failed to delete service %s, %v", serviceName, err)
+		}
+		report.Services++
+		if err := d.audit(AuditEntry{Action: AuditActionDeleteService, Tenant: tenant, Service: serviceName}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasOtherTraces returns whether spans of the service and operation remain in traces which weren't deleted.
+// The deleted traces are ignored, as they are still found in dry runs and the eventually consistent index.
+func (d *Deleter) hasOtherTraces(ctx context.Context, serviceName, operationName string, deleted traceIDSet) (bool, error) {
+	traceIDs, err := d.reader.FindTraceIDs(ctx, &spanstore.TraceQueryParameters{
+		ServiceName:   serviceName,
+		OperationName: operationName,
+		StartTimeMin:  time.Unix(0, 0),
+		StartTimeMax:  time.Now().Add(24 * time.Hour),
+		NumTraces:     len(deleted) + 1,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to find remaining spans of %s, %v", serviceName, err)
+	}
+
+	for _, traceID := range traceIDs {
+		if _, ok := deleted[traceID]; !ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *Deleter) deleteItem(ctx context.Context, table string, key map[string]types.AttributeValue) error {
+	if d.options.DryRun {
+		return nil
+	}
+
+	_, err := d.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key:       key,
+	})
+	return err
+}
+
+func (d *Deleter) audit(entry AuditEntry) error {
+	if d.options.AuditLog == nil {
+		return nil
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.DryRun = d.options.DryRun
+	entry.Reason = d.options.Reason
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry, %v", err)
+	}
+
+	d.auditMu.Lock()
+	defer d.auditMu.Unlock()
+	if _, err := d.options.AuditLog.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log, %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAuditLog(assert *assert.Assertions, b *bytes.Buffer) []AuditEntry {
+	entries := []AuditEntry{}
+	decoder := json.NewDecoder(b)
+	for decoder.More() {
+		entry := AuditEntry{}
+		assert.NoError(decoder.Decode(&entry))
+		entry.Time = time.Time{}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestDeleteTrace(t *testing.T) {
+	assert := assert.New(t)
+	logger := hclog.NewNullLogger()
+	svc := createFake(assert, context.TODO())
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+	ctx := tenancy.WithTenant(context.TODO(), "acme")
+
+	writer, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable, dynamospanstore.WithWriterTenancy(manager))
+	assert.NoError(err)
+	child := testSpan(1, "checkout", "pay", "")
+	child.SpanID = model.NewSpanID(2)
+	for _, span := range []*model.Span{testSpan(1, "frontend", "GET /", "server"), child, testSpan(2, "frontend", "GET /", "server")} {
+		assert.NoError(writer.WriteSpan(ctx, span))
+	}
+	// Another tenant's trace with the same ID remains
+	assert.NoError(writer.WriteSpan(tenancy.WithTenant(context.TODO(), "other"), testSpan(1, "checkout", "pay", "")))
+
+	var auditLog bytes.Buffer
+	deleter := NewDeleter(logger, svc, &DeleteOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+		Tenancy:         manager,
+		AuditLog:        &auditLog,
+		Reason:          "DSR-1",
+	})
+	report, err := deleter.DeleteTrace(ctx, model.NewTraceID(0, 1))
+	assert.NoError(err)
+	assert.Equal(&DeleteReport{Traces: 1, Spans: 2, Operations: 1, Services: 1}, report)
+
+	traceID := model.NewTraceID(0, 1).String()
+	assert.Equal([]AuditEntry{
+		{Action: AuditActionDeleteSpan, Tenant: "acme", TraceID: traceID, SpanID: model.NewSpanID(1).String(), Service: "frontend", Operation: "GET /", Reason: "DSR-1"},
+		{Action: AuditActionDeleteSpan, Tenant: "acme", TraceID: traceID, SpanID: model.NewSpanID(2).String(), Service: "checkout", Operation: "pay", Reason: "DSR-1"},
+		{Action: AuditActionDeleteOperation, Tenant: "acme", Service: "checkout", Operation: "pay", Reason: "DSR-1"},
+		{Action: AuditActionDeleteService, Tenant: "acme", Service: "checkout", Reason: "DSR-1"},
+	}, readAuditLog(assert, &auditLog))
+
+	reader := dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable, dynamospanstore.WithReaderTenancy(manager))
+	_, err = reader.GetTrace(ctx, model.NewTraceID(0, 1))
+	assert.Equal(spanstore.ErrTraceNotFound, err)
+	_, err = reader.GetTrace(ctx, model.NewTraceID(0, 2))
+	assert.NoError(err)
+
+	// The frontend service remains with the second trace
+	services, err := reader.GetServices(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{"frontend"}, services)
+
+	_, err = reader.GetTrace(tenancy.WithTenant(context.TODO(), "other"), model.NewTraceID(0, 1))
+	assert.NoError(err)
+	services, err = reader.GetServices(tenancy.WithTenant(context.TODO(), "other"))
+	assert.NoError(err)
+	assert.Equal([]string{"checkout"}, services)
+}
+
+func TestDeleteTraces(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	logger := hclog.NewNullLogger()
+	svc := createFake(assert, ctx)
+
+	writer, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable)
+	assert.NoError(err)
+	for i := uint64(1); i <= 10; i++ {
+		span := testSpan(i, "frontend", "GET /", "server")
+		if i%2 == 0 {
+			span.Tags = append(span.Tags, model.String("user.email", "jane@example.com"))
+		}
+		assert.NoError(writer.WriteSpan(ctx, span))
+	}
+
+	options := &DeleteOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+	}
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		Tags:         map[string]string{"user.email": "jane@example.com"},
+		StartTimeMin: time.Now().Add(-time.Hour),
+		StartTimeMax: time.Now().Add(time.Hour),
+		NumTraces:    1,
+	}
+
+	var auditLog bytes.Buffer
+	dryRunOptions := *options
+	dryRunOptions.DryRun = true
+	dryRunOptions.AuditLog = &auditLog
+	report, err := NewDeleter(logger, svc, &dryRunOptions).DeleteTraces(ctx, query)
+	assert.NoError(err)
+	assert.Equal(&DeleteReport{Traces: 5, Spans: 5}, report)
+	entries := readAuditLog(assert, &auditLog)
+	assert.Len(entries, 5)
+	assert.True(entries[0].DryRun)
+
+	report, err = NewDeleter(logger, svc, options).DeleteTraces(ctx, query)
+	assert.NoError(err)
+	assert.Equal(&DeleteReport{Traces: 5, Spans: 5}, report)
+
+	reader := dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable)
+	query.NumTraces = 20
+	query.Tags = nil
+	traceIDs, err := reader.FindTraceIDs(ctx, query)
+	assert.NoError(err)
+	assert.ElementsMatch([]model.TraceID{
+		model.NewTraceID(0, 1), model.NewTraceID(0, 3), model.NewTraceID(0, 5), model.NewTraceID(0, 7), model.NewTraceID(0, 9),
+	}, traceIDs)
+
+	// Deleting the remaining traces removes the service and operation
+	report, err = NewDeleter(logger, svc, options).DeleteTraces(ctx, query)
+	assert.NoError(err)
+	assert.Equal(&DeleteReport{Traces: 5, Spans: 5, Operations: 1, Services: 1}, report)
+	services, err := reader.GetServices(ctx)
+	assert.NoError(err)
+	assert.Empty(services)
+}
@@ -0,0 +1,356 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/dependencystream"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+const defaultParentLookback = time.Hour
+
+type RecomputeDependenciesAPI interface {
+	DynamoDBAPI
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+type RecomputeDependenciesOptions struct {
+	SpansTable        string
+	DependenciesTable string
+	// All buckets overlapping the time range are recomputed
+	Start time.Time
+	End   time.Time
+	// Size of the dependency buckets, defaults to an hour
+	BucketSize time.Duration
+	// Spans starting up to this long before the range are read as parents of spans in the range, defaults to an hour
+	ParentLookback time.Duration
+	// Number of segments of the spans table scanned in parallel
+	Segments int
+	// Read capacity units consumed by the scan per second, unlimited when zero
+	MaxReadRate float64
+	// Dependency writes and deletes per second, unlimited when zero
+	MaxWriteRate float64
+	// Only reports the dependency items which would be written and deleted
+	DryRun bool
+	// Called periodically with the current progress
+	Progress         func(report RecomputeDependenciesReport)
+	ProgressInterval time.Duration
+}
+
+type RecomputeDependenciesReport struct {
+	// Approximate number of spans in the table, DynamoDB updates it about every six hours
+	TotalSpans        int64
+	ScannedSpans      int64
+	ConsumedReadUnits float64
+	// Calls counted in the range
+	Calls int64
+	// References to parents which weren't found, e.g. because they started before the parent lookback
+	UnresolvedParents int64
+	// Dependency items replaced and removed from the range
+	Written int64
+	Deleted int64
+}
+
+type dependencyItemKey struct {
+	Key            string
+	CallTimeBucket int64
+}
+
+type recomputer struct {
+	logger       hclog.Logger
+	svc          RecomputeDependenciesAPI
+	options      RecomputeDependenciesOptions
+	writeLimiter *rate.Limiter
+	firstBucket  int64
+	lastBucket   int64
+
+	mu    sync.Mutex
+	spans []*dependencystream.Span
+
+	scannedSpans      int64
+	consumedReadUnits uint64
+	calls             int64
+	unresolvedParents int64
+	written           int64
+	deleted           int64
+}
+
+// RecomputeDependencies replaces the dependencies of a time range with the calls between the spans in the range. The
+// spans are scanned and matched with their parents in memory, so large ranges should be recomputed in parts. Calls
+// written by the dependency lambda meanwhile are replaced as well, so ranges should end before the spans being
+// written.
+func RecomputeDependencies(ctx context.Context, logger hclog.Logger, svc RecomputeDependenciesAPI, options *RecomputeDependenciesOptions) (*RecomputeDependenciesReport, error) {
+	r := &recomputer{
+		logger:       logger,
+		svc:          svc,
+		options:      *options,
+		writeLimiter: rate.NewLimiter(rate.Inf, 1),
+	}
+	if !r.options.End.After(r.options.Start) {
+		return nil, errors.New("end has to be after start")
+	}
+	if r.options.BucketSize <= 0 {
+		r.options.BucketSize = dynamodependencystore.DefaultBucketSize
+	}
+	if r.options.ParentLookback <= 0 {
+		r.options.ParentLookback = defaultParentLookback
+	}
+	if r.options.Segments <= 0 {
+		r.options.Segments = defaultSegments
+	}
+	if r.options.ProgressInterval <= 0 {
+		r.options.ProgressInterval = defaultProgressInterval
+	}
+	if r.options.MaxWriteRate > 0 {
+		r.writeLimiter = rate.NewLimiter(rate.Limit(r.options.MaxWriteRate), int(math.Max(1, r.options.MaxWriteRate)))
+	}
+	r.firstBucket = dynamodependencystore.TimeToBucketOfSize(r.options.Start, r.options.BucketSize)
+	r.lastBucket = dynamodependencystore.TimeToBucketOfSize(r.options.End.Add(-time.Nanosecond), r.options.BucketSize)
+
+	table, err := svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(r.options.SpansTable)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe spans table, %v", err)
+	}
+	totalSpans := table.Table.ItemCount
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	if r.options.Progress != nil {
+		go func() {
+			ticker := time.NewTicker(r.options.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-progressCtx.Done():
+					return
+				case <-ticker.C:
+					r.options.Progress(r.report(totalSpans))
+				}
+			}
+		}()
+	}
+
+	err = r.recompute(ctx)
+	report := r.report(totalSpans)
+	return &report, err
+}
+
+func (r *recomputer) recompute(ctx context.Context) error {
+	g, gCtx := errgroup.WithContext(ctx)
+	for segment := 0; segment < r.options.Segments; segment++ {
+		segment := int32(segment)
+		g.Go(func() error {
+			return r.scanSegment(gCtx, segment)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	callCounts, statistics := dependencystream.CountDependencyCalls(r.spans, r.options.BucketSize)
+	atomic.StoreInt64(&r.unresolvedParents, int64(statistics.FetchedSpans))
+
+	// Write the new items first, so the range is never empty while it's recomputed
+	items := map[dependencyItemKey]bool{}
+	for bucket, dependencyCallCounts := range callCounts {
+		// Calls of the parents before the range are only counted in the recomputation of their range
+		if bucket.CallTimeBucket < r.firstBucket || bucket.CallTimeBucket > r.lastBucket {
+			continue
+		}
+		for dependency, statistics := range dependencyCallCounts.Services {
+			atomic.AddInt64(&r.calls, int64(statistics.CallCount))
+			if err := r.put(ctx, items, dynamodependencystore.NewServiceDependencyItem(bucket.KeyPrefix, dependency, bucket.CallTimeBucket, statistics)); err != nil {
+				return err
+			}
+		}
+		for dependency, statistics := range dependencyCallCounts.Operations {
+			if err := r.put(ctx, items, dynamodependencystore.NewOperationDependencyItem(bucket.KeyPrefix, dependency, bucket.CallTimeBucket, statistics)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.deleteStale(ctx, items)
+}
+
+func (r *recomputer) report(totalSpans int64) RecomputeDependenciesReport {
+	return RecomputeDependenciesReport{
+		TotalSpans:        totalSpans,
+		ScannedSpans:      atomic.LoadInt64(&r.scannedSpans),
+		ConsumedReadUnits: math.Float64frombits(atomic.LoadUint64(&r.consumedReadUnits)),
+		Calls:             atomic.LoadInt64(&r.calls),
+		UnresolvedParents: atomic.LoadInt64(&r.unresolvedParents),
+		Written:           atomic.LoadInt64(&r.written),
+		Deleted:           atomic.LoadInt64(&r.deleted),
+	}
+}
+
+func (r *recomputer) addConsumedReadUnits(units float64) {
+	for {
+		old := atomic.LoadUint64(&r.consumedReadUnits)
+		if atomic.CompareAndSwapUint64(&r.consumedReadUnits, old, math.Float64bits(math.Float64frombits(old)+units)) {
+			return
+		}
+	}
+}
+
+func (r *recomputer) scanSegment(ctx context.Context, segment int32) error {
+	// Spans are filtered by their start time in nanoseconds
+	start := time.Unix(r.firstBucket, 0).Add(-r.options.ParentLookback).UnixNano()
+	end := time.Unix(r.lastBucket, 0).Add(r.options.BucketSize).UnixNano() - 1
+
+	paginator := dynamodb.NewScanPaginator(r.svc, &dynamodb.ScanInput{
+		TableName:     aws.String(r.options.SpansTable),
+		Segment:       aws.Int32(segment),
+		TotalSegments: aws.Int32(int32(r.options.Segments)),
+		// Error tags contain a dot, so this can't be built with the expression builder
+		ProjectionExpression: aws.String("TraceID, SpanID, #references, ServiceName, OperationName, StartTime, #duration, #searchableTags.#error, #searchableTags.#statusCode"),
+		FilterExpression:     aws.String("StartTime BETWEEN :start AND :end"),
+		ExpressionAttributeNames: map[string]string{
+			"#references":     "References",
+			"#duration":       "Duration",
+			"#searchableTags": "SearchableTags",
+			"#error":          "error",
+			"#statusCode":     "otel.status_code",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":start": &types.AttributeValueMemberN{Value: strconv.FormatInt(start, 10)},
+			":end":   &types.AttributeValueMemberN{Value: strconv.FormatInt(end, 10)},
+		},
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan segment %d, %v", segment, err)
+		}
+
+		spans := make([]*dependencystream.Span, len(output.Items))
+		for i, item := range output.Items {
+			if spans[i], err = dependencystream.ParseSpanItem(item); err != nil {
+				return err
+			}
+		}
+		r.mu.Lock()
+		r.spans = append(r.spans, spans...)
+		r.mu.Unlock()
+		atomic.AddInt64(&r.scannedSpans, int64(len(output.Items)))
+
+		// Each segment gets an equal share of the read rate
+		if output.ConsumedCapacity != nil {
+			units := aws.ToFloat64(output.ConsumedCapacity.CapacityUnits)
+			r.addConsumedReadUnits(units)
+			if r.options.MaxReadRate > 0 {
+				delay := time.Duration(units / (r.options.MaxReadRate / float64(r.options.Segments)) * float64(time.Second))
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+	}
+
+	r.logger.Debug("Scanned segment.", "segment", segment)
+	return nil
+}
+
+// put replaces the stored dependency item and records its key
+func (r *recomputer) put(ctx context.Context, items map[dependencyItemKey]bool, item *dynamodependencystore.DependencyItem) error {
+	items[dependencyItemKey{Key: item.Key, CallTimeBucket: item.CallTimeBucket}] = true
+	atomic.AddInt64(&r.written, 1)
+	if r.options.DryRun {
+		return nil
+	}
+
+	av, err := dynamodependencystore.MarshalDependencyItem(item)
+	if err != nil {
+		return err
+	}
+	if err := r.writeLimiter.Wait(ctx); err != nil {
+		return err
+	}
+	if _, err := r.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.options.DependenciesTable),
+		Item:      av,
+	}); err != nil {
+		return fmt.Errorf("failed to put dependency item, %v", err)
+	}
+	return nil
+}
+
+// deleteStale removes the dependency items of the range which weren't recomputed
+func (r *recomputer) deleteStale(ctx context.Context, items map[dependencyItemKey]bool) error {
+	// Skips the checkpoints stored next to the dependencies
+	filter := expression.Name("CallTimeBucket").Between(expression.Value(r.firstBucket), expression.Value(r.lastBucket)).
+		And(expression.Name("Parent").AttributeExists())
+	expr, err := expression.NewBuilder().
+		WithFilter(filter).
+		WithProjection(expression.NamesList(expression.Name("Key"), expression.Name("CallTimeBucket"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build scan expression, %v", err)
+	}
+
+	stale := []dependencyItemKey{}
+	paginator := dynamodb.NewScanPaginator(r.svc, &dynamodb.ScanInput{
+		TableName:                 aws.String(r.options.DependenciesTable),
+		ProjectionExpression:      expr.Projection(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan dependencies, %v", err)
+		}
+		for _, av := range output.Items {
+			key := dependencyItemKey{}
+			if err := attributevalue.UnmarshalMap(av, &key); err != nil {
+				return fmt.Errorf("failed to unmarshal dependency item, %v", err)
+			}
+			if !items[key] {
+				stale = append(stale, key)
+			}
+		}
+	}
+
+	for _, key := range stale {
+		atomic.AddInt64(&r.deleted, 1)
+		if r.options.DryRun {
+			continue
+		}
+		if err := r.writeLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		if _, err := r.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(r.options.DependenciesTable),
+			Key: map[string]types.AttributeValue{
+				"Key":            &types.AttributeValueMemberS{Value: key.Key},
+				"CallTimeBucket": &types.AttributeValueMemberN{Value: strconv.FormatInt(key.CallTimeBucket, 10)},
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to delete dependency item, %v", err)
+		}
+	}
+	return nil
+}
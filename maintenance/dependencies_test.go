@@ -0,0 +1,125 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/assert"
+)
+
+const dependenciesTable = "jaeger.dependencies"
+
+func dependencySpan(traceID uint64, spanID model.SpanID, parentID model.SpanID, service string, operation string, startTime time.Time, tags ...model.KeyValue) *model.Span {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, traceID),
+		SpanID:        spanID,
+		OperationName: operation,
+		StartTime:     startTime,
+		Duration:      20 * time.Millisecond,
+		Process:       &model.Process{ServiceName: service},
+		Tags:          tags,
+	}
+	if parentID != 0 {
+		span.References = []model.SpanRef{model.NewChildOfRef(span.TraceID, parentID)}
+	}
+	return span
+}
+
+func TestRecomputeDependencies(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	logger := hclog.NewNullLogger()
+
+	svc := createFake(assert, ctx)
+	assert.NoError(setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
+		DependenciesTable: dependenciesTable,
+	}))
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+	acmeCtx := tenancy.WithTenant(ctx, "acme")
+
+	start := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	writer, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable, dynamospanstore.WithWriterTenancy(manager))
+	assert.NoError(err)
+	for _, span := range []*model.Span{
+		dependencySpan(1, 1, 0, "jaeger", "Export", start.Add(time.Minute)),
+		dependencySpan(1, 2, 1, "dynamodb-plugin", "WriteSpan", start.Add(time.Minute), model.Bool("error", true)),
+		// The parent started before the range
+		dependencySpan(2, 1, 0, "jaeger", "Export", start.Add(-30*time.Minute)),
+		dependencySpan(2, 2, 1, "dynamodb-plugin", "WriteSpan", start.Add(10*time.Minute)),
+		// Calls before the range aren't replaced
+		dependencySpan(3, 2, 1, "dynamodb-plugin", "WriteSpan", start.Add(-20*time.Minute)),
+	} {
+		assert.NoError(writer.WriteSpan(acmeCtx, span))
+	}
+
+	// Calls counted twice, a stale edge and an edge before the range
+	prefix := manager.KeyPrefix("acme")
+	statistics := &dynamodependencystore.CallStatistics{}
+	for i := 0; i < 10; i++ {
+		statistics.AddCall(time.Second, false)
+	}
+	callTimeBucket := dynamodependencystore.TimeToBucket(start)
+	for _, item := range []*dynamodependencystore.DependencyItem{
+		dynamodependencystore.NewServiceDependencyItem(prefix, dynamodependencystore.ServiceDependency{Parent: "jaeger", Child: "dynamodb-plugin"}, callTimeBucket, statistics),
+		dynamodependencystore.NewServiceDependencyItem(prefix, dynamodependencystore.ServiceDependency{Parent: "frontend", Child: "checkout"}, callTimeBucket, statistics),
+		dynamodependencystore.NewServiceDependencyItem(prefix, dynamodependencystore.ServiceDependency{Parent: "frontend", Child: "billing"}, callTimeBucket-3600, statistics),
+	} {
+		av, err := dynamodependencystore.MarshalDependencyItem(item)
+		assert.NoError(err)
+		_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(dependenciesTable), Item: av})
+		assert.NoError(err)
+	}
+
+	options := &RecomputeDependenciesOptions{
+		SpansTable:        spansTable,
+		DependenciesTable: dependenciesTable,
+		Start:             start.Add(30 * time.Minute),
+		End:               start.Add(time.Hour),
+		Segments:          2,
+		DryRun:            true,
+	}
+	report, err := RecomputeDependencies(ctx, logger, svc, options)
+	assert.NoError(err)
+	assert.Equal(RecomputeDependenciesReport{
+		TotalSpans:        5,
+		ScannedSpans:      5,
+		ConsumedReadUnits: report.ConsumedReadUnits,
+		Calls:             2,
+		UnresolvedParents: 1,
+		Written:           2,
+		Deleted:           1,
+	}, *report)
+
+	reader := dynamodependencystore.NewReader(logger, svc, dependenciesTable, dynamodependencystore.WithReaderTenancy(manager))
+	dependencies, err := reader.GetDependencyStatistics(acmeCtx, start.Add(time.Hour), 2*time.Hour)
+	assert.NoError(err)
+	assert.Len(dependencies, 3)
+
+	options.DryRun = false
+	_, err = RecomputeDependencies(ctx, logger, svc, options)
+	assert.NoError(err)
+
+	expected := &dynamodependencystore.CallStatistics{}
+	expected.AddCall(20*time.Millisecond, true)
+	expected.AddCall(20*time.Millisecond, false)
+	dependencies, err = reader.GetDependencyStatistics(acmeCtx, start.Add(time.Hour), 2*time.Hour)
+	assert.NoError(err)
+	assert.ElementsMatch([]dynamodependencystore.DependencyLinkStatistics{
+		{ServiceDependency: dynamodependencystore.ServiceDependency{Parent: "jaeger", Child: "dynamodb-plugin"}, CallStatistics: *expected},
+		{ServiceDependency: dynamodependencystore.ServiceDependency{Parent: "frontend", Child: "billing"}, CallStatistics: *statistics},
+	}, dependencies)
+
+	operations, err := reader.GetOperationDependencies(acmeCtx, start.Add(time.Hour), time.Hour)
+	assert.NoError(err)
+	assert.Len(operations, 1)
+}
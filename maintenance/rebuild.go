@@ -0,0 +1,302 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultSegments         = 4
+	defaultProgressInterval = 10 * time.Second
+)
+
+type DynamoDBAPI interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+}
+
+type RebuildOptions struct {
+	SpansTable      string
+	ServicesTable   string
+	OperationsTable string
+	// Splits the tenant key prefix off the trace IDs in the key mode
+	Tenancy *tenancy.Manager
+	// Number of segments of the spans table scanned in parallel
+	Segments int
+	// Read capacity units consumed by the scan per second, unlimited when zero
+	MaxReadRate float64
+	// Service and operation writes per second, unlimited when zero
+	MaxWriteRate float64
+	// Called periodically with the current progress
+	Progress         func(report RebuildReport)
+	ProgressInterval time.Duration
+}
+
+type RebuildReport struct {
+	// Approximate number of spans in the table, DynamoDB updates it about every six hours
+	TotalSpans        int64
+	ScannedSpans      int64
+	ConsumedReadUnits float64
+	// Written items, items with a later expiry already in the table aren't counted
+	Services   int64
+	Operations int64
+}
+
+// spanIndexItem contains the attributes of a span item the services and operations are derived from
+type spanIndexItem struct {
+	TraceID        string
+	ServiceName    string
+	OperationName  string
+	ExpiresAfter   int64
+	SearchableTags map[string]string
+}
+
+type rebuilder struct {
+	logger       hclog.Logger
+	svc          DynamoDBAPI
+	options      RebuildOptions
+	writeLimiter *rate.Limiter
+
+	mu sync.Mutex
+	// Latest expiry written per service and operation
+	services   map[string]int64
+	operations map[string]int64
+
+	scannedSpans      int64
+	consumedReadUnits uint64
+	writtenServices   int64
+	writtenOperations int64
+}
+
+// RebuildIndexes regenerates the services and operations of all spans with a parallel scan of the spans table.
+// Existing items are only replaced when the spans expire later, so it is safe to run next to the plugin.
+func RebuildIndexes(ctx context.Context, logger hclog.Logger, svc DynamoDBAPI, options *RebuildOptions) (*RebuildReport, error) {
+	r := &rebuilder{
+		logger:       logger,
+		svc:          svc,
+		options:      *options,
+		writeLimiter: rate.NewLimiter(rate.Inf, 1),
+		services:     map[string]int64{},
+		operations:   map[string]int64{},
+	}
+	if r.options.Segments <= 0 {
+		r.options.Segments = defaultSegments
+	}
+	if r.options.ProgressInterval <= 0 {
+		r.options.ProgressInterval = defaultProgressInterval
+	}
+	if r.options.MaxWriteRate > 0 {
+		r.writeLimiter = rate.NewLimiter(rate.Limit(r.options.MaxWriteRate), int(math.Max(1, r.options.MaxWriteRate)))
+	}
+
+	table, err := svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(r.options.SpansTable)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe spans table, %v", err)
+	}
+	totalSpans := table.Table.ItemCount
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	if r.options.Progress != nil {
+		go func() {
+			ticker := time.NewTicker(r.options.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-progressCtx.Done():
+					return
+				case <-ticker.C:
+					r.options.Progress(r.report(totalSpans))
+				}
+			}
+		}()
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+	for segment := 0; segment < r.options.Segments; segment++ {
+		segment := int32(segment)
+		g.Go(func() error {
+			return r.scanSegment(gCtx, segment)
+		})
+	}
+	err = g.Wait()
+
+	report := r.report(totalSpans)
+	return &report, err
+}
+
+func (r *rebuilder) report(totalSpans int64) RebuildReport {
+	return RebuildReport{
+		TotalSpans:        totalSpans,
+		ScannedSpans:      atomic.LoadInt64(&r.scannedSpans),
+		ConsumedReadUnits: math.Float64frombits(atomic.LoadUint64(&r.consumedReadUnits)),
+		Services:          atomic.LoadInt64(&r.writtenServices),
+		Operations:        atomic.LoadInt64(&r.writtenOperations),
+	}
+}
+
+func (r *rebuilder) addConsumedReadUnits(units float64) {
+	for {
+		old := atomic.LoadUint64(&r.consumedReadUnits)
+		if atomic.CompareAndSwapUint64(&r.consumedReadUnits, old, math.Float64bits(math.Float64frombits(old)+units)) {
+			return
+		}
+	}
+}
+
+func (r *rebuilder) scanSegment(ctx context.Context, segment int32) error {
+	paginator := dynamodb.NewScanPaginator(r.svc, &dynamodb.ScanInput{
+		TableName:     aws.String(r.options.SpansTable),
+		Segment:       aws.Int32(segment),
+		TotalSegments: aws.Int32(int32(r.options.Segments)),
+		// Span kind tags contain a dot, so this can't be built with the expression builder
+		ProjectionExpression:     aws.String("TraceID, ServiceName, OperationName, ExpiresAfter, #searchableTags.#spanKind"),
+		ExpressionAttributeNames: map[string]string{"#searchableTags": "SearchableTags", "#spanKind": "span.kind"},
+		ReturnConsumedCapacity:   types.ReturnConsumedCapacityTotal,
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan segment %d, %v", segment, err)
+		}
+
+		for _, av := range output.Items {
+			item := &spanIndexItem{}
+			if err := attributevalue.UnmarshalMap(av, item); err != nil {
+				return fmt.Errorf("failed to unmarshal span, %v", err)
+			}
+			if err := r.rebuildItem(ctx, item); err != nil {
+				return err
+			}
+		}
+		atomic.AddInt64(&r.scannedSpans, int64(len(output.Items)))
+
+		// Each segment gets an equal share of the read rate
+		if output.ConsumedCapacity != nil {
+			units := aws.ToFloat64(output.ConsumedCapacity.CapacityUnits)
+			r.addConsumedReadUnits(units)
+			if r.options.MaxReadRate > 0 {
+				delay := time.Duration(units / (r.options.MaxReadRate / float64(r.options.Segments)) * float64(time.Second))
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+	}
+
+	r.logger.Debug("Scanned segment.", "segment", segment)
+	return nil
+}
+
+// newer records the expiry of a key and returns whether it is later than the last one written
+func (r *rebuilder) newer(written map[string]int64, key string, expiresAfter int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if last, ok := written[key]; ok && last >= expiresAfter {
+		return false
+	}
+	written[key] = expiresAfter
+	return true
+}
+
+func (r *rebuilder) rebuildItem(ctx context.Context, item *spanIndexItem) error {
+	if item.ServiceName == "" {
+		return nil
+	}
+
+	keyPrefix := ""
+	if r.options.Tenancy.Mode() == tenancy.ModeKey {
+		keyPrefix, _ = tenancy.SplitKey(item.TraceID)
+	}
+	serviceName := keyPrefix + item.ServiceName
+
+	if r.newer(r.services, serviceName, item.ExpiresAfter) {
+		written, err := r.putIfNewer(ctx, r.options.ServicesTable, item.ExpiresAfter, &dynamospanstore.ServiceItem{
+			Name:         serviceName,
+			ExpiresAfter: item.ExpiresAfter,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write service item, %v", err)
+		}
+		if written {
+			atomic.AddInt64(&r.writtenServices, 1)
+		}
+	}
+
+	if item.OperationName == "" {
+		return nil
+	}
+	if r.newer(r.operations, fmt.Sprintf("%s__%s", serviceName, item.OperationName), item.ExpiresAfter) {
+		written, err := r.putIfNewer(ctx, r.options.OperationsTable, item.ExpiresAfter, &dynamospanstore.OperationItem{
+			Name:         item.OperationName,
+			ServiceName:  serviceName,
+			SpanKind:     item.SearchableTags["span.kind"],
+			ExpiresAfter: item.ExpiresAfter,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write operation item, %v", err)
+		}
+		if written {
+			atomic.AddInt64(&r.writtenOperations, 1)
+		}
+	}
+
+	return nil
+}
+
+// putIfNewer writes an item unless the existing item expires later, e.g. because the plugin wrote it meanwhile
+func (r *rebuilder) putIfNewer(ctx context.Context, table string, expiresAfter int64, item interface{}) (bool, error) {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal item, %v", err)
+	}
+
+	condition := expression.AttributeNotExists(expression.Name("ExpiresAfter")).Or(
+		expression.Name("ExpiresAfter").LessThan(expression.Value(expiresAfter)))
+	expr, err := expression.NewBuilder().WithCondition(condition).Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build condition expression, %v", err)
+	}
+
+	if err := r.writeLimiter.Wait(ctx); err != nil {
+		return false, err
+	}
+	_, err = r.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(table),
+		Item:                      av,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
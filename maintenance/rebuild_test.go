@@ -0,0 +1,168 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spansTable      = "jaeger.spans"
+	servicesTable   = "jaeger.services"
+	operationsTable = "jaeger.operations"
+)
+
+func createFake(assert *assert.Assertions, ctx context.Context) *dynamodbfake.Client {
+	svc := dynamodbfake.New(&dynamodbfake.Options{MaxPageSize: 3})
+	assert.NoError(setup.RecreateSpanStoreTables(ctx, svc, &setup.SetupSpanOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+	}))
+	return svc
+}
+
+// clearTable deletes all items, like an expired or lost table
+func clearTable(assert *assert.Assertions, ctx context.Context, svc *dynamodbfake.Client, table string, keys ...string) {
+	paginator := dynamodb.NewScanPaginator(svc, &dynamodb.ScanInput{TableName: aws.String(table)})
+	items := []map[string]types.AttributeValue{}
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		assert.NoError(err)
+		items = append(items, output.Items...)
+	}
+
+	for _, item := range items {
+		key := map[string]types.AttributeValue{}
+		for _, k := range keys {
+			key[k] = item[k]
+		}
+		_, err := svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(table), Key: key})
+		assert.NoError(err)
+	}
+}
+
+func testSpan(traceID uint64, service, operation, spanKind string) *model.Span {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, traceID),
+		SpanID:        model.NewSpanID(traceID),
+		OperationName: operation,
+		StartTime:     time.Now(),
+		Process:       &model.Process{ServiceName: service},
+	}
+	if spanKind != "" {
+		span.Tags = append(span.Tags, model.String("span.kind", spanKind))
+	}
+	return span
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	logger := hclog.NewNullLogger()
+
+	svc := createFake(assert, ctx)
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+
+	writer, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable, dynamospanstore.WithWriterTenancy(manager))
+	assert.NoError(err)
+	spans := map[string][]*model.Span{
+		"acme": {
+			testSpan(1, "frontend", "GET /", "server"),
+			testSpan(2, "frontend", "GET /", "server"),
+			testSpan(3, "frontend", "fetch", "client"),
+			testSpan(4, "checkout", "pay", ""),
+		},
+		"other": {
+			testSpan(5, "billing", "invoice", "server"),
+		},
+	}
+	for tenant, tenantSpans := range spans {
+		for _, span := range tenantSpans {
+			assert.NoError(writer.WriteSpan(tenancy.WithTenant(ctx, tenant), span))
+		}
+	}
+
+	clearTable(assert, ctx, svc, servicesTable, "Name")
+	clearTable(assert, ctx, svc, operationsTable, "ServiceName", "Name")
+
+	report, err := RebuildIndexes(ctx, logger, svc, &RebuildOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+		Tenancy:         manager,
+		Segments:        2,
+		MaxWriteRate:    1000,
+	})
+	assert.NoError(err)
+	assert.Equal(int64(5), report.ScannedSpans)
+	assert.Equal(int64(5), report.TotalSpans)
+	assert.Greater(report.ConsumedReadUnits, float64(0))
+	assert.Equal(int64(3), report.Services)
+	assert.Equal(int64(4), report.Operations)
+
+	reader := dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable, dynamospanstore.WithReaderTenancy(manager))
+	acmeCtx := tenancy.WithTenant(ctx, "acme")
+	services, err := reader.GetServices(acmeCtx)
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"frontend", "checkout"}, services)
+
+	operations, err := reader.GetOperations(acmeCtx, spanstore.OperationQueryParameters{ServiceName: "frontend"})
+	assert.NoError(err)
+	assert.ElementsMatch([]spanstore.Operation{{Name: "GET /", SpanKind: "server"}, {Name: "fetch", SpanKind: "client"}}, operations)
+
+	services, err = reader.GetServices(tenancy.WithTenant(ctx, "other"))
+	assert.NoError(err)
+	assert.Equal([]string{"billing"}, services)
+}
+
+func TestRebuildIndexesKeepsLaterExpiry(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	logger := hclog.NewNullLogger()
+
+	svc := createFake(assert, ctx)
+	writer, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable)
+	assert.NoError(err)
+	assert.NoError(writer.WriteSpan(ctx, testSpan(1, "frontend", "GET /", "server")))
+
+	// The plugin wrote the service meanwhile
+	expiresAfter := time.Now().Add(30 * 24 * time.Hour).Unix()
+	av, err := attributevalue.MarshalMap(&dynamospanstore.ServiceItem{Name: "frontend", ExpiresAfter: expiresAfter})
+	assert.NoError(err)
+	_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(servicesTable), Item: av})
+	assert.NoError(err)
+	clearTable(assert, ctx, svc, operationsTable, "ServiceName", "Name")
+
+	report, err := RebuildIndexes(ctx, logger, svc, &RebuildOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+	})
+	assert.NoError(err)
+	assert.Equal(int64(0), report.Services)
+	assert.Equal(int64(1), report.Operations)
+
+	output, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(servicesTable),
+		Key:       map[string]types.AttributeValue{"Name": &types.AttributeValueMemberS{Value: "frontend"}},
+	})
+	assert.NoError(err)
+	item := &dynamospanstore.ServiceItem{}
+	assert.NoError(attributevalue.UnmarshalMap(output.Item, item))
+	assert.Equal(expiresAfter, item.ExpiresAfter)
+}
@@ -0,0 +1,201 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	pConfig "github.com/johanneswuerbach/jaeger-dynamodb/plugin/config"
+)
+
+const (
+	BackoffModeExponential = "exponential"
+	BackoffModeConstant    = "constant"
+
+	defaultSessionName = "jaeger-dynamodb"
+)
+
+// Load returns the AWS config shared by all clients. The custom endpoint only applies to DynamoDB clients, see
+// NewDynamoDBClient, so other services and the credentials aren't affected by it.
+func Load(ctx context.Context, configuration *pConfig.DynamoDBConfiguration) (aws.Config, error) {
+	retryer, err := newRetryer(&configuration.Retry)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	optFns := []func(*config.LoadOptions) error{
+		config.WithRetryer(retryer),
+	}
+	if configuration.Region != "" {
+		optFns = append(optFns, config.WithRegion(configuration.Region))
+	}
+	if configuration.Profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(configuration.Profile))
+	}
+	if provider := staticCredentials(&configuration.Credentials); provider != nil {
+		optFns = append(optFns, config.WithCredentialsProvider(provider))
+	}
+	if httpClient := newHTTPClient(&configuration.HTTP); httpClient != nil {
+		optFns = append(optFns, config.WithHTTPClient(httpClient))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("unable to load SDK config, %v", err)
+	}
+	if cfg.Region == "" {
+		return aws.Config{}, errors.New("region is required, either configure it or set AWS_REGION")
+	}
+
+	if webIdentity := configuration.WebIdentity; webIdentity.RoleARN != "" {
+		if webIdentity.TokenFile == "" {
+			return aws.Config{}, errors.New("web identity requires a token file")
+		}
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+			newSTSClient(cfg, configuration.STSEndpoint),
+			webIdentity.RoleARN,
+			stscreds.IdentityTokenFile(webIdentity.TokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = sessionName(webIdentity.SessionName)
+			}))
+	}
+
+	// The role is assumed with the credentials configured before, including the web identity
+	if assumeRole := configuration.AssumeRole; assumeRole.RoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(
+			newSTSClient(cfg, configuration.STSEndpoint),
+			assumeRole.RoleARN,
+			func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = sessionName(assumeRole.SessionName)
+				if assumeRole.ExternalID != "" {
+					o.ExternalID = aws.String(assumeRole.ExternalID)
+				}
+				if assumeRole.Duration > 0 {
+					o.Duration = assumeRole.Duration
+				}
+			}))
+	}
+
+	return cfg, nil
+}
+
+// NewDynamoDBClient creates a client using the custom endpoint when configured
+func NewDynamoDBClient(ctx context.Context, configuration *pConfig.DynamoDBConfiguration, optFns ...func(*dynamodb.Options)) (*dynamodb.Client, error) {
+	cfg, err := Load(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodb.NewFromConfig(cfg, append([]func(*dynamodb.Options){func(o *dynamodb.Options) {
+		if configuration.Endpoint != "" {
+			o.EndpointResolver = dynamodb.EndpointResolverFromURL(configuration.Endpoint)
+		}
+	}}, optFns...)...), nil
+}
+
+// NewDynamoDBStreamsClient creates a client using the custom endpoint when configured, DynamoDB Local serves streams
+// on the same endpoint
+func NewDynamoDBStreamsClient(ctx context.Context, configuration *pConfig.DynamoDBConfiguration, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.Client, error) {
+	cfg, err := Load(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodbstreams.NewFromConfig(cfg, append([]func(*dynamodbstreams.Options){func(o *dynamodbstreams.Options) {
+		if configuration.Endpoint != "" {
+			o.EndpointResolver = dynamodbstreams.EndpointResolverFromURL(configuration.Endpoint)
+		}
+	}}, optFns...)...), nil
+}
+
+func newSTSClient(cfg aws.Config, endpoint string) *sts.Client {
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = sts.EndpointResolverFromURL(endpoint)
+		}
+	})
+}
+
+func sessionName(name string) string {
+	if name == "" {
+		return defaultSessionName
+	}
+	return name
+}
+
+func staticCredentials(configuration *pConfig.StaticCredentialsConfiguration) aws.CredentialsProvider {
+	if configuration.AccessKeyID == "" {
+		return nil
+	}
+	return credentials.NewStaticCredentialsProvider(configuration.AccessKeyID, configuration.SecretAccessKey, configuration.SessionToken)
+}
+
+func newRetryer(configuration *pConfig.RetryConfiguration) (func() aws.Retryer, error) {
+	maxBackoff := configuration.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = retry.DefaultMaxBackoff
+	}
+
+	var backoff retry.BackoffDelayer
+	switch configuration.BackoffMode {
+	case "", BackoffModeExponential:
+		backoff = retry.NewExponentialJitterBackoff(maxBackoff)
+	case BackoffModeConstant:
+		backoff = retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
+			return maxBackoff, nil
+		})
+	default:
+		return nil, fmt.Errorf("unknown backoff mode %q", configuration.BackoffMode)
+	}
+
+	return func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			if configuration.MaxAttempts > 0 {
+				o.MaxAttempts = configuration.MaxAttempts
+			}
+			o.MaxBackoff = maxBackoff
+			o.Backoff = backoff
+		})
+	}, nil
+}
+
+// newHTTPClient returns nil when the default client of the SDK should be used
+func newHTTPClient(configuration *pConfig.HTTPConfiguration) *awshttp.BuildableClient {
+	if *configuration == (pConfig.HTTPConfiguration{}) {
+		return nil
+	}
+
+	return awshttp.NewBuildableClient().
+		WithTimeout(configuration.Timeout).
+		WithDialerOptions(func(d *net.Dialer) {
+			if configuration.ConnectTimeout > 0 {
+				d.Timeout = configuration.ConnectTimeout
+			}
+		}).
+		WithTransportOptions(func(t *http.Transport) {
+			if configuration.TLSHandshakeTimeout > 0 {
+				t.TLSHandshakeTimeout = configuration.TLSHandshakeTimeout
+			}
+			if configuration.ResponseHeaderTimeout > 0 {
+				t.ResponseHeaderTimeout = configuration.ResponseHeaderTimeout
+			}
+			if configuration.IdleConnTimeout > 0 {
+				t.IdleConnTimeout = configuration.IdleConnTimeout
+			}
+			if configuration.MaxIdleConnsPerHost > 0 {
+				t.MaxIdleConnsPerHost = configuration.MaxIdleConnsPerHost
+			}
+		})
+}
@@ -0,0 +1,173 @@
+package awsconfig
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	pConfig "github.com/johanneswuerbach/jaeger-dynamodb/plugin/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// isolateEnvironment prevents the AWS configuration of the host from being used
+func isolateEnvironment(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	isolateEnvironment(t)
+
+	_, err := Load(ctx, &pConfig.DynamoDBConfiguration{})
+	assert.Error(err)
+
+	cfg, err := Load(ctx, &pConfig.DynamoDBConfiguration{
+		Region:      "eu-west-1",
+		Credentials: pConfig.StaticCredentialsConfiguration{AccessKeyID: "AKID", SecretAccessKey: "SECRET"},
+		Retry:       pConfig.RetryConfiguration{MaxAttempts: 5, MaxBackoff: time.Second, BackoffMode: BackoffModeConstant},
+		HTTP:        pConfig.HTTPConfiguration{Timeout: 3 * time.Second},
+	})
+	assert.NoError(err)
+	assert.Equal("eu-west-1", cfg.Region)
+	credentials, err := cfg.Credentials.Retrieve(ctx)
+	assert.NoError(err)
+	assert.Equal("AKID", credentials.AccessKeyID)
+
+	retryer := cfg.Retryer()
+	assert.Equal(5, retryer.MaxAttempts())
+	delay, err := retryer.RetryDelay(3, nil)
+	assert.NoError(err)
+	assert.Equal(time.Second, delay)
+
+	_, err = Load(ctx, &pConfig.DynamoDBConfiguration{Region: "eu-west-1", Retry: pConfig.RetryConfiguration{BackoffMode: "linear"}})
+	assert.Error(err)
+	_, err = Load(ctx, &pConfig.DynamoDBConfiguration{Region: "eu-west-1", WebIdentity: pConfig.WebIdentityConfiguration{RoleARN: "arn:aws:iam::123456789012:role/jaeger"}})
+	assert.Error(err)
+}
+
+func TestLoadProfile(t *testing.T) {
+	assert := assert.New(t)
+	dir := isolateEnvironment(t)
+
+	assert.NoError(os.WriteFile(filepath.Join(dir, "config"), []byte("[profile tracing]\nregion = ap-southeast-2\n"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "credentials"), []byte("[tracing]\naws_access_key_id = PROFILE\naws_secret_access_key = SECRET\n"), 0o600))
+
+	cfg, err := Load(context.TODO(), &pConfig.DynamoDBConfiguration{Profile: "tracing"})
+	assert.NoError(err)
+	assert.Equal("ap-southeast-2", cfg.Region)
+	credentials, err := cfg.Credentials.Retrieve(context.TODO())
+	assert.NoError(err)
+	assert.Equal("PROFILE", credentials.AccessKeyID)
+}
+
+func TestLoadAssumeRole(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	isolateEnvironment(t)
+
+	var form map[string][]string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(r.ParseForm())
+		form = r.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMED</AccessKeyId>
+      <SecretAccessKey>SECRET</SecretAccessKey>
+      <SessionToken>TOKEN</SessionToken>
+      <Expiration>2100-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/jaeger/tracing</Arn>
+      <AssumedRoleId>ARO:tracing</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`))
+	}))
+	defer sts.Close()
+
+	cfg, err := Load(ctx, &pConfig.DynamoDBConfiguration{
+		Region:      "eu-west-1",
+		Credentials: pConfig.StaticCredentialsConfiguration{AccessKeyID: "BASE", SecretAccessKey: "SECRET"},
+		AssumeRole: pConfig.AssumeRoleConfiguration{
+			RoleARN:     "arn:aws:iam::123456789012:role/jaeger",
+			ExternalID:  "external",
+			SessionName: "tracing",
+		},
+		STSEndpoint: sts.URL,
+	})
+	assert.NoError(err)
+
+	credentials, err := cfg.Credentials.Retrieve(ctx)
+	assert.NoError(err)
+	assert.Equal("ASSUMED", credentials.AccessKeyID)
+	assert.Equal("TOKEN", credentials.SessionToken)
+	assert.Equal([]string{"AssumeRole"}, form["Action"])
+	assert.Equal([]string{"arn:aws:iam::123456789012:role/jaeger"}, form["RoleArn"])
+	assert.Equal([]string{"external"}, form["ExternalId"])
+	assert.Equal([]string{"tracing"}, form["RoleSessionName"])
+}
+
+func TestNewDynamoDBClientEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	isolateEnvironment(t)
+
+	requests := 0
+	dynamodb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_, _ = w.Write([]byte(`{"TableNames":[]}`))
+	}))
+	defer dynamodb.Close()
+
+	svc, err := NewDynamoDBClient(context.TODO(), &pConfig.DynamoDBConfiguration{
+		Endpoint:    dynamodb.URL,
+		Region:      "eu-west-1",
+		Credentials: pConfig.StaticCredentialsConfiguration{AccessKeyID: "AKID", SecretAccessKey: "SECRET"},
+		Retry:       pConfig.RetryConfiguration{MaxAttempts: 1, MaxBackoff: retry.DefaultMaxBackoff},
+	})
+	assert.NoError(err)
+	_, err = svc.ListTables(context.TODO(), nil)
+	assert.NoError(err)
+	assert.Equal(1, requests)
+}
+
+func TestNewDynamoDBStreamsClientEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	isolateEnvironment(t)
+
+	requests := 0
+	streams := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_, _ = w.Write([]byte(`{"Streams":[]}`))
+	}))
+	defer streams.Close()
+
+	svc, err := NewDynamoDBStreamsClient(context.TODO(), &pConfig.DynamoDBConfiguration{
+		Endpoint:    streams.URL,
+		Region:      "eu-west-1",
+		Credentials: pConfig.StaticCredentialsConfiguration{AccessKeyID: "AKID", SecretAccessKey: "SECRET"},
+		Retry:       pConfig.RetryConfiguration{MaxAttempts: 1, MaxBackoff: retry.DefaultMaxBackoff},
+	})
+	assert.NoError(err)
+	_, err = svc.ListStreams(context.TODO(), &dynamodbstreams.ListStreamsInput{})
+	assert.NoError(err)
+	assert.Equal(1, requests)
+}
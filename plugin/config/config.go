@@ -1,10 +1,217 @@
 package config
 
+import "time"
+
+type StaticCredentialsConfiguration struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+type WebIdentityConfiguration struct {
+	RoleARN string
+	// File containing the OIDC token, e.g. a projected service account token
+	TokenFile   string
+	SessionName string
+}
+
+type AssumeRoleConfiguration struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	Duration    time.Duration
+}
+
+type RetryConfiguration struct {
+	// Attempts of a request including the first one
+	MaxAttempts int
+	// Maximum delay between attempts
+	MaxBackoff time.Duration
+	// Either "exponential" or "constant"
+	BackoffMode string
+}
+
+type HTTPConfiguration struct {
+	// Timeout of a whole request including reading the response
+	Timeout               time.Duration
+	ConnectTimeout        time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConnsPerHost   int
+}
+
 type DynamoDBConfiguration struct {
+	// Custom endpoint of DynamoDB only, e.g. DynamoDB local or a VPC endpoint
 	Endpoint       string
 	RecreateTables bool
+	// Defaults to the region of the environment
+	Region string
+	// Named profile of the shared config files
+	Profile string
+	// Used instead of the default credential chain when set
+	Credentials StaticCredentialsConfiguration
+	// Assumes a role with a web identity token instead of using the credentials
+	WebIdentity WebIdentityConfiguration
+	// Assumes a role using the credentials or the web identity
+	AssumeRole AssumeRoleConfiguration
+	// Custom endpoint of STS, e.g. a VPC endpoint
+	STSEndpoint string
+	Retry       RetryConfiguration
+	HTTP        HTTPConfiguration
+}
+
+type TenancyConfiguration struct {
+	Enabled bool
+	// Name of the gRPC metadata header containing the tenant
+	Header string
+	// Either "table" or "key"
+	Mode string
+	// Tenants to accept, tables are created for each of them in the "table" mode
+	Tenants []string
+}
+
+type StreamingSpanWriterConfiguration struct {
+	Enabled   bool
+	QueueSize int
+	Workers   int
+	// Either "drop-newest", "drop-oldest" or "block"
+	DropPolicy string
+}
+
+type WriteAheadLogConfiguration struct {
+	Enabled   bool
+	Directory string
+	// Size in bytes after which a new segment file is started
+	SegmentSize int64
+	// Size in bytes of all segment files after which spans are dropped
+	MaxSize        int64
+	SyncWrites     bool
+	ReplayInterval time.Duration
+}
+
+type RateLimitConfiguration struct {
+	Enabled bool
+	// Writes per second per table
+	InitialRate float64
+	MinRate     float64
+	MaxRate     float64
+	// Writes per second added after a second without throttling
+	IncreaseStep float64
+	// Factor the rate is multiplied with after a write was throttled
+	DecreaseFactor float64
+}
+
+type SamplingRuleConfiguration struct {
+	Service string
+	// Matches all operations of the service when empty
+	Operation string
+	Rate      float64
+}
+
+type SamplingConfiguration struct {
+	Enabled bool
+	// Share of traces to keep between 0 and 1 when no rule matches, defaults to 1. 0 requires rules.
+	DefaultRate *float64
+	Rules       []SamplingRuleConfiguration
+	// Spans taking longer are always kept
+	LatencyThreshold time.Duration
+	// Duration the decision of a trace is remembered for its later spans, defaults to 5 minutes
+	DecisionTTL time.Duration
+	// Decisions remembered per DecisionTTL, defaults to 100000
+	DecisionCacheSize int
+}
+
+type ScrubRuleConfiguration struct {
+	Keys         []string
+	KeyPattern   string
+	ValuePattern string
+	// Either "redact", "hash", "truncate" or "drop"
+	Action    string
+	MaxLength int
+}
+
+type ScrubbingConfiguration struct {
+	Enabled bool
+	Rules   []ScrubRuleConfiguration
+	// Secret key of hashed values, required by hash rules
+	HashKey string
+}
+
+type LocalKeyConfiguration struct {
+	ID string
+	// Base64 encoded 256 bit key
+	Key string
+}
+
+type EncryptionConfiguration struct {
+	Enabled bool
+	// Either "kms" or "local"
+	KeyProvider string
+	// ID, ARN or alias of the KMS key data keys are generated with
+	KMSKeyID string
+	// Master keys of the local key provider, data keys are encrypted with the active key
+	LocalKeys        []LocalKeyConfiguration
+	ActiveLocalKeyID string
+	// Duration a data key is used for new spans
+	DataKeyTTL time.Duration
+	// Tags which stay searchable, besides span.kind, error and otel.status_code
+	SearchableTags []string
+}
+
+type TracingConfiguration struct {
+	// Either "otlp" or "stdout", which writes to stderr as stdout is used by the plugin protocol. Disabled when empty.
+	Exporter string
+	// Address of the OTLP gRPC receiver, defaults to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317
+	Endpoint string
+	Insecure bool
+	// Share of traces to record between 0 and 1, defaults to 1
+	SampleRatio float64
+}
+
+type DependencyAggregatorConfiguration struct {
+	// Counts the dependencies of written spans in the plugin, e.g. when the dependency lambda isn't deployed
+	Enabled bool
+	// Interval between the writes of the counted calls, defaults to a minute
+	FlushInterval time.Duration
+	// Duration spans are cached to match them with their parents or children, defaults to 5 minutes
+	SpanTTL time.Duration
+	// Spans cached per SpanTTL, defaults to 100000
+	CacheSize int
+}
+
+type DependenciesConfiguration struct {
+	// Size of the buckets dependencies are aggregated in, has to match the dependency lambda. Defaults to an hour.
+	BucketSize time.Duration
+	Aggregator DependencyAggregatorConfiguration
+}
+
+type AdminConfiguration struct {
+	// Address of the HTTP server exposing the prometheus metrics on /metrics and the health checks on /healthz
+	// and /readyz, disabled when empty
+	HTTPAddress string
+}
+
+type HealthConfiguration struct {
+	// Interval between the table checks, defaults to 30s
+	Interval time.Duration
+	// Timeout of a check of all tables, defaults to 10s
+	Timeout time.Duration
+	// Requires a stream on the spans table, e.g. when the dependency lambda is used
+	RequireSpansStream bool
 }
 
 type Configuration struct {
-	DynamoDB DynamoDBConfiguration
+	DynamoDB            DynamoDBConfiguration
+	Tenancy             TenancyConfiguration
+	StreamingSpanWriter StreamingSpanWriterConfiguration
+	WriteAheadLog       WriteAheadLogConfiguration
+	RateLimit           RateLimitConfiguration
+	Sampling            SamplingConfiguration
+	Scrubbing           ScrubbingConfiguration
+	Encryption          EncryptionConfiguration
+	Admin               AdminConfiguration
+	Health              HealthConfiguration
+	Tracing             TracingConfiguration
+	Dependencies        DependenciesConfiguration
 }
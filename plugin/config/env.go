@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultSpansTable        = "jaeger.spans"
+	DefaultDependenciesTable = "jaeger.dependencies"
+)
+
+// DependencyLambdaConfiguration is read from environment variables, as the dependency lambda has no config file
+type DependencyLambdaConfiguration struct {
+	DynamoDB DynamoDBConfiguration
+	// Table whose stream the lambda consumes
+	SpansTable        string
+	DependenciesTable string
+	Dependencies      DependenciesConfiguration
+}
+
+func NewDependencyLambdaConfiguration() *DependencyLambdaConfiguration {
+	return &DependencyLambdaConfiguration{
+		SpansTable:        DefaultSpansTable,
+		DependenciesTable: DefaultDependenciesTable,
+	}
+}
+
+// LoadDependencyLambdaConfiguration reads the configuration using lookupEnv, e.g. os.LookupEnv. Unset variables
+// keep their defaults:
+//
+//	SPANS_TABLE         name of the spans table, defaults to jaeger.spans
+//	DEPENDENCIES_TABLE  name of the dependencies table, defaults to jaeger.dependencies
+//	BUCKET_SIZE         size of the dependency buckets, e.g. 15m, defaults to an hour
+//	DYNAMODB_ENDPOINT   custom endpoint of DynamoDB, e.g. http://localhost:8000 for DynamoDB Local
+//	DYNAMODB_REGION     defaults to the region of the environment
+func LoadDependencyLambdaConfiguration(lookupEnv func(string) (string, bool)) (*DependencyLambdaConfiguration, error) {
+	configuration := NewDependencyLambdaConfiguration()
+
+	if value, ok := lookupEnv("SPANS_TABLE"); ok && value != "" {
+		configuration.SpansTable = value
+	}
+	if value, ok := lookupEnv("DEPENDENCIES_TABLE"); ok && value != "" {
+		configuration.DependenciesTable = value
+	}
+	if value, ok := lookupEnv("BUCKET_SIZE"); ok && value != "" {
+		bucketSize, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse BUCKET_SIZE, %v", err)
+		}
+		if bucketSize <= 0 {
+			return nil, fmt.Errorf("BUCKET_SIZE must be positive, got %s", value)
+		}
+		configuration.Dependencies.BucketSize = bucketSize
+	}
+	if value, ok := lookupEnv("DYNAMODB_ENDPOINT"); ok {
+		configuration.DynamoDB.Endpoint = value
+	}
+	if value, ok := lookupEnv("DYNAMODB_REGION"); ok {
+		configuration.DynamoDB.Region = value
+	}
+
+	return configuration, nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoadDependencyLambdaConfiguration(t *testing.T) {
+	assert := assert.New(t)
+
+	configuration, err := LoadDependencyLambdaConfiguration(lookupEnv(map[string]string{}))
+	assert.NoError(err)
+	assert.Equal(NewDependencyLambdaConfiguration(), configuration)
+
+	configuration, err = LoadDependencyLambdaConfiguration(lookupEnv(map[string]string{
+		"SPANS_TABLE":        "tenant.spans",
+		"DEPENDENCIES_TABLE": "tenant.dependencies",
+		"BUCKET_SIZE":        "15m",
+		"DYNAMODB_ENDPOINT":  "http://localhost:8000",
+		"DYNAMODB_REGION":    "us-east-1",
+	}))
+	assert.NoError(err)
+	assert.Equal("tenant.spans", configuration.SpansTable)
+	assert.Equal("tenant.dependencies", configuration.DependenciesTable)
+	assert.Equal(15*time.Minute, configuration.Dependencies.BucketSize)
+	assert.Equal("http://localhost:8000", configuration.DynamoDB.Endpoint)
+	assert.Equal("us-east-1", configuration.DynamoDB.Region)
+
+	_, err = LoadDependencyLambdaConfiguration(lookupEnv(map[string]string{"BUCKET_SIZE": "hourly"}))
+	assert.Error(err)
+	_, err = LoadDependencyLambdaConfiguration(lookupEnv(map[string]string{"BUCKET_SIZE": "-1h"}))
+	assert.Error(err)
+}
@@ -0,0 +1,744 @@
+package dynamodbfake
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenValue
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ':' || c == '#' || c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			i++
+			for i < len(expr) && (expr[i] == '_' || expr[i] == '-' || unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i]))) {
+				i++
+			}
+			kind := tokenName
+			if c == ':' {
+				kind = tokenValue
+			}
+			tokens = append(tokens, token{kind: kind, text: expr[start:i]})
+		case unicode.IsDigit(rune(c)):
+			start := i
+			for i < len(expr) && unicode.IsDigit(rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[start:i]})
+		case strings.HasPrefix(expr[i:], "<>") || strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, token{kind: tokenPunct, text: expr[i : i+2]})
+			i += 2
+		case strings.ContainsRune("()[],.=<>+-", rune(c)):
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c)})
+			i++
+		default:
+			return nil, validationError("invalid character %q in expression %q", c, expr)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+type parser struct {
+	expr   string
+	tokens []token
+	pos    int
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newParser(expr string, names map[string]string, values map[string]types.AttributeValue) (*parser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{expr: expr, tokens: tokens, names: names, values: values}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenName && strings.EqualFold(t.text, keyword)
+}
+
+func (p *parser) isPunct(punct string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.text == punct
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !p.isPunct(punct) {
+		return p.errorf("expected %q", punct)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectEOF() error {
+	if p.peek().kind != tokenEOF {
+		return p.errorf("unexpected token")
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return validationError("invalid expression %q at %q: %s", p.expr, p.peek().text, validationMessage(format, args...))
+}
+
+// pathElement is either an attribute name or a list index
+type pathElement struct {
+	name  string
+	index int
+}
+
+type path []pathElement
+
+func (p path) String() string {
+	b := strings.Builder{}
+	for i, e := range p {
+		if e.name == "" {
+			b.WriteString("[" + strconv.Itoa(e.index) + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(e.name)
+	}
+	return b.String()
+}
+
+func (p *parser) parseName() (string, error) {
+	t := p.next()
+	if t.kind != tokenName {
+		return "", p.errorf("expected attribute name")
+	}
+	if strings.HasPrefix(t.text, "#") {
+		name, ok := p.names[t.text]
+		if !ok {
+			return "", validationError("expression attribute name %s is not defined", t.text)
+		}
+		return name, nil
+	}
+	return t.text, nil
+}
+
+func (p *parser) parsePath() (path, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+
+	result := path{{name: name}}
+	for {
+		switch {
+		case p.isPunct("."):
+			p.next()
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, pathElement{name: name})
+		case p.isPunct("["):
+			p.next()
+			t := p.next()
+			if t.kind != tokenNumber {
+				return nil, p.errorf("expected list index")
+			}
+			index, err := strconv.Atoi(t.text)
+			if err != nil {
+				return nil, p.errorf("invalid list index")
+			}
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			result = append(result, pathElement{index: index})
+		default:
+			return result, nil
+		}
+	}
+}
+
+func (p *parser) parseValue() (types.AttributeValue, error) {
+	t := p.next()
+	value, ok := p.values[t.text]
+	if !ok {
+		return nil, validationError("expression attribute value %s is not defined", t.text)
+	}
+	return value, nil
+}
+
+// resolvePath returns the value at the path or nil if it doesn't exist
+func resolvePath(i item, p path) types.AttributeValue {
+	var current types.AttributeValue = &types.AttributeValueMemberM{Value: i}
+	for _, e := range p {
+		switch v := current.(type) {
+		case *types.AttributeValueMemberM:
+			if e.name == "" {
+				return nil
+			}
+			next, ok := v.Value[e.name]
+			if !ok {
+				return nil
+			}
+			current = next
+		case *types.AttributeValueMemberL:
+			if e.name != "" || e.index >= len(v.Value) {
+				return nil
+			}
+			current = v.Value[e.index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+// setPath sets the value at the path, the parent of the path must exist
+func setPath(i item, p path, value types.AttributeValue) error {
+	if len(p) == 1 {
+		if p[0].name == "" {
+			return validationError("invalid document path %s", p)
+		}
+		i[p[0].name] = value
+		return nil
+	}
+
+	parent := resolvePath(i, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch v := parent.(type) {
+	case *types.AttributeValueMemberM:
+		if last.name == "" {
+			return validationError("invalid document path %s", p)
+		}
+		v.Value[last.name] = value
+		return nil
+	case *types.AttributeValueMemberL:
+		if last.name != "" {
+			return validationError("invalid document path %s", p)
+		}
+		if last.index >= len(v.Value) {
+			v.Value = append(v.Value, value)
+		} else {
+			v.Value[last.index] = value
+		}
+		return nil
+	}
+	return validationError("the document path %s is invalid for update", p)
+}
+
+func removePath(i item, p path) {
+	if len(p) == 1 {
+		delete(i, p[0].name)
+		return
+	}
+
+	parent := resolvePath(i, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch v := parent.(type) {
+	case *types.AttributeValueMemberM:
+		delete(v.Value, last.name)
+	case *types.AttributeValueMemberL:
+		if last.name == "" && last.index < len(v.Value) {
+			v.Value = append(v.Value[:last.index], v.Value[last.index+1:]...)
+		}
+	}
+}
+
+// operand is a path, a placeholder value or the size function within a condition
+type operand struct {
+	path  path
+	value types.AttributeValue
+	size  bool
+}
+
+func (o *operand) resolve(i item) types.AttributeValue {
+	if o.path == nil {
+		return o.value
+	}
+
+	value := resolvePath(i, o.path)
+	if !o.size || value == nil {
+		return value
+	}
+
+	size := 0
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberB:
+		size = len(v.Value)
+	case *types.AttributeValueMemberSS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberNS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberBS:
+		size = len(v.Value)
+	case *types.AttributeValueMemberL:
+		size = len(v.Value)
+	case *types.AttributeValueMemberM:
+		size = len(v.Value)
+	default:
+		return nil
+	}
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(size)}
+}
+
+func (p *parser) parseOperand() (*operand, error) {
+	t := p.peek()
+	if t.kind == tokenValue {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &operand{value: value}, nil
+	}
+
+	if p.isKeyword("size") && p.tokens[p.pos+1].text == "(" {
+		p.next()
+		p.next()
+		attributePath, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &operand{path: attributePath, size: true}, nil
+	}
+
+	attributePath, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return &operand{path: attributePath}, nil
+}
+
+type condition interface {
+	eval(i item) bool
+}
+
+type andCondition struct{ left, right condition }
+
+func (c *andCondition) eval(i item) bool { return c.left.eval(i) && c.right.eval(i) }
+
+type orCondition struct{ left, right condition }
+
+func (c *orCondition) eval(i item) bool { return c.left.eval(i) || c.right.eval(i) }
+
+type notCondition struct{ condition condition }
+
+func (c *notCondition) eval(i item) bool { return !c.condition.eval(i) }
+
+type compareCondition struct {
+	left, right *operand
+	comparator  string
+}
+
+func (c *compareCondition) eval(i item) bool {
+	left := c.left.resolve(i)
+	right := c.right.resolve(i)
+	if left == nil || right == nil {
+		return c.comparator == "<>" && (left != nil || right != nil)
+	}
+
+	switch c.comparator {
+	case "=":
+		return equalValues(left, right)
+	case "<>":
+		return !equalValues(left, right)
+	}
+
+	cmp, ok := compareValues(left, right)
+	if !ok {
+		return false
+	}
+	switch c.comparator {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+type betweenCondition struct {
+	operand, lower, upper *operand
+}
+
+func (c *betweenCondition) eval(i item) bool {
+	value := c.operand.resolve(i)
+	lower := c.lower.resolve(i)
+	upper := c.upper.resolve(i)
+	if value == nil || lower == nil || upper == nil {
+		return false
+	}
+
+	lowerCmp, ok := compareValues(value, lower)
+	if !ok {
+		return false
+	}
+	upperCmp, ok := compareValues(value, upper)
+	return ok && lowerCmp >= 0 && upperCmp <= 0
+}
+
+type inCondition struct {
+	operand *operand
+	options []*operand
+}
+
+func (c *inCondition) eval(i item) bool {
+	value := c.operand.resolve(i)
+	if value == nil {
+		return false
+	}
+	for _, option := range c.options {
+		if other := option.resolve(i); other != nil && equalValues(value, other) {
+			return true
+		}
+	}
+	return false
+}
+
+type functionCondition struct {
+	name string
+	args []*operand
+}
+
+func (c *functionCondition) eval(i item) bool {
+	switch c.name {
+	case "attribute_exists":
+		return c.args[0].resolve(i) != nil
+	case "attribute_not_exists":
+		return c.args[0].resolve(i) == nil
+	case "attribute_type":
+		value := c.args[0].resolve(i)
+		expected, ok := c.args[1].resolve(i).(*types.AttributeValueMemberS)
+		return value != nil && ok && typeOf(value) == expected.Value
+	case "begins_with":
+		value := c.args[0].resolve(i)
+		prefix := c.args[1].resolve(i)
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			p, ok := prefix.(*types.AttributeValueMemberS)
+			return ok && strings.HasPrefix(v.Value, p.Value)
+		case *types.AttributeValueMemberB:
+			p, ok := prefix.(*types.AttributeValueMemberB)
+			return ok && strings.HasPrefix(string(v.Value), string(p.Value))
+		}
+		return false
+	case "contains":
+		value := c.args[0].resolve(i)
+		operand := c.args[1].resolve(i)
+		if operand == nil {
+			return false
+		}
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			o, ok := operand.(*types.AttributeValueMemberS)
+			return ok && strings.Contains(v.Value, o.Value)
+		case *types.AttributeValueMemberSS:
+			o, ok := operand.(*types.AttributeValueMemberS)
+			return ok && containsString(v.Value, o.Value)
+		case *types.AttributeValueMemberNS:
+			o, ok := operand.(*types.AttributeValueMemberN)
+			return ok && containsString(normalizeNumbers(v.Value), normalizeNumbers([]string{o.Value})[0])
+		case *types.AttributeValueMemberL:
+			for _, e := range v.Value {
+				if equalValues(e, operand) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var functionArgs = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"attribute_type":       2,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+func parseCondition(expr string, names map[string]string, values map[string]types.AttributeValue) (condition, error) {
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *parser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andCondition{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (condition, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notCondition{condition: c}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (condition, error) {
+	if p.isPunct("(") {
+		p.next()
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	t := p.peek()
+	if argCount, ok := functionArgs[t.text]; ok && t.kind == tokenName && p.tokens[p.pos+1].text == "(" {
+		p.next()
+		p.next()
+		args := []*operand{}
+		for len(args) < argCount {
+			if len(args) > 0 {
+				if err := p.expectPunct(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &functionCondition{name: t.text, args: args}, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.isKeyword("BETWEEN"):
+		p.next()
+		lower, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			return nil, p.errorf("expected AND")
+		}
+		p.next()
+		upper, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenCondition{operand: left, lower: lower, upper: upper}, nil
+	case p.isKeyword("IN"):
+		p.next()
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		options := []*operand{}
+		for {
+			option, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, option)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &inCondition{operand: left, options: options}, nil
+	}
+
+	comparator := p.next()
+	switch comparator.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, p.errorf("expected comparator")
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &compareCondition{left: left, right: right, comparator: comparator.text}, nil
+}
+
+// keyEqualities returns the attributes compared for equality within the top level conjunction of a key condition
+func keyEqualities(c condition) map[string]bool {
+	equalities := map[string]bool{}
+	switch v := c.(type) {
+	case *andCondition:
+		for k := range keyEqualities(v.left) {
+			equalities[k] = true
+		}
+		for k := range keyEqualities(v.right) {
+			equalities[k] = true
+		}
+	case *compareCondition:
+		if v.comparator == "=" && len(v.left.path) == 1 && !v.left.size {
+			equalities[v.left.path[0].name] = true
+		}
+	}
+	return equalities
+}
+
+func parseProjection(expr string, names map[string]string) ([]path, error) {
+	p, err := newParser(expr, names, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []path{}
+	for {
+		attributePath, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, attributePath)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// project copies the values at the given paths into a new item
+func project(i item, paths []path) item {
+	result := item{}
+	for _, p := range paths {
+		value := resolvePath(i, p)
+		if value == nil {
+			continue
+		}
+
+		// Nested paths keep the enclosing maps, list elements are projected into a list
+		target := result
+		for j, e := range p[:len(p)-1] {
+			if e.name == "" {
+				break
+			}
+			next, ok := target[e.name].(*types.AttributeValueMemberM)
+			if !ok {
+				if p[j+1].name == "" {
+					target[e.name] = &types.AttributeValueMemberL{Value: []types.AttributeValue{copyValue(value)}}
+					target = nil
+					break
+				}
+				next = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+				target[e.name] = next
+			}
+			target = next.Value
+		}
+		if target == nil {
+			continue
+		}
+
+		last := p[len(p)-1]
+		if last.name != "" {
+			target[last.name] = copyValue(value)
+		}
+	}
+	return result
+}
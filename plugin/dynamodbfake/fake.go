@@ -0,0 +1,1107 @@
+// Package dynamodbfake provides an in-memory implementation of the DynamoDB API used by the plugin, so tests
+// can run without DynamoDB Local.
+package dynamodbfake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// DynamoDB stops reading once a page exceeds 1MB
+const maxPageBytes = 1024 * 1024
+
+func validationMessage(format string, args ...interface{}) string {
+	return fmt.Sprintf(format, args...)
+}
+
+func validationError(format string, args ...interface{}) error {
+	return &smithy.GenericAPIError{Code: "ValidationException", Message: validationMessage(format, args...)}
+}
+
+func resourceNotFoundError(tableName string) error {
+	return &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("Requested resource not found: Table: %s not found", tableName))}
+}
+
+func conditionalCheckFailedError() error {
+	return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+}
+
+type Options struct {
+	// Limits the number of items evaluated per Query or Scan page, to exercise pagination
+	MaxPageSize int
+}
+
+// Client is an in-memory DynamoDB, safe for concurrent use
+type Client struct {
+	options Options
+
+	mu     sync.RWMutex
+	tables map[string]*table
+}
+
+func New(options *Options) *Client {
+	c := &Client{tables: map[string]*table{}}
+	if options != nil {
+		c.options = *options
+	}
+	return c
+}
+
+type keySchema struct {
+	hashKey  string
+	rangeKey string
+}
+
+func newKeySchema(elements []types.KeySchemaElement) (keySchema, error) {
+	schema := keySchema{}
+	for _, element := range elements {
+		switch element.KeyType {
+		case types.KeyTypeHash:
+			schema.hashKey = aws.ToString(element.AttributeName)
+		case types.KeyTypeRange:
+			schema.rangeKey = aws.ToString(element.AttributeName)
+		}
+	}
+	if schema.hashKey == "" {
+		return schema, validationError("key schema requires a hash key")
+	}
+	return schema, nil
+}
+
+// has returns whether the item contains all key attributes, items without them aren't part of an index
+func (k keySchema) has(i item) bool {
+	if _, ok := i[k.hashKey]; !ok {
+		return false
+	}
+	if k.rangeKey != "" {
+		if _, ok := i[k.rangeKey]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (k keySchema) encode(i item) string {
+	encoded := encodeKeyValue(i[k.hashKey])
+	if k.rangeKey != "" {
+		encoded += "\x00" + encodeKeyValue(i[k.rangeKey])
+	}
+	return encoded
+}
+
+func (k keySchema) extract(i item) item {
+	key := item{k.hashKey: i[k.hashKey]}
+	if k.rangeKey != "" {
+		key[k.rangeKey] = i[k.rangeKey]
+	}
+	return key
+}
+
+type index struct {
+	name       string
+	keySchema  keySchema
+	projection *types.Projection
+}
+
+type table struct {
+	description    types.TableDescription
+	keySchema      keySchema
+	attributeTypes map[string]types.ScalarAttributeType
+	indexes        map[string]*index
+	items          map[string]item
+	ttl            *types.TimeToLiveDescription
+}
+
+func (t *table) validateKey(key item, exact bool) error {
+	attributes := []string{t.keySchema.hashKey}
+	if t.keySchema.rangeKey != "" {
+		attributes = append(attributes, t.keySchema.rangeKey)
+	}
+
+	for _, attribute := range attributes {
+		value, ok := key[attribute]
+		if !ok {
+			return validationError("one of the required keys was not given a value: %s", attribute)
+		}
+		if typeOf(value) != string(t.attributeTypes[attribute]) {
+			return validationError("type mismatch for key %s expected: %s actual: %s", attribute, t.attributeTypes[attribute], typeOf(value))
+		}
+	}
+	if exact && len(key) != len(attributes) {
+		return validationError("the provided key element does not match the schema")
+	}
+	return nil
+}
+
+// validateIndexKeys ensures index key attributes of an item have the declared types
+func (t *table) validateIndexKeys(i item) error {
+	for _, idx := range t.indexes {
+		for _, attribute := range []string{idx.keySchema.hashKey, idx.keySchema.rangeKey} {
+			if value, ok := i[attribute]; ok && attribute != "" && typeOf(value) != string(t.attributeTypes[attribute]) {
+				return validationError("type mismatch for index key %s expected: %s actual: %s", attribute, t.attributeTypes[attribute], typeOf(value))
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) table(name *string) (*table, error) {
+	t, ok := c.tables[aws.ToString(name)]
+	if !ok {
+		return nil, resourceNotFoundError(aws.ToString(name))
+	}
+	return t, nil
+}
+
+func (c *Client) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := aws.ToString(params.TableName)
+	if _, ok := c.tables[name]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String(fmt.Sprintf("Table already exists: %s", name))}
+	}
+
+	schema, err := newKeySchema(params.KeySchema)
+	if err != nil {
+		return nil, err
+	}
+
+	attributeTypes := map[string]types.ScalarAttributeType{}
+	for _, definition := range params.AttributeDefinitions {
+		attributeTypes[aws.ToString(definition.AttributeName)] = definition.AttributeType
+	}
+
+	t := &table{
+		keySchema:      schema,
+		attributeTypes: attributeTypes,
+		indexes:        map[string]*index{},
+		items:          map[string]item{},
+	}
+
+	description := types.TableDescription{
+		TableName:            params.TableName,
+		TableArn:             aws.String(fmt.Sprintf("arn:aws:dynamodb:us-east-1:000000000000:table/%s", name)),
+		TableStatus:          types.TableStatusActive,
+		KeySchema:            params.KeySchema,
+		AttributeDefinitions: params.AttributeDefinitions,
+		CreationDateTime:     aws.Time(time.Now()),
+		StreamSpecification:  params.StreamSpecification,
+	}
+	if params.BillingMode != "" {
+		description.BillingModeSummary = &types.BillingModeSummary{BillingMode: params.BillingMode}
+	}
+	if params.StreamSpecification != nil && aws.ToBool(params.StreamSpecification.StreamEnabled) {
+		description.LatestStreamArn = aws.String(fmt.Sprintf("%s/stream/%s", aws.ToString(description.TableArn), time.Now().UTC().Format("2006-01-02T15:04:05.000")))
+	}
+
+	for _, gsi := range params.GlobalSecondaryIndexes {
+		indexSchema, err := newKeySchema(gsi.KeySchema)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes[aws.ToString(gsi.IndexName)] = &index{name: aws.ToString(gsi.IndexName), keySchema: indexSchema, projection: gsi.Projection}
+		description.GlobalSecondaryIndexes = append(description.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:   gsi.IndexName,
+			KeySchema:   gsi.KeySchema,
+			Projection:  gsi.Projection,
+			IndexStatus: types.IndexStatusActive,
+		})
+	}
+	for _, lsi := range params.LocalSecondaryIndexes {
+		indexSchema, err := newKeySchema(lsi.KeySchema)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes[aws.ToString(lsi.IndexName)] = &index{name: aws.ToString(lsi.IndexName), keySchema: indexSchema, projection: lsi.Projection}
+		description.LocalSecondaryIndexes = append(description.LocalSecondaryIndexes, types.LocalSecondaryIndexDescription{
+			IndexName:  lsi.IndexName,
+			KeySchema:  lsi.KeySchema,
+			Projection: lsi.Projection,
+		})
+	}
+
+	for _, attribute := range append([]string{schema.hashKey, schema.rangeKey}, indexKeyAttributes(t.indexes)...) {
+		if _, ok := attributeTypes[attribute]; attribute != "" && !ok {
+			return nil, validationError("key attribute %s is not defined", attribute)
+		}
+	}
+
+	t.description = description
+	c.tables[name] = t
+
+	return &dynamodb.CreateTableOutput{TableDescription: c.describe(t)}, nil
+}
+
+func indexKeyAttributes(indexes map[string]*index) []string {
+	attributes := []string{}
+	for _, idx := range indexes {
+		attributes = append(attributes, idx.keySchema.hashKey, idx.keySchema.rangeKey)
+	}
+	return attributes
+}
+
+func (c *Client) describe(t *table) *types.TableDescription {
+	description := t.description
+	size := 0
+	for _, i := range t.items {
+		size += itemSize(i)
+	}
+	description.ItemCount = int64(len(t.items))
+	description.TableSizeBytes = int64(size)
+	return &description
+}
+
+func (c *Client) DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(c.tables, aws.ToString(params.TableName))
+
+	description := c.describe(t)
+	description.TableStatus = types.TableStatusDeleting
+	return &dynamodb.DeleteTableOutput{TableDescription: description}, nil
+}
+
+func (c *Client) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.DescribeTableOutput{Table: c.describe(t)}, nil
+}
+
+func (c *Client) ListTables(ctx context.Context, params *dynamodb.ListTablesInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ListTablesOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	for name := range c.tables {
+		if params.ExclusiveStartTableName == nil || name > *params.ExclusiveStartTableName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	output := &dynamodb.ListTablesOutput{}
+	if limit := int(aws.ToInt32(params.Limit)); limit > 0 && len(names) > limit {
+		names = names[:limit]
+		output.LastEvaluatedTableName = aws.String(names[limit-1])
+	}
+	output.TableNames = names
+	return output, nil
+}
+
+func (c *Client) UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if params.TimeToLiveSpecification == nil {
+		return nil, validationError("time to live specification is required")
+	}
+
+	status := types.TimeToLiveStatusDisabled
+	if aws.ToBool(params.TimeToLiveSpecification.Enabled) {
+		status = types.TimeToLiveStatusEnabled
+	}
+	t.ttl = &types.TimeToLiveDescription{
+		AttributeName:    params.TimeToLiveSpecification.AttributeName,
+		TimeToLiveStatus: status,
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: params.TimeToLiveSpecification}, nil
+}
+
+func (c *Client) DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	description := &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	if t.ttl != nil {
+		ttl := *t.ttl
+		description = &ttl
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: description}, nil
+}
+
+// checkCondition evaluates a condition expression against the current item, which is empty if it doesn't exist
+func checkCondition(current item, expr *string, names map[string]string, values map[string]types.AttributeValue) error {
+	if expr == nil {
+		return nil
+	}
+
+	cond, err := parseCondition(*expr, names, values)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = item{}
+	}
+	if !cond.eval(current) {
+		return conditionalCheckFailedError()
+	}
+	return nil
+}
+
+func writeCapacity(tableName *string, returnConsumedCapacity types.ReturnConsumedCapacity, items ...item) *types.ConsumedCapacity {
+	if returnConsumedCapacity == "" || returnConsumedCapacity == types.ReturnConsumedCapacityNone {
+		return nil
+	}
+
+	units := 0.0
+	for _, i := range items {
+		units += math.Max(1, math.Ceil(float64(itemSize(i))/1024))
+	}
+	return &types.ConsumedCapacity{TableName: tableName, CapacityUnits: aws.Float64(units), WriteCapacityUnits: aws.Float64(units)}
+}
+
+func readCapacity(tableName *string, returnConsumedCapacity types.ReturnConsumedCapacity, consistentRead bool, size int) *types.ConsumedCapacity {
+	if returnConsumedCapacity == "" || returnConsumedCapacity == types.ReturnConsumedCapacityNone {
+		return nil
+	}
+
+	units := math.Max(1, math.Ceil(float64(size)/4096))
+	if !consistentRead {
+		units /= 2
+	}
+	return &types.ConsumedCapacity{TableName: tableName, CapacityUnits: aws.Float64(units), ReadCapacityUnits: aws.Float64(units)}
+}
+
+func (c *Client) putItem(t *table, i item, condition *string, names map[string]string, values map[string]types.AttributeValue) (item, error) {
+	if err := t.validateKey(i, false); err != nil {
+		return nil, err
+	}
+	if err := t.validateIndexKeys(i); err != nil {
+		return nil, err
+	}
+
+	key := t.keySchema.encode(i)
+	old := t.items[key]
+	if err := checkCondition(old, condition, names, values); err != nil {
+		return nil, err
+	}
+
+	t.items[key] = copyItem(i)
+	return old, nil
+}
+
+func (c *Client) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := c.putItem(t, params.Item, params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.PutItemOutput{ConsumedCapacity: writeCapacity(params.TableName, params.ReturnConsumedCapacity, params.Item)}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = copyItem(old)
+	}
+	return output, nil
+}
+
+func (c *Client) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.validateKey(params.Key, true); err != nil {
+		return nil, err
+	}
+
+	found, ok := t.items[t.keySchema.encode(params.Key)]
+	output := &dynamodb.GetItemOutput{ConsumedCapacity: readCapacity(params.TableName, params.ReturnConsumedCapacity, aws.ToBool(params.ConsistentRead), itemSize(found))}
+	if !ok {
+		return output, nil
+	}
+
+	result, err := projectItem(found, params.ProjectionExpression, params.ExpressionAttributeNames)
+	if err != nil {
+		return nil, err
+	}
+	output.Item = result
+	return output, nil
+}
+
+func projectItem(i item, projection *string, names map[string]string) (item, error) {
+	if projection == nil {
+		return copyItem(i), nil
+	}
+
+	paths, err := parseProjection(*projection, names)
+	if err != nil {
+		return nil, err
+	}
+	return project(i, paths), nil
+}
+
+func (c *Client) updateItem(t *table, key item, update *string, condition *string, names map[string]string, values map[string]types.AttributeValue) (item, item, error) {
+	if err := t.validateKey(key, true); err != nil {
+		return nil, nil, err
+	}
+
+	encodedKey := t.keySchema.encode(key)
+	old := t.items[encodedKey]
+	if err := checkCondition(old, condition, names, values); err != nil {
+		return nil, nil, err
+	}
+
+	updated := copyItem(old)
+	if updated == nil {
+		updated = copyItem(key)
+	}
+	if update != nil {
+		actions, err := parseUpdate(*update, names, values)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, action := range actions {
+			if action.path[0].name == t.keySchema.hashKey || action.path[0].name == t.keySchema.rangeKey {
+				return nil, nil, validationError("cannot update attribute %s, this attribute is part of the key", action.path[0].name)
+			}
+		}
+		if err := applyUpdate(updated, actions); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := t.validateIndexKeys(updated); err != nil {
+		return nil, nil, err
+	}
+
+	t.items[encodedKey] = updated
+	return old, updated, nil
+}
+
+func (c *Client) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	old, updated, err := c.updateItem(t, params.Key, params.UpdateExpression, params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.UpdateItemOutput{ConsumedCapacity: writeCapacity(params.TableName, params.ReturnConsumedCapacity, updated)}
+	switch params.ReturnValues {
+	case types.ReturnValueAllOld:
+		output.Attributes = copyItem(old)
+	case types.ReturnValueAllNew:
+		output.Attributes = copyItem(updated)
+	case types.ReturnValueUpdatedOld, types.ReturnValueUpdatedNew:
+		source := updated
+		if params.ReturnValues == types.ReturnValueUpdatedOld {
+			source = old
+		}
+		actions, _ := parseUpdate(aws.ToString(params.UpdateExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		output.Attributes = item{}
+		for _, action := range actions {
+			if value, ok := source[action.path[0].name]; ok {
+				output.Attributes[action.path[0].name] = copyValue(value)
+			}
+		}
+	}
+	return output, nil
+}
+
+func (c *Client) deleteItem(t *table, key item, condition *string, names map[string]string, values map[string]types.AttributeValue) (item, error) {
+	if err := t.validateKey(key, true); err != nil {
+		return nil, err
+	}
+
+	encodedKey := t.keySchema.encode(key)
+	old := t.items[encodedKey]
+	if err := checkCondition(old, condition, names, values); err != nil {
+		return nil, err
+	}
+
+	delete(t.items, encodedKey)
+	return old, nil
+}
+
+func (c *Client) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, err := c.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := c.deleteItem(t, params.Key, params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.DeleteItemOutput{ConsumedCapacity: writeCapacity(params.TableName, params.ReturnConsumedCapacity, params.Key)}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = copyItem(old)
+	}
+	return output, nil
+}
+
+func (c *Client) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keyCount := 0
+	for _, request := range params.RequestItems {
+		keyCount += len(request.Keys)
+	}
+	if keyCount > 100 {
+		return nil, validationError("too many items requested for the BatchGetItem call")
+	}
+
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]types.AttributeValue{},
+		UnprocessedKeys: map[string]types.KeysAndAttributes{},
+	}
+	for tableName, request := range params.RequestItems {
+		t, err := c.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+
+		responses := []map[string]types.AttributeValue{}
+		size := 0
+		for _, key := range request.Keys {
+			if err := t.validateKey(key, true); err != nil {
+				return nil, err
+			}
+			found, ok := t.items[t.keySchema.encode(key)]
+			if !ok {
+				continue
+			}
+			result, err := projectItem(found, request.ProjectionExpression, request.ExpressionAttributeNames)
+			if err != nil {
+				return nil, err
+			}
+			size += itemSize(found)
+			responses = append(responses, result)
+		}
+		output.Responses[tableName] = responses
+		if capacity := readCapacity(aws.String(tableName), params.ReturnConsumedCapacity, aws.ToBool(request.ConsistentRead), size); capacity != nil {
+			output.ConsumedCapacity = append(output.ConsumedCapacity, *capacity)
+		}
+	}
+	return output, nil
+}
+
+func (c *Client) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	requestCount := 0
+	for tableName, requests := range params.RequestItems {
+		if _, err := c.table(aws.String(tableName)); err != nil {
+			return nil, err
+		}
+		requestCount += len(requests)
+	}
+	if requestCount > 25 {
+		return nil, validationError("too many items requested for the BatchWriteItem call")
+	}
+
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]types.WriteRequest{}}
+	for tableName, requests := range params.RequestItems {
+		t := c.tables[tableName]
+		written := []item{}
+		for _, request := range requests {
+			switch {
+			case request.PutRequest != nil:
+				if _, err := c.putItem(t, request.PutRequest.Item, nil, nil, nil); err != nil {
+					return nil, err
+				}
+				written = append(written, request.PutRequest.Item)
+			case request.DeleteRequest != nil:
+				if _, err := c.deleteItem(t, request.DeleteRequest.Key, nil, nil, nil); err != nil {
+					return nil, err
+				}
+				written = append(written, request.DeleteRequest.Key)
+			}
+		}
+		if capacity := writeCapacity(aws.String(tableName), params.ReturnConsumedCapacity, written...); capacity != nil {
+			output.ConsumedCapacity = append(output.ConsumedCapacity, *capacity)
+		}
+	}
+	return output, nil
+}
+
+// position orders the items of a Query or Scan
+type position struct {
+	hash       string
+	rangeValue types.AttributeValue
+	primary    string
+}
+
+func comparePositions(a, b position) int {
+	if a.hash != b.hash {
+		if a.hash < b.hash {
+			return -1
+		}
+		return 1
+	}
+	if a.rangeValue != nil && b.rangeValue != nil {
+		if cmp, _ := compareValues(a.rangeValue, b.rangeValue); cmp != 0 {
+			return cmp
+		}
+	}
+	if a.primary < b.primary {
+		return -1
+	} else if a.primary > b.primary {
+		return 1
+	}
+	return 0
+}
+
+type readRequest struct {
+	tableName         *string
+	indexName         *string
+	keyCondition      *string
+	filter            *string
+	projection        *string
+	names             map[string]string
+	values            map[string]types.AttributeValue
+	limit             *int32
+	exclusiveStartKey map[string]types.AttributeValue
+	forward           bool
+	selectCount       bool
+	segment           *int32
+	totalSegments     *int32
+}
+
+type readResult struct {
+	items            []map[string]types.AttributeValue
+	count            int32
+	scannedCount     int32
+	lastEvaluatedKey map[string]types.AttributeValue
+	size             int
+}
+
+// read implements Query and Scan, a Scan has no key condition
+func (c *Client) read(request *readRequest) (*readResult, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, err := c.table(request.tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := t.keySchema
+	var idx *index
+	if request.indexName != nil {
+		var ok bool
+		idx, ok = t.indexes[*request.indexName]
+		if !ok {
+			return nil, validationError("the table does not have the specified index: %s", *request.indexName)
+		}
+		schema = idx.keySchema
+	}
+
+	var keyCondition condition
+	if request.keyCondition != nil {
+		keyCondition, err = parseCondition(*request.keyCondition, request.names, request.values)
+		if err != nil {
+			return nil, err
+		}
+		if !keyEqualities(keyCondition)[schema.hashKey] {
+			return nil, validationError("query condition missed key schema element: %s", schema.hashKey)
+		}
+	}
+
+	var filter condition
+	if request.filter != nil {
+		filter, err = parseCondition(*request.filter, request.names, request.values)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var projection []path
+	if request.projection != nil {
+		projection, err = parseProjection(*request.projection, request.names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if (request.segment == nil) != (request.totalSegments == nil) {
+		return nil, validationError("segment and total segments must be specified together")
+	}
+
+	positionOf := func(i item) position {
+		p := position{hash: encodeKeyValue(i[schema.hashKey]), primary: t.keySchema.encode(i)}
+		if schema.rangeKey != "" {
+			p.rangeValue = i[schema.rangeKey]
+		}
+		return p
+	}
+
+	type candidate struct {
+		item     item
+		position position
+	}
+	candidates := []candidate{}
+	for _, i := range t.items {
+		if !schema.has(i) {
+			continue
+		}
+		if keyCondition != nil && !keyCondition.eval(i) {
+			continue
+		}
+		if request.segment != nil {
+			h := fnv.New32a()
+			h.Write([]byte(encodeKeyValue(i[t.keySchema.hashKey])))
+			if int32(h.Sum32()%uint32(*request.totalSegments)) != *request.segment {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate{item: i, position: positionOf(i)})
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		cmp := comparePositions(candidates[a].position, candidates[b].position)
+		if !request.forward {
+			cmp = -cmp
+		}
+		return cmp < 0
+	})
+
+	if request.exclusiveStartKey != nil {
+		start := positionOf(request.exclusiveStartKey)
+		skip := 0
+		for skip < len(candidates) {
+			cmp := comparePositions(candidates[skip].position, start)
+			if !request.forward {
+				cmp = -cmp
+			}
+			if cmp > 0 {
+				break
+			}
+			skip++
+		}
+		candidates = candidates[skip:]
+	}
+
+	limit := len(candidates)
+	if request.limit != nil && int(*request.limit) < limit {
+		limit = int(*request.limit)
+	}
+	if c.options.MaxPageSize > 0 && c.options.MaxPageSize < limit {
+		limit = c.options.MaxPageSize
+	}
+
+	result := &readResult{items: []map[string]types.AttributeValue{}}
+	evaluated := 0
+	for _, candidate := range candidates {
+		if evaluated >= limit || result.size >= maxPageBytes {
+			break
+		}
+		evaluated++
+		result.scannedCount++
+		result.size += itemSize(candidate.item)
+
+		i := candidate.item
+		if idx != nil {
+			i = projectIndex(t, idx, i)
+		}
+		if filter != nil && !filter.eval(i) {
+			continue
+		}
+
+		result.count++
+		if request.selectCount {
+			continue
+		}
+		if projection != nil {
+			result.items = append(result.items, project(i, projection))
+		} else {
+			result.items = append(result.items, copyItem(i))
+		}
+	}
+
+	if evaluated < len(candidates) && evaluated > 0 {
+		last := candidates[evaluated-1].item
+		lastEvaluatedKey := t.keySchema.extract(last)
+		for k, v := range schema.extract(last) {
+			lastEvaluatedKey[k] = v
+		}
+		result.lastEvaluatedKey = copyItem(lastEvaluatedKey)
+	}
+	if request.selectCount {
+		result.items = nil
+	}
+
+	return result, nil
+}
+
+// projectIndex returns the attributes of an item projected into an index
+func projectIndex(t *table, idx *index, i item) item {
+	if idx.projection == nil || idx.projection.ProjectionType == types.ProjectionTypeAll {
+		return i
+	}
+
+	projected := t.keySchema.extract(i)
+	for k, v := range idx.keySchema.extract(i) {
+		projected[k] = v
+	}
+	if idx.projection.ProjectionType == types.ProjectionTypeInclude {
+		for _, attribute := range idx.projection.NonKeyAttributes {
+			if v, ok := i[attribute]; ok {
+				projected[attribute] = v
+			}
+		}
+	}
+	return projected
+}
+
+func (c *Client) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if params.KeyConditionExpression == nil {
+		return nil, validationError("key condition expression is required")
+	}
+
+	result, err := c.read(&readRequest{
+		tableName:         params.TableName,
+		indexName:         params.IndexName,
+		keyCondition:      params.KeyConditionExpression,
+		filter:            params.FilterExpression,
+		projection:        params.ProjectionExpression,
+		names:             params.ExpressionAttributeNames,
+		values:            params.ExpressionAttributeValues,
+		limit:             params.Limit,
+		exclusiveStartKey: params.ExclusiveStartKey,
+		forward:           params.ScanIndexForward == nil || *params.ScanIndexForward,
+		selectCount:       params.Select == types.SelectCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.QueryOutput{
+		Items:            result.items,
+		Count:            result.count,
+		ScannedCount:     result.scannedCount,
+		LastEvaluatedKey: result.lastEvaluatedKey,
+		ConsumedCapacity: readCapacity(params.TableName, params.ReturnConsumedCapacity, aws.ToBool(params.ConsistentRead), result.size),
+	}, nil
+}
+
+func (c *Client) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	result, err := c.read(&readRequest{
+		tableName:         params.TableName,
+		indexName:         params.IndexName,
+		filter:            params.FilterExpression,
+		projection:        params.ProjectionExpression,
+		names:             params.ExpressionAttributeNames,
+		values:            params.ExpressionAttributeValues,
+		limit:             params.Limit,
+		exclusiveStartKey: params.ExclusiveStartKey,
+		forward:           true,
+		selectCount:       params.Select == types.SelectCount,
+		segment:           params.Segment,
+		totalSegments:     params.TotalSegments,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.ScanOutput{
+		Items:            result.items,
+		Count:            result.count,
+		ScannedCount:     result.scannedCount,
+		LastEvaluatedKey: result.lastEvaluatedKey,
+		ConsumedCapacity: readCapacity(params.TableName, params.ReturnConsumedCapacity, aws.ToBool(params.ConsistentRead), result.size),
+	}, nil
+}
+
+// DynamoDB rejects transactions with more actions
+const maxTransactionItems = 100
+
+// transactionItem is one action of a transaction, conditions are evaluated on the state before the transaction
+type transactionItem struct {
+	table     *table
+	key       item
+	condition *string
+	names     map[string]string
+	values    map[string]types.AttributeValue
+	apply     func() (item, error)
+}
+
+func (c *Client) transactionItem(action types.TransactWriteItem) (*transactionItem, error) {
+	switch {
+	case action.ConditionCheck != nil:
+		t, err := c.table(action.ConditionCheck.TableName)
+		if err != nil {
+			return nil, err
+		}
+		check := action.ConditionCheck
+		return &transactionItem{table: t, key: check.Key, condition: check.ConditionExpression, names: check.ExpressionAttributeNames, values: check.ExpressionAttributeValues,
+			apply: func() (item, error) {
+				return nil, t.validateKey(check.Key, true)
+			}}, nil
+	case action.Put != nil:
+		t, err := c.table(action.Put.TableName)
+		if err != nil {
+			return nil, err
+		}
+		put := action.Put
+		return &transactionItem{table: t, key: t.keySchema.extract(put.Item), condition: put.ConditionExpression, names: put.ExpressionAttributeNames, values: put.ExpressionAttributeValues,
+			apply: func() (item, error) {
+				_, err := c.putItem(t, put.Item, nil, nil, nil)
+				return put.Item, err
+			}}, nil
+	case action.Update != nil:
+		t, err := c.table(action.Update.TableName)
+		if err != nil {
+			return nil, err
+		}
+		update := action.Update
+		return &transactionItem{table: t, key: update.Key, condition: update.ConditionExpression, names: update.ExpressionAttributeNames, values: update.ExpressionAttributeValues,
+			apply: func() (item, error) {
+				_, updated, err := c.updateItem(t, update.Key, update.UpdateExpression, nil, update.ExpressionAttributeNames, update.ExpressionAttributeValues)
+				return updated, err
+			}}, nil
+	case action.Delete != nil:
+		t, err := c.table(action.Delete.TableName)
+		if err != nil {
+			return nil, err
+		}
+		del := action.Delete
+		return &transactionItem{table: t, key: del.Key, condition: del.ConditionExpression, names: del.ExpressionAttributeNames, values: del.ExpressionAttributeValues,
+			apply: func() (item, error) {
+				_, err := c.deleteItem(t, del.Key, nil, nil, nil)
+				return del.Key, err
+			}}, nil
+	}
+	return nil, validationError("transact item must contain one action")
+}
+
+// TransactWriteItems applies all actions or none of them, failed conditions are reported as cancellation reasons
+func (c *Client) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(params.TransactItems) > maxTransactionItems {
+		return nil, validationError("member must have length less than or equal to %d", maxTransactionItems)
+	}
+
+	items := make([]*transactionItem, len(params.TransactItems))
+	seen := map[*table]map[string]bool{}
+	for i, action := range params.TransactItems {
+		write, err := c.transactionItem(action)
+		if err != nil {
+			return nil, err
+		}
+		if err := write.table.validateKey(write.key, false); err != nil {
+			return nil, err
+		}
+		key := write.table.keySchema.encode(write.key)
+		if seen[write.table] == nil {
+			seen[write.table] = map[string]bool{}
+		}
+		if seen[write.table][key] {
+			return nil, validationError("transaction request cannot include multiple operations on one item")
+		}
+		seen[write.table][key] = true
+		items[i] = write
+	}
+
+	reasons := make([]types.CancellationReason, len(items))
+	canceled := false
+	for i, write := range items {
+		reasons[i] = types.CancellationReason{Code: aws.String("None")}
+		current := write.table.items[write.table.keySchema.encode(write.key)]
+		if err := checkCondition(current, write.condition, write.names, write.values); err != nil {
+			var conditionalCheckFailed *types.ConditionalCheckFailedException
+			if !errors.As(err, &conditionalCheckFailed) {
+				return nil, err
+			}
+			reasons[i] = types.CancellationReason{Code: aws.String("ConditionalCheckFailed"), Message: aws.String("The conditional request failed")}
+			canceled = true
+		}
+	}
+	if canceled {
+		codes := make([]string, len(reasons))
+		for i, reason := range reasons {
+			codes[i] = aws.ToString(reason.Code)
+		}
+		return nil, &types.TransactionCanceledException{
+			Message:             aws.String(fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(codes, ", "))),
+			CancellationReasons: reasons,
+		}
+	}
+
+	// Restore the tables when an action turns out to be invalid
+	snapshots := map[*table]map[string]item{}
+	for t := range seen {
+		snapshot := make(map[string]item, len(t.items))
+		for key, i := range t.items {
+			snapshot[key] = i
+		}
+		snapshots[t] = snapshot
+	}
+	written := map[string][]item{}
+	for i, write := range items {
+		result, err := write.apply()
+		if err != nil {
+			for t, snapshot := range snapshots {
+				t.items = snapshot
+			}
+			return nil, err
+		}
+		if params.TransactItems[i].ConditionCheck == nil {
+			tableName := aws.ToString(write.table.description.TableName)
+			written[tableName] = append(written[tableName], result)
+		}
+	}
+
+	output := &dynamodb.TransactWriteItemsOutput{}
+	for tableName, tableItems := range written {
+		// Transactional writes consume twice the capacity
+		if capacity := writeCapacity(aws.String(tableName), params.ReturnConsumedCapacity, append(tableItems, tableItems...)...); capacity != nil {
+			output.ConsumedCapacity = append(output.ConsumedCapacity, *capacity)
+		}
+	}
+	return output, nil
+}
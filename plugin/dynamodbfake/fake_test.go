@@ -0,0 +1,341 @@
+package dynamodbfake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	PK     string
+	SK     int64
+	Bucket string `dynamodbav:",omitempty"`
+	Time   int64
+	Tags   map[string]string
+	Count  int64
+}
+
+func createTestTable(assert *assert.Assertions, ctx context.Context, client *Client) {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String("test"),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("PK"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("SK"), AttributeType: types.ScalarAttributeTypeN},
+			{AttributeName: aws.String("Bucket"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("Time"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("BucketIndex"),
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("Bucket"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("Time"), KeyType: types.KeyTypeRange},
+				},
+				Projection: &types.Projection{
+					ProjectionType:   types.ProjectionTypeInclude,
+					NonKeyAttributes: []string{"Tags"},
+				},
+			},
+		},
+	})
+	assert.NoError(err)
+}
+
+func putTestItem(assert *assert.Assertions, ctx context.Context, client *Client, i *testItem) {
+	av, err := attributevalue.MarshalMap(i)
+	assert.NoError(err)
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String("test"), Item: av})
+	assert.NoError(err)
+}
+
+func TestQuery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	client := New(&Options{MaxPageSize: 2})
+	createTestTable(assert, ctx, client)
+
+	for i := int64(0); i < 5; i++ {
+		putTestItem(assert, ctx, client, &testItem{PK: "a", SK: i, Bucket: "b", Time: 1637000000000000000 + i, Tags: map[string]string{"k": fmt.Sprint(i % 2)}})
+	}
+	putTestItem(assert, ctx, client, &testItem{PK: "b", SK: 0, Time: 1637000000000000000})
+
+	keyCond := expression.Key("PK").Equal(expression.Value("a")).And(expression.Key("SK").GreaterThanEqual(expression.Value(1)))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).WithProjection(expression.NamesList(expression.Name("SK"))).Build()
+	assert.NoError(err)
+
+	paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+		TableName:                 aws.String("test"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+	})
+
+	pages := 0
+	items := []testItem{}
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		assert.NoError(err)
+
+		pageItems := []testItem{}
+		assert.NoError(attributevalue.UnmarshalListOfMaps(output.Items, &pageItems))
+		items = append(items, pageItems...)
+		pages++
+	}
+	assert.Equal(2, pages)
+	assert.Equal([]testItem{{SK: 4}, {SK: 3}, {SK: 2}, {SK: 1}}, items)
+
+	// Index queries only return projected attributes of items with index keys, the limit applies before the filter
+	keyCond = expression.Key("Bucket").Equal(expression.Value("b")).And(
+		expression.Key("Time").Between(expression.Value(int64(1637000000000000001)), expression.Value(int64(1637000000000000003))))
+	expr, err = expression.NewBuilder().WithKeyCondition(keyCond).WithFilter(expression.Name("Tags.k").Equal(expression.Value("1"))).Build()
+	assert.NoError(err)
+
+	output, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String("test"),
+		IndexName:                 aws.String("BucketIndex"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Limit:                     aws.Int32(2),
+	})
+	assert.NoError(err)
+	assert.Equal(int32(1), output.Count)
+	assert.Equal(int32(2), output.ScannedCount)
+	assert.Len(output.LastEvaluatedKey, 4)
+
+	items = []testItem{}
+	assert.NoError(attributevalue.UnmarshalListOfMaps(output.Items, &items))
+	assert.Equal([]testItem{{PK: "a", SK: 1, Bucket: "b", Time: 1637000000000000001, Tags: map[string]string{"k": "1"}}}, items)
+
+	// Queries require the hash key
+	expr, err = expression.NewBuilder().WithKeyCondition(expression.Key("SK").Equal(expression.Value(1))).Build()
+	assert.NoError(err)
+	_, err = client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String("test"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	assert.Error(err)
+
+	_, err = client.Query(ctx, &dynamodb.QueryInput{TableName: aws.String("missing"), KeyConditionExpression: aws.String("PK = :a")})
+	var notFound *types.ResourceNotFoundException
+	assert.True(errors.As(err, &notFound))
+}
+
+func TestScanSegments(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	client := New(nil)
+	createTestTable(assert, ctx, client)
+	for i := int64(0); i < 20; i++ {
+		putTestItem(assert, ctx, client, &testItem{PK: fmt.Sprintf("pk-%d", i), SK: i})
+	}
+
+	expr, err := expression.NewBuilder().WithFilter(expression.Name("PK").BeginsWith("pk-1")).Build()
+	assert.NoError(err)
+
+	total := 0
+	for segment := int32(0); segment < 4; segment++ {
+		output, err := client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:                 aws.String("test"),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			Segment:                   aws.Int32(segment),
+			TotalSegments:             aws.Int32(4),
+		})
+		assert.NoError(err)
+		total += int(output.Count)
+	}
+	// pk-1 and pk-10 to pk-19
+	assert.Equal(11, total)
+}
+
+func TestUpdateItem(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	client := New(nil)
+	createTestTable(assert, ctx, client)
+
+	key := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "a"},
+		"SK": &types.AttributeValueMemberN{Value: "1"},
+	}
+
+	update := func(builder expression.UpdateBuilder, condition *expression.ConditionBuilder) (*dynamodb.UpdateItemOutput, error) {
+		b := expression.NewBuilder().WithUpdate(builder)
+		if condition != nil {
+			b = b.WithCondition(*condition)
+		}
+		expr, err := b.Build()
+		assert.NoError(err)
+
+		return client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String("test"),
+			Key:                       key,
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ReturnValues:              types.ReturnValueAllNew,
+		})
+	}
+
+	_, err := update(expression.Add(expression.Name("Count"), expression.Value(2)).Set(expression.Name("Bucket"), expression.Value("b")), nil)
+	assert.NoError(err)
+	output, err := update(expression.Add(expression.Name("Count"), expression.Value(3)).
+		Set(expression.Name("Time"), expression.IfNotExists(expression.Name("Time"), expression.Value(42))), nil)
+	assert.NoError(err)
+
+	var updated testItem
+	assert.NoError(attributevalue.UnmarshalMap(output.Attributes, &updated))
+	assert.Equal(testItem{PK: "a", SK: 1, Bucket: "b", Time: 42, Count: 5}, updated)
+
+	condition := expression.Name("Count").LessThan(expression.Value(5))
+	_, err = update(expression.Remove(expression.Name("Bucket")), &condition)
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	assert.True(errors.As(err, &conditionalCheckFailed))
+
+	condition = expression.AttributeExists(expression.Name("Bucket"))
+	output, err = update(expression.Remove(expression.Name("Bucket")).Set(expression.Name("Count"), expression.Name("Count").Minus(expression.Value(1))), &condition)
+	assert.NoError(err)
+	updated = testItem{}
+	assert.NoError(attributevalue.UnmarshalMap(output.Attributes, &updated))
+	assert.Equal(testItem{PK: "a", SK: 1, Time: 42, Count: 4}, updated)
+
+	getOutput, err := client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{
+			"test": {Keys: []map[string]types.AttributeValue{key, {
+				"PK": &types.AttributeValueMemberS{Value: "missing"},
+				"SK": &types.AttributeValueMemberN{Value: "1"},
+			}}},
+		},
+	})
+	assert.NoError(err)
+	assert.Len(getOutput.Responses["test"], 1)
+}
+
+func TestConditionalPut(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	client := New(nil)
+	createTestTable(assert, ctx, client)
+
+	av, err := attributevalue.MarshalMap(&testItem{PK: "a", SK: 1})
+	assert.NoError(err)
+
+	expr, err := expression.NewBuilder().WithCondition(expression.AttributeNotExists(expression.Name("PK"))).Build()
+	assert.NoError(err)
+
+	put := func() error {
+		_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String("test"),
+			Item:                      av,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		return err
+	}
+	assert.NoError(put())
+
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	assert.True(errors.As(put(), &conditionalCheckFailed))
+
+	// Keys must match the declared types
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String("test"),
+		Item: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "a"},
+			"SK": &types.AttributeValueMemberS{Value: "1"},
+		},
+	})
+	assert.Error(err)
+}
+
+func TestTransactWriteItems(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	client := New(nil)
+	createTestTable(assert, ctx, client)
+
+	av, err := attributevalue.MarshalMap(&testItem{PK: "marker", SK: 1})
+	assert.NoError(err)
+	expr, err := expression.NewBuilder().WithCondition(expression.AttributeNotExists(expression.Name("PK"))).Build()
+	assert.NoError(err)
+	update, err := expression.NewBuilder().WithUpdate(expression.Add(expression.Name("Count"), expression.Value(1))).Build()
+	assert.NoError(err)
+
+	transact := func() error {
+		_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{
+				{Update: &types.Update{
+					TableName: aws.String("test"),
+					Key: map[string]types.AttributeValue{
+						"PK": &types.AttributeValueMemberS{Value: "counter"},
+						"SK": &types.AttributeValueMemberN{Value: "1"},
+					},
+					UpdateExpression:          update.Update(),
+					ExpressionAttributeNames:  update.Names(),
+					ExpressionAttributeValues: update.Values(),
+				}},
+				{Put: &types.Put{
+					TableName:                 aws.String("test"),
+					Item:                      av,
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+				}},
+			},
+		})
+		return err
+	}
+	assert.NoError(transact())
+
+	// The failed condition of the marker cancels the update
+	var canceled *types.TransactionCanceledException
+	assert.True(errors.As(transact(), &canceled))
+	assert.Equal("None", aws.ToString(canceled.CancellationReasons[0].Code))
+	assert.Equal("ConditionalCheckFailed", aws.ToString(canceled.CancellationReasons[1].Code))
+
+	output, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("test"),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "counter"},
+			"SK": &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	assert.NoError(err)
+	assert.Equal(&types.AttributeValueMemberN{Value: "1"}, output.Item["Count"])
+
+	// Items can only be written once per transaction
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{TableName: aws.String("test"), Item: av}},
+			{Delete: &types.Delete{TableName: aws.String("test"), Key: av}},
+		},
+	})
+	assert.Error(err)
+}
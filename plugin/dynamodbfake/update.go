@@ -0,0 +1,325 @@
+package dynamodbfake
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// updateOperand is a value within a SET action
+type updateOperand struct {
+	operand *operand
+	// if_not_exists or list_append
+	function string
+	args     []*updateOperand
+}
+
+func (o *updateOperand) resolve(i item) (types.AttributeValue, error) {
+	switch o.function {
+	case "if_not_exists":
+		if value := o.args[0].operand.resolve(i); value != nil {
+			return value, nil
+		}
+		return o.args[1].resolve(i)
+	case "list_append":
+		first, err := o.args[0].resolve(i)
+		if err != nil {
+			return nil, err
+		}
+		second, err := o.args[1].resolve(i)
+		if err != nil {
+			return nil, err
+		}
+		firstList, ok := first.(*types.AttributeValueMemberL)
+		if !ok {
+			return nil, validationError("list_append requires list operands")
+		}
+		secondList, ok := second.(*types.AttributeValueMemberL)
+		if !ok {
+			return nil, validationError("list_append requires list operands")
+		}
+		return &types.AttributeValueMemberL{Value: append(append([]types.AttributeValue{}, firstList.Value...), secondList.Value...)}, nil
+	}
+
+	value := o.operand.resolve(i)
+	if value == nil {
+		return nil, validationError("the provided expression refers to an attribute that does not exist in the item")
+	}
+	return value, nil
+}
+
+type updateAction struct {
+	action string
+	path   path
+	// Operands of SET, combined with the operator + or -
+	operands []*updateOperand
+	operator string
+	// Value of ADD and DELETE
+	value types.AttributeValue
+}
+
+func parseUpdate(expr string, names map[string]string, values map[string]types.AttributeValue) ([]*updateAction, error) {
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []*updateAction{}
+	seen := map[string]bool{}
+	for p.peek().kind != tokenEOF {
+		clause := strings.ToUpper(p.next().text)
+		if seen[clause] {
+			return nil, p.errorf("the %s section can only be used once", clause)
+		}
+		seen[clause] = true
+
+		for {
+			action, err := p.parseUpdateAction(clause)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, action)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if len(actions) == 0 {
+		return nil, validationError("update expression must not be empty")
+	}
+	return actions, nil
+}
+
+func (p *parser) parseUpdateAction(clause string) (*updateAction, error) {
+	attributePath, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	action := &updateAction{action: clause, path: attributePath}
+
+	switch clause {
+	case "SET":
+		if err := p.expectPunct("="); err != nil {
+			return nil, err
+		}
+		first, err := p.parseUpdateOperand()
+		if err != nil {
+			return nil, err
+		}
+		action.operands = []*updateOperand{first}
+		if p.isPunct("+") || p.isPunct("-") {
+			action.operator = p.next().text
+			second, err := p.parseUpdateOperand()
+			if err != nil {
+				return nil, err
+			}
+			action.operands = append(action.operands, second)
+		}
+	case "ADD", "DELETE":
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		action.value = value
+	case "REMOVE":
+	default:
+		return nil, p.errorf("unknown update clause %s", clause)
+	}
+	return action, nil
+}
+
+func (p *parser) parseUpdateOperand() (*updateOperand, error) {
+	t := p.peek()
+	if (t.text == "if_not_exists" || t.text == "list_append") && p.tokens[p.pos+1].text == "(" {
+		p.next()
+		p.next()
+		first, err := p.parseUpdateOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		second, err := p.parseUpdateOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		if t.text == "if_not_exists" && first.operand == nil {
+			return nil, p.errorf("if_not_exists requires a path")
+		}
+		return &updateOperand{function: t.text, args: []*updateOperand{first, second}}, nil
+	}
+
+	o, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &updateOperand{operand: o}, nil
+}
+
+// applyUpdate applies all actions to the item, operands are resolved against the item before the update
+func applyUpdate(i item, actions []*updateAction) error {
+	original := copyItem(i)
+	for _, action := range actions {
+		if err := action.apply(original, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *updateAction) apply(original, i item) error {
+	switch a.action {
+	case "SET":
+		value, err := a.operands[0].resolve(original)
+		if err != nil {
+			return err
+		}
+		if a.operator != "" {
+			other, err := a.operands[1].resolve(original)
+			if err != nil {
+				return err
+			}
+			value, err = addNumbers(value, other, a.operator == "-")
+			if err != nil {
+				return err
+			}
+		}
+		return setPath(i, a.path, copyValue(value))
+	case "REMOVE":
+		removePath(i, a.path)
+		return nil
+	case "ADD":
+		current := resolvePath(i, a.path)
+		if current == nil {
+			return setPath(i, a.path, copyValue(a.value))
+		}
+		switch a.value.(type) {
+		case *types.AttributeValueMemberN:
+			value, err := addNumbers(current, a.value, false)
+			if err != nil {
+				return err
+			}
+			return setPath(i, a.path, value)
+		case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+			value, err := combineSets(current, a.value, false)
+			if err != nil {
+				return err
+			}
+			return setPath(i, a.path, value)
+		}
+		return validationError("ADD only supports numbers and sets")
+	case "DELETE":
+		current := resolvePath(i, a.path)
+		if current == nil {
+			return nil
+		}
+		value, err := combineSets(current, a.value, true)
+		if err != nil {
+			return err
+		}
+		if setLength(value) == 0 {
+			removePath(i, a.path)
+			return nil
+		}
+		return setPath(i, a.path, value)
+	}
+	return nil
+}
+
+func addNumbers(a, b types.AttributeValue, subtract bool) (types.AttributeValue, error) {
+	an, ok := a.(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, validationError("an operand in the update expression has an incorrect data type")
+	}
+	bn, ok := b.(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, validationError("an operand in the update expression has an incorrect data type")
+	}
+
+	ar, err := parseNumber(an.Value)
+	if err != nil {
+		return nil, err
+	}
+	br, err := parseNumber(bn.Value)
+	if err != nil {
+		return nil, err
+	}
+	if subtract {
+		return &types.AttributeValueMemberN{Value: formatNumber(ar.Sub(ar, br))}, nil
+	}
+	return &types.AttributeValueMemberN{Value: formatNumber(ar.Add(ar, br))}, nil
+}
+
+func combineSets(current, value types.AttributeValue, remove bool) (types.AttributeValue, error) {
+	if typeOf(current) != typeOf(value) {
+		return nil, validationError("an operand in the update expression has an incorrect data type")
+	}
+
+	combine := func(current, values []string, normalize func(string) string) []string {
+		result := []string{}
+		removed := map[string]bool{}
+		for _, v := range values {
+			removed[normalize(v)] = true
+		}
+		seen := map[string]bool{}
+		for _, v := range current {
+			if remove && removed[normalize(v)] {
+				continue
+			}
+			seen[normalize(v)] = true
+			result = append(result, v)
+		}
+		if !remove {
+			for _, v := range values {
+				if !seen[normalize(v)] {
+					seen[normalize(v)] = true
+					result = append(result, v)
+				}
+			}
+		}
+		return result
+	}
+	identity := func(s string) string { return s }
+
+	switch c := current.(type) {
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: combine(c.Value, value.(*types.AttributeValueMemberSS).Value, identity)}, nil
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: combine(c.Value, value.(*types.AttributeValueMemberNS).Value, func(s string) string {
+			return normalizeNumbers([]string{s})[0]
+		})}, nil
+	case *types.AttributeValueMemberBS:
+		currentEncoded := encodeBytes(c.Value)
+		combined := combine(currentEncoded, encodeBytes(value.(*types.AttributeValueMemberBS).Value), identity)
+		byEncoding := map[string][]byte{}
+		for i, b := range c.Value {
+			byEncoding[currentEncoded[i]] = b
+		}
+		for i, b := range value.(*types.AttributeValueMemberBS).Value {
+			byEncoding[encodeBytes(value.(*types.AttributeValueMemberBS).Value)[i]] = b
+		}
+		result := make([][]byte, len(combined))
+		for i, e := range combined {
+			result[i] = byEncoding[e]
+		}
+		return &types.AttributeValueMemberBS{Value: result}, nil
+	}
+	return nil, validationError("an operand in the update expression has an incorrect data type")
+}
+
+func setLength(value types.AttributeValue) int {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberSS:
+		return len(v.Value)
+	case *types.AttributeValueMemberNS:
+		return len(v.Value)
+	case *types.AttributeValueMemberBS:
+		return len(v.Value)
+	}
+	return 0
+}
@@ -0,0 +1,286 @@
+package dynamodbfake
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type item map[string]types.AttributeValue
+
+func copyItem(i item) item {
+	if i == nil {
+		return nil
+	}
+
+	c := make(item, len(i))
+	for k, v := range i {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(value types.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: append([]byte{}, v.Value...)}
+	case *types.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberBS:
+		bs := make([][]byte, len(v.Value))
+		for i, b := range v.Value {
+			bs[i] = append([]byte{}, b...)
+		}
+		return &types.AttributeValueMemberBS{Value: bs}
+	case *types.AttributeValueMemberL:
+		l := make([]types.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = copyValue(e)
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	}
+	return value
+}
+
+// typeOf returns the DynamoDB type descriptor of a value, e.g. "S" or "N"
+func typeOf(value types.AttributeValue) string {
+	switch value.(type) {
+	case *types.AttributeValueMemberS:
+		return "S"
+	case *types.AttributeValueMemberN:
+		return "N"
+	case *types.AttributeValueMemberB:
+		return "B"
+	case *types.AttributeValueMemberBOOL:
+		return "BOOL"
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberSS:
+		return "SS"
+	case *types.AttributeValueMemberNS:
+		return "NS"
+	case *types.AttributeValueMemberBS:
+		return "BS"
+	case *types.AttributeValueMemberL:
+		return "L"
+	case *types.AttributeValueMemberM:
+		return "M"
+	}
+	return ""
+}
+
+func parseNumber(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, validationError("invalid number %q", value)
+	}
+	return r, nil
+}
+
+func formatNumber(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	return strings.TrimRight(r.FloatString(38), "0")
+}
+
+// compareValues compares two scalar values of the same type, ok is false if they can't be ordered
+func compareValues(a, b types.AttributeValue) (int, bool) {
+	switch av := a.(type) {
+	case *types.AttributeValueMemberS:
+		bv, ok := b.(*types.AttributeValueMemberS)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av.Value, bv.Value), true
+	case *types.AttributeValueMemberN:
+		bv, ok := b.(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		an, err := parseNumber(av.Value)
+		if err != nil {
+			return 0, false
+		}
+		bn, err := parseNumber(bv.Value)
+		if err != nil {
+			return 0, false
+		}
+		return an.Cmp(bn), true
+	case *types.AttributeValueMemberB:
+		bv, ok := b.(*types.AttributeValueMemberB)
+		if !ok {
+			return 0, false
+		}
+		return bytes.Compare(av.Value, bv.Value), true
+	}
+	return 0, false
+}
+
+func equalValues(a, b types.AttributeValue) bool {
+	if typeOf(a) != typeOf(b) {
+		return false
+	}
+
+	switch av := a.(type) {
+	case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberB:
+		c, ok := compareValues(a, b)
+		return ok && c == 0
+	case *types.AttributeValueMemberBOOL:
+		return av.Value == b.(*types.AttributeValueMemberBOOL).Value
+	case *types.AttributeValueMemberNULL:
+		return true
+	case *types.AttributeValueMemberSS:
+		return equalStringSets(av.Value, b.(*types.AttributeValueMemberSS).Value)
+	case *types.AttributeValueMemberNS:
+		return equalStringSets(normalizeNumbers(av.Value), normalizeNumbers(b.(*types.AttributeValueMemberNS).Value))
+	case *types.AttributeValueMemberBS:
+		return equalStringSets(encodeBytes(av.Value), encodeBytes(b.(*types.AttributeValueMemberBS).Value))
+	case *types.AttributeValueMemberL:
+		bv := b.(*types.AttributeValueMemberL)
+		if len(av.Value) != len(bv.Value) {
+			return false
+		}
+		for i := range av.Value {
+			if !equalValues(av.Value[i], bv.Value[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		bv := b.(*types.AttributeValueMemberM)
+		if len(av.Value) != len(bv.Value) {
+			return false
+		}
+		for k, v := range av.Value {
+			other, ok := bv.Value[k]
+			if !ok || !equalValues(v, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func equalStringSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeNumbers(numbers []string) []string {
+	normalized := make([]string, len(numbers))
+	for i, n := range numbers {
+		normalized[i] = n
+		if r, err := parseNumber(n); err == nil {
+			normalized[i] = formatNumber(r)
+		}
+	}
+	return normalized
+}
+
+func encodeBytes(bs [][]byte) []string {
+	encoded := make([]string, len(bs))
+	for i, b := range bs {
+		encoded[i] = base64.StdEncoding.EncodeToString(b)
+	}
+	return encoded
+}
+
+// encodeKeyValue encodes a key attribute, so equal keys have the same encoding
+func encodeKeyValue(value types.AttributeValue) string {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return "S:" + v.Value
+	case *types.AttributeValueMemberN:
+		if r, err := parseNumber(v.Value); err == nil {
+			return "N:" + formatNumber(r)
+		}
+		return "N:" + v.Value
+	case *types.AttributeValueMemberB:
+		return "B:" + base64.StdEncoding.EncodeToString(v.Value)
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// itemSize approximates the size DynamoDB bills for an item
+func itemSize(i item) int {
+	size := 0
+	for k, v := range i {
+		size += len(k) + valueSize(v)
+	}
+	return size
+}
+
+func valueSize(value types.AttributeValue) int {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return (len(v.Value)+1)/2 + 1
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberBOOL, *types.AttributeValueMemberNULL:
+		return 1
+	case *types.AttributeValueMemberSS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberNS:
+		size := 0
+		for _, n := range v.Value {
+			size += (len(n)+1)/2 + 1
+		}
+		return size
+	case *types.AttributeValueMemberBS:
+		size := 0
+		for _, b := range v.Value {
+			size += len(b)
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		size := 3
+		for _, e := range v.Value {
+			size += 1 + valueSize(e)
+		}
+		return size
+	case *types.AttributeValueMemberM:
+		size := 3
+		for k, e := range v.Value {
+			size += 1 + len(k) + valueSize(e)
+		}
+		return size
+	}
+	return 0
+}
@@ -0,0 +1,347 @@
+package dynamodependencystore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+const (
+	defaultFlushInterval = time.Minute
+	defaultSpanTTL       = 5 * time.Minute
+	defaultCacheSize     = 100000
+	defaultFlushTimeout  = 30 * time.Second
+)
+
+type AggregatorOptions struct {
+	// Interval between the writes of the counted calls, defaults to a minute
+	FlushInterval time.Duration
+	// Duration spans are cached to match them with their parents or children, defaults to 5 minutes
+	SpanTTL time.Duration
+	// Spans cached per generation, older spans are dropped early when exceeded. Defaults to 100000.
+	CacheSize  int
+	BucketSize time.Duration
+	Tenancy    *tenancy.Manager
+}
+
+type aggregatorMetrics struct {
+	Calls        metrics.Counter `metric:"calls_counted"`
+	Unresolved   metrics.Counter `metric:"spans_unresolved"`
+	Written      metrics.Counter `metric:"items_written"`
+	Failed       metrics.Counter `metric:"items_failed"`
+	CachedSpans  metrics.Gauge   `metric:"cached_spans"`
+	WaitingSpans metrics.Gauge   `metric:"waiting_spans"`
+}
+
+// aggregatedSpan is the part of a span needed to count its calls
+type aggregatedSpan struct {
+	service   string
+	operation string
+	startTime time.Time
+	duration  time.Duration
+	failed    bool
+}
+
+type tenantBucket struct {
+	tenant         string
+	callTimeBucket int64
+}
+
+// spanGeneration holds the spans seen within one SpanTTL, a generation is dropped after two TTLs
+type spanGeneration struct {
+	// Spans by tenant, trace and span ID
+	spans map[string]*aggregatedSpan
+	// Spans whose parents weren't seen yet by the key of the parent
+	waiting map[string][]*aggregatedSpan
+}
+
+func newSpanGeneration() *spanGeneration {
+	return &spanGeneration{spans: map[string]*aggregatedSpan{}, waiting: map[string][]*aggregatedSpan{}}
+}
+
+// Aggregator counts the calls between the services of written spans and periodically adds them to the stored
+// dependencies. Parents and children are matched using the spans of the last one to two SpanTTLs, so only calls
+// between spans written to the same instance are counted. Every instance adds its own counts, so any number of
+// instances can write to the same table.
+type Aggregator struct {
+	logger            hclog.Logger
+	svc               DynamoDBAPI
+	dependenciesTable string
+	bucketSize        time.Duration
+	spanTTL           time.Duration
+	cacheSize         int
+	tenancy           *tenancy.Manager
+	metrics           *aggregatorMetrics
+
+	mu               sync.Mutex
+	current          *spanGeneration
+	previous         *spanGeneration
+	rotatedAt        time.Time
+	tenantCallCounts map[tenantBucket]*DependencyCallCounts
+	flushMu          sync.Mutex
+	done             chan struct{}
+	closeOnce        sync.Once
+	wg               sync.WaitGroup
+}
+
+func NewAggregator(logger hclog.Logger, svc DynamoDBAPI, dependenciesTable string, metricsFactory metrics.Factory, options *AggregatorOptions) (*Aggregator, error) {
+	flushInterval := options.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = defaultFlushInterval
+	}
+	spanTTL := options.SpanTTL
+	if spanTTL == 0 {
+		spanTTL = defaultSpanTTL
+	}
+	cacheSize := options.CacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultCacheSize
+	}
+	bucketSize := options.BucketSize
+	if bucketSize == 0 {
+		bucketSize = DefaultBucketSize
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	aggregatorMetrics := &aggregatorMetrics{}
+	if err := metrics.Init(aggregatorMetrics, metricsFactory.Namespace(metrics.NSOptions{Name: "dependency_aggregator"}), nil); err != nil {
+		return nil, fmt.Errorf("failed to init metrics, %v", err)
+	}
+
+	a := &Aggregator{
+		logger:            logger,
+		svc:               svc,
+		dependenciesTable: dependenciesTable,
+		bucketSize:        bucketSize,
+		spanTTL:           spanTTL,
+		cacheSize:         cacheSize,
+		tenancy:           options.Tenancy,
+		metrics:           aggregatorMetrics,
+		current:           newSpanGeneration(),
+		previous:          newSpanGeneration(),
+		rotatedAt:         time.Now(),
+		tenantCallCounts:  map[tenantBucket]*DependencyCallCounts{},
+		done:              make(chan struct{}),
+	}
+
+	a.wg.Add(1)
+	go a.flushLoop(flushInterval)
+
+	return a, nil
+}
+
+func spanKey(tenant string, traceID model.TraceID, spanID model.SpanID) string {
+	return fmt.Sprintf("%s/%s/%s", tenant, traceID, spanID)
+}
+
+// AddSpan counts the calls between the span and its parents or children seen before
+func (a *Aggregator) AddSpan(tenant string, span *model.Span) {
+	aggregated := &aggregatedSpan{
+		service:   span.Process.GetServiceName(),
+		operation: span.OperationName,
+		startTime: span.StartTime,
+		duration:  span.Duration,
+		failed:    SpanFailed(span),
+	}
+	key := spanKey(tenant, span.TraceID, span.SpanID)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rotate()
+
+	for _, reference := range span.References {
+		parentKey := spanKey(tenant, reference.TraceID, reference.SpanID)
+		if parent := a.cachedSpan(parentKey); parent != nil {
+			a.countCall(tenant, parent, aggregated)
+			continue
+		}
+		a.current.waiting[parentKey] = append(a.current.waiting[parentKey], aggregated)
+	}
+
+	// Children usually finish and are written before their parents
+	for _, generation := range []*spanGeneration{a.previous, a.current} {
+		for _, child := range generation.waiting[key] {
+			a.countCall(tenant, aggregated, child)
+		}
+		delete(generation.waiting, key)
+	}
+
+	a.current.spans[key] = aggregated
+	a.updateCacheMetrics()
+}
+
+func (a *Aggregator) cachedSpan(key string) *aggregatedSpan {
+	if span, ok := a.current.spans[key]; ok {
+		return span
+	}
+	return a.previous.spans[key]
+}
+
+// rotate drops the previous generation after a TTL or once the current generation is full
+func (a *Aggregator) rotate() {
+	if time.Since(a.rotatedAt) < a.spanTTL && len(a.current.spans) < a.cacheSize {
+		return
+	}
+
+	unresolved := 0
+	for _, children := range a.previous.waiting {
+		unresolved += len(children)
+	}
+	a.metrics.Unresolved.Inc(int64(unresolved))
+
+	a.previous = a.current
+	a.current = newSpanGeneration()
+	a.rotatedAt = time.Now()
+}
+
+func (a *Aggregator) updateCacheMetrics() {
+	waiting := 0
+	for _, generation := range []*spanGeneration{a.previous, a.current} {
+		for _, children := range generation.waiting {
+			waiting += len(children)
+		}
+	}
+	a.metrics.CachedSpans.Update(int64(len(a.previous.spans) + len(a.current.spans)))
+	a.metrics.WaitingSpans.Update(int64(waiting))
+}
+
+func (a *Aggregator) countCall(tenant string, parent, child *aggregatedSpan) {
+	bucket := tenantBucket{tenant: tenant, callTimeBucket: TimeToBucketOfSize(child.startTime, a.bucketSize)}
+	bucketCallCounts(a.tenantCallCounts, bucket).CountCall(OperationDependency{
+		Parent:          parent.service,
+		ParentOperation: parent.operation,
+		Child:           child.service,
+		ChildOperation:  child.operation,
+	}, child.duration, child.failed)
+	a.metrics.Calls.Inc(1)
+}
+
+// IsErrorTag returns whether the tag marks a failed span, following the error tag of Jaeger and the status of
+// OpenTelemetry spans
+func IsErrorTag(key, value string) bool {
+	switch key {
+	case "error":
+		return value == "true"
+	case "otel.status_code":
+		return value == "ERROR"
+	}
+	return false
+}
+
+// SpanFailed returns whether any tag of the span marks it as failed
+func SpanFailed(span *model.Span) bool {
+	for _, tag := range span.Tags {
+		if IsErrorTag(tag.Key, tag.AsString()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Aggregator) flushLoop(interval time.Duration) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+			if err := a.Flush(ctx); err != nil {
+				a.logger.Warn("failed to flush dependencies, retrying with the next flush", "err", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Flush adds the calls counted since the last flush to the stored dependencies, calls which couldn't be written
+// are kept for the next flush
+func (a *Aggregator) Flush(ctx context.Context) error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.mu.Lock()
+	tenantCallCounts := a.tenantCallCounts
+	a.tenantCallCounts = map[tenantBucket]*DependencyCallCounts{}
+	a.mu.Unlock()
+
+	unwritten := map[tenantBucket]*DependencyCallCounts{}
+	var flushErr error
+	for bucket, dependencyCallCounts := range tenantCallCounts {
+		keyPrefix := a.tenancy.KeyPrefix(bucket.tenant)
+		table := a.tenancy.Table(bucket.tenant, a.dependenciesTable)
+
+		for dependency, statistics := range dependencyCallCounts.Services {
+			if err := a.write(ctx, table, NewServiceDependencyItem(keyPrefix, dependency, bucket.callTimeBucket, statistics)); err != nil {
+				flushErr = err
+				bucketCallCounts(unwritten, bucket).AddServiceStatistics(dependency.Parent, dependency.Child, statistics)
+			}
+		}
+		for dependency, statistics := range dependencyCallCounts.Operations {
+			if err := a.write(ctx, table, NewOperationDependencyItem(keyPrefix, dependency, bucket.callTimeBucket, statistics)); err != nil {
+				flushErr = err
+				bucketCallCounts(unwritten, bucket).AddOperationStatistics(dependency, statistics)
+			}
+		}
+	}
+
+	if len(unwritten) > 0 {
+		a.mu.Lock()
+		for bucket, dependencyCallCounts := range unwritten {
+			target := bucketCallCounts(a.tenantCallCounts, bucket)
+			for dependency, statistics := range dependencyCallCounts.Services {
+				target.AddServiceStatistics(dependency.Parent, dependency.Child, statistics)
+			}
+			for dependency, statistics := range dependencyCallCounts.Operations {
+				target.AddOperationStatistics(dependency, statistics)
+			}
+		}
+		a.mu.Unlock()
+	}
+
+	return flushErr
+}
+
+func (a *Aggregator) write(ctx context.Context, table string, item *DependencyItem) error {
+	if err := WriteDependencyItem(ctx, a.svc, table, item); err != nil {
+		a.metrics.Failed.Inc(1)
+		return err
+	}
+	a.metrics.Written.Inc(1)
+	return nil
+}
+
+func bucketCallCounts(tenantCallCounts map[tenantBucket]*DependencyCallCounts, bucket tenantBucket) *DependencyCallCounts {
+	dependencyCallCounts, ok := tenantCallCounts[bucket]
+	if !ok {
+		dependencyCallCounts = NewDependencyCallCounts()
+		tenantCallCounts[bucket] = dependencyCallCounts
+	}
+	return dependencyCallCounts
+}
+
+// Close stops the periodic flushes and writes the remaining calls
+func (a *Aggregator) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+	a.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFlushTimeout)
+	defer cancel()
+	return a.Flush(ctx)
+}
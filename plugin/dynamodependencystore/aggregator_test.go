@@ -0,0 +1,107 @@
+package dynamodependencystore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAggregatorSpan(spanID model.SpanID, parentID model.SpanID, service string, operation string, tags ...model.KeyValue) *model.Span {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        spanID,
+		OperationName: operation,
+		StartTime:     time.Now(),
+		Duration:      20 * time.Millisecond,
+		Process:       model.NewProcess(service, nil),
+		Tags:          tags,
+	}
+	if parentID != 0 {
+		span.References = []model.SpanRef{model.NewChildOfRef(span.TraceID, parentID)}
+	}
+	return span
+}
+
+func TestAggregator(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+	ctx := context.TODO()
+
+	svc := createDynamoDBSvc(assert, ctx)
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+	aggregator, err := NewAggregator(logger, svc, dependenciesTable, nil, &AggregatorOptions{FlushInterval: time.Hour, Tenancy: manager})
+	assert.NoError(err)
+
+	// Children are usually written before their parents
+	aggregator.AddSpan("acme", newAggregatorSpan(2, 1, "dynamodb-plugin", "WriteSpan", model.Bool("error", true)))
+	aggregator.AddSpan("acme", newAggregatorSpan(1, 0, "jaeger", "Export"))
+	aggregator.AddSpan("acme", newAggregatorSpan(3, 1, "dynamodb-plugin", "WriteSpan"))
+	// Spans of other tenants aren't parents
+	aggregator.AddSpan("other", newAggregatorSpan(4, 1, "dynamodb-plugin", "WriteSpan"))
+	assert.NoError(aggregator.Flush(ctx))
+
+	// Later flushes add to the stored counts
+	aggregator.AddSpan("acme", newAggregatorSpan(5, 1, "dynamodb-plugin", "WriteSpan"))
+	assert.NoError(aggregator.Close())
+
+	reader := NewReader(logger, svc, dependenciesTable, WithReaderTenancy(manager))
+	dependencyLinks, err := reader.GetDependencyStatistics(tenancy.WithTenant(ctx, "acme"), time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Len(dependencyLinks, 1)
+	assert.Equal(ServiceDependency{Parent: "jaeger", Child: "dynamodb-plugin"}, dependencyLinks[0].ServiceDependency)
+	assert.Equal(uint64(3), dependencyLinks[0].CallCount)
+	assert.Equal(uint64(1), dependencyLinks[0].ErrorCount)
+	assert.Equal(60*time.Millisecond, dependencyLinks[0].DurationSum)
+
+	operationLinks, err := reader.GetOperationDependencies(tenancy.WithTenant(ctx, "acme"), time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Len(operationLinks, 1)
+	assert.Equal("Export", operationLinks[0].ParentOperation)
+	assert.Equal("WriteSpan", operationLinks[0].ChildOperation)
+
+	dependencyLinks, err = reader.GetDependencyStatistics(tenancy.WithTenant(ctx, "other"), time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Empty(dependencyLinks)
+}
+
+func TestAggregatorSpanTTL(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	svc := createDynamoDBSvc(assert, ctx)
+	aggregator, err := NewAggregator(hclog.NewNullLogger(), svc, dependenciesTable, nil, &AggregatorOptions{FlushInterval: time.Hour, CacheSize: 1})
+	assert.NoError(err)
+	defer aggregator.Close()
+
+	// Every span starts a new generation, so the child is dropped two spans later
+	aggregator.AddSpan("", newAggregatorSpan(2, 1, "dynamodb-plugin", "WriteSpan"))
+	aggregator.AddSpan("", newAggregatorSpan(3, 0, "jaeger", "Export"))
+	aggregator.AddSpan("", newAggregatorSpan(4, 0, "jaeger", "Export"))
+	aggregator.AddSpan("", newAggregatorSpan(1, 0, "jaeger", "Export"))
+	assert.Empty(aggregator.tenantCallCounts)
+
+	// The parent is still in the previous generation
+	aggregator.AddSpan("", newAggregatorSpan(5, 1, "dynamodb-plugin", "WriteSpan"))
+	assert.Len(aggregator.tenantCallCounts, 1)
+}
+
+func TestSpanFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(SpanFailed(&model.Span{Tags: []model.KeyValue{model.Bool("error", true)}}))
+	assert.True(SpanFailed(&model.Span{Tags: []model.KeyValue{model.String("error", "true")}}))
+	assert.True(SpanFailed(&model.Span{Tags: []model.KeyValue{model.String("otel.status_code", "ERROR")}}))
+	assert.False(SpanFailed(&model.Span{Tags: []model.KeyValue{model.Bool("error", false)}}))
+	assert.False(SpanFailed(&model.Span{}))
+}
@@ -5,40 +5,176 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/model"
-	"github.com/opentracing/opentracing-go"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"go.opentelemetry.io/otel"
 )
 
-func NewReader(logger hclog.Logger, svc *dynamodb.Client, dependenciesTable string) *Reader {
-	return &Reader{
+// Spans are exported once a tracer provider is registered, see the telemetry package
+var tracer = otel.Tracer("github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore")
+
+// DynamoDBReaderAPI is the subset of the DynamoDB API used by the reader
+type DynamoDBReaderAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+type ReaderOption func(*Reader)
+
+// WithReaderTenancy restricts all reads to the tenant of the request
+func WithReaderTenancy(manager *tenancy.Manager) ReaderOption {
+	return func(r *Reader) {
+		r.tenancy = manager
+	}
+}
+
+// WithBucketSize sets the size of the buckets dependencies were written with
+func WithBucketSize(bucketSize time.Duration) ReaderOption {
+	return func(r *Reader) {
+		r.bucketSize = bucketSize
+	}
+}
+
+func NewReader(logger hclog.Logger, svc DynamoDBReaderAPI, dependenciesTable string, options ...ReaderOption) *Reader {
+	reader := &Reader{
 		svc:               svc,
 		dependenciesTable: dependenciesTable,
+		bucketSize:        DefaultBucketSize,
 		logger:            logger,
 	}
+	for _, option := range options {
+		option(reader)
+	}
+
+	return reader
 }
 
 type Reader struct {
 	logger            hclog.Logger
-	svc               *dynamodb.Client
+	svc               DynamoDBReaderAPI
 	dependenciesTable string
+	bucketSize        time.Duration
+	tenancy           *tenancy.Manager
 }
 
 func (r *Reader) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
 	r.logger.Debug("GetDependencies")
-	otSpan, _ := opentracing.StartSpanFromContext(ctx, "GetDependencies")
-	defer otSpan.Finish()
+	ctx, otSpan := tracer.Start(ctx, "GetDependencies")
+	defer otSpan.End()
+
+	dependencyCallCounts := NewDependencyCallCounts()
+	// Dependencies between operations are stored in the same table
+	filter := expression.Name("ParentOperation").AttributeNotExists()
+	if err := r.scanDependencyItems(ctx, endTs, lookback, filter, func(dependencyItem *DependencyItem) {
+		dependencyCallCounts.CountRequest(dependencyItem.Parent, dependencyItem.Child, dependencyItem.CallCount)
+	}); err != nil {
+		return nil, err
+	}
+
+	dependencyLinks := []model.DependencyLink{}
+	for parent, children := range dependencyCallCounts.CallCounts {
+		for child, callCount := range children {
+			dependencyLinks = append(dependencyLinks, model.DependencyLink{
+				Parent:    parent,
+				Child:     child,
+				CallCount: callCount,
+			})
+		}
+	}
+
+	return dependencyLinks, nil
+}
+
+type DependencyLinkStatistics struct {
+	ServiceDependency
+	CallStatistics
+}
+
+// GetDependencyStatistics returns the calls between services including errors and latencies
+func (r *Reader) GetDependencyStatistics(ctx context.Context, endTs time.Time, lookback time.Duration) ([]DependencyLinkStatistics, error) {
+	r.logger.Debug("GetDependencyStatistics")
+	ctx, otSpan := tracer.Start(ctx, "GetDependencyStatistics")
+	defer otSpan.End()
+
+	dependencyCallCounts := NewDependencyCallCounts()
+	filter := expression.Name("ParentOperation").AttributeNotExists()
+	if err := r.scanDependencyItems(ctx, endTs, lookback, filter, func(dependencyItem *DependencyItem) {
+		dependencyCallCounts.AddServiceStatistics(dependencyItem.Parent, dependencyItem.Child, dependencyItem.Statistics())
+	}); err != nil {
+		return nil, err
+	}
 
-	builder := expression.NewBuilder().WithFilter(
-		expression.Name("CallTimeBucket").Between(
-			expression.Value(TimeToBucket(endTs.Add(-lookback))), expression.Value(TimeToBucket(endTs))))
+	dependencyLinks := []DependencyLinkStatistics{}
+	for dependency, statistics := range dependencyCallCounts.Services {
+		dependencyLinks = append(dependencyLinks, DependencyLinkStatistics{
+			ServiceDependency: dependency,
+			CallStatistics:    *statistics,
+		})
+	}
+
+	return dependencyLinks, nil
+}
+
+type OperationDependencyLink struct {
+	OperationDependency
+	CallStatistics
+}
+
+// GetOperationDependencies returns the calls between operations, GetDependencies aggregates them by service
+func (r *Reader) GetOperationDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]OperationDependencyLink, error) {
+	r.logger.Debug("GetOperationDependencies")
+	ctx, otSpan := tracer.Start(ctx, "GetOperationDependencies")
+	defer otSpan.End()
+
+	dependencyCallCounts := NewDependencyCallCounts()
+	filter := expression.Name("ParentOperation").AttributeExists()
+	if err := r.scanDependencyItems(ctx, endTs, lookback, filter, func(dependencyItem *DependencyItem) {
+		dependencyCallCounts.AddOperationStatistics(OperationDependency{
+			Parent:          dependencyItem.Parent,
+			ParentOperation: dependencyItem.ParentOperation,
+			Child:           dependencyItem.Child,
+			ChildOperation:  dependencyItem.ChildOperation,
+		}, dependencyItem.Statistics())
+	}); err != nil {
+		return nil, err
+	}
+
+	dependencyLinks := []OperationDependencyLink{}
+	for dependency, statistics := range dependencyCallCounts.Operations {
+		dependencyLinks = append(dependencyLinks, OperationDependencyLink{
+			OperationDependency: dependency,
+			CallStatistics:      *statistics,
+		})
+	}
+
+	return dependencyLinks, nil
+}
+
+// scanDependencyItems calls fn with all items of the tenant in the time range matching the filter
+func (r *Reader) scanDependencyItems(ctx context.Context, endTs time.Time, lookback time.Duration, filter expression.ConditionBuilder, fn func(*DependencyItem)) error {
+	tenant, err := r.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant, %w", err)
+	}
 
+	filter = filter.And(expression.Name("CallTimeBucket").Between(
+		expression.Value(TimeToBucketOfSize(endTs.Add(-lookback), r.bucketSize)), expression.Value(TimeToBucketOfSize(endTs, r.bucketSize))))
+	// Skips the checkpoints stored next to the dependencies
+	filter = filter.And(expression.Name("Parent").AttributeExists())
+	if keyPrefix := r.tenancy.KeyPrefix(tenant); keyPrefix != "" {
+		filter = filter.And(expression.Name("Key").BeginsWith(keyPrefix))
+	}
+	builder := expression.NewBuilder().WithFilter(filter)
 	expr, err := builder.Build()
 	if err != nil {
-		return nil, fmt.Errorf("failed to build query expression, %v", err)
+		return fmt.Errorf("failed to build query expression, %v", err)
 	}
 
 	paginator := dynamodb.NewScanPaginator(r.svc, &dynamodb.ScanInput{
@@ -46,37 +182,26 @@ func (r *Reader) GetDependencies(ctx context.Context, endTs time.Time, lookback
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
-		TableName:                 &r.dependenciesTable,
+		TableName:                 aws.String(r.tenancy.Table(tenant, r.dependenciesTable)),
 	})
 
-	dependencyCallCounts := NewDependencyCallCounts()
 	for paginator.HasMorePages() {
 		output, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan page: %w", err)
+			return fmt.Errorf("failed to scan page: %w", err)
 		}
 
 		for _, item := range output.Items {
 			dependencyItem := &DependencyItem{}
-
 			if err := attributevalue.UnmarshalMap(item, dependencyItem); err != nil {
-				return nil, fmt.Errorf("failed to marshal span: %w", err)
+				return fmt.Errorf("failed to marshal span: %w", err)
 			}
-
-			dependencyCallCounts.CountRequest(dependencyItem.Parent, dependencyItem.Child, dependencyItem.CallCount)
-		}
-	}
-
-	dependencyLinks := []model.DependencyLink{}
-	for parent, children := range dependencyCallCounts.CallCounts {
-		for child, callCount := range children {
-			dependencyLinks = append(dependencyLinks, model.DependencyLink{
-				Parent:    parent,
-				Child:     child,
-				CallCount: callCount,
-			})
+			if dependencyItem.DurationBuckets, err = unmarshalDurationBuckets(item); err != nil {
+				return err
+			}
+			fn(dependencyItem)
 		}
 	}
 
-	return dependencyLinks, nil
+	return nil
 }
@@ -12,6 +12,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
 	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/sync/errgroup"
@@ -22,24 +24,30 @@ const (
 	dependenciesTable = "jaeger.dependencies"
 )
 
-func createDynamoDBSvc(assert *assert.Assertions, ctx context.Context) *dynamodb.Client {
-	dynamodbURL := os.Getenv("DYNAMODB_URL")
-	if dynamodbURL == "" {
-		dynamodbURL = "http://localhost:8000"
-	}
+// testDynamoDBAPI is implemented by both DynamoDB and the in-memory fake
+type testDynamoDBAPI interface {
+	DynamoDBAPI
+	DynamoDBReaderAPI
+	setup.DynamoDBAPI
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
-		lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
-		lo.Region = "us-east-1"
-		lo.EndpointResolver = aws.EndpointResolverFunc(
-			func(service, region string) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
-			})
-		return nil
-	})
-	assert.NoError(err)
+// createDynamoDBSvc uses DynamoDB at DYNAMODB_URL when set and the in-memory fake otherwise
+func createDynamoDBSvc(assert *assert.Assertions, ctx context.Context) testDynamoDBAPI {
+	var svc testDynamoDBAPI = dynamodbfake.New(nil)
+	if dynamodbURL := os.Getenv("DYNAMODB_URL"); dynamodbURL != "" {
+		cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
+			lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
+			lo.Region = "us-east-1"
+			lo.EndpointResolver = aws.EndpointResolverFunc(
+				func(service, region string) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
+				})
+			return nil
+		})
+		assert.NoError(err)
 
-	svc := dynamodb.NewFromConfig(cfg)
+		svc = dynamodb.NewFromConfig(cfg)
+	}
 
 	assert.NoError(setup.PollUntilReady(ctx, svc))
 	assert.NoError(setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
@@ -119,3 +127,108 @@ func TestGetDependencies(t *testing.T) {
 		CallCount: 2,
 	}})
 }
+
+func TestGetDependenciesTenancy(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	ctx := context.TODO()
+
+	svc := createDynamoDBSvc(assert, ctx)
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+	reader := NewReader(logger, svc, dependenciesTable, WithReaderTenancy(manager))
+
+	assert.NoError(WriteDependencyItem(ctx, svc, dependenciesTable, &DependencyItem{
+		Key:            DependencyKey("acme/", "jaeger", "dynamodb-plugin"),
+		Parent:         "jaeger",
+		Child:          "dynamodb-plugin",
+		CallCount:      5,
+		CallTimeBucket: TimeToBucket(time.Now()),
+	}))
+	assert.NoError(WriteDependencyItem(ctx, svc, dependenciesTable, &DependencyItem{
+		Key:            DependencyKey("other/", "jaeger", "dynamodb-plugin2"),
+		Parent:         "jaeger",
+		Child:          "dynamodb-plugin2",
+		CallCount:      2,
+		CallTimeBucket: TimeToBucket(time.Now()),
+	}))
+
+	dependencyLinks, err := reader.GetDependencies(tenancy.WithTenant(ctx, "acme"), time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.ElementsMatch(dependencyLinks, []model.DependencyLink{{
+		Parent:    "jaeger",
+		Child:     "dynamodb-plugin",
+		CallCount: 5,
+	}})
+
+	dependencyLinks, err = reader.GetDependencies(tenancy.WithTenant(ctx, "unknown"), time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Empty(dependencyLinks)
+}
+
+func TestGetOperationDependencies(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	svc := createDynamoDBSvc(assert, ctx)
+	reader := NewReader(hclog.NewNullLogger(), svc, dependenciesTable)
+
+	dependency := OperationDependency{Parent: "frontend", ParentOperation: "GET /", Child: "checkout", ChildOperation: "/checkout.Cart/Get"}
+	failed := &CallStatistics{}
+	failed.AddCall(3*time.Millisecond, true)
+	slow := &CallStatistics{}
+	slow.AddCall(20*time.Second, false)
+
+	for _, statistics := range []*CallStatistics{failed, slow} {
+		assert.NoError(WriteDependencyItem(ctx, svc, dependenciesTable, NewServiceDependencyItem("", ServiceDependency{Parent: "frontend", Child: "checkout"}, TimeToBucket(time.Now()), statistics)))
+		assert.NoError(WriteDependencyItem(ctx, svc, dependenciesTable, NewOperationDependencyItem("", dependency, TimeToBucket(time.Now()), statistics)))
+	}
+
+	expected := CallStatistics{
+		CallCount:       2,
+		ErrorCount:      1,
+		DurationSum:     20*time.Second + 3*time.Millisecond,
+		DurationBuckets: []uint64{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+	}
+
+	operationLinks, err := reader.GetOperationDependencies(ctx, time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Equal([]OperationDependencyLink{{OperationDependency: dependency, CallStatistics: expected}}, operationLinks)
+
+	statistics, err := reader.GetDependencyStatistics(ctx, time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Equal([]DependencyLinkStatistics{{ServiceDependency: ServiceDependency{Parent: "frontend", Child: "checkout"}, CallStatistics: expected}}, statistics)
+
+	// Operation dependencies aren't counted twice in the service graph
+	dependencyLinks, err := reader.GetDependencies(ctx, time.Now(), time.Hour)
+	assert.NoError(err)
+	assert.Equal([]model.DependencyLink{{Parent: "frontend", Child: "checkout", CallCount: 2}}, dependencyLinks)
+}
+
+func TestGetDependenciesBucketSize(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	svc := createDynamoDBSvc(assert, ctx)
+	reader := NewReader(hclog.NewNullLogger(), svc, dependenciesTable, WithBucketSize(time.Minute))
+
+	endTs := time.Date(2022, 3, 14, 10, 30, 0, 0, time.UTC)
+	for _, startTime := range []time.Time{endTs.Add(-5 * time.Minute), endTs.Add(-time.Hour)} {
+		assert.NoError(WriteDependencyItem(ctx, svc, dependenciesTable, &DependencyItem{
+			Key:            DependencyKey("", "frontend", "checkout"),
+			Parent:         "frontend",
+			Child:          "checkout",
+			CallCount:      1,
+			CallTimeBucket: TimeToBucketOfSize(startTime, time.Minute),
+		}))
+	}
+
+	// Buckets within the last hour are included, which hourly buckets would truncate
+	dependencyLinks, err := reader.GetDependencies(ctx, endTs, 10*time.Minute)
+	assert.NoError(err)
+	assert.Equal([]model.DependencyLink{{Parent: "frontend", Child: "checkout", CallCount: 1}}, dependencyLinks)
+}
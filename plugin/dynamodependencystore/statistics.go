@@ -0,0 +1,135 @@
+package dynamodependencystore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DurationBuckets are the upper bounds of the latency histogram, slower calls are counted in an overflow bucket.
+// Every bucket is stored in its own attribute, so buckets can be added without invalidating existing items.
+var DurationBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// durationBucketAttribute returns the attribute of the bucket, the index after the last bound is the overflow bucket
+func durationBucketAttribute(i int) string {
+	if i >= len(DurationBuckets) {
+		return "DurationBucketInf"
+	}
+	return fmt.Sprintf("DurationBucketLe%d", DurationBuckets[i].Microseconds())
+}
+
+// CallStatistics of an edge, the durations are the durations of the called spans
+type CallStatistics struct {
+	CallCount uint64
+	// Calls failed in the child
+	ErrorCount  uint64
+	DurationSum time.Duration
+	// Calls per DurationBuckets bound plus the overflow bucket
+	DurationBuckets []uint64
+}
+
+func (c *CallStatistics) AddCall(duration time.Duration, failed bool) {
+	c.CallCount++
+	if failed {
+		c.ErrorCount++
+	}
+	c.DurationSum += duration
+
+	c.ensureBuckets()
+	bucket := len(DurationBuckets)
+	for i, bound := range DurationBuckets {
+		if duration <= bound {
+			bucket = i
+			break
+		}
+	}
+	c.DurationBuckets[bucket]++
+}
+
+func (c *CallStatistics) Add(other *CallStatistics) {
+	c.CallCount += other.CallCount
+	c.ErrorCount += other.ErrorCount
+	c.DurationSum += other.DurationSum
+
+	c.ensureBuckets()
+	for i, count := range other.DurationBuckets {
+		if i < len(c.DurationBuckets) {
+			c.DurationBuckets[i] += count
+		}
+	}
+}
+
+func (c *CallStatistics) ensureBuckets() {
+	if len(c.DurationBuckets) == 0 {
+		c.DurationBuckets = make([]uint64, len(DurationBuckets)+1)
+	}
+}
+
+// ErrorRate returns the share of failed calls
+func (c *CallStatistics) ErrorRate() float64 {
+	if c.CallCount == 0 {
+		return 0
+	}
+	return float64(c.ErrorCount) / float64(c.CallCount)
+}
+
+func (c *CallStatistics) MeanDuration() time.Duration {
+	if c.CallCount == 0 {
+		return 0
+	}
+	return c.DurationSum / time.Duration(c.CallCount)
+}
+
+// Quantile returns the upper bound of the bucket containing the quantile, calls in the overflow bucket are
+// reported with the largest bound
+func (c *CallStatistics) Quantile(q float64) time.Duration {
+	total := uint64(0)
+	for _, count := range c.DurationBuckets {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	seen := uint64(0)
+	for i, count := range c.DurationBuckets {
+		seen += count
+		if float64(seen) >= rank && i < len(DurationBuckets) {
+			return DurationBuckets[i]
+		}
+	}
+	return DurationBuckets[len(DurationBuckets)-1]
+}
+
+// unmarshalDurationBuckets reads the bucket attributes of a dependency item
+func unmarshalDurationBuckets(item map[string]types.AttributeValue) ([]uint64, error) {
+	buckets := make([]uint64, len(DurationBuckets)+1)
+	for i := range buckets {
+		value, ok := item[durationBucketAttribute(i)].(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+
+		count, err := strconv.ParseUint(value.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration bucket, %v", err)
+		}
+		buckets[i] = count
+	}
+	return buckets, nil
+}
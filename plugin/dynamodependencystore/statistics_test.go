@@ -0,0 +1,55 @@
+package dynamodependencystore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallStatistics(t *testing.T) {
+	assert := assert.New(t)
+
+	statistics := &CallStatistics{}
+	assert.Equal(time.Duration(0), statistics.Quantile(0.99))
+	assert.Equal(0.0, statistics.ErrorRate())
+
+	for i := 0; i < 8; i++ {
+		statistics.AddCall(7*time.Millisecond, false)
+	}
+	statistics.AddCall(300*time.Millisecond, true)
+	statistics.AddCall(time.Minute, true)
+
+	assert.Equal(uint64(10), statistics.CallCount)
+	assert.Equal(0.2, statistics.ErrorRate())
+	assert.Equal((56*time.Millisecond+300*time.Millisecond+time.Minute)/10, statistics.MeanDuration())
+	assert.Equal(10*time.Millisecond, statistics.Quantile(0.5))
+	assert.Equal(500*time.Millisecond, statistics.Quantile(0.9))
+	assert.Equal(10*time.Second, statistics.Quantile(0.99))
+
+	total := &CallStatistics{}
+	total.Add(statistics)
+	total.Add(statistics)
+	assert.Equal(uint64(20), total.CallCount)
+	assert.Equal(uint64(16), total.DurationBuckets[1])
+	assert.Equal(uint64(2), total.DurationBuckets[len(DurationBuckets)])
+}
+
+func TestMarshalDependencyItem(t *testing.T) {
+	assert := assert.New(t)
+
+	statistics := &CallStatistics{}
+	statistics.AddCall(7*time.Millisecond, false)
+	statistics.AddCall(time.Minute, true)
+	item := NewServiceDependencyItem("", ServiceDependency{Parent: "jaeger", Child: "dynamodb-plugin"}, 1636290000, statistics)
+
+	av, err := MarshalDependencyItem(item)
+	assert.NoError(err)
+	assert.Equal(&types.AttributeValueMemberN{Value: "1"}, av["DurationBucketLe10000"])
+	assert.Equal(&types.AttributeValueMemberN{Value: "1"}, av["DurationBucketInf"])
+
+	buckets, err := unmarshalDurationBuckets(av)
+	assert.NoError(err)
+	assert.Equal(statistics.DurationBuckets, buckets)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -19,25 +20,146 @@ type DependencyItem struct {
 	CallCount      uint64
 	Source         string
 	CallTimeBucket int64
+	ErrorCount     uint64
+	DurationSum    time.Duration
+	// Stored in one attribute per bucket, see DurationBuckets
+	DurationBuckets []uint64 `dynamodbav:"-"`
+	// Only set on dependencies between operations, which are stored next to the dependencies between services
+	ParentOperation string `dynamodbav:",omitempty"`
+	ChildOperation  string `dynamodbav:",omitempty"`
 	// XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	// XXX_unrecognized     []byte   `json:"-"`
 	// XXX_sizecache        int32    `json:"-"`
 }
 
+func NewServiceDependencyItem(keyPrefix string, dependency ServiceDependency, callTimeBucket int64, statistics *CallStatistics) *DependencyItem {
+	return &DependencyItem{
+		Key:             DependencyKey(keyPrefix, dependency.Parent, dependency.Child),
+		Parent:          dependency.Parent,
+		Child:           dependency.Child,
+		CallCount:       statistics.CallCount,
+		CallTimeBucket:  callTimeBucket,
+		ErrorCount:      statistics.ErrorCount,
+		DurationSum:     statistics.DurationSum,
+		DurationBuckets: statistics.DurationBuckets,
+	}
+}
+
+func NewOperationDependencyItem(keyPrefix string, dependency OperationDependency, callTimeBucket int64, statistics *CallStatistics) *DependencyItem {
+	return &DependencyItem{
+		Key:             OperationDependencyKey(keyPrefix, dependency),
+		Parent:          dependency.Parent,
+		ParentOperation: dependency.ParentOperation,
+		Child:           dependency.Child,
+		ChildOperation:  dependency.ChildOperation,
+		CallCount:       statistics.CallCount,
+		CallTimeBucket:  callTimeBucket,
+		ErrorCount:      statistics.ErrorCount,
+		DurationSum:     statistics.DurationSum,
+		DurationBuckets: statistics.DurationBuckets,
+	}
+}
+
+func (i *DependencyItem) Statistics() *CallStatistics {
+	return &CallStatistics{
+		CallCount:       i.CallCount,
+		ErrorCount:      i.ErrorCount,
+		DurationSum:     i.DurationSum,
+		DurationBuckets: i.DurationBuckets,
+	}
+}
+
+// DependencyKey returns the partition key of the dependency between parent and child
+func DependencyKey(keyPrefix, parent, child string) string {
+	return fmt.Sprintf("%s%s/%s", keyPrefix, parent, child)
+}
+
+// OperationDependencyKey returns the partition key of the dependency between two operations
+func OperationDependencyKey(keyPrefix string, dependency OperationDependency) string {
+	return fmt.Sprintf("%soperation|%s|%s|%s|%s", keyPrefix, dependency.Parent, dependency.ParentOperation, dependency.Child, dependency.ChildOperation)
+}
+
+// DefaultBucketSize is the time range dependencies are aggregated in, the reader and the writers of dependencies
+// have to use the same size
+const DefaultBucketSize = time.Hour
+
 func TimeToBucket(t time.Time) int64 {
-	return t.Truncate(1*time.Hour).UnixMilli() / 1000
+	return TimeToBucketOfSize(t, DefaultBucketSize)
+}
+
+// TimeToBucketOfSize returns the start of the bucket containing t in seconds
+func TimeToBucketOfSize(t time.Time, bucketSize time.Duration) int64 {
+	if bucketSize <= 0 {
+		bucketSize = DefaultBucketSize
+	}
+	return t.Truncate(bucketSize).UnixMilli() / 1000
+}
+
+type ServiceDependency struct {
+	Parent string
+	Child  string
+}
+
+type OperationDependency struct {
+	Parent          string
+	ParentOperation string
+	Child           string
+	ChildOperation  string
 }
 
 type DependencyCallCounts struct {
 	CallCounts map[string]map[string]uint64
+	Services   map[ServiceDependency]*CallStatistics
+	Operations map[OperationDependency]*CallStatistics
 }
 
 func NewDependencyCallCounts() *DependencyCallCounts {
 	return &DependencyCallCounts{
 		CallCounts: map[string]map[string]uint64{},
+		Services:   map[ServiceDependency]*CallStatistics{},
+		Operations: map[OperationDependency]*CallStatistics{},
 	}
 }
 
+// CountCall counts a call between services and, when the operations are known, between operations
+func (d *DependencyCallCounts) CountCall(dependency OperationDependency, duration time.Duration, failed bool) {
+	d.CountRequest(dependency.Parent, dependency.Child, 1)
+	d.serviceStatistics(dependency.Parent, dependency.Child).AddCall(duration, failed)
+
+	// Items without operations would be counted as dependencies between services
+	if dependency.ParentOperation != "" || dependency.ChildOperation != "" {
+		d.operationStatistics(dependency).AddCall(duration, failed)
+	}
+}
+
+func (d *DependencyCallCounts) AddServiceStatistics(parent, child string, statistics *CallStatistics) {
+	d.CountRequest(parent, child, statistics.CallCount)
+	d.serviceStatistics(parent, child).Add(statistics)
+}
+
+func (d *DependencyCallCounts) AddOperationStatistics(dependency OperationDependency, statistics *CallStatistics) {
+	d.operationStatistics(dependency).Add(statistics)
+}
+
+func (d *DependencyCallCounts) serviceStatistics(parent, child string) *CallStatistics {
+	dependency := ServiceDependency{Parent: parent, Child: child}
+	statistics, ok := d.Services[dependency]
+	if !ok {
+		statistics = &CallStatistics{}
+		d.Services[dependency] = statistics
+	}
+	return statistics
+}
+
+func (d *DependencyCallCounts) operationStatistics(dependency OperationDependency) *CallStatistics {
+	statistics, ok := d.Operations[dependency]
+	if !ok {
+		statistics = &CallStatistics{}
+		d.Operations[dependency] = statistics
+	}
+	return statistics
+}
+
 func (d *DependencyCallCounts) CountRequest(parent, child string, count uint64) {
 	children, ok := d.CallCounts[parent]
 	if !ok {
@@ -58,17 +180,66 @@ type DynamoDBAPI interface {
 }
 
 func WriteDependencyItem(ctx context.Context, svc DynamoDBAPI, dependenciesTable string, item *DependencyItem) error {
-	builder := expression.NewBuilder().WithUpdate(expression.
+	update, err := NewDependencyItemUpdate(dependenciesTable, item)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                       update.Key,
+		TableName:                 update.TableName,
+		UpdateExpression:          update.UpdateExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+	return nil
+}
+
+// MarshalDependencyItem returns the complete item including its duration buckets, writing it replaces the stored
+// dependency instead of adding to it
+func MarshalDependencyItem(item *DependencyItem) (map[string]types.AttributeValue, error) {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal dependency item, %v", err)
+	}
+	for i, count := range item.DurationBuckets {
+		if count > 0 {
+			av[durationBucketAttribute(i)] = &types.AttributeValueMemberN{Value: strconv.FormatUint(count, 10)}
+		}
+	}
+	return av, nil
+}
+
+// NewDependencyItemUpdate returns the update adding the item to the stored dependency, so it can also be written
+// in a transaction
+func NewDependencyItemUpdate(dependenciesTable string, item *DependencyItem) (*types.Update, error) {
+	update := expression.
 		Add(expression.Name("CallCount"), expression.Value(item.CallCount)).
 		Set(expression.Name("Parent"), expression.Value(item.Parent)).
 		Set(expression.Name("Child"), expression.Value(item.Child)).
-		Set(expression.Name("Source"), expression.Value(item.Source)))
+		Set(expression.Name("Source"), expression.Value(item.Source)).
+		Add(expression.Name("ErrorCount"), expression.Value(item.ErrorCount)).
+		Add(expression.Name("DurationSum"), expression.Value(item.DurationSum))
+	for i, count := range item.DurationBuckets {
+		if count > 0 {
+			update = update.Add(expression.Name(durationBucketAttribute(i)), expression.Value(count))
+		}
+	}
+	if item.ParentOperation != "" || item.ChildOperation != "" {
+		update = update.
+			Set(expression.Name("ParentOperation"), expression.Value(item.ParentOperation)).
+			Set(expression.Name("ChildOperation"), expression.Value(item.ChildOperation))
+	}
+	builder := expression.NewBuilder().WithUpdate(update)
 	expr, err := builder.Build()
 	if err != nil {
-		return fmt.Errorf("failed to build update expression, %v", err)
+		return nil, fmt.Errorf("failed to build update expression, %v", err)
 	}
 
-	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	return &types.Update{
 		Key: map[string]types.AttributeValue{
 			"Key": &types.AttributeValueMemberS{
 				Value: item.Key,
@@ -81,9 +252,5 @@ func WriteDependencyItem(ctx context.Context, svc DynamoDBAPI, dependenciesTable
 		UpdateExpression:          expr.Update(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to put item: %w", err)
-	}
-	return nil
+	}, nil
 }
@@ -0,0 +1,128 @@
+package dynamosamplingstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// DefaultLeaseTTL is used when a lease is acquired without a duration
+	DefaultLeaseTTL = time.Minute
+
+	// TTL deletions lag behind, leases are kept a while after expiring to not race with renewals
+	leaseCleanupDelay = time.Hour
+)
+
+var errLockOwnership = errors.New("this host does not own the resource lock")
+
+type LeaseItem struct {
+	Name  string
+	Owner string
+	// Time in milliseconds the lease expires at, others can only acquire it afterwards
+	LeaseExpiry int64
+	ExpireTime  int64
+}
+
+// Lock implements Jaeger's distributedlock.Lock with conditional writes, which relies on roughly synchronized
+// clocks of all owners
+type Lock struct {
+	svc         DynamoDBAPI
+	leasesTable string
+	owner       string
+}
+
+// NewLock creates a lock held by the owner, which has to be unique across all participants, e.g. the hostname
+func NewLock(svc DynamoDBAPI, leasesTable, owner string) *Lock {
+	return &Lock{
+		svc:         svc,
+		leasesTable: leasesTable,
+		owner:       owner,
+	}
+}
+
+// Acquire acquires or extends a lease around the resource, unless another owner holds an unexpired lease
+func (l *Lock) Acquire(resource string, ttl time.Duration) (bool, error) {
+	ctx, otSpan := tracer.Start(context.Background(), "Acquire")
+	defer otSpan.End()
+
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	now := time.Now()
+	leaseExpiry := now.Add(ttl)
+	av, err := attributevalue.MarshalMap(&LeaseItem{
+		Name:        resource,
+		Owner:       l.owner,
+		LeaseExpiry: leaseExpiry.UnixMilli(),
+		ExpireTime:  leaseExpiry.Add(leaseCleanupDelay).Unix(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal lease, %v", err)
+	}
+
+	cond := expression.Or(
+		expression.AttributeNotExists(expression.Name("Name")),
+		expression.Name("Owner").Equal(expression.Value(l.owner)),
+		expression.Name("LeaseExpiry").LessThan(expression.Value(now.UnixMilli())),
+	)
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build condition expression, %v", err)
+	}
+
+	_, err = l.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(l.leasesTable),
+		Item:                      av,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire resource lock, %v", err)
+	}
+	return true, nil
+}
+
+// Forfeit releases the lease around the resource, which fails when it's held by another owner
+func (l *Lock) Forfeit(resource string) (bool, error) {
+	ctx, otSpan := tracer.Start(context.Background(), "Forfeit")
+	defer otSpan.End()
+
+	key, err := attributevalue.MarshalMap(map[string]string{"Name": resource})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal key, %v", err)
+	}
+	cond := expression.Name("Owner").Equal(expression.Value(l.owner))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build condition expression, %v", err)
+	}
+
+	_, err = l.svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(l.leasesTable),
+		Key:                       key,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return false, fmt.Errorf("failed to forfeit resource lock, %w", errLockOwnership)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to forfeit resource lock, %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,58 @@
+package dynamosamplingstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	svc := createDynamoDBSvc(assert, ctx)
+	leader := NewLock(svc, leasesTable, "collector-1")
+	follower := NewLock(svc, leasesTable, "collector-2")
+
+	acquired, err := leader.Acquire("sampling_lock", time.Minute)
+	assert.NoError(err)
+	assert.True(acquired)
+
+	acquired, err = follower.Acquire("sampling_lock", time.Minute)
+	assert.NoError(err)
+	assert.False(acquired)
+
+	// Other resources are locked independently
+	acquired, err = follower.Acquire("other_lock", time.Minute)
+	assert.NoError(err)
+	assert.True(acquired)
+
+	forfeited, err := follower.Forfeit("sampling_lock")
+	assert.True(errors.Is(err, errLockOwnership))
+	assert.False(forfeited)
+
+	// The owner extends its lease
+	acquired, err = leader.Acquire("sampling_lock", 100*time.Millisecond)
+	assert.NoError(err)
+	assert.True(acquired)
+
+	time.Sleep(200 * time.Millisecond)
+	acquired, err = follower.Acquire("sampling_lock", time.Minute)
+	assert.NoError(err)
+	assert.True(acquired)
+
+	acquired, err = leader.Acquire("sampling_lock", 0)
+	assert.NoError(err)
+	assert.False(acquired)
+
+	forfeited, err = follower.Forfeit("sampling_lock")
+	assert.NoError(err)
+	assert.True(forfeited)
+
+	acquired, err = leader.Acquire("sampling_lock", 0)
+	assert.NoError(err)
+	assert.True(acquired)
+}
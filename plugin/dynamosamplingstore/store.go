@@ -0,0 +1,372 @@
+package dynamosamplingstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/cmd/collector/app/sampling/model"
+	"go.opentelemetry.io/otel"
+)
+
+// Spans are exported once a tracer provider is registered, see the telemetry package
+var tracer = otel.Tracer("github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamosamplingstore")
+
+const (
+	// DefaultTTL is the duration throughput and probabilities are kept by default
+	DefaultTTL = 24 * time.Hour
+
+	// Throughput is spread over multiple keys, as every collector writes it
+	throughputBuckets = 10
+	// Keeps throughput and probabilities items well below the item size limit of 400KB
+	maxThroughputPerItem    = 1000
+	maxProbabilitiesPerItem = 1000
+	// Probabilities items read per page while looking for the latest complete calculation
+	probabilitiesPageSize = 100
+	// Attempts to find a free timestamp when another item was written at the same time
+	maxWriteAttempts = 10
+
+	probabilitiesKey = "probabilities"
+)
+
+// DynamoDBAPI is the subset of the DynamoDB API used by the sampling store and lock
+type DynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+type Options struct {
+	// Duration throughput and probabilities are kept, defaults to a day
+	TTL time.Duration
+}
+
+type ThroughputEntry struct {
+	Service       string
+	Operation     string
+	Count         int64
+	Probabilities []string
+}
+
+type ThroughputItem struct {
+	Key        string
+	Timestamp  int64
+	Throughput []ThroughputEntry
+	ExpireTime int64
+}
+
+type ProbabilityEntry struct {
+	Service     string
+	Operation   string
+	Probability float64
+	QPS         float64
+}
+
+// ProbabilitiesItem is one part of the probabilities of a calculation, the parts are written in order
+type ProbabilitiesItem struct {
+	Key       string
+	Timestamp int64
+	Hostname  string
+	// Timestamp of the first part, shared by all parts of the calculation
+	CalculatedAt  int64
+	Part          int
+	Parts         int
+	Probabilities []ProbabilityEntry
+	ExpireTime    int64
+}
+
+func throughputKey(bucket int) string {
+	return "throughput|" + strconv.Itoa(bucket)
+}
+
+// Store implements Jaeger's samplingstore.Store. Timestamps are stored in nanoseconds.
+type Store struct {
+	logger        hclog.Logger
+	svc           DynamoDBAPI
+	samplingTable string
+	ttl           time.Duration
+}
+
+func NewStore(logger hclog.Logger, svc DynamoDBAPI, samplingTable string, options *Options) *Store {
+	if options == nil {
+		options = &Options{}
+	}
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Store{
+		logger:        logger,
+		svc:           svc,
+		samplingTable: samplingTable,
+		ttl:           ttl,
+	}
+}
+
+// InsertThroughput writes the throughput to a random bucket, split into multiple items if needed
+func (s *Store) InsertThroughput(throughput []*model.Throughput) error {
+	s.logger.Debug("InsertThroughput")
+	ctx, otSpan := tracer.Start(context.Background(), "InsertThroughput")
+	defer otSpan.End()
+
+	key := throughputKey(rand.Intn(throughputBuckets))
+	now := time.Now()
+	for start := 0; start < len(throughput); start += maxThroughputPerItem {
+		end := start + maxThroughputPerItem
+		if end > len(throughput) {
+			end = len(throughput)
+		}
+
+		item := &ThroughputItem{
+			Key:        key,
+			Timestamp:  now.UnixNano(),
+			Throughput: make([]ThroughputEntry, 0, end-start),
+			ExpireTime: now.Add(s.ttl).Unix(),
+		}
+		for _, t := range throughput[start:end] {
+			entry := ThroughputEntry{Service: t.Service, Operation: t.Operation, Count: t.Count}
+			for probability := range t.Probabilities {
+				entry.Probabilities = append(entry.Probabilities, probability)
+			}
+			item.Throughput = append(item.Throughput, entry)
+		}
+
+		if err := s.putItem(ctx, item, func() { item.Timestamp++ }); err != nil {
+			return fmt.Errorf("failed to insert throughput, %v", err)
+		}
+		now = time.Unix(0, item.Timestamp+1)
+	}
+
+	return nil
+}
+
+// GetThroughput returns the throughput written after start until end
+func (s *Store) GetThroughput(start, end time.Time) ([]*model.Throughput, error) {
+	s.logger.Debug("GetThroughput")
+	ctx, otSpan := tracer.Start(context.Background(), "GetThroughput")
+	defer otSpan.End()
+
+	throughput := []*model.Throughput{}
+	for bucket := 0; bucket < throughputBuckets; bucket++ {
+		keyCond := expression.KeyAnd(
+			expression.Key("Key").Equal(expression.Value(throughputKey(bucket))),
+			expression.Key("Timestamp").Between(expression.Value(start.UnixNano()+1), expression.Value(end.UnixNano())),
+		)
+		expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build query expression, %v", err)
+		}
+
+		paginator := dynamodb.NewQueryPaginator(s.svc, &dynamodb.QueryInput{
+			KeyConditionExpression:    expr.KeyCondition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(s.samplingTable),
+		})
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to query throughput, %v", err)
+			}
+
+			for _, av := range output.Items {
+				item := &ThroughputItem{}
+				if err := attributevalue.UnmarshalMap(av, item); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal throughput, %v", err)
+				}
+				for _, entry := range item.Throughput {
+					t := &model.Throughput{
+						Service:       entry.Service,
+						Operation:     entry.Operation,
+						Count:         entry.Count,
+						Probabilities: map[string]struct{}{},
+					}
+					for _, probability := range entry.Probabilities {
+						t.Probabilities[probability] = struct{}{}
+					}
+					throughput = append(throughput, t)
+				}
+			}
+		}
+	}
+
+	return throughput, nil
+}
+
+// InsertProbabilitiesAndQPS writes the probabilities calculated by the leader together with the measured QPS, split
+// into multiple items if needed
+func (s *Store) InsertProbabilitiesAndQPS(hostname string, probabilities model.ServiceOperationProbabilities, qps model.ServiceOperationQPS) error {
+	s.logger.Debug("InsertProbabilitiesAndQPS")
+	ctx, otSpan := tracer.Start(context.Background(), "InsertProbabilitiesAndQPS")
+	defer otSpan.End()
+
+	entries := []ProbabilityEntry{}
+	for service, operations := range probabilities {
+		for operation, probability := range operations {
+			entries = append(entries, ProbabilityEntry{
+				Service:     service,
+				Operation:   operation,
+				Probability: probability,
+				QPS:         qps[service][operation],
+			})
+		}
+	}
+
+	parts := (len(entries) + maxProbabilitiesPerItem - 1) / maxProbabilitiesPerItem
+	if parts == 0 {
+		parts = 1
+	}
+	now := time.Now()
+	calculatedAt := now.UnixNano()
+	for part := 0; part < parts; part++ {
+		start := part * maxProbabilitiesPerItem
+		end := start + maxProbabilitiesPerItem
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		item := &ProbabilitiesItem{
+			Key:           probabilitiesKey,
+			Timestamp:     now.UnixNano(),
+			Hostname:      hostname,
+			CalculatedAt:  calculatedAt,
+			Part:          part,
+			Parts:         parts,
+			Probabilities: entries[start:end],
+			ExpireTime:    now.Add(s.ttl).Unix(),
+		}
+		advance := func() { item.Timestamp++ }
+		if part == 0 {
+			// The calculation is identified by the timestamp of its first part
+			advance = func() {
+				item.Timestamp++
+				item.CalculatedAt++
+			}
+		}
+		if err := s.putItem(ctx, item, advance); err != nil {
+			return fmt.Errorf("failed to insert probabilities, %v", err)
+		}
+		calculatedAt = item.CalculatedAt
+		now = time.Unix(0, item.Timestamp+1)
+	}
+
+	return nil
+}
+
+// GetLatestProbabilities returns the probabilities of the last calculation whose parts were all written, which are
+// empty before the first calculation
+func (s *Store) GetLatestProbabilities() (model.ServiceOperationProbabilities, error) {
+	s.logger.Debug("GetLatestProbabilities")
+	ctx, otSpan := tracer.Start(context.Background(), "GetLatestProbabilities")
+	defer otSpan.End()
+
+	keyCond := expression.Key("Key").Equal(expression.Value(probabilitiesKey))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query expression, %v", err)
+	}
+
+	// Parts are written in order, so the newest last part is followed by the other parts of its calculation
+	paginator := dynamodb.NewQueryPaginator(s.svc, &dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(s.samplingTable),
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(probabilitiesPageSize),
+	})
+	var latest *ProbabilitiesItem
+	parts := map[int]*ProbabilitiesItem{}
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query probabilities, %v", err)
+		}
+
+		for _, av := range output.Items {
+			item := &ProbabilitiesItem{}
+			if err := attributevalue.UnmarshalMap(av, item); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal probabilities, %v", err)
+			}
+			// Items written before they were split are complete calculations
+			if item.Parts == 0 {
+				item.Parts = 1
+				item.CalculatedAt = item.Timestamp
+			}
+
+			if latest == nil {
+				// Calculations whose last part is missing failed or are still being written
+				if item.Part != item.Parts-1 {
+					continue
+				}
+				latest = item
+			}
+			if item.CalculatedAt != latest.CalculatedAt || item.Hostname != latest.Hostname {
+				continue
+			}
+			parts[item.Part] = item
+			if len(parts) == latest.Parts {
+				return probabilitiesOfParts(parts), nil
+			}
+		}
+	}
+
+	if latest != nil {
+		return nil, fmt.Errorf("failed to read all %d parts of the probabilities, %d parts expired", latest.Parts, latest.Parts-len(parts))
+	}
+	return model.ServiceOperationProbabilities{}, nil
+}
+
+func probabilitiesOfParts(parts map[int]*ProbabilitiesItem) model.ServiceOperationProbabilities {
+	probabilities := model.ServiceOperationProbabilities{}
+	for _, item := range parts {
+		for _, entry := range item.Probabilities {
+			if _, ok := probabilities[entry.Service]; !ok {
+				probabilities[entry.Service] = map[string]float64{}
+			}
+			probabilities[entry.Service][entry.Operation] = entry.Probability
+		}
+	}
+	return probabilities
+}
+
+// putItem writes a new item, moving its timestamp forward with advance while another item exists at the same time
+func (s *Store) putItem(ctx context.Context, item interface{}, advance func()) error {
+	cond := expression.AttributeNotExists(expression.Name("Key"))
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build condition expression, %v", err)
+	}
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			return fmt.Errorf("failed to marshal item, %v", err)
+		}
+
+		_, err = s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(s.samplingTable),
+			Item:                      av,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		var conditionalCheckFailed *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionalCheckFailed) {
+			return err
+		}
+		advance()
+	}
+
+	return fmt.Errorf("no free timestamp after %d attempts", maxWriteAttempts)
+}
@@ -0,0 +1,148 @@
+package dynamosamplingstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/cmd/collector/app/sampling/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	samplingTable = "jaeger.sampling"
+	leasesTable   = "jaeger.leases"
+)
+
+// testDynamoDBAPI is implemented by both DynamoDB and the in-memory fake
+type testDynamoDBAPI interface {
+	DynamoDBAPI
+	setup.DynamoDBAPI
+}
+
+// createDynamoDBSvc uses DynamoDB at DYNAMODB_URL when set and the in-memory fake otherwise
+func createDynamoDBSvc(assert *assert.Assertions, ctx context.Context) testDynamoDBAPI {
+	var svc testDynamoDBAPI = dynamodbfake.New(&dynamodbfake.Options{MaxPageSize: 2})
+	if dynamodbURL := os.Getenv("DYNAMODB_URL"); dynamodbURL != "" {
+		cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
+			lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
+			lo.Region = "us-east-1"
+			lo.EndpointResolver = aws.EndpointResolverFunc(
+				func(service, region string) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
+				})
+			return nil
+		})
+		assert.NoError(err)
+
+		svc = dynamodb.NewFromConfig(cfg)
+	}
+
+	assert.NoError(setup.PollUntilReady(ctx, svc))
+	assert.NoError(setup.RecreateSamplingStoreTables(ctx, svc, &setup.SetupSamplingOptions{
+		SamplingTable: samplingTable,
+		LeasesTable:   leasesTable,
+	}))
+
+	return svc
+}
+
+func TestThroughput(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	store := NewStore(hclog.NewNullLogger(), createDynamoDBSvc(assert, ctx), samplingTable, nil)
+
+	start := time.Now()
+	throughput, err := store.GetThroughput(start.Add(-time.Minute), start)
+	assert.NoError(err)
+	assert.Empty(throughput)
+
+	assert.NoError(store.InsertThroughput([]*model.Throughput{
+		{Service: "jaeger", Operation: "Export", Count: 10, Probabilities: map[string]struct{}{"0.001": {}, "0.01": {}}},
+	}))
+	// Larger throughput is split into multiple items
+	large := []*model.Throughput{}
+	for i := 0; i < maxThroughputPerItem+1; i++ {
+		large = append(large, &model.Throughput{Service: "dynamodb-plugin", Operation: fmt.Sprintf("op-%d", i), Count: 1, Probabilities: map[string]struct{}{}})
+	}
+	assert.NoError(store.InsertThroughput(large))
+	end := time.Now()
+
+	throughput, err = store.GetThroughput(start, end)
+	assert.NoError(err)
+	assert.Len(throughput, maxThroughputPerItem+2)
+	assert.Contains(throughput, &model.Throughput{
+		Service: "jaeger", Operation: "Export", Count: 10, Probabilities: map[string]struct{}{"0.001": {}, "0.01": {}},
+	})
+	assert.Contains(throughput, &model.Throughput{
+		Service: "dynamodb-plugin", Operation: fmt.Sprintf("op-%d", maxThroughputPerItem), Count: 1, Probabilities: map[string]struct{}{},
+	})
+
+	// The start is exclusive
+	throughput, err = store.GetThroughput(end, end.Add(time.Minute))
+	assert.NoError(err)
+	assert.Empty(throughput)
+}
+
+func TestProbabilities(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	store := NewStore(hclog.NewNullLogger(), createDynamoDBSvc(assert, ctx), samplingTable, &Options{TTL: time.Hour})
+
+	probabilities, err := store.GetLatestProbabilities()
+	assert.NoError(err)
+	assert.Equal(model.ServiceOperationProbabilities{}, probabilities)
+
+	assert.NoError(store.InsertProbabilitiesAndQPS("collector-1",
+		model.ServiceOperationProbabilities{"jaeger": {"Export": 0.5}},
+		model.ServiceOperationQPS{"jaeger": {"Export": 2}},
+	))
+	assert.NoError(store.InsertProbabilitiesAndQPS("collector-2",
+		model.ServiceOperationProbabilities{"jaeger": {"Export": 0.1, "Query": 1}, "dynamodb-plugin": {"WriteSpan": 0.01}},
+		model.ServiceOperationQPS{"jaeger": {"Export": 10}},
+	))
+
+	probabilities, err = store.GetLatestProbabilities()
+	assert.NoError(err)
+	assert.Equal(model.ServiceOperationProbabilities{
+		"jaeger":          {"Export": 0.1, "Query": 1},
+		"dynamodb-plugin": {"WriteSpan": 0.01},
+	}, probabilities)
+
+	// Larger calculations are split into multiple items
+	large := model.ServiceOperationProbabilities{"dynamodb-plugin": {}}
+	for i := 0; i < maxProbabilitiesPerItem*2+1; i++ {
+		large["dynamodb-plugin"][fmt.Sprintf("op-%d", i)] = 0.5
+	}
+	assert.NoError(store.InsertProbabilitiesAndQPS("collector-1", large, model.ServiceOperationQPS{}))
+
+	probabilities, err = store.GetLatestProbabilities()
+	assert.NoError(err)
+	assert.Equal(large, probabilities)
+
+	// Calculations without their last part, e.g. of a failed leader, are ignored
+	assert.NoError(store.putItem(context.TODO(), &ProbabilitiesItem{
+		Key:           probabilitiesKey,
+		Timestamp:     time.Now().UnixNano(),
+		Hostname:      "collector-2",
+		CalculatedAt:  time.Now().UnixNano(),
+		Part:          0,
+		Parts:         2,
+		Probabilities: []ProbabilityEntry{{Service: "jaeger", Operation: "Export", Probability: 1}},
+	}, func() {}))
+
+	probabilities, err = store.GetLatestProbabilities()
+	assert.NoError(err)
+	assert.Equal(large, probabilities)
+}
@@ -0,0 +1,231 @@
+package dynamospanstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/wal"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+const defaultReplayInterval = 5 * time.Second
+
+type BufferedWriterOptions struct {
+	ReplayInterval time.Duration
+	Tenancy        *tenancy.Manager
+}
+
+type bufferedWriterMetrics struct {
+	Buffered     metrics.Counter `metric:"spans_buffered"`
+	Replayed     metrics.Counter `metric:"spans_replayed"`
+	Dropped      metrics.Counter `metric:"spans_dropped"`
+	Rejected     metrics.Counter `metric:"spans_rejected"`
+	BacklogSpans metrics.Gauge   `metric:"backlog_spans"`
+	BacklogBytes metrics.Gauge   `metric:"backlog_bytes"`
+}
+
+// isRetryableError reports whether a failed write can succeed later, e.g. after throttling, server or network
+// errors. Other errors, like items exceeding the size limit, would fail again on every replay.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || isThrottlingError(err) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultServer {
+		return true
+	}
+
+	var timeoutErr interface{ Timeout() bool }
+	if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
+		return true
+	}
+
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// BufferedWriter appends spans to a write-ahead log when they can't be written because of retryable errors and
+// replays them once writes succeed again
+type BufferedWriter struct {
+	logger  hclog.Logger
+	writer  spanstore.Writer
+	wal     *wal.WAL
+	tenancy *tenancy.Manager
+	metrics *bufferedWriterMetrics
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewBufferedWriter(logger hclog.Logger, writer spanstore.Writer, log *wal.WAL, metricsFactory metrics.Factory, options *BufferedWriterOptions) (*BufferedWriter, error) {
+	replayInterval := options.ReplayInterval
+	if replayInterval == 0 {
+		replayInterval = defaultReplayInterval
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	writerMetrics := &bufferedWriterMetrics{}
+	if err := metrics.Init(writerMetrics, metricsFactory.Namespace(metrics.NSOptions{Name: "write_ahead_log"}), nil); err != nil {
+		return nil, fmt.Errorf("failed to init metrics, %v", err)
+	}
+
+	b := &BufferedWriter{
+		logger:  logger,
+		writer:  writer,
+		wal:     log,
+		tenancy: options.Tenancy,
+		metrics: writerMetrics,
+		done:    make(chan struct{}),
+	}
+	b.updateBacklogMetrics()
+
+	b.wg.Add(1)
+	go b.replayLoop(replayInterval)
+
+	return b, nil
+}
+
+func (b *BufferedWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	tenant, err := b.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant, %w", err)
+	}
+
+	// Spans must not overtake the backlog, otherwise they would be replayed out of order
+	if b.wal.Len() == 0 {
+		err := b.writer.WriteSpan(ctx, span)
+		if err == nil {
+			return nil
+		}
+		if !isRetryableError(err) {
+			b.metrics.Rejected.Inc(1)
+			return err
+		}
+		b.logger.Warn("failed to write span, buffering it", "err", err)
+	}
+
+	data, err := encodeBufferedSpan(tenant, span)
+	if err != nil {
+		return err
+	}
+
+	if err := b.wal.Append(data); err != nil {
+		b.metrics.Dropped.Inc(1)
+		return fmt.Errorf("failed to buffer span, %w", err)
+	}
+
+	b.metrics.Buffered.Inc(1)
+	b.updateBacklogMetrics()
+	return nil
+}
+
+func (b *BufferedWriter) replayLoop(interval time.Duration) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if b.wal.Len() == 0 {
+				continue
+			}
+			if err := b.Replay(context.Background()); err != nil {
+				b.logger.Warn("failed to replay buffered spans", "err", err, "backlog", b.wal.Len())
+			}
+		}
+	}
+}
+
+// Replay writes all buffered spans in order and stops at the first write failing with a retryable error
+func (b *BufferedWriter) Replay(ctx context.Context) error {
+	defer b.updateBacklogMetrics()
+
+	return b.wal.Replay(func(data []byte) error {
+		tenant, span, err := decodeBufferedSpan(data)
+		if err != nil {
+			// A span which can't be decoded would block the replay forever
+			b.logger.Error("dropping undecodable buffered span", "err", err)
+			b.metrics.Dropped.Inc(1)
+			return nil
+		}
+
+		spanCtx := ctx
+		if tenant != "" {
+			spanCtx = tenancy.WithTenant(ctx, tenant)
+		}
+
+		if err := b.writer.WriteSpan(spanCtx, span); err != nil {
+			if isRetryableError(err) {
+				return err
+			}
+			// Like undecodable spans, spans which can't ever be written would block the replay forever
+			b.logger.Error("dropping buffered span failing permanently", "err", err, "traceID", span.TraceID.String())
+			b.metrics.Rejected.Inc(1)
+			return nil
+		}
+
+		b.metrics.Replayed.Inc(1)
+		return nil
+	})
+}
+
+func (b *BufferedWriter) updateBacklogMetrics() {
+	b.metrics.BacklogSpans.Update(b.wal.Len())
+	b.metrics.BacklogBytes.Update(b.wal.Size())
+}
+
+// Close stops replaying and closes the write-ahead log, the backlog is replayed after the next start
+func (b *BufferedWriter) Close() error {
+	close(b.done)
+	b.wg.Wait()
+
+	return b.wal.Close()
+}
+
+// encodeBufferedSpan prefixes the protobuf encoded span with the length prefixed tenant
+func encodeBufferedSpan(tenant string, span *model.Span) ([]byte, error) {
+	spanData, err := span.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal span, %v", err)
+	}
+
+	data := make([]byte, binary.MaxVarintLen64+len(tenant)+len(spanData))
+	n := binary.PutUvarint(data, uint64(len(tenant)))
+	n += copy(data[n:], tenant)
+	n += copy(data[n:], spanData)
+
+	return data[:n], nil
+}
+
+func decodeBufferedSpan(data []byte) (string, *model.Span, error) {
+	tenantLength, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < tenantLength {
+		return "", nil, fmt.Errorf("invalid tenant length")
+	}
+
+	tenant := string(data[n : n+int(tenantLength)])
+	span := &model.Span{}
+	if err := span.Unmarshal(data[n+int(tenantLength):]); err != nil {
+		return "", nil, fmt.Errorf("failed to unmarshal span, %v", err)
+	}
+
+	return tenant, span, nil
+}
@@ -0,0 +1,154 @@
+package dynamospanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+)
+
+type failingSpanWriter struct {
+	mu      sync.Mutex
+	err     error
+	tenancy *tenancy.Manager
+	spans   []model.SpanID
+	tenants []string
+}
+
+func (f *failingSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	tenant, err := f.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.spans = append(f.spans, span.SpanID)
+	f.tenants = append(f.tenants, tenant)
+	return nil
+}
+
+func (f *failingSpanWriter) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func TestBufferedWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Error,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Tenants: []string{"acme", "other"}})
+	assert.NoError(err)
+
+	log, err := wal.Open(&wal.Options{Directory: t.TempDir()})
+	assert.NoError(err)
+
+	metricsFactory := metricstest.NewFactory(0)
+	spanWriter := &failingSpanWriter{tenancy: manager}
+	writer, err := NewBufferedWriter(logger, spanWriter, log, metricsFactory, &BufferedWriterOptions{Tenancy: manager})
+	assert.NoError(err)
+
+	ctx := tenancy.WithTenant(context.TODO(), "acme")
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 1}))
+
+	spanWriter.setErr(&smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException"})
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 2}))
+	assert.NoError(writer.WriteSpan(tenancy.WithTenant(context.TODO(), "other"), &model.Span{SpanID: 3}))
+	assert.Error(writer.Replay(context.TODO()))
+
+	// Spans are buffered while there is a backlog, even if DynamoDB is available again
+	spanWriter.setErr(nil)
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 4}))
+	assert.Equal([]model.SpanID{1}, spanWriter.spans)
+	metricsFactory.AssertGaugeMetrics(t, metricstest.ExpectedMetric{Name: "write_ahead_log.backlog_spans", Value: 3})
+
+	assert.NoError(writer.Replay(context.TODO()))
+	assert.Equal([]model.SpanID{1, 2, 3, 4}, spanWriter.spans)
+	assert.Equal([]string{"acme", "acme", "other", "acme"}, spanWriter.tenants)
+
+	metricsFactory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "write_ahead_log.spans_buffered", Value: 3},
+		metricstest.ExpectedMetric{Name: "write_ahead_log.spans_replayed", Value: 3},
+	)
+	metricsFactory.AssertGaugeMetrics(t, metricstest.ExpectedMetric{Name: "write_ahead_log.backlog_spans", Value: 0})
+
+	assert.ErrorIs(writer.WriteSpan(context.TODO(), &model.Span{SpanID: 5}), tenancy.ErrMissingTenant)
+	assert.NoError(writer.Close())
+}
+
+// failOnSpanWriter fails permanently for a single span
+type failOnSpanWriter struct {
+	failingSpanWriter
+	spanID model.SpanID
+}
+
+func (f *failOnSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	if span.SpanID == f.spanID {
+		return fmt.Errorf("failed to write span item, %w", &smithy.GenericAPIError{Code: "ValidationException", Message: "Item size has exceeded the maximum allowed size", Fault: smithy.FaultClient})
+	}
+	return f.failingSpanWriter.WriteSpan(ctx, span)
+}
+
+func TestBufferedWriterPermanentErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Off,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	log, err := wal.Open(&wal.Options{Directory: t.TempDir()})
+	assert.NoError(err)
+
+	metricsFactory := metricstest.NewFactory(0)
+	spanWriter := &failOnSpanWriter{spanID: 2}
+	writer, err := NewBufferedWriter(logger, spanWriter, log, metricsFactory, &BufferedWriterOptions{})
+	assert.NoError(err)
+
+	ctx := context.TODO()
+	// Permanent errors are returned instead of being buffered
+	assert.Error(writer.WriteSpan(ctx, &model.Span{SpanID: 2}))
+	assert.Equal(int64(0), log.Len())
+
+	// A span failing permanently once it's replayed doesn't block the spans after it
+	spanWriter.setErr(errors.New("invalid span"))
+	assert.Error(writer.WriteSpan(ctx, &model.Span{SpanID: 1}))
+	spanWriter.setErr(&smithy.GenericAPIError{Code: "InternalServerError", Fault: smithy.FaultServer})
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 1}))
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 2}))
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 3}))
+
+	spanWriter.setErr(nil)
+	assert.NoError(writer.Replay(ctx))
+	assert.Equal([]model.SpanID{1, 3}, spanWriter.spans)
+	assert.Equal(int64(0), log.Len())
+
+	metricsFactory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "write_ahead_log.spans_buffered", Value: 3},
+		metricstest.ExpectedMetric{Name: "write_ahead_log.spans_replayed", Value: 2},
+		metricstest.ExpectedMetric{Name: "write_ahead_log.spans_rejected", Value: 3},
+	)
+	assert.NoError(writer.Close())
+}
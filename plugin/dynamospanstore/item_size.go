@@ -0,0 +1,74 @@
+package dynamospanstore
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// MaxItemSize is the maximum size of a DynamoDB item in bytes
+	MaxItemSize = 400 * 1024
+	// writeUnitSize is the item size covered by one write capacity unit
+	writeUnitSize = 1024
+)
+
+// ItemSize approximates the size of an item the way DynamoDB calculates it for the size limit and capacity units
+// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/CapacityUnitCalculations.html
+func ItemSize(item map[string]types.AttributeValue) int {
+	size := 0
+	for name, value := range item {
+		size += len(name) + attributeValueSize(value)
+	}
+	return size
+}
+
+// WriteUnits returns the write capacity units consumed by writing an item of the given size
+func WriteUnits(size int) int {
+	if size <= 0 {
+		return 1
+	}
+	return (size + writeUnitSize - 1) / writeUnitSize
+}
+
+func attributeValueSize(value types.AttributeValue) int {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return (len(v.Value)+1)/2 + 1
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberBOOL, *types.AttributeValueMemberNULL:
+		return 1
+	case *types.AttributeValueMemberSS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberNS:
+		size := 0
+		for _, n := range v.Value {
+			size += (len(n)+1)/2 + 1
+		}
+		return size
+	case *types.AttributeValueMemberBS:
+		size := 0
+		for _, b := range v.Value {
+			size += len(b)
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		size := 3
+		for _, e := range v.Value {
+			size += 1 + attributeValueSize(e)
+		}
+		return size
+	case *types.AttributeValueMemberM:
+		size := 3
+		for name, e := range v.Value {
+			size += 1 + len(name) + attributeValueSize(e)
+		}
+		return size
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package dynamospanstore
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemSize(t *testing.T) {
+	assert := assert.New(t)
+
+	item := map[string]types.AttributeValue{
+		"Name":  &types.AttributeValueMemberS{Value: "frontend"},
+		"Count": &types.AttributeValueMemberN{Value: "123"},
+		"Tags": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"a": &types.AttributeValueMemberBOOL{Value: true},
+		}},
+	}
+	// 4+8 + 5+3 + 4+(3+1+1+1)
+	assert.Equal(30, ItemSize(item))
+
+	assert.Equal(1, WriteUnits(0))
+	assert.Equal(1, WriteUnits(1024))
+	assert.Equal(2, WriteUnits(1025))
+}
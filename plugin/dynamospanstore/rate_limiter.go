@@ -0,0 +1,265 @@
+package dynamospanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+const (
+	defaultInitialRate    = 1000
+	defaultMinRate        = 10
+	defaultMaxRate        = 40000
+	defaultIncreaseStep   = 50
+	defaultDecreaseFactor = 0.5
+
+	// Rates are decreased at most once per interval, so a burst of throttled requests decreases the rate only once,
+	// and only increased after an interval without adjustments
+	rateAdjustInterval = time.Second
+)
+
+// Error codes returned by DynamoDB once a table or the account exceeds its throughput
+var throttlingErrorCodes = map[string]struct{}{
+	"ProvisionedThroughputExceededException": {},
+	"ThrottlingException":                    {},
+	"RequestLimitExceeded":                   {},
+}
+
+func isThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	_, ok := throttlingErrorCodes[apiErr.ErrorCode()]
+	return ok
+}
+
+// writePriority orders the writes across all tables of a rate limiter, as throttling can also apply to the whole
+// account
+type writePriority int
+
+const (
+	// Span writes wait until their table has capacity again
+	priorityHigh writePriority = iota
+	// Service and operation index writes are skipped while their table has no capacity or any span write waits
+	priorityLow
+)
+
+type RateLimiterOptions struct {
+	// Writes per second per table
+	InitialRate float64
+	MinRate     float64
+	MaxRate     float64
+	// Writes per second the rate is increased by, after a second without throttling
+	IncreaseStep float64
+	// Factor the rate is multiplied with when a write was throttled
+	DecreaseFactor float64
+}
+
+// RateLimiter limits the writes per table, decreasing the rate multiplicatively when throttled and
+// increasing it additively otherwise (AIMD)
+type RateLimiter struct {
+	options        RateLimiterOptions
+	metricsFactory metrics.Factory
+
+	mu     sync.Mutex
+	tables map[string]*tableRateLimiter
+	// High priority writes waiting for capacity
+	waiting int64
+}
+
+func NewRateLimiter(metricsFactory metrics.Factory, options *RateLimiterOptions) (*RateLimiter, error) {
+	o := *options
+	if o.InitialRate == 0 {
+		o.InitialRate = defaultInitialRate
+	}
+	if o.MinRate == 0 {
+		o.MinRate = defaultMinRate
+	}
+	if o.MaxRate == 0 {
+		o.MaxRate = defaultMaxRate
+	}
+	if o.IncreaseStep == 0 {
+		o.IncreaseStep = defaultIncreaseStep
+	}
+	if o.DecreaseFactor == 0 {
+		o.DecreaseFactor = defaultDecreaseFactor
+	}
+
+	if o.MinRate > o.MaxRate || o.InitialRate < o.MinRate || o.InitialRate > o.MaxRate {
+		return nil, fmt.Errorf("initial rate must be between min and max rate")
+	}
+	if o.DecreaseFactor <= 0 || o.DecreaseFactor >= 1 {
+		return nil, fmt.Errorf("decrease factor must be between 0 and 1")
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	return &RateLimiter{
+		options:        o,
+		metricsFactory: metricsFactory.Namespace(metrics.NSOptions{Name: "rate_limiter"}),
+		tables:         map[string]*tableRateLimiter{},
+	}, nil
+}
+
+func (r *RateLimiter) table(table string) *tableRateLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limiter, ok := r.tables[table]
+	if !ok {
+		tags := map[string]string{"table": table}
+		limiter = &tableRateLimiter{
+			options:    &r.options,
+			rate:       r.options.InitialRate,
+			tokens:     r.options.InitialRate,
+			lastRefill: time.Now(),
+			lastAdjust: time.Now(),
+			rateGauge:  r.metricsFactory.Gauge(metrics.Options{Name: "rate", Tags: tags}),
+			throttled:  r.metricsFactory.Counter(metrics.Options{Name: "throttled", Tags: tags}),
+			skipped:    r.metricsFactory.Counter(metrics.Options{Name: "skipped", Tags: tags}),
+		}
+		limiter.rateGauge.Update(int64(limiter.rate))
+		r.tables[table] = limiter
+	}
+
+	return limiter
+}
+
+// Acquire waits for capacity for a write of the given priority and returns false if the write should be skipped
+func (r *RateLimiter) Acquire(ctx context.Context, table string, priority writePriority) (bool, error) {
+	limiter := r.table(table)
+	if priority == priorityLow {
+		if atomic.LoadInt64(&r.waiting) > 0 || !limiter.tryAcquire() {
+			limiter.skipped.Inc(1)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	if limiter.tryAcquire() {
+		return true, nil
+	}
+	atomic.AddInt64(&r.waiting, 1)
+	defer atomic.AddInt64(&r.waiting, -1)
+	if err := limiter.wait(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Observe adjusts the rate of a table based on the result of a write
+func (r *RateLimiter) Observe(table string, err error) {
+	limiter := r.table(table)
+	if isThrottlingError(err) {
+		limiter.throttled.Inc(1)
+		limiter.decrease()
+	} else if err == nil {
+		limiter.increase()
+	}
+}
+
+// Rate returns the current rate of a table
+func (r *RateLimiter) Rate(table string) float64 {
+	limiter := r.table(table)
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.rate
+}
+
+type tableRateLimiter struct {
+	options *RateLimiterOptions
+
+	mu         sync.Mutex
+	rate       float64
+	tokens     float64
+	lastRefill time.Time
+	lastAdjust time.Time
+	// Zero until the first decrease, which isn't delayed by the creation of the limiter
+	lastDecrease time.Time
+
+	rateGauge metrics.Gauge
+	throttled metrics.Counter
+	skipped   metrics.Counter
+}
+
+// refill adds the tokens accumulated since the last refill, bursts are limited to a second worth of writes
+func (t *tableRateLimiter) refill(now time.Time) {
+	t.tokens = math.Min(t.rate, t.tokens+now.Sub(t.lastRefill).Seconds()*t.rate)
+	t.lastRefill = now
+}
+
+func (t *tableRateLimiter) tryAcquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refill(time.Now())
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+func (t *tableRateLimiter) wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		t.refill(time.Now())
+		if t.tokens >= 1 {
+			t.tokens--
+			t.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+		t.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *tableRateLimiter) decrease() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastDecrease) < rateAdjustInterval {
+		return
+	}
+
+	t.rate = math.Max(t.options.MinRate, t.rate*t.options.DecreaseFactor)
+	t.tokens = math.Min(t.tokens, t.rate)
+	t.lastAdjust = now
+	t.lastDecrease = now
+	t.rateGauge.Update(int64(t.rate))
+}
+
+func (t *tableRateLimiter) increase() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastAdjust) < rateAdjustInterval {
+		return
+	}
+
+	t.rate = math.Min(t.options.MaxRate, t.rate+t.options.IncreaseStep)
+	t.lastAdjust = now
+	t.rateGauge.Update(int64(t.rate))
+}
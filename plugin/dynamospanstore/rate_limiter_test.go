@@ -0,0 +1,156 @@
+package dynamospanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+)
+
+func TestRateLimiterAIMD(t *testing.T) {
+	assert := assert.New(t)
+
+	metricsFactory := metricstest.NewFactory(0)
+	limiter, err := NewRateLimiter(metricsFactory, &RateLimiterOptions{InitialRate: 100, MinRate: 10, MaxRate: 120, IncreaseStep: 10})
+	assert.NoError(err)
+
+	throttled := fmt.Errorf("failed to put item: %w", &types.ProvisionedThroughputExceededException{})
+	assert.True(isThrottlingError(throttled))
+	assert.False(isThrottlingError(errors.New("ProvisionedThroughputExceededException")))
+
+	limiter.Observe("jaeger.spans", throttled)
+	assert.Equal(50.0, limiter.Rate("jaeger.spans"))
+	assert.Equal(100.0, limiter.Rate("jaeger.services"))
+
+	// Concurrent throttled writes only decrease the rate once per interval
+	limiter.Observe("jaeger.spans", throttled)
+	assert.Equal(50.0, limiter.Rate("jaeger.spans"))
+
+	// Successful writes increase the rate once per interval up to the max rate
+	limiter.Observe("jaeger.spans", nil)
+	assert.Equal(50.0, limiter.Rate("jaeger.spans"))
+	for i := 0; i < 10; i++ {
+		limiter.table("jaeger.spans").lastAdjust = time.Now().Add(-rateAdjustInterval)
+		limiter.Observe("jaeger.spans", nil)
+	}
+	assert.Equal(120.0, limiter.Rate("jaeger.spans"))
+
+	// The interval also applies at or above the initial rate
+	limiter.Observe("jaeger.spans", throttled)
+	assert.Equal(120.0, limiter.Rate("jaeger.spans"))
+	for i := 0; i < 10; i++ {
+		limiter.table("jaeger.spans").lastDecrease = time.Now().Add(-rateAdjustInterval)
+		limiter.Observe("jaeger.spans", throttled)
+	}
+	assert.Equal(10.0, limiter.Rate("jaeger.spans"))
+
+	metricsFactory.AssertGaugeMetrics(t, metricstest.ExpectedMetric{
+		Name: "rate_limiter.rate", Tags: map[string]string{"table": "jaeger.spans"}, Value: 10,
+	})
+
+	_, err = NewRateLimiter(nil, &RateLimiterOptions{InitialRate: 5, MinRate: 10})
+	assert.Error(err)
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	limiter, err := NewRateLimiter(nil, &RateLimiterOptions{InitialRate: 20, MinRate: 10})
+	assert.NoError(err)
+	limiter.table("jaeger.spans").tokens = 0
+
+	// Low priority writes are skipped without capacity
+	ok, err := limiter.Acquire(context.TODO(), "jaeger.spans", priorityLow)
+	assert.NoError(err)
+	assert.False(ok)
+
+	// High priority writes wait for capacity
+	ok, err = limiter.Acquire(context.TODO(), "jaeger.spans", priorityHigh)
+	assert.NoError(err)
+	assert.True(ok)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	limiter.table("jaeger.spans").tokens = 0
+	_, err = limiter.Acquire(ctx, "jaeger.spans", priorityHigh)
+	assert.ErrorIs(err, context.Canceled)
+
+	// Low priority writes to other tables are skipped while high priority writes wait
+	limiter.table("jaeger.spans").tokens = -10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ok, err := limiter.Acquire(context.TODO(), "jaeger.spans", priorityHigh)
+		assert.NoError(err)
+		assert.True(ok)
+	}()
+	assert.Eventually(func() bool { return atomic.LoadInt64(&limiter.waiting) > 0 }, time.Second, time.Millisecond)
+	ok, err = limiter.Acquire(context.TODO(), "jaeger.services", priorityLow)
+	assert.NoError(err)
+	assert.False(ok)
+
+	<-done
+	ok, err = limiter.Acquire(context.TODO(), "jaeger.services", priorityLow)
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func TestWriteSpanRateLimited(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	var (
+		spansTable      = "jaeger.spans"
+		servicesTable   = "jaeger.services"
+		operationsTable = "jaeger.operations"
+	)
+
+	writesPerTable := map[string]int{}
+	var mu sync.Mutex
+	svc := mockPutItemAPI(func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+		mu.Lock()
+		writesPerTable[*params.TableName] += 1
+		mu.Unlock()
+
+		return nil, nil
+	})
+
+	limiter, err := NewRateLimiter(nil, &RateLimiterOptions{})
+	assert.NoError(err)
+
+	writer, err := NewWriter(logger, svc, spansTable, servicesTable, operationsTable, WithRateLimiter(limiter))
+	assert.NoError(err)
+
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        model.NewSpanID(1),
+		OperationName: "example-operation",
+		Process:       &model.Process{ServiceName: "example-service"},
+	}
+
+	// Index writes are skipped while the services table has no capacity
+	limiter.table(servicesTable).rate = defaultMinRate
+	limiter.table(servicesTable).tokens = 0
+	assert.NoError(writer.WriteSpan(context.TODO(), span))
+	assert.Equal(map[string]int{spansTable: 1, operationsTable: 1}, writesPerTable)
+
+	// and retried with the next span
+	limiter.table(servicesTable).tokens = 1
+	assert.NoError(writer.WriteSpan(context.TODO(), span))
+	assert.Equal(map[string]int{spansTable: 2, servicesTable: 1, operationsTable: 1}, writesPerTable)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,32 +12,79 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
-	"github.com/opentracing/opentracing-go"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/encryption"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/errgroup"
 )
 
-func NewReader(logger hclog.Logger, svc *dynamodb.Client, spansTable, servicesTable, operationsTable string) *Reader {
-	return &Reader{
+// Spans are exported once a tracer provider is registered, see the telemetry package
+var tracer = otel.Tracer("github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore")
+
+// DynamoDBReaderAPI is the subset of the DynamoDB API used by the reader
+type DynamoDBReaderAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+type ReaderOption func(*Reader)
+
+// WithReaderTenancy restricts all reads to the tenant of the request
+func WithReaderTenancy(manager *tenancy.Manager) ReaderOption {
+	return func(r *Reader) {
+		r.tenancy = manager
+	}
+}
+
+func NewReader(logger hclog.Logger, svc DynamoDBReaderAPI, spansTable, servicesTable, operationsTable string, options ...ReaderOption) *Reader {
+	reader := &Reader{
 		svc:             svc,
 		spansTable:      spansTable,
 		servicesTable:   servicesTable,
 		operationsTable: operationsTable,
 		logger:          logger,
 	}
+	for _, option := range options {
+		option(reader)
+	}
+
+	return reader
 }
 
 type Reader struct {
 	logger          hclog.Logger
-	svc             *dynamodb.Client
+	svc             DynamoDBReaderAPI
 	spansTable      string
 	servicesTable   string
 	operationsTable string
+	tenancy         *tenancy.Manager
+	encryptor       *encryption.Encryptor
+}
+
+// unscopeSpanItem reverts scopeSpanItem
+func unscopeSpanItem(spanItem *SpanItem, keyPrefix string) {
+	if keyPrefix == "" {
+		return
+	}
+
+	spanItem.TraceID = strings.TrimPrefix(spanItem.TraceID, keyPrefix)
+	spanItem.ServiceNameBucket = strings.TrimPrefix(spanItem.ServiceNameBucket, keyPrefix)
+	for _, reference := range spanItem.References {
+		reference.TraceID = strings.TrimPrefix(reference.TraceID, keyPrefix)
+	}
 }
 
 func NewSpanFromSpanItem(spanItem *SpanItem) (*model.Span, error) {
+	if spanItem.EncryptedBody != nil {
+		return nil, errSpanEncrypted
+	}
+
 	traceID, err := model.TraceIDFromString(spanItem.TraceID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get trace id from string, %v", err)
@@ -133,8 +181,9 @@ func NewOperationFromOperationItem(operationItem *OperationItem) spanstore.Opera
 	}
 }
 
-func (s *Reader) getTraceByID(ctx context.Context, traceID string) (*model.Trace, error) {
-	keyCond := expression.Key("TraceID").Equal(expression.Value(traceID))
+func (s *Reader) getTraceByID(ctx context.Context, tenant, traceID string) (*model.Trace, error) {
+	keyPrefix := s.tenancy.KeyPrefix(tenant)
+	keyCond := expression.Key("TraceID").Equal(expression.Value(keyPrefix + traceID))
 	builder := expression.NewBuilder().WithKeyCondition(keyCond)
 	expr, err := builder.Build()
 	if err != nil {
@@ -146,7 +195,7 @@ func (s *Reader) getTraceByID(ctx context.Context, traceID string) (*model.Trace
 		ProjectionExpression:      expr.Projection(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
-		TableName:                 &s.spansTable,
+		TableName:                 aws.String(s.tenancy.Table(tenant, s.spansTable)),
 	})
 
 	spans := []*model.Span{}
@@ -162,6 +211,11 @@ func (s *Reader) getTraceByID(ctx context.Context, traceID string) (*model.Trace
 			if err := attributevalue.UnmarshalMap(item, spanItem); err != nil {
 				return nil, fmt.Errorf("failed to marshal span: %w", err)
 			}
+			// The encrypted body is bound to the scoped keys
+			if err := decryptSpanItem(ctx, s.encryptor, spanItem); err != nil {
+				return nil, err
+			}
+			unscopeSpanItem(spanItem, keyPrefix)
 
 			span, err := NewSpanFromSpanItem(spanItem)
 			if err != nil {
@@ -183,21 +237,45 @@ func (s *Reader) getTraceByID(ctx context.Context, traceID string) (*model.Trace
 
 func (s *Reader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
 	s.logger.Trace("GetTrace", traceID.String())
-	otSpan, _ := opentracing.StartSpanFromContext(ctx, "GetTrace")
-	defer otSpan.Finish()
+	ctx, otSpan := tracer.Start(ctx, "GetTrace")
+	defer otSpan.End()
 
-	return s.getTraceByID(ctx, traceID.String())
+	tenant, err := s.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant, %w", err)
+	}
+
+	return s.getTraceByID(ctx, tenant, traceID.String())
 }
 
 // TODO beggningOfTime might not be a good idea, maybe make a system property that the image is run with?
 func (s *Reader) GetServices(ctx context.Context) ([]string, error) {
 	s.logger.Trace("GetServices")
-	otSpan, _ := opentracing.StartSpanFromContext(ctx, "GetServices")
-	defer otSpan.Finish()
+	ctx, otSpan := tracer.Start(ctx, "GetServices")
+	defer otSpan.End()
 
-	paginator := dynamodb.NewScanPaginator(s.svc, &dynamodb.ScanInput{
-		TableName: &s.servicesTable,
-	})
+	tenant, err := s.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant, %w", err)
+	}
+
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(s.tenancy.Table(tenant, s.servicesTable)),
+	}
+
+	keyPrefix := s.tenancy.KeyPrefix(tenant)
+	if keyPrefix != "" {
+		expr, err := expression.NewBuilder().WithFilter(
+			expression.Name("Name").BeginsWith(keyPrefix)).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build scan expression, %v", err)
+		}
+		scanInput.FilterExpression = expr.Filter()
+		scanInput.ExpressionAttributeNames = expr.Names()
+		scanInput.ExpressionAttributeValues = expr.Values()
+	}
+
+	paginator := dynamodb.NewScanPaginator(s.svc, scanInput)
 
 	services := []string{}
 	for paginator.HasMorePages() {
@@ -213,6 +291,8 @@ func (s *Reader) GetServices(ctx context.Context) ([]string, error) {
 				return nil, fmt.Errorf("failed to marshal span: %w", err)
 			}
 
+			serviceItem.Name = strings.TrimPrefix(serviceItem.Name, keyPrefix)
+
 			services = append(services, NewServiceFromServiceItem(serviceItem))
 		}
 	}
@@ -224,14 +304,19 @@ func (s *Reader) GetServices(ctx context.Context) ([]string, error) {
 // TODO beggningOfTime might not be a good idea, maybe make a system property that the image is run with?
 func (s *Reader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
 	s.logger.Trace("GetOperations", query)
-	span, _ := opentracing.StartSpanFromContext(ctx, "GetOperations")
-	defer span.Finish()
+	ctx, otSpan := tracer.Start(ctx, "GetOperations")
+	defer otSpan.End()
 
 	if query.ServiceName == "" {
 		return nil, fmt.Errorf("querying without service name is not supported yet")
 	}
 
-	keyCond := expression.Key("ServiceName").Equal(expression.Value(query.ServiceName))
+	tenant, err := s.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant, %w", err)
+	}
+
+	keyCond := expression.Key("ServiceName").Equal(expression.Value(s.tenancy.KeyPrefix(tenant) + query.ServiceName))
 	builder := expression.NewBuilder().WithKeyCondition(keyCond)
 
 	if query.SpanKind != "" {
@@ -245,10 +330,11 @@ func (s *Reader) GetOperations(ctx context.Context, query spanstore.OperationQue
 
 	paginator := dynamodb.NewQueryPaginator(s.svc, &dynamodb.QueryInput{
 		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
 		ProjectionExpression:      expr.Projection(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
-		TableName:                 &s.operationsTable,
+		TableName:                 aws.String(s.tenancy.Table(tenant, s.operationsTable)),
 	})
 
 	operations := []spanstore.Operation{}
@@ -272,6 +358,41 @@ func (s *Reader) GetOperations(ctx context.Context, query spanstore.OperationQue
 	return operations, nil
 }
 
+// withTagFilter adds conditions on the searchable tags to a filter expression. The expression builder splits
+// names on dots, which are common in tag keys like "http.status_code", so these conditions are built manually.
+func withTagFilter(filter *string, names map[string]string, values map[string]types.AttributeValue, tags map[string]string) (*string, map[string]string, map[string]types.AttributeValue) {
+	if len(tags) == 0 {
+		return filter, names, values
+	}
+
+	tagNames := map[string]string{"#searchableTags": "SearchableTags"}
+	for k, v := range names {
+		tagNames[k] = v
+	}
+	tagValues := map[string]types.AttributeValue{}
+	for k, v := range values {
+		tagValues[k] = v
+	}
+
+	conditions := []string{}
+	if filter != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s)", *filter))
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		tagNames[fmt.Sprintf("#tag%d", i)] = key
+		tagValues[fmt.Sprintf(":tag%d", i)] = &types.AttributeValueMemberS{Value: tags[key]}
+		conditions = append(conditions, fmt.Sprintf("(#searchableTags.#tag%d = :tag%d)", i, i))
+	}
+
+	return aws.String(strings.Join(conditions, " AND ")), tagNames, tagValues
+}
+
 type TraceIDResult struct {
 	TraceID string
 }
@@ -315,13 +436,86 @@ func (s *TraceIDSet) Items() []string {
 
 func (s *Reader) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
 	s.logger.Trace("FindTraces", query)
-	span, _ := opentracing.StartSpanFromContext(ctx, "FindTraces")
-	defer span.Finish()
+	ctx, otSpan := tracer.Start(ctx, "FindTraces")
+	defer otSpan.End()
 
 	if query.ServiceName == "" {
 		return nil, fmt.Errorf("querying without service name is not supported yet")
 	}
 
+	tenant, err := s.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant, %w", err)
+	}
+	traceIDs, err := s.findTraceIDs(ctx, tenant, query)
+	if err != nil {
+		return nil, err
+	}
+
+	tracesChan := make(chan *model.Trace, len(traceIDs))
+	getGroup, getCtx := errgroup.WithContext(ctx)
+	for _, traceID := range traceIDs {
+		traceID := traceID
+		// TODO Might be better to use BatchGetItem here
+		getGroup.Go(func() error {
+			trace, err := s.getTraceByID(getCtx, tenant, traceID)
+			if err != nil {
+				return fmt.Errorf("failed to fetch trace %s, %v", traceID, err)
+			}
+			tracesChan <- trace
+			return nil
+		})
+	}
+	if err := getGroup.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to fetch traces, %v", err)
+	}
+	close(tracesChan)
+
+	traces := []*model.Trace{}
+	for trace := range tracesChan {
+		traces = append(traces, trace)
+	}
+
+	return traces, nil
+}
+
+// FindTraceIDs isn't used by the query service, but by maintenance jobs which don't need the spans
+func (s *Reader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	s.logger.Trace("FindTraceIDs", query)
+	ctx, otSpan := tracer.Start(ctx, "FindTraceIDs")
+	defer otSpan.End()
+
+	if query.ServiceName == "" {
+		return nil, fmt.Errorf("querying without service name is not supported yet")
+	}
+
+	tenant, err := s.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant, %w", err)
+	}
+
+	ids, err := s.findTraceIDs(ctx, tenant, query)
+	if err != nil {
+		return nil, err
+	}
+
+	traceIDs := make([]model.TraceID, 0, len(ids))
+	for _, id := range ids {
+		traceID, err := model.TraceIDFromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trace ID %s, %v", id, err)
+		}
+		traceIDs = append(traceIDs, traceID)
+	}
+
+	return traceIDs, nil
+}
+
+// findTraceIDs returns the IDs of traces with spans matching the query from all service name buckets
+func (s *Reader) findTraceIDs(ctx context.Context, tenant string, query *spanstore.TraceQueryParameters) ([]string, error) {
+	keyPrefix := s.tenancy.KeyPrefix(tenant)
+	spansTable := s.tenancy.Table(tenant, s.spansTable)
+
 	scanGroup, scanCtx := errgroup.WithContext(ctx)
 	traceIDSet := NewTraceIDSet()
 	for i := 0; i < serviceNameBuckets; i++ {
@@ -330,7 +524,7 @@ func (s *Reader) FindTraces(ctx context.Context, query *spanstore.TraceQueryPara
 		scanGroup.Go(func() error {
 			builder := expression.NewBuilder()
 			builder = builder.WithKeyCondition(expression.KeyEqual(
-				expression.Key("ServiceNameBucket"), expression.Value(toServiceNameBucket(query.ServiceName, serviceNameBucket))).And(expression.KeyBetween(
+				expression.Key("ServiceNameBucket"), expression.Value(keyPrefix+toServiceNameBucket(query.ServiceName, serviceNameBucket))).And(expression.KeyBetween(
 				expression.Key("StartTime"),
 				expression.Value(query.StartTimeMin.UnixNano()),
 				expression.Value(query.StartTimeMax.UnixNano()))))
@@ -349,10 +543,6 @@ func (s *Reader) FindTraces(ctx context.Context, query *spanstore.TraceQueryPara
 				expressions = append(expressions, expression.Name("Duration").LessThanEqual(expression.Value(query.DurationMax.Nanoseconds())))
 			}
 
-			for key, value := range query.Tags {
-				expressions = append(expressions, expression.Name(fmt.Sprintf("SearchableTags.%s", key)).Equal(expression.Value(value)))
-			}
-
 			if len(expressions) > 0 {
 				if len(expressions) == 1 {
 					builder = builder.WithFilter(expressions[0])
@@ -368,13 +558,15 @@ func (s *Reader) FindTraces(ctx context.Context, query *spanstore.TraceQueryPara
 				return fmt.Errorf("failed to build query expression, %v", err)
 			}
 
+			filter, names, values := withTagFilter(expr.Filter(), expr.Names(), expr.Values(), query.Tags)
+
 			paginator := dynamodb.NewQueryPaginator(s.svc, &dynamodb.QueryInput{
 				KeyConditionExpression:    expr.KeyCondition(),
-				ExpressionAttributeNames:  expr.Names(),
-				ExpressionAttributeValues: expr.Values(),
-				FilterExpression:          expr.Filter(),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				FilterExpression:          filter,
 				ProjectionExpression:      expr.Projection(),
-				TableName:                 &s.spansTable,
+				TableName:                 &spansTable,
 				IndexName:                 aws.String("SpanSearchIndex"),
 				ScanIndexForward:          aws.Bool(false),
 			})
@@ -393,7 +585,7 @@ func (s *Reader) FindTraces(ctx context.Context, query *spanstore.TraceQueryPara
 					if traceIDSet.Len() >= query.NumTraces {
 						break
 					}
-					traceIDSet.Add(item.TraceID)
+					traceIDSet.Add(strings.TrimPrefix(item.TraceID, keyPrefix))
 				}
 			}
 
@@ -404,39 +596,5 @@ func (s *Reader) FindTraces(ctx context.Context, query *spanstore.TraceQueryPara
 		return nil, fmt.Errorf("failed to query span search index, %v", err)
 	}
 
-	traceIDs := traceIDSet.Items()
-	tracesChan := make(chan *model.Trace, len(traceIDs))
-	getGroup, getCtx := errgroup.WithContext(ctx)
-	for _, traceID := range traceIDs {
-		traceID := traceID
-		// TODO Might be better to use BatchGetItem here
-		getGroup.Go(func() error {
-			trace, err := s.getTraceByID(getCtx, traceID)
-			if err != nil {
-				return fmt.Errorf("failed to fetch trace %s, %v", traceID, err)
-			}
-			tracesChan <- trace
-			return nil
-		})
-	}
-	if err := getGroup.Wait(); err != nil {
-		return nil, fmt.Errorf("failed to fetch traces, %v", err)
-	}
-	close(tracesChan)
-
-	traces := []*model.Trace{}
-	for trace := range tracesChan {
-		traces = append(traces, trace)
-	}
-
-	return traces, nil
-}
-
-// This method is not used
-func (s *Reader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
-	s.logger.Trace("FindTraceIDs", query)
-	span, _ := opentracing.StartSpanFromContext(ctx, "FindTraceIDs")
-	defer span.Finish()
-
-	return []model.TraceID{}, nil
+	return traceIDSet.Items(), nil
 }
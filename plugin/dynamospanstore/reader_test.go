@@ -15,6 +15,8 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
 	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,24 +28,30 @@ const (
 	operationsTable = "jaeger.operations"
 )
 
-func createDynamoDBSvc(assert *assert.Assertions, ctx context.Context) *dynamodb.Client {
-	dynamodbURL := os.Getenv("DYNAMODB_URL")
-	if dynamodbURL == "" {
-		dynamodbURL = "http://localhost:8000"
-	}
+// testDynamoDBAPI is implemented by both DynamoDB and the in-memory fake
+type testDynamoDBAPI interface {
+	DynamoDBAPI
+	DynamoDBReaderAPI
+	setup.DynamoDBAPI
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
-		lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
-		lo.Region = "us-east-1"
-		lo.EndpointResolver = aws.EndpointResolverFunc(
-			func(service, region string) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
-			})
-		return nil
-	})
-	assert.NoError(err)
+// createDynamoDBSvc uses DynamoDB at DYNAMODB_URL when set and the in-memory fake otherwise
+func createDynamoDBSvc(assert *assert.Assertions, ctx context.Context) testDynamoDBAPI {
+	var svc testDynamoDBAPI = dynamodbfake.New(nil)
+	if dynamodbURL := os.Getenv("DYNAMODB_URL"); dynamodbURL != "" {
+		cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
+			lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
+			lo.Region = "us-east-1"
+			lo.EndpointResolver = aws.EndpointResolverFunc(
+				func(service, region string) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
+				})
+			return nil
+		})
+		assert.NoError(err)
 
-	svc := dynamodb.NewFromConfig(cfg)
+		svc = dynamodb.NewFromConfig(cfg)
+	}
 
 	assert.NoError(setup.PollUntilReady(ctx, svc))
 	assert.NoError(setup.RecreateSpanStoreTables(ctx, svc, &setup.SetupSpanOptions{
@@ -370,6 +378,75 @@ func TestFindTracesWithOperatioName(t *testing.T) {
 	assert.Equal(traces[0].GetSpans()[0].TraceID.String(), "0000000000000011")
 }
 
+func TestReadTenancy(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	ctx := context.TODO()
+
+	svc := createDynamoDBSvc(assert, ctx)
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+
+	reader := NewReader(logger, svc, spansTable, servicesTable, operationsTable, WithReaderTenancy(manager))
+	writer, err := NewWriter(logger, svc, spansTable, servicesTable, operationsTable, WithWriterTenancy(manager))
+	assert.NoError(err)
+
+	acmeCtx := tenancy.WithTenant(ctx, "acme")
+	otherCtx := tenancy.WithTenant(ctx, "other")
+
+	var span model.Span
+	assert.NoError(jsonpb.Unmarshal(strings.NewReader(inputWithTraceTag), &span))
+	assert.NoError(writer.WriteSpan(acmeCtx, &span))
+
+	serviceNames, err := reader.GetServices(acmeCtx)
+	assert.NoError(err)
+	assert.ElementsMatch(serviceNames, []string{"query12-service"})
+
+	serviceNames, err = reader.GetServices(otherCtx)
+	assert.NoError(err)
+	assert.Empty(serviceNames)
+
+	operations, err := reader.GetOperations(acmeCtx, spanstore.OperationQueryParameters{ServiceName: "query12-service"})
+	assert.NoError(err)
+	assert.ElementsMatch(operations, []spanstore.Operation{{Name: "query12-operation"}})
+
+	operations, err = reader.GetOperations(otherCtx, spanstore.OperationQueryParameters{ServiceName: "query12-service"})
+	assert.NoError(err)
+	assert.Empty(operations)
+
+	trace, err := reader.GetTrace(acmeCtx, span.TraceID)
+	assert.NoError(err)
+	assert.Len(trace.Spans, 1)
+	assert.Equal(span.TraceID, trace.Spans[0].TraceID)
+
+	_, err = reader.GetTrace(otherCtx, span.TraceID)
+	assert.ErrorIs(err, spanstore.ErrTraceNotFound)
+
+	_, err = reader.GetTrace(ctx, span.TraceID)
+	assert.ErrorIs(err, tenancy.ErrMissingTenant)
+
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "query12-service",
+		StartTimeMin: parseTime(t, "2017-01-26T16:40:31.639875Z"),
+		StartTimeMax: parseTime(t, "2017-01-26T16:50:31.639875Z"),
+		NumTraces:    20,
+	}
+
+	traces, err := reader.FindTraces(acmeCtx, query)
+	assert.NoError(err)
+	assert.Len(traces, 1)
+
+	traces, err = reader.FindTraces(otherCtx, query)
+	assert.NoError(err)
+	assert.Empty(traces)
+}
+
 func parseTime(t *testing.T, timeStr string) time.Time {
 	time, err := time.Parse(time.RFC3339, timeStr)
 	if err != nil {
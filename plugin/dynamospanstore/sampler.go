@@ -0,0 +1,210 @@
+package dynamospanstore
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+const (
+	defaultDecisionTTL       = 5 * time.Minute
+	defaultDecisionCacheSize = 100000
+)
+
+type SamplingRule struct {
+	Service string
+	// Matches all operations of the service when empty
+	Operation string
+	// Share of traces to keep between 0 and 1
+	Rate float64
+}
+
+type SamplerOptions struct {
+	// Share of traces to keep between 0 and 1, when no rule matches. 0 requires rules, as only errors and slow
+	// spans would be kept otherwise.
+	DefaultRate float64
+	// Rules for an operation take precedence over rules for the whole service
+	Rules []SamplingRule
+	// Spans taking longer are always kept, disabled when 0
+	LatencyThreshold time.Duration
+	// Duration the decision of a trace is remembered for its later spans, defaults to 5 minutes
+	DecisionTTL time.Duration
+	// Decisions remembered per generation, older decisions are dropped early when exceeded. Defaults to 100000.
+	DecisionCacheSize int
+}
+
+type samplerMetrics struct {
+	Kept    metrics.Counter `metric:"spans_kept"`
+	Dropped metrics.Counter `metric:"spans_dropped"`
+}
+
+type samplerKey struct {
+	service   string
+	operation string
+}
+
+// Sampler decides which traces are stored. The first span of a trace decides with the rate of its service and
+// operation, and later spans written to the same instance within the DecisionTTL follow that decision. Errors and
+// slow spans keep the rest of their trace, but spans of it dropped before are lost.
+type Sampler struct {
+	defaultRate      float64
+	rules            map[samplerKey]float64
+	latencyThreshold time.Duration
+	decisionTTL      time.Duration
+	cacheSize        int
+	metrics          *samplerMetrics
+
+	mu        sync.Mutex
+	current   map[model.TraceID]bool
+	previous  map[model.TraceID]bool
+	rotatedAt time.Time
+}
+
+func NewSampler(metricsFactory metrics.Factory, options *SamplerOptions) (*Sampler, error) {
+	if err := validateRate(options.DefaultRate); err != nil {
+		return nil, err
+	}
+	if options.DefaultRate == 0 && len(options.Rules) == 0 {
+		return nil, fmt.Errorf("sampling requires a default rate above 0 or rules")
+	}
+
+	rules := map[samplerKey]float64{}
+	for _, rule := range options.Rules {
+		if rule.Service == "" {
+			return nil, fmt.Errorf("sampling rule requires a service")
+		}
+		if err := validateRate(rule.Rate); err != nil {
+			return nil, err
+		}
+		rules[samplerKey{service: rule.Service, operation: rule.Operation}] = rule.Rate
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	samplerMetrics := &samplerMetrics{}
+	if err := metrics.Init(samplerMetrics, metricsFactory.Namespace(metrics.NSOptions{Name: "sampler"}), nil); err != nil {
+		return nil, fmt.Errorf("failed to init metrics, %v", err)
+	}
+
+	decisionTTL := options.DecisionTTL
+	if decisionTTL == 0 {
+		decisionTTL = defaultDecisionTTL
+	}
+	cacheSize := options.DecisionCacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultDecisionCacheSize
+	}
+
+	return &Sampler{
+		defaultRate:      options.DefaultRate,
+		rules:            rules,
+		latencyThreshold: options.LatencyThreshold,
+		decisionTTL:      decisionTTL,
+		cacheSize:        cacheSize,
+		metrics:          samplerMetrics,
+		current:          map[model.TraceID]bool{},
+		previous:         map[model.TraceID]bool{},
+		rotatedAt:        time.Now(),
+	}, nil
+}
+
+func validateRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("sampling rate %v must be between 0 and 1", rate)
+	}
+	return nil
+}
+
+// Keep returns whether the span should be stored
+func (s *Sampler) Keep(span *model.Span) bool {
+	keep := s.keep(span)
+	if keep {
+		s.metrics.Kept.Inc(1)
+	} else {
+		s.metrics.Dropped.Inc(1)
+	}
+	return keep
+}
+
+func (s *Sampler) keep(span *model.Span) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rotate()
+
+	keep, decided := s.current[span.TraceID]
+	if !decided {
+		keep, decided = s.previous[span.TraceID]
+	}
+	if !decided {
+		keep = s.sample(span)
+	}
+	if dynamodependencystore.SpanFailed(span) || (s.latencyThreshold > 0 && span.Duration >= s.latencyThreshold) {
+		keep = true
+	}
+
+	s.current[span.TraceID] = keep
+	return keep
+}
+
+// rotate drops the previous generation of decisions after a TTL or once the current generation is full
+func (s *Sampler) rotate() {
+	if time.Since(s.rotatedAt) < s.decisionTTL && len(s.current) < s.cacheSize {
+		return
+	}
+
+	s.previous = s.current
+	s.current = map[model.TraceID]bool{}
+	s.rotatedAt = time.Now()
+}
+
+// sample decides about a trace not seen before, the decision is derived from the trace ID, so instances sampling
+// the trace with the same rate agree on it
+func (s *Sampler) sample(span *model.Span) bool {
+	rate := s.rate(span)
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	return float64(traceIDHash(span.TraceID)) < rate*math.MaxUint64
+}
+
+func (s *Sampler) rate(span *model.Span) float64 {
+	serviceName := ""
+	if span.Process != nil {
+		serviceName = span.Process.ServiceName
+	}
+
+	if rate, ok := s.rules[samplerKey{service: serviceName, operation: span.OperationName}]; ok {
+		return rate
+	}
+	if rate, ok := s.rules[samplerKey{service: serviceName}]; ok {
+		return rate
+	}
+	return s.defaultRate
+}
+
+// traceIDHash spreads trace IDs evenly, as some clients only generate 64 bit or otherwise non-random IDs
+func traceIDHash(traceID model.TraceID) uint64 {
+	return mix64(mix64(traceID.High) ^ traceID.Low)
+}
+
+// mix64 is the finalizer of splitmix64
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
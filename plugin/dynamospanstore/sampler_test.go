@@ -0,0 +1,131 @@
+package dynamospanstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+)
+
+func sampledShare(sampler *Sampler, newSpan func(traceID model.TraceID) *model.Span) float64 {
+	kept := 0
+	for i := uint64(0); i < 10000; i++ {
+		if sampler.Keep(newSpan(model.NewTraceID(0, i))) {
+			kept++
+		}
+	}
+	return float64(kept) / 10000
+}
+
+func TestSampler(t *testing.T) {
+	assert := assert.New(t)
+
+	// Decisions are remembered per trace, so every share is measured with a new sampler
+	newSampler := func() *Sampler {
+		sampler, err := NewSampler(metricstest.NewFactory(0), &SamplerOptions{
+			DefaultRate: 0.1,
+			Rules: []SamplingRule{
+				{Service: "checkout", Rate: 1},
+				{Service: "frontend", Rate: 0.5},
+				{Service: "frontend", Operation: "/health", Rate: 0},
+			},
+			LatencyThreshold: time.Second,
+		})
+		assert.NoError(err)
+		return sampler
+	}
+
+	newSpan := func(serviceName, operationName string) func(traceID model.TraceID) *model.Span {
+		return func(traceID model.TraceID) *model.Span {
+			return &model.Span{
+				TraceID:       traceID,
+				OperationName: operationName,
+				Process:       &model.Process{ServiceName: serviceName},
+			}
+		}
+	}
+
+	assert.InDelta(0.1, sampledShare(newSampler(), newSpan("other", "get")), 0.02)
+	assert.Equal(1.0, sampledShare(newSampler(), newSpan("checkout", "get")))
+	assert.InDelta(0.5, sampledShare(newSampler(), newSpan("frontend", "get")), 0.02)
+	assert.Equal(0.0, sampledShare(newSampler(), newSpan("frontend", "/health")))
+
+	// All spans of a trace get the decision of its first span, even with different rates
+	sampler := newSampler()
+	kept := 0
+	for i := uint64(0); i < 1000; i++ {
+		traceID := model.NewTraceID(0, i)
+		keep := sampler.Keep(newSpan("other", "get")(traceID))
+		assert.Equal(keep, sampler.Keep(newSpan("frontend", "get")(traceID)))
+		assert.Equal(keep, sampler.Keep(newSpan("other", "post")(traceID)))
+		if keep {
+			kept++
+		}
+	}
+	assert.InDelta(100, kept, 30)
+
+	// Errors and slow spans are always kept together with the later spans of their trace
+	errorSpan := newSpan("frontend", "/health")(model.NewTraceID(1, 1))
+	errorSpan.Tags = []model.KeyValue{model.String("otel.status_code", "ERROR")}
+	assert.True(sampler.Keep(errorSpan))
+	assert.True(sampler.Keep(newSpan("frontend", "/health")(model.NewTraceID(1, 1))))
+
+	slowSpan := newSpan("frontend", "/health")(model.NewTraceID(1, 2))
+	slowSpan.Duration = 2 * time.Second
+	assert.True(sampler.Keep(slowSpan))
+	assert.True(sampler.Keep(newSpan("frontend", "/health")(model.NewTraceID(1, 2))))
+
+	var err error
+	_, err = NewSampler(nil, &SamplerOptions{DefaultRate: 1.5})
+	assert.Error(err)
+	_, err = NewSampler(nil, &SamplerOptions{DefaultRate: 1, Rules: []SamplingRule{{Operation: "get", Rate: 1}}})
+	assert.Error(err)
+	// All spans but errors and slow ones would be dropped
+	_, err = NewSampler(nil, &SamplerOptions{})
+	assert.Error(err)
+}
+
+func TestWriteSpanSampled(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	writesPerTable := map[string]int{}
+	var mu sync.Mutex
+	svc := mockPutItemAPI(func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+		mu.Lock()
+		writesPerTable[*params.TableName] += 1
+		mu.Unlock()
+
+		return nil, nil
+	})
+
+	sampler, err := NewSampler(nil, &SamplerOptions{DefaultRate: 0, Rules: []SamplingRule{{Service: "checkout", Rate: 1}}})
+	assert.NoError(err)
+
+	writer, err := NewWriter(logger, svc, "jaeger.spans", "jaeger.services", "jaeger.operations", WithSampler(sampler))
+	assert.NoError(err)
+
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        model.NewSpanID(1),
+		OperationName: "example-operation",
+		Process:       &model.Process{ServiceName: "example-service"},
+	}
+	assert.NoError(writer.WriteSpan(context.TODO(), span))
+	assert.Equal(map[string]int{"jaeger.services": 1, "jaeger.operations": 1}, writesPerTable)
+
+	span.Tags = []model.KeyValue{model.String("error", "true")}
+	assert.NoError(writer.WriteSpan(context.TODO(), span))
+	assert.Equal(map[string]int{"jaeger.spans": 1, "jaeger.services": 1, "jaeger.operations": 1}, writesPerTable)
+}
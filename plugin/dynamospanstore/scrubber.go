@@ -0,0 +1,246 @@
+package dynamospanstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+// Actions of a scrub rule
+const (
+	// Replaces the value, or the parts matching the value pattern, with a placeholder
+	ScrubActionRedact = "redact"
+	// Replaces the value, or the parts matching the value pattern, with a keyed hash, so equal values can still be correlated
+	ScrubActionHash = "hash"
+	// Shortens string and binary values to the max length
+	ScrubActionTruncate = "truncate"
+	// Removes the tag or log field
+	ScrubActionDrop = "drop"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+	hashPrefix    = "sha256:"
+)
+
+type ScrubRule struct {
+	// Tag and log field keys the rule applies to
+	Keys []string
+	// Regular expression matched against the keys, the rule applies to all keys without keys and key pattern
+	KeyPattern string
+	// Regular expression matched against the values as strings, the rule applies to all values when empty
+	ValuePattern string
+	// One of the scrub actions
+	Action string
+	// Length in bytes values are truncated to
+	MaxLength int
+}
+
+type ScrubberOptions struct {
+	// Rules are applied in order, a value can be changed by multiple rules
+	Rules []ScrubRule
+	// Secret key of the hashes, so hashed values can't be guessed by hashing candidates. Required by hash rules.
+	HashKey string
+}
+
+type scrubberMetrics struct {
+	Redacted  metrics.Counter `metric:"values_redacted"`
+	Hashed    metrics.Counter `metric:"values_hashed"`
+	Truncated metrics.Counter `metric:"values_truncated"`
+	Dropped   metrics.Counter `metric:"values_dropped"`
+}
+
+type scrubRule struct {
+	keys         map[string]struct{}
+	keyPattern   *regexp.Regexp
+	valuePattern *regexp.Regexp
+	action       string
+	maxLength    int
+}
+
+// Scrubber removes sensitive data from the tags, log fields and process tags of spans before they are stored.
+// Searchable tags are derived from the scrubbed span, so scrubbed values can't be searched.
+type Scrubber struct {
+	rules   []*scrubRule
+	hashKey []byte
+	metrics *scrubberMetrics
+}
+
+func NewScrubber(metricsFactory metrics.Factory, options *ScrubberOptions) (*Scrubber, error) {
+	rules := []*scrubRule{}
+	for i, rule := range options.Rules {
+		r := &scrubRule{action: rule.Action, maxLength: rule.MaxLength}
+
+		switch rule.Action {
+		case ScrubActionRedact, ScrubActionDrop:
+		case ScrubActionHash:
+			if options.HashKey == "" {
+				return nil, fmt.Errorf("scrub rule %d requires a hash key", i)
+			}
+		case ScrubActionTruncate:
+			if rule.MaxLength <= 0 {
+				return nil, fmt.Errorf("scrub rule %d requires a max length", i)
+			}
+		default:
+			return nil, fmt.Errorf("unknown action %q of scrub rule %d", rule.Action, i)
+		}
+
+		if len(rule.Keys) > 0 {
+			r.keys = map[string]struct{}{}
+			for _, key := range rule.Keys {
+				r.keys[key] = struct{}{}
+			}
+		}
+
+		var err error
+		if rule.KeyPattern != "" {
+			if r.keyPattern, err = regexp.Compile(rule.KeyPattern); err != nil {
+				return nil, fmt.Errorf("invalid key pattern of scrub rule %d, %v", i, err)
+			}
+		}
+		if rule.ValuePattern != "" {
+			if r.valuePattern, err = regexp.Compile(rule.ValuePattern); err != nil {
+				return nil, fmt.Errorf("invalid value pattern of scrub rule %d, %v", i, err)
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	scrubberMetrics := &scrubberMetrics{}
+	if err := metrics.Init(scrubberMetrics, metricsFactory.Namespace(metrics.NSOptions{Name: "scrubber"}), nil); err != nil {
+		return nil, fmt.Errorf("failed to init metrics, %v", err)
+	}
+
+	return &Scrubber{
+		rules:   rules,
+		hashKey: []byte(options.HashKey),
+		metrics: scrubberMetrics,
+	}, nil
+}
+
+// Scrub returns a copy of the span with all rules applied, the span itself isn't modified
+func (s *Scrubber) Scrub(span *model.Span) *model.Span {
+	scrubbed := *span
+	scrubbed.Tags = s.scrubKeyValues(span.Tags)
+
+	if span.Logs != nil {
+		scrubbed.Logs = make([]model.Log, 0, len(span.Logs))
+		for _, log := range span.Logs {
+			scrubbed.Logs = append(scrubbed.Logs, model.Log{
+				Timestamp: log.Timestamp,
+				Fields:    s.scrubKeyValues(log.Fields),
+			})
+		}
+	}
+
+	if span.Process != nil {
+		scrubbed.Process = &model.Process{
+			ServiceName: span.Process.ServiceName,
+			Tags:        s.scrubKeyValues(span.Process.Tags),
+		}
+	}
+
+	return &scrubbed
+}
+
+func (s *Scrubber) scrubKeyValues(kvs []model.KeyValue) []model.KeyValue {
+	if kvs == nil {
+		return nil
+	}
+
+	scrubbed := make([]model.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		if kv, ok := s.scrubKeyValue(kv); ok {
+			scrubbed = append(scrubbed, kv)
+		}
+	}
+	return scrubbed
+}
+
+// scrubKeyValue returns the scrubbed key value and false when it was dropped
+func (s *Scrubber) scrubKeyValue(kv model.KeyValue) (model.KeyValue, bool) {
+	for _, rule := range s.rules {
+		if !rule.matchesKey(kv.Key) {
+			continue
+		}
+		value := kv.AsString()
+		if rule.valuePattern != nil && !rule.valuePattern.MatchString(value) {
+			continue
+		}
+
+		switch rule.action {
+		case ScrubActionDrop:
+			s.metrics.Dropped.Inc(1)
+			return kv, false
+		case ScrubActionRedact:
+			kv = model.String(kv.Key, rule.replace(value, func(string) string { return redactedValue }))
+			s.metrics.Redacted.Inc(1)
+		case ScrubActionHash:
+			kv = model.String(kv.Key, rule.replace(value, s.hash))
+			s.metrics.Hashed.Inc(1)
+		case ScrubActionTruncate:
+			if truncated, ok := truncateKeyValue(kv, rule.maxLength); ok {
+				kv = truncated
+				s.metrics.Truncated.Inc(1)
+			}
+		}
+	}
+
+	return kv, true
+}
+
+func (r *scrubRule) matchesKey(key string) bool {
+	if r.keys == nil && r.keyPattern == nil {
+		return true
+	}
+	if _, ok := r.keys[key]; ok {
+		return true
+	}
+	return r.keyPattern != nil && r.keyPattern.MatchString(key)
+}
+
+// replace replaces the matches of the value pattern or the whole value without pattern
+func (r *scrubRule) replace(value string, replacement func(string) string) string {
+	if r.valuePattern == nil {
+		return replacement(value)
+	}
+	return r.valuePattern.ReplaceAllStringFunc(value, replacement)
+}
+
+func (s *Scrubber) hash(value string) string {
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte(value))
+	return hashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncateKeyValue(kv model.KeyValue, maxLength int) (model.KeyValue, bool) {
+	switch kv.VType {
+	case model.StringType:
+		if len(kv.VStr) <= maxLength {
+			return kv, false
+		}
+		// Don't split multi-byte characters
+		end := maxLength
+		for end > 0 && !utf8.RuneStart(kv.VStr[end]) {
+			end--
+		}
+		return model.String(kv.Key, kv.VStr[:end]), true
+	case model.BinaryType:
+		if len(kv.VBinary) <= maxLength {
+			return kv, false
+		}
+		return model.Binary(kv.Key, kv.VBinary[:maxLength]), true
+	}
+	return kv, false
+}
@@ -0,0 +1,134 @@
+package dynamospanstore
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+)
+
+const emailPattern = `[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`
+
+func testScrubberOptions() *ScrubberOptions {
+	return &ScrubberOptions{
+		Rules: []ScrubRule{
+			{Keys: []string{"http.request.header.authorization"}, Action: ScrubActionDrop},
+			{KeyPattern: `(?i)password|secret`, Action: ScrubActionRedact},
+			{Keys: []string{"user.email"}, Action: ScrubActionHash},
+			{KeyPattern: `^http\.`, ValuePattern: emailPattern, Action: ScrubActionRedact},
+			{Keys: []string{"message"}, Action: ScrubActionTruncate, MaxLength: 2},
+		},
+		HashKey: "secret",
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	assert := assert.New(t)
+
+	metricsFactory := metricstest.NewFactory(0)
+	scrubber, err := NewScrubber(metricsFactory, testScrubberOptions())
+	assert.NoError(err)
+
+	span := &model.Span{
+		Tags: []model.KeyValue{
+			model.String("http.request.header.authorization", "Bearer token"),
+			model.String("user.email", "jane@example.com"),
+			model.String("http.url", "/users?email=jane@example.com&page=2"),
+			model.Int64("http.status_code", 200),
+		},
+		Logs: []model.Log{{Timestamp: time.Unix(1, 0), Fields: []model.KeyValue{
+			model.String("event", "login"),
+			model.String("http.request.header.authorization", "Bearer token"),
+			model.String("message", "héllo"),
+		}}},
+		Process: &model.Process{ServiceName: "frontend", Tags: []model.KeyValue{model.String("db.password", "hunter2")}},
+	}
+	scrubbed := scrubber.Scrub(span)
+
+	hashed := scrubber.hash("jane@example.com")
+	assert.True(strings.HasPrefix(hashed, hashPrefix))
+	assert.Equal([]model.KeyValue{
+		model.String("user.email", hashed),
+		model.String("http.url", "/users?email=[REDACTED]&page=2"),
+		model.Int64("http.status_code", 200),
+	}, scrubbed.Tags)
+	// Multi-byte characters aren't split
+	assert.Equal([]model.Log{{Timestamp: time.Unix(1, 0), Fields: []model.KeyValue{
+		model.String("event", "login"),
+		model.String("message", "h"),
+	}}}, scrubbed.Logs)
+	assert.Equal(&model.Process{ServiceName: "frontend", Tags: []model.KeyValue{model.String("db.password", "[REDACTED]")}}, scrubbed.Process)
+
+	// The original span is unchanged
+	assert.Len(span.Tags, 4)
+	assert.Equal("hunter2", span.Process.Tags[0].VStr)
+
+	metricsFactory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "scrubber.values_dropped", Value: 2},
+		metricstest.ExpectedMetric{Name: "scrubber.values_redacted", Value: 2},
+		metricstest.ExpectedMetric{Name: "scrubber.values_hashed", Value: 1},
+		metricstest.ExpectedMetric{Name: "scrubber.values_truncated", Value: 1},
+	)
+
+	// Hashes depend on the key
+	otherScrubber, err := NewScrubber(nil, &ScrubberOptions{HashKey: "other"})
+	assert.NoError(err)
+	assert.NotEqual(hashed, otherScrubber.hash("jane@example.com"))
+
+	_, err = NewScrubber(nil, &ScrubberOptions{Rules: []ScrubRule{{Action: "encrypt"}}})
+	assert.Error(err)
+	_, err = NewScrubber(nil, &ScrubberOptions{Rules: []ScrubRule{{Action: ScrubActionTruncate}}})
+	assert.Error(err)
+	_, err = NewScrubber(nil, &ScrubberOptions{Rules: []ScrubRule{{KeyPattern: "(", Action: ScrubActionDrop}}})
+	assert.Error(err)
+	// Hashes without key can be reversed by hashing candidates
+	_, err = NewScrubber(nil, &ScrubberOptions{Rules: []ScrubRule{{Keys: []string{"user.email"}, Action: ScrubActionHash}}})
+	assert.Error(err)
+}
+
+func TestWriteSpanScrubbed(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	spanItems := []*SpanItem{}
+	svc := mockPutItemAPI(func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+		if *params.TableName == "jaeger.spans" {
+			spanItem := &SpanItem{}
+			assert.NoError(attributevalue.UnmarshalMap(params.Item, spanItem))
+			mu.Lock()
+			spanItems = append(spanItems, spanItem)
+			mu.Unlock()
+		}
+		return nil, nil
+	})
+
+	scrubber, err := NewScrubber(nil, testScrubberOptions())
+	assert.NoError(err)
+	writer, err := NewWriter(hclog.NewNullLogger(), svc, "jaeger.spans", "jaeger.services", "jaeger.operations", WithScrubber(scrubber))
+	assert.NoError(err)
+
+	assert.NoError(writer.WriteSpan(context.TODO(), &model.Span{
+		TraceID: model.NewTraceID(0, 1),
+		SpanID:  model.NewSpanID(1),
+		Tags:    []model.KeyValue{model.String("user.email", "jane@example.com")},
+		Logs: []model.Log{{Fields: []model.KeyValue{
+			model.String("http.request.header.authorization", "Bearer token"),
+		}}},
+		Process: &model.Process{ServiceName: "frontend"},
+	}))
+
+	assert.Len(spanItems, 1)
+	hashed := scrubber.hash("jane@example.com")
+	assert.Equal([]model.KeyValue{model.String("user.email", hashed)}, spanItems[0].Tags)
+	assert.Empty(spanItems[0].Logs[0].Fields)
+	// Searchable tags are derived from the scrubbed span
+	assert.Equal(map[string]string{"user.email": hashed}, spanItems[0].SearchableTags)
+}
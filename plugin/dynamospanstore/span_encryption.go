@@ -0,0 +1,121 @@
+package dynamospanstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/encryption"
+)
+
+// Tags which stay searchable in encrypted spans, as they are used besides tag searches, e.g. errors are counted
+// by the dependency stream and the recomputation of dependencies
+var alwaysSearchableTags = []string{"span.kind", "error", "otel.status_code"}
+
+var errSpanEncrypted = errors.New("span is encrypted, but no encryptor is configured")
+
+// encryptedSpanBody contains the span item attributes which aren't used to look up spans
+type encryptedSpanBody struct {
+	Tags        []model.KeyValue
+	Logs        []*SpanItemLog
+	ProcessTags []model.KeyValue
+	Warnings    []string
+}
+
+// WithEncryption encrypts the tags, logs, process tags and warnings of spans. Only the searchable tags keep their
+// values in plaintext, all other tags can't be searched anymore.
+func WithEncryption(encryptor *encryption.Encryptor, searchableTags []string) WriterOption {
+	return func(w *Writer) {
+		w.encryptor = encryptor
+		w.searchableTags = map[string]struct{}{}
+		for _, tag := range append(append([]string{}, alwaysSearchableTags...), searchableTags...) {
+			w.searchableTags[tag] = struct{}{}
+		}
+	}
+}
+
+// WithReaderEncryption decrypts spans written with encryption, spans without encryption are read as before
+func WithReaderEncryption(encryptor *encryption.Encryptor) ReaderOption {
+	return func(r *Reader) {
+		r.encryptor = encryptor
+	}
+}
+
+// spanItemAdditionalData binds the encrypted body to the keys of the item, so it can't be moved to another span
+func spanItemAdditionalData(spanItem *SpanItem) []byte {
+	return []byte(spanItem.TraceID + "/" + spanItem.SpanID)
+}
+
+func encryptSpanItem(ctx context.Context, encryptor *encryption.Encryptor, spanItem *SpanItem, searchableTags map[string]struct{}) error {
+	body := &encryptedSpanBody{
+		Tags:     spanItem.Tags,
+		Logs:     spanItem.Logs,
+		Warnings: spanItem.Warnings,
+	}
+	if spanItem.Process != nil {
+		body.ProcessTags = spanItem.Process.Tags
+	}
+
+	plaintext, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode span body, %v", err)
+	}
+	envelope, err := encryptor.Encrypt(ctx, plaintext, spanItemAdditionalData(spanItem))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt span body, %v", err)
+	}
+
+	spanItem.Tags = nil
+	spanItem.Logs = nil
+	spanItem.Warnings = nil
+	if spanItem.Process != nil {
+		spanItem.Process = &SpanItemProcess{ServiceName: spanItem.Process.ServiceName}
+	}
+	for key := range spanItem.SearchableTags {
+		if _, ok := searchableTags[key]; !ok {
+			delete(spanItem.SearchableTags, key)
+		}
+	}
+
+	spanItem.EncryptedBody = envelope.Ciphertext
+	spanItem.EncryptedDataKey = envelope.EncryptedDataKey
+	spanItem.KeyID = envelope.KeyID
+	return nil
+}
+
+func decryptSpanItem(ctx context.Context, encryptor *encryption.Encryptor, spanItem *SpanItem) error {
+	if spanItem.EncryptedBody == nil {
+		return nil
+	}
+	if encryptor == nil {
+		return errSpanEncrypted
+	}
+
+	plaintext, err := encryptor.Decrypt(ctx, &encryption.Envelope{
+		KeyID:            spanItem.KeyID,
+		EncryptedDataKey: spanItem.EncryptedDataKey,
+		Ciphertext:       spanItem.EncryptedBody,
+	}, spanItemAdditionalData(spanItem))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt span body, %v", err)
+	}
+
+	body := &encryptedSpanBody{}
+	if err := json.Unmarshal(plaintext, body); err != nil {
+		return fmt.Errorf("failed to decode span body, %v", err)
+	}
+
+	spanItem.Tags = body.Tags
+	spanItem.Logs = body.Logs
+	spanItem.Warnings = body.Warnings
+	if spanItem.Process == nil {
+		spanItem.Process = &SpanItemProcess{}
+	}
+	spanItem.Process.Tags = body.ProcessTags
+	spanItem.EncryptedBody = nil
+	spanItem.EncryptedDataKey = nil
+	spanItem.KeyID = ""
+	return nil
+}
@@ -0,0 +1,102 @@
+package dynamospanstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/encryption"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSpanEncrypted(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	logger := hclog.NewNullLogger()
+	svc := createDynamoDBSvc(assert, ctx)
+
+	provider, err := encryption.NewLocalKeyProvider(map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	assert.NoError(err)
+	encryptor, err := encryption.NewEncryptor(provider, nil)
+	assert.NoError(err)
+
+	writer, err := NewWriter(logger, svc, spansTable, servicesTable, operationsTable, WithEncryption(encryptor, []string{"http.method"}))
+	assert.NoError(err)
+
+	startTime := time.Unix(0, time.Now().UnixNano())
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        model.NewSpanID(1),
+		OperationName: "GET /",
+		References:    []model.SpanRef{model.NewChildOfRef(model.NewTraceID(0, 1), model.NewSpanID(2))},
+		StartTime:     startTime,
+		Duration:      time.Second,
+		Tags: []model.KeyValue{
+			model.String("span.kind", "server"),
+			model.String("otel.status_code", "ERROR"),
+			model.String("http.method", "GET"),
+			model.String("user.email", "jane@example.com"),
+		},
+		Logs:     []model.Log{{Timestamp: startTime, Fields: []model.KeyValue{model.String("event", "login")}}},
+		Process:  &model.Process{ServiceName: "frontend", Tags: []model.KeyValue{model.String("hostname", "web-1")}},
+		Warnings: []string{"clock skew"},
+	}
+	assert.NoError(writer.WriteSpan(ctx, span))
+
+	output, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(spansTable),
+		Key: map[string]types.AttributeValue{
+			"TraceID": &types.AttributeValueMemberS{Value: span.TraceID.String()},
+			"SpanID":  &types.AttributeValueMemberS{Value: span.SpanID.String()},
+		},
+	})
+	assert.NoError(err)
+	spanItem := &SpanItem{}
+	assert.NoError(attributevalue.UnmarshalMap(output.Item, spanItem))
+	assert.Empty(spanItem.Tags)
+	assert.Empty(spanItem.Logs)
+	assert.Empty(spanItem.Process.Tags)
+	assert.Equal("frontend", spanItem.ServiceName)
+	assert.Len(spanItem.References, 1)
+	assert.Equal("k1", spanItem.KeyID)
+	assert.NotContains(string(spanItem.EncryptedBody), "jane@example.com")
+	assert.Equal(map[string]string{"span.kind": "server", "otel.status_code": "ERROR", "http.method": "GET"}, spanItem.SearchableTags)
+
+	// Reading without the encryptor fails
+	_, err = NewSpanFromSpanItem(spanItem)
+	assert.Error(err)
+
+	reader := NewReader(logger, svc, spansTable, servicesTable, operationsTable, WithReaderEncryption(encryptor))
+	trace, err := reader.GetTrace(ctx, span.TraceID)
+	assert.NoError(err)
+	assert.Equal([]*model.Span{span}, trace.Spans)
+
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: startTime.Add(-time.Minute),
+		StartTimeMax: startTime.Add(time.Minute),
+		NumTraces:    10,
+		Tags:         map[string]string{"http.method": "GET"},
+	}
+	traces, err := reader.FindTraces(ctx, query)
+	assert.NoError(err)
+	assert.Len(traces, 1)
+
+	// Encrypted tags can't be searched
+	query.Tags = map[string]string{"user.email": "jane@example.com"}
+	traces, err = reader.FindTraces(ctx, query)
+	assert.NoError(err)
+	assert.Empty(traces)
+
+	// Moving the encrypted body to another span fails
+	spanItem.SpanID = model.NewSpanID(3).String()
+	assert.Error(decryptSpanItem(ctx, encryptor, spanItem))
+}
@@ -0,0 +1,207 @@
+package dynamospanstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/uber/jaeger-lib/metrics"
+)
+
+const (
+	// DropPolicyNewest rejects incoming spans while the queue is full
+	DropPolicyNewest = "drop-newest"
+	// DropPolicyOldest evicts the oldest queued span to make room for an incoming span
+	DropPolicyOldest = "drop-oldest"
+	// DropPolicyBlock blocks incoming spans until the queue has room again
+	DropPolicyBlock = "block"
+
+	defaultQueueSize = 10000
+	defaultWorkers   = 50
+)
+
+var ErrWriterClosed = errors.New("writer closed")
+
+type StreamingWriterOptions struct {
+	QueueSize  int
+	Workers    int
+	DropPolicy string
+}
+
+type streamingWriterMetrics struct {
+	Queued      metrics.Counter `metric:"spans_queued"`
+	Dropped     metrics.Counter `metric:"spans_dropped"`
+	Written     metrics.Counter `metric:"spans_written"`
+	Failed      metrics.Counter `metric:"spans_failed"`
+	QueueLength metrics.Gauge   `metric:"queue_length"`
+}
+
+type queuedSpan struct {
+	ctx  context.Context
+	span *model.Span
+}
+
+// StreamingWriter acknowledges spans as soon as they are queued and writes them asynchronously
+type StreamingWriter struct {
+	logger     hclog.Logger
+	writer     spanstore.Writer
+	dropPolicy string
+	queue      chan *queuedSpan
+	metrics    *streamingWriterMetrics
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	mu        sync.RWMutex
+	workers   sync.WaitGroup
+}
+
+func NewStreamingWriter(logger hclog.Logger, writer spanstore.Writer, metricsFactory metrics.Factory, options *StreamingWriterOptions) (*StreamingWriter, error) {
+	queueSize := options.QueueSize
+	if queueSize == 0 {
+		queueSize = defaultQueueSize
+	}
+
+	workers := options.Workers
+	if workers == 0 {
+		workers = defaultWorkers
+	}
+
+	dropPolicy := options.DropPolicy
+	if dropPolicy == "" {
+		dropPolicy = DropPolicyNewest
+	}
+	if dropPolicy != DropPolicyNewest && dropPolicy != DropPolicyOldest && dropPolicy != DropPolicyBlock {
+		return nil, fmt.Errorf("unsupported drop policy %q", options.DropPolicy)
+	}
+
+	if metricsFactory == nil {
+		metricsFactory = metrics.NullFactory
+	}
+
+	writerMetrics := &streamingWriterMetrics{}
+	if err := metrics.Init(writerMetrics, metricsFactory.Namespace(metrics.NSOptions{Name: "streaming_writer"}), nil); err != nil {
+		return nil, fmt.Errorf("failed to init metrics, %v", err)
+	}
+
+	s := &StreamingWriter{
+		logger:     logger,
+		writer:     writer,
+		dropPolicy: dropPolicy,
+		queue:      make(chan *queuedSpan, queueSize),
+		metrics:    writerMetrics,
+		closed:     make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+
+	return s, nil
+}
+
+func (s *StreamingWriter) work() {
+	defer s.workers.Done()
+
+	for queued := range s.queue {
+		s.metrics.QueueLength.Update(int64(len(s.queue)))
+
+		if err := s.writer.WriteSpan(queued.ctx, queued.span); err != nil {
+			s.metrics.Failed.Inc(1)
+			s.logger.Error("failed to write queued span", "err", err)
+			continue
+		}
+		s.metrics.Written.Inc(1)
+	}
+}
+
+func (s *StreamingWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	select {
+	case <-s.closed:
+		return ErrWriterClosed
+	default:
+	}
+
+	// The request context is cancelled once the stream ends, but its values like the tenant are still needed
+	queued := &queuedSpan{ctx: detachedContext{ctx}, span: span}
+
+	switch s.dropPolicy {
+	case DropPolicyBlock:
+		select {
+		case s.queue <- queued:
+		case <-ctx.Done():
+			s.metrics.Dropped.Inc(1)
+			return ctx.Err()
+		}
+	case DropPolicyOldest:
+		for !s.enqueue(queued) {
+			select {
+			case <-s.queue:
+				s.metrics.Dropped.Inc(1)
+			default:
+			}
+		}
+	default:
+		if !s.enqueue(queued) {
+			s.metrics.Dropped.Inc(1)
+			return nil
+		}
+	}
+
+	s.metrics.Queued.Inc(1)
+	s.metrics.QueueLength.Update(int64(len(s.queue)))
+	return nil
+}
+
+func (s *StreamingWriter) enqueue(queued *queuedSpan) bool {
+	select {
+	case s.queue <- queued:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting spans and waits until all queued spans are written
+func (s *StreamingWriter) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		// Wait for in-flight WriteSpan calls before closing the queue
+		s.mu.Lock()
+		close(s.queue)
+		s.mu.Unlock()
+	})
+
+	s.workers.Wait()
+	return nil
+}
+
+// detachedContext keeps the values of its parent, but is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
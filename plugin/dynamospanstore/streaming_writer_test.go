@@ -0,0 +1,124 @@
+package dynamospanstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-lib/metrics/metricstest"
+)
+
+type mockSpanWriter struct {
+	mu      sync.Mutex
+	block   chan struct{}
+	tenancy *tenancy.Manager
+	spans   []*model.Span
+	tenants []string
+}
+
+func (m *mockSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	<-m.block
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tenant, err := m.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, span)
+	m.tenants = append(m.tenants, tenant)
+	return nil
+}
+
+func TestStreamingWriterDropPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	tests := []struct {
+		dropPolicy string
+		queued     int
+		written    []uint64
+	}{
+		// The first span is taken by the worker, the second one queued and the rest dropped
+		{dropPolicy: DropPolicyNewest, queued: 2, written: []uint64{1, 2}},
+		// The first span is taken by the worker, the last one replaces all previously queued ones
+		{dropPolicy: DropPolicyOldest, queued: 5, written: []uint64{1, 5}},
+	}
+
+	for _, tc := range tests {
+		metricsFactory := metricstest.NewFactory(0)
+		spanWriter := &mockSpanWriter{block: make(chan struct{})}
+
+		writer, err := NewStreamingWriter(logger, spanWriter, metricsFactory, &StreamingWriterOptions{
+			QueueSize:  1,
+			Workers:    1,
+			DropPolicy: tc.dropPolicy,
+		})
+		assert.NoError(err)
+
+		// Wait until the worker picked up the first span, so the queue is empty again
+		assert.NoError(writer.WriteSpan(context.TODO(), &model.Span{SpanID: 1}))
+		assert.Eventually(func() bool { return len(writer.queue) == 0 }, time.Second, time.Millisecond)
+
+		for i := uint64(2); i <= 5; i++ {
+			assert.NoError(writer.WriteSpan(context.TODO(), &model.Span{SpanID: model.SpanID(i)}))
+		}
+
+		close(spanWriter.block)
+		assert.NoError(writer.Close())
+
+		written := []uint64{}
+		for _, span := range spanWriter.spans {
+			written = append(written, uint64(span.SpanID))
+		}
+		assert.Equal(tc.written, written, tc.dropPolicy)
+
+		metricsFactory.AssertCounterMetrics(t,
+			metricstest.ExpectedMetric{Name: "streaming_writer.spans_queued", Value: tc.queued},
+			metricstest.ExpectedMetric{Name: "streaming_writer.spans_dropped", Value: 3},
+			metricstest.ExpectedMetric{Name: "streaming_writer.spans_written", Value: len(tc.written)},
+		)
+
+		assert.ErrorIs(writer.WriteSpan(context.TODO(), &model.Span{}), ErrWriterClosed)
+	}
+}
+
+func TestStreamingWriterDetachesContext(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Tenants: []string{"acme"}})
+	assert.NoError(err)
+
+	spanWriter := &mockSpanWriter{block: make(chan struct{}), tenancy: manager}
+	writer, err := NewStreamingWriter(logger, spanWriter, nil, &StreamingWriterOptions{DropPolicy: DropPolicyBlock})
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(tenancy.WithTenant(context.TODO(), "acme"))
+	assert.NoError(writer.WriteSpan(ctx, &model.Span{SpanID: 1}))
+	cancel()
+
+	close(spanWriter.block)
+	assert.NoError(writer.Close())
+	assert.Equal([]string{"acme"}, spanWriter.tenants)
+}
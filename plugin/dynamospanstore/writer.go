@@ -2,6 +2,7 @@ package dynamospanstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -12,6 +13,9 @@ import (
 	"github.com/hashicorp/go-hclog"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/encryption"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -26,13 +30,57 @@ const (
 var (
 	serviceDedupeWritesFor    = 5 * time.Minute
 	operationsDedupeWritesFor = 5 * time.Minute
+
+	errWriteSkipped = errors.New("write skipped by rate limiter")
 )
 
 type DynamoDBAPI interface {
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 }
 
-func NewWriter(logger hclog.Logger, svc DynamoDBAPI, spansTable, servicesTable, operationsTable string) (*Writer, error) {
+type WriterOption func(*Writer)
+
+// DependencyAggregator counts the calls between services of written spans, see dynamodependencystore.Aggregator
+type DependencyAggregator interface {
+	AddSpan(tenant string, span *model.Span)
+}
+
+// WithWriterTenancy scopes all writes to the tenant of the request
+func WithWriterTenancy(manager *tenancy.Manager) WriterOption {
+	return func(w *Writer) {
+		w.tenancy = manager
+	}
+}
+
+// WithRateLimiter limits the writes per table and skips index writes while a table is throttled
+func WithRateLimiter(limiter *RateLimiter) WriterOption {
+	return func(w *Writer) {
+		w.rateLimiter = limiter
+	}
+}
+
+// WithSampler only stores sampled spans, services and operations are stored for all spans
+func WithSampler(sampler *Sampler) WriterOption {
+	return func(w *Writer) {
+		w.sampler = sampler
+	}
+}
+
+// WithScrubber removes sensitive data from spans before they are stored
+func WithScrubber(scrubber *Scrubber) WriterOption {
+	return func(w *Writer) {
+		w.scrubber = scrubber
+	}
+}
+
+// WithDependencyAggregator passes all spans to the aggregator, including spans which aren't sampled
+func WithDependencyAggregator(aggregator DependencyAggregator) WriterOption {
+	return func(w *Writer) {
+		w.aggregator = aggregator
+	}
+}
+
+func NewWriter(logger hclog.Logger, svc DynamoDBAPI, spansTable, servicesTable, operationsTable string, options ...WriterOption) (*Writer, error) {
 	serviceCache, err := lru.New(serviceCacheSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create service cache, %v", err)
@@ -43,7 +91,7 @@ func NewWriter(logger hclog.Logger, svc DynamoDBAPI, spansTable, servicesTable,
 		return nil, fmt.Errorf("failed to create operations cache, %v", err)
 	}
 
-	return &Writer{
+	writer := &Writer{
 		svc:             svc,
 		spansTable:      spansTable,
 		servicesTable:   servicesTable,
@@ -51,7 +99,12 @@ func NewWriter(logger hclog.Logger, svc DynamoDBAPI, spansTable, servicesTable,
 		logger:          logger,
 		serviceCache:    serviceCache,
 		operationsCache: operationsCache,
-	}, nil
+	}
+	for _, option := range options {
+		option(writer)
+	}
+
+	return writer, nil
 }
 
 type Writer struct {
@@ -62,6 +115,13 @@ type Writer struct {
 	operationsTable string
 	serviceCache    *lru.Cache
 	operationsCache *lru.Cache
+	tenancy         *tenancy.Manager
+	rateLimiter     *RateLimiter
+	sampler         *Sampler
+	scrubber        *Scrubber
+	aggregator      DependencyAggregator
+	encryptor       *encryption.Encryptor
+	searchableTags  map[string]struct{}
 }
 
 type SpanItemProcess struct {
@@ -99,6 +159,10 @@ type SpanItem struct {
 	// Used for querying with a sharded GSI
 	// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/bp-indexes-gsi-sharding.html
 	ServiceNameBucket string
+	// Set instead of the tags, logs, process tags and warnings when the span is encrypted
+	EncryptedBody    []byte `dynamodbav:",omitempty"`
+	EncryptedDataKey []byte `dynamodbav:",omitempty"`
+	KeyID            string `dynamodbav:",omitempty"`
 	// XXX_NoUnkeyedLiteral struct{}
 	// XXX_unrecognized     []byte
 	// XXX_sizecache        int32
@@ -221,16 +285,29 @@ func NewOperationItemFromSpan(span *model.Span) *OperationItem {
 	}
 }
 
-func (s *Writer) writeItem(ctx context.Context, item interface{}, table string) error {
+func (s *Writer) writeItem(ctx context.Context, item interface{}, table string, priority writePriority) error {
 	av, err := attributevalue.MarshalMap(item)
 	if err != nil {
 		return fmt.Errorf("failed to marshal span: %w", err)
 	}
 
+	if s.rateLimiter != nil {
+		ok, err := s.rateLimiter.Acquire(ctx, table, priority)
+		if err != nil {
+			return fmt.Errorf("failed to wait for rate limiter: %w", err)
+		}
+		if !ok {
+			return errWriteSkipped
+		}
+	}
+
 	_, err = s.svc.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(table),
 		Item:      av,
 	})
+	if s.rateLimiter != nil {
+		s.rateLimiter.Observe(table, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to put item: %w", err)
 	}
@@ -238,54 +315,109 @@ func (s *Writer) writeItem(ctx context.Context, item interface{}, table string)
 	return nil
 }
 
-func (s *Writer) writeSpanItem(ctx context.Context, span *model.Span) error {
-	return s.writeItem(ctx, NewSpanItemFromSpan(span), s.spansTable)
+// scopeSpanItem prefixes all keys of a span item, which are used to look it up, with the tenant key prefix
+func scopeSpanItem(spanItem *SpanItem, keyPrefix string) {
+	if keyPrefix == "" {
+		return
+	}
+
+	spanItem.TraceID = keyPrefix + spanItem.TraceID
+	spanItem.ServiceNameBucket = keyPrefix + spanItem.ServiceNameBucket
+	for _, reference := range spanItem.References {
+		reference.TraceID = keyPrefix + reference.TraceID
+	}
+}
+
+func (s *Writer) writeSpanItem(ctx context.Context, tenant string, span *model.Span) error {
+	spanItem := NewSpanItemFromSpan(span)
+	scopeSpanItem(spanItem, s.tenancy.KeyPrefix(tenant))
+	if s.encryptor != nil {
+		if err := encryptSpanItem(ctx, s.encryptor, spanItem, s.searchableTags); err != nil {
+			return err
+		}
+	}
+
+	return s.writeItem(ctx, spanItem, s.tenancy.Table(tenant, s.spansTable), priorityHigh)
 }
 
-func (s *Writer) writeServiceItem(ctx context.Context, span *model.Span) error {
+func (s *Writer) writeServiceItem(ctx context.Context, tenant string, span *model.Span) error {
 	serviceName := span.Process.ServiceName
 	if serviceName == "" {
 		return nil
 	}
 
-	return dedupeFunc(s.serviceCache, serviceName, serviceDedupeWritesFor, func() error {
-		return s.writeItem(ctx, NewServiceItemFromSpan(span), s.servicesTable)
+	keyPrefix := s.tenancy.KeyPrefix(tenant)
+	dedupeKey := fmt.Sprintf("%s__%s", tenant, serviceName)
+	err := dedupeFunc(s.serviceCache, dedupeKey, serviceDedupeWritesFor, func() error {
+		serviceItem := NewServiceItemFromSpan(span)
+		serviceItem.Name = keyPrefix + serviceItem.Name
+
+		return s.writeItem(ctx, serviceItem, s.tenancy.Table(tenant, s.servicesTable), priorityLow)
 	})
+	// Skipped writes aren't cached, so they are retried with one of the next spans
+	if errors.Is(err, errWriteSkipped) {
+		return nil
+	}
+	return err
 }
 
-func (s *Writer) writeOperationItem(ctx context.Context, span *model.Span) error {
+func (s *Writer) writeOperationItem(ctx context.Context, tenant string, span *model.Span) error {
 	operationName := span.OperationName
 	serviceName := span.Process.ServiceName
 	if operationName == "" || serviceName == "" {
 		return nil
 	}
 
-	dedupeKey := fmt.Sprintf("%s__%s", serviceName, operationName)
-	return dedupeFunc(s.operationsCache, dedupeKey, operationsDedupeWritesFor, func() error {
-		return s.writeItem(ctx, NewOperationItemFromSpan(span), s.operationsTable)
+	keyPrefix := s.tenancy.KeyPrefix(tenant)
+	dedupeKey := fmt.Sprintf("%s__%s__%s", tenant, serviceName, operationName)
+	err := dedupeFunc(s.operationsCache, dedupeKey, operationsDedupeWritesFor, func() error {
+		operationItem := NewOperationItemFromSpan(span)
+		operationItem.ServiceName = keyPrefix + operationItem.ServiceName
+
+		return s.writeItem(ctx, operationItem, s.tenancy.Table(tenant, s.operationsTable), priorityLow)
 	})
+	// Skipped writes aren't cached, so they are retried with one of the next spans
+	if errors.Is(err, errWriteSkipped) {
+		return nil
+	}
+	return err
 }
 
 func (s *Writer) WriteSpan(ctx context.Context, span *model.Span) error {
 	// s.logger.Debug("WriteSpan", span)
 
-	g, ctx := errgroup.WithContext(context.Background())
+	ctx, otSpan := tracer.Start(ctx, "WriteSpan")
+	defer otSpan.End()
+
+	tenant, err := s.tenancy.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tenant, %w", err)
+	}
+
+	if s.scrubber != nil {
+		span = s.scrubber.Scrub(span)
+	}
+
+	// The writes aren't canceled with the request, but traced as part of it
+	g, ctx := errgroup.WithContext(trace.ContextWithSpan(context.Background(), otSpan))
 	// TODO Writes should be batched here
+	if s.sampler == nil || s.sampler.Keep(span) {
+		g.Go(func() error {
+			if err := s.writeSpanItem(ctx, tenant, span); err != nil {
+				return fmt.Errorf("failed to write span item, %w", err)
+			}
+			return nil
+		})
+	}
 	g.Go(func() error {
-		if err := s.writeSpanItem(ctx, span); err != nil {
-			return fmt.Errorf("failed to write span item, %v", err)
-		}
-		return nil
-	})
-	g.Go(func() error {
-		if err := s.writeServiceItem(ctx, span); err != nil {
-			return fmt.Errorf("failed to write service item, %v", err)
+		if err := s.writeServiceItem(ctx, tenant, span); err != nil {
+			return fmt.Errorf("failed to write service item, %w", err)
 		}
 		return nil
 	})
 	g.Go(func() error {
-		if err := s.writeOperationItem(ctx, span); err != nil {
-			return fmt.Errorf("failed to write operation item, %v", err)
+		if err := s.writeOperationItem(ctx, tenant, span); err != nil {
+			return fmt.Errorf("failed to write operation item, %w", err)
 		}
 		return nil
 	})
@@ -294,6 +426,11 @@ func (s *Writer) WriteSpan(ctx context.Context, span *model.Span) error {
 		return err
 	}
 
+	// Failed spans are counted once they are retried, e.g. by the buffered writer
+	if s.aggregator != nil {
+		s.aggregator.AddSpan(tenant, span)
+	}
+
 	return nil
 }
 
@@ -8,10 +8,13 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/gogo/protobuf/jsonpb"
 	"github.com/hashicorp/go-hclog"
 	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
 )
 
 type mockPutItemAPI func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
@@ -91,3 +94,146 @@ func TestWriteSpanDedupe(t *testing.T) {
 	assert.Equal(writesPerTable[servicesTable], 1)
 	assert.Equal(writesPerTable[operationsTable], 1)
 }
+
+func TestWriteSpanTenancy(t *testing.T) {
+	assert := assert.New(t)
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Warn,
+		Name:       loggerName,
+		JSONFormat: true,
+	})
+
+	var (
+		spansTable      = "jaeger.spans"
+		servicesTable   = "jaeger.services"
+		operationsTable = "jaeger.operations"
+	)
+
+	type write struct {
+		table string
+		key   string
+	}
+
+	var span model.Span
+	assert.NoError(jsonpb.Unmarshal(strings.NewReader(`{
+		"traceId": "AAAAAAAAAAAAAAAAAAAAEQ==",
+		"spanId": "AAAAAAAAAAM=",
+		"operationName": "example-operation-1",
+		"references": [{"traceId": "AAAAAAAAAAAAAAAAAAAAEQ==", "spanId": "AAAAAAAAAAI="}],
+		"startTime": "2017-01-26T16:46:31.639875Z",
+		"duration": "100000ns",
+		"process": {
+			"serviceName": "example-service-1"
+		}
+	}`), &span))
+
+	tests := []struct {
+		mode   string
+		writes []write
+	}{
+		{
+			mode: tenancy.ModeTable,
+			writes: []write{
+				{table: "acme.jaeger.spans", key: "0000000000000011"},
+				{table: "acme.jaeger.services", key: "example-service-1"},
+				{table: "acme.jaeger.operations", key: "example-service-1"},
+				{table: "other.jaeger.spans", key: "0000000000000011"},
+				{table: "other.jaeger.services", key: "example-service-1"},
+				{table: "other.jaeger.operations", key: "example-service-1"},
+			},
+		},
+		{
+			mode: tenancy.ModeKey,
+			writes: []write{
+				{table: "jaeger.spans", key: "acme/0000000000000011"},
+				{table: "jaeger.services", key: "acme/example-service-1"},
+				{table: "jaeger.operations", key: "acme/example-service-1"},
+				{table: "jaeger.spans", key: "other/0000000000000011"},
+				{table: "jaeger.services", key: "other/example-service-1"},
+				{table: "jaeger.operations", key: "other/example-service-1"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tc.mode, Tenants: []string{"acme", "other"}})
+		assert.NoError(err)
+
+		writes := []write{}
+		var mu sync.Mutex
+		svc := mockPutItemAPI(func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+			var key string
+			switch *params.TableName {
+			case manager.Table("acme", spansTable), manager.Table("other", spansTable):
+				key = params.Item["TraceID"].(*types.AttributeValueMemberS).Value
+				assert.Equal(key, params.Item["References"].(*types.AttributeValueMemberL).Value[0].(*types.AttributeValueMemberM).Value["TraceID"].(*types.AttributeValueMemberS).Value)
+			case manager.Table("acme", servicesTable), manager.Table("other", servicesTable):
+				key = params.Item["Name"].(*types.AttributeValueMemberS).Value
+			default:
+				key = params.Item["ServiceName"].(*types.AttributeValueMemberS).Value
+			}
+
+			mu.Lock()
+			writes = append(writes, write{table: *params.TableName, key: key})
+			mu.Unlock()
+
+			return nil, nil
+		})
+
+		writer, err := NewWriter(logger, svc, spansTable, servicesTable, operationsTable, WithWriterTenancy(manager))
+		assert.NoError(err)
+
+		assert.ErrorIs(writer.WriteSpan(context.TODO(), &span), tenancy.ErrMissingTenant)
+		assert.NoError(writer.WriteSpan(metadata.NewIncomingContext(context.TODO(), metadata.Pairs("x-tenant", "acme")), &span))
+		assert.NoError(writer.WriteSpan(metadata.NewIncomingContext(context.TODO(), metadata.Pairs("x-tenant", "other")), &span))
+
+		assert.ElementsMatch(tc.writes, writes)
+	}
+}
+
+type recordingAggregator struct {
+	mu    sync.Mutex
+	spans map[string][]*model.Span
+}
+
+func (r *recordingAggregator) AddSpan(tenant string, span *model.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans[tenant] = append(r.spans[tenant], span)
+}
+
+func TestWriteSpanDependencyAggregator(t *testing.T) {
+	assert := assert.New(t)
+
+	writesPerTable := map[string]int{}
+	var mu sync.Mutex
+	svc := mockPutItemAPI(func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+		mu.Lock()
+		writesPerTable[*params.TableName] += 1
+		mu.Unlock()
+
+		return nil, nil
+	})
+
+	sampler, err := NewSampler(nil, &SamplerOptions{DefaultRate: 0, Rules: []SamplingRule{{Service: "checkout", Rate: 1}}})
+	assert.NoError(err)
+	manager, err := tenancy.NewManager(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey})
+	assert.NoError(err)
+	aggregator := &recordingAggregator{spans: map[string][]*model.Span{}}
+	writer, err := NewWriter(hclog.NewNullLogger(), svc, "jaeger.spans", "jaeger.services", "jaeger.operations",
+		WithWriterTenancy(manager), WithSampler(sampler), WithDependencyAggregator(aggregator))
+	assert.NoError(err)
+
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 1),
+		SpanID:        model.NewSpanID(1),
+		OperationName: "example-operation-1",
+		Process:       model.NewProcess("example-service-1", nil),
+	}
+	assert.NoError(writer.WriteSpan(tenancy.WithTenant(context.TODO(), "acme"), span))
+
+	// Dependencies are counted for spans which aren't stored
+	assert.Equal(0, writesPerTable["jaeger.spans"])
+	assert.Equal([]*model.Span{span}, aggregator.spans["acme"])
+}
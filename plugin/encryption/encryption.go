@@ -0,0 +1,207 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	defaultDataKeyTTL     = 5 * time.Minute
+	defaultDataKeyMaxUses = 1 << 20
+	defaultCacheSize      = 1000
+)
+
+var errInvalidCiphertext = errors.New("ciphertext is too short")
+
+// DataKey is a key used to encrypt items, which is stored encrypted by a master key next to the items
+type DataKey struct {
+	// ID of the master key the data key is encrypted with
+	KeyID     string
+	Plaintext []byte
+	Encrypted []byte
+}
+
+// KeyProvider generates data keys and decrypts them
+type KeyProvider interface {
+	GenerateDataKey(ctx context.Context) (*DataKey, error)
+	DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error)
+}
+
+type Options struct {
+	// Duration a data key is used for new items, before a new one is generated
+	DataKeyTTL time.Duration
+	// Number of items encrypted with a data key, before a new one is generated
+	DataKeyMaxUses int64
+	// Number of decrypted data keys kept in memory
+	CacheSize int
+}
+
+// Envelope is an encrypted payload together with the encrypted data key needed to decrypt it
+type Envelope struct {
+	KeyID            string
+	EncryptedDataKey []byte
+	Ciphertext       []byte
+}
+
+type encryptionKey struct {
+	dataKey   *DataKey
+	aead      cipher.AEAD
+	expiresAt time.Time
+	uses      int64
+}
+
+// Encryptor encrypts payloads with AES-256-GCM using data keys of a key provider. Data keys are reused for a
+// while and decrypted data keys are cached, so the key provider isn't called for every item.
+type Encryptor struct {
+	provider KeyProvider
+	options  Options
+
+	mu      sync.Mutex
+	current *encryptionKey
+	// Decrypted data keys by key ID and encrypted data key
+	cache *lru.Cache
+}
+
+func NewEncryptor(provider KeyProvider, options *Options) (*Encryptor, error) {
+	encryptor := &Encryptor{provider: provider}
+	if options != nil {
+		encryptor.options = *options
+	}
+	if encryptor.options.DataKeyTTL <= 0 {
+		encryptor.options.DataKeyTTL = defaultDataKeyTTL
+	}
+	if encryptor.options.DataKeyMaxUses <= 0 {
+		encryptor.options.DataKeyMaxUses = defaultDataKeyMaxUses
+	}
+	if encryptor.options.CacheSize <= 0 {
+		encryptor.options.CacheSize = defaultCacheSize
+	}
+
+	cache, err := lru.New(encryptor.options.CacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data key cache, %v", err)
+	}
+	encryptor.cache = cache
+
+	return encryptor, nil
+}
+
+// Encrypt seals the plaintext, the additional data isn't encrypted but has to match on decryption
+func (e *Encryptor) Encrypt(ctx context.Context, plaintext, additionalData []byte) (*Envelope, error) {
+	key, err := e.encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce, %v", err)
+	}
+
+	return &Envelope{
+		KeyID:            key.dataKey.KeyID,
+		EncryptedDataKey: key.dataKey.Encrypted,
+		Ciphertext:       key.aead.Seal(nonce, nonce, plaintext, additionalData),
+	}, nil
+}
+
+func (e *Encryptor) Decrypt(ctx context.Context, envelope *Envelope, additionalData []byte) ([]byte, error) {
+	aead, err := e.decryptionKey(ctx, envelope.KeyID, envelope.EncryptedDataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, envelope.Ciphertext, additionalData)
+}
+
+// Rotate stops using the current data key, e.g. after the master key was rotated
+func (e *Encryptor) Rotate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.current = nil
+}
+
+func (e *Encryptor) encryptionKey(ctx context.Context) (*encryptionKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != nil && e.current.uses < e.options.DataKeyMaxUses && time.Now().Before(e.current.expiresAt) {
+		e.current.uses++
+		return e.current, nil
+	}
+
+	dataKey, err := e.provider.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key, %v", err)
+	}
+	aead, err := newAEAD(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	e.current = &encryptionKey{
+		dataKey:   dataKey,
+		aead:      aead,
+		expiresAt: time.Now().Add(e.options.DataKeyTTL),
+		uses:      1,
+	}
+	// Items written with the data key are likely read soon
+	e.cache.Add(cacheKey(dataKey.KeyID, dataKey.Encrypted), aead)
+	return e.current, nil
+}
+
+func (e *Encryptor) decryptionKey(ctx context.Context, keyID string, encrypted []byte) (cipher.AEAD, error) {
+	key := cacheKey(keyID, encrypted)
+	if aead, ok := e.cache.Get(key); ok {
+		return aead.(cipher.AEAD), nil
+	}
+
+	plaintext, err := e.provider.DecryptDataKey(ctx, keyID, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key, %v", err)
+	}
+	aead, err := newAEAD(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	e.cache.Add(key, aead)
+	return aead, nil
+}
+
+func cacheKey(keyID string, encrypted []byte) string {
+	return keyID + "/" + string(encrypted)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher, %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher, %v", err)
+	}
+	return aead, nil
+}
+
+// open decrypts a ciphertext prefixed with its nonce
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errInvalidCiphertext
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt, %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,173 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+type countingProvider struct {
+	KeyProvider
+	generated int
+	decrypted int
+}
+
+func (p *countingProvider) GenerateDataKey(ctx context.Context) (*DataKey, error) {
+	p.generated++
+	return p.KeyProvider.GenerateDataKey(ctx)
+}
+
+func (p *countingProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	p.decrypted++
+	return p.KeyProvider.DecryptDataKey(ctx, keyID, encrypted)
+}
+
+func TestEncryptor(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	local, err := NewLocalKeyProvider(map[string][]byte{"k1": testKey(1)}, "k1")
+	assert.NoError(err)
+	provider := &countingProvider{KeyProvider: local}
+	encryptor, err := NewEncryptor(provider, &Options{DataKeyMaxUses: 2})
+	assert.NoError(err)
+
+	envelopes := []*Envelope{}
+	for i := 0; i < 3; i++ {
+		envelope, err := encryptor.Encrypt(ctx, []byte("payload"), []byte("item"))
+		assert.NoError(err)
+		assert.Equal("k1", envelope.KeyID)
+		assert.NotContains(string(envelope.Ciphertext), "payload")
+		envelopes = append(envelopes, envelope)
+	}
+	// A new data key is generated after two uses
+	assert.Equal(2, provider.generated)
+	assert.Equal(envelopes[0].EncryptedDataKey, envelopes[1].EncryptedDataKey)
+	assert.NotEqual(envelopes[0].EncryptedDataKey, envelopes[2].EncryptedDataKey)
+
+	// Rotating generates a new data key before the current one is used up
+	encryptor.Rotate()
+	_, err = encryptor.Encrypt(ctx, []byte("payload"), []byte("item"))
+	assert.NoError(err)
+	assert.Equal(3, provider.generated)
+
+	for _, envelope := range envelopes {
+		plaintext, err := encryptor.Decrypt(ctx, envelope, []byte("item"))
+		assert.NoError(err)
+		assert.Equal([]byte("payload"), plaintext)
+	}
+	// Generated data keys are cached
+	assert.Equal(0, provider.decrypted)
+
+	// Another encryptor, e.g. of the query service, has to decrypt the data key once
+	other, err := NewEncryptor(provider, nil)
+	assert.NoError(err)
+	for i := 0; i < 2; i++ {
+		plaintext, err := other.Decrypt(ctx, envelopes[0], []byte("item"))
+		assert.NoError(err)
+		assert.Equal([]byte("payload"), plaintext)
+	}
+	assert.Equal(1, provider.decrypted)
+
+	// The additional data has to match
+	_, err = encryptor.Decrypt(ctx, envelopes[0], []byte("other item"))
+	assert.Error(err)
+}
+
+func TestEncryptorDataKeyTTL(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	local, err := NewLocalKeyProvider(map[string][]byte{"k1": testKey(1)}, "k1")
+	assert.NoError(err)
+	provider := &countingProvider{KeyProvider: local}
+	encryptor, err := NewEncryptor(provider, &Options{DataKeyTTL: time.Millisecond})
+	assert.NoError(err)
+
+	_, err = encryptor.Encrypt(ctx, []byte("a"), nil)
+	assert.NoError(err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = encryptor.Encrypt(ctx, []byte("b"), nil)
+	assert.NoError(err)
+	assert.Equal(2, provider.generated)
+}
+
+func TestLocalKeyRotation(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	before, err := NewLocalKeyProvider(map[string][]byte{"k1": testKey(1)}, "k1")
+	assert.NoError(err)
+	encryptor, err := NewEncryptor(before, nil)
+	assert.NoError(err)
+	envelope, err := encryptor.Encrypt(ctx, []byte("old"), nil)
+	assert.NoError(err)
+
+	// The previous key is kept to decrypt existing items
+	after, err := NewLocalKeyProvider(map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2")
+	assert.NoError(err)
+	encryptor, err = NewEncryptor(after, nil)
+	assert.NoError(err)
+	plaintext, err := encryptor.Decrypt(ctx, envelope, nil)
+	assert.NoError(err)
+	assert.Equal([]byte("old"), plaintext)
+
+	envelope, err = encryptor.Encrypt(ctx, []byte("new"), nil)
+	assert.NoError(err)
+	assert.Equal("k2", envelope.KeyID)
+
+	_, err = NewLocalKeyProvider(map[string][]byte{"k1": testKey(1)}, "k2")
+	assert.Error(err)
+	_, err = NewLocalKeyProvider(map[string][]byte{"k1": []byte("short")}, "k1")
+	assert.Error(err)
+}
+
+type mockKMS struct {
+	keys map[string][]byte
+}
+
+func (m *mockKMS) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	plaintext := testKey(9)
+	// The ciphertext blob identifies the key in KMS
+	m.keys["blob"] = plaintext
+	return &kms.GenerateDataKeyOutput{
+		KeyId:          aws.String("arn:aws:kms:eu-west-1:123456789012:key/" + aws.ToString(params.KeyId)),
+		Plaintext:      plaintext,
+		CiphertextBlob: []byte("blob"),
+	}, nil
+}
+
+func (m *mockKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	plaintext, ok := m.keys[string(params.CiphertextBlob)]
+	if !ok {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return &kms.DecryptOutput{KeyId: params.KeyId, Plaintext: plaintext}, nil
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	provider := NewKMSKeyProvider(&mockKMS{keys: map[string][]byte{}}, "tracing")
+	dataKey, err := provider.GenerateDataKey(ctx)
+	assert.NoError(err)
+	assert.Equal("arn:aws:kms:eu-west-1:123456789012:key/tracing", dataKey.KeyID)
+
+	plaintext, err := provider.DecryptDataKey(ctx, dataKey.KeyID, dataKey.Encrypted)
+	assert.NoError(err)
+	assert.Equal(dataKey.Plaintext, plaintext)
+
+	_, err = provider.DecryptDataKey(ctx, dataKey.KeyID, []byte("unknown"))
+	assert.Error(err)
+}
@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeyProvider generates data keys with an AWS KMS key. Automatic key rotation of KMS is transparent, when
+// the key ID is changed, items remain readable as long as the previous key is enabled.
+type KMSKeyProvider struct {
+	svc   KMSAPI
+	keyID string
+}
+
+// NewKMSKeyProvider accepts a key ID, key ARN or alias
+func NewKMSKeyProvider(svc KMSAPI, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{svc: svc, keyID: keyID}
+}
+
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) (*DataKey, error) {
+	output, err := p.svc.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key with %s, %v", p.keyID, err)
+	}
+
+	return &DataKey{
+		// The ARN of the key, which doesn't change when an alias is moved
+		KeyID:     aws.ToString(output.KeyId),
+		Plaintext: output.Plaintext,
+		Encrypted: output.CiphertextBlob,
+	}, nil
+}
+
+func (p *KMSKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	output, err := p.svc.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(keyID),
+		CiphertextBlob: encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key with %s, %v", keyID, err)
+	}
+	return output.Plaintext, nil
+}
@@ -0,0 +1,65 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+const dataKeySize = 32
+
+// LocalKeyProvider wraps data keys with master keys held in memory, e.g. for tests or deployments without KMS.
+// Master keys are rotated by adding a new active key, while previous keys are kept to decrypt existing items.
+type LocalKeyProvider struct {
+	keys        map[string][]byte
+	activeKeyID string
+}
+
+// NewLocalKeyProvider expects 256 bit master keys by ID
+func NewLocalKeyProvider(keys map[string][]byte, activeKeyID string) (*LocalKeyProvider, error) {
+	for keyID, key := range keys {
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key %s must be %d bytes long", keyID, dataKeySize)
+		}
+	}
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("active key %q doesn't exist", activeKeyID)
+	}
+
+	return &LocalKeyProvider{keys: keys, activeKeyID: activeKeyID}, nil
+}
+
+func (p *LocalKeyProvider) GenerateDataKey(ctx context.Context) (*DataKey, error) {
+	plaintext := make([]byte, dataKeySize)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, fmt.Errorf("failed to generate data key, %v", err)
+	}
+
+	aead, err := newAEAD(p.keys[p.activeKeyID])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce, %v", err)
+	}
+
+	return &DataKey{
+		KeyID:     p.activeKeyID,
+		Plaintext: plaintext,
+		Encrypted: aead.Seal(nonce, nonce, plaintext, []byte(p.activeKeyID)),
+	}, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, encrypted, []byte(keyID))
+}
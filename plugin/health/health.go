@@ -0,0 +1,277 @@
+// Package health periodically checks the tables used by the plugin and exposes the result as liveness and
+// readiness endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// DynamoDBAPI is the subset of the DynamoDB API used to check tables
+type DynamoDBAPI interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+}
+
+// Table describes the expected state of a table
+type Table struct {
+	Name string
+	// Requires an enabled TTL, a TTL on another attribute is reported as a warning. Not checked when empty.
+	TTLAttribute string
+	// Requires an enabled stream
+	Stream bool
+	// Global secondary indexes which have to be queryable
+	Indexes []string
+}
+
+type Options struct {
+	Tables []Table
+	// Interval between two checks
+	Interval time.Duration
+	// Timeout of a single check of all tables
+	Timeout time.Duration
+}
+
+type TableStatus struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type Status struct {
+	Ready     bool          `json:"ready"`
+	CheckedAt time.Time     `json:"checkedAt"`
+	Tables    []TableStatus `json:"tables"`
+}
+
+// Checker runs DescribeTable on all tables and keeps the result of the last check
+type Checker struct {
+	logger   hclog.Logger
+	svc      DynamoDBAPI
+	tables   []Table
+	interval time.Duration
+	timeout  time.Duration
+
+	mu         sync.RWMutex
+	status     *Status
+	lastErrors map[string]string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewChecker(logger hclog.Logger, svc DynamoDBAPI, options *Options) *Checker {
+	c := &Checker{
+		logger:     logger,
+		svc:        svc,
+		tables:     options.Tables,
+		interval:   options.Interval,
+		timeout:    options.Timeout,
+		lastErrors: map[string]string{},
+		done:       make(chan struct{}),
+	}
+	if c.interval <= 0 {
+		c.interval = defaultInterval
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	return c
+}
+
+// Start checks the tables in the background until the checker is closed
+func (c *Checker) Start() {
+	c.wg.Add(1)
+	go c.checkLoop()
+}
+
+func (c *Checker) checkLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(context.Background())
+
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks all tables once, logs problems which weren't logged by the previous check and returns the result
+func (c *Checker) Check(ctx context.Context) *Status {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	status := &Status{Ready: true, CheckedAt: time.Now()}
+	for _, table := range c.tables {
+		tableStatus := c.checkTable(ctx, table)
+		if len(tableStatus.Errors) > 0 {
+			status.Ready = false
+		}
+		status.Tables = append(status.Tables, tableStatus)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tableStatus := range status.Tables {
+		problems := strings.Join(append(append([]string{}, tableStatus.Errors...), tableStatus.Warnings...), "; ")
+		if problems == c.lastErrors[tableStatus.Name] {
+			continue
+		}
+
+		switch {
+		case len(tableStatus.Errors) > 0:
+			c.logger.Error("table is not ready", "table", tableStatus.Name, "errors", strings.Join(tableStatus.Errors, "; "))
+		case len(tableStatus.Warnings) > 0:
+			c.logger.Warn("table is misconfigured", "table", tableStatus.Name, "warnings", strings.Join(tableStatus.Warnings, "; "))
+		default:
+			c.logger.Info("table is ready again", "table", tableStatus.Name)
+		}
+		c.lastErrors[tableStatus.Name] = problems
+	}
+	c.status = status
+
+	return status
+}
+
+func (c *Checker) checkTable(ctx context.Context, table Table) TableStatus {
+	tableStatus := TableStatus{Name: table.Name}
+
+	output, err := c.svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table.Name)})
+	if err != nil {
+		tableStatus.Errors = append(tableStatus.Errors, describeError("dynamodb:DescribeTable", table.Name, err))
+		return tableStatus
+	}
+
+	description := output.Table
+	tableStatus.Status = string(description.TableStatus)
+	if description.TableStatus != types.TableStatusActive && description.TableStatus != types.TableStatusUpdating {
+		tableStatus.Errors = append(tableStatus.Errors, fmt.Sprintf("table has status %s", description.TableStatus))
+	}
+
+	if table.Stream && (description.StreamSpecification == nil || !aws.ToBool(description.StreamSpecification.StreamEnabled)) {
+		tableStatus.Errors = append(tableStatus.Errors, "stream is disabled")
+	}
+
+	for _, indexName := range table.Indexes {
+		if err := checkIndex(description.GlobalSecondaryIndexes, indexName); err != nil {
+			tableStatus.Errors = append(tableStatus.Errors, err.Error())
+		}
+	}
+
+	if table.TTLAttribute != "" {
+		ttlOutput, err := c.svc.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(table.Name)})
+		if err != nil {
+			tableStatus.Errors = append(tableStatus.Errors, describeError("dynamodb:DescribeTimeToLive", table.Name, err))
+			return tableStatus
+		}
+
+		ttl := ttlOutput.TimeToLiveDescription
+		switch {
+		case ttl == nil || ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabled && ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabling:
+			tableStatus.Errors = append(tableStatus.Errors, "ttl is disabled")
+		case aws.ToString(ttl.AttributeName) != table.TTLAttribute:
+			tableStatus.Warnings = append(tableStatus.Warnings, fmt.Sprintf("ttl uses attribute %s instead of %s, items won't expire", aws.ToString(ttl.AttributeName), table.TTLAttribute))
+		}
+	}
+
+	return tableStatus
+}
+
+func checkIndex(indexes []types.GlobalSecondaryIndexDescription, indexName string) error {
+	for _, index := range indexes {
+		if aws.ToString(index.IndexName) != indexName {
+			continue
+		}
+		if index.IndexStatus != types.IndexStatusActive && index.IndexStatus != types.IndexStatusUpdating {
+			return fmt.Errorf("index %s has status %s", indexName, index.IndexStatus)
+		}
+		return nil
+	}
+	return fmt.Errorf("index %s doesn't exist", indexName)
+}
+
+// describeError explains the common causes of failed checks
+func describeError(action string, tableName string, err error) string {
+	var rnfe *types.ResourceNotFoundException
+	if errors.As(err, &rnfe) {
+		return "table doesn't exist"
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "AccessDeniedException" || apiErr.ErrorCode() == "UnrecognizedClientException") {
+		return fmt.Sprintf("missing permission %s on table %s, %s", action, tableName, apiErr.ErrorMessage())
+	}
+
+	return fmt.Sprintf("failed to describe table, %v", err)
+}
+
+// Status returns the result of the last check, nil before the first check finished
+func (c *Checker) Status() *Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.status
+}
+
+// LivenessHandler always succeeds while the process is serving, table problems aren't fixed by a restart
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadinessHandler succeeds once the last check found all tables ready
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := c.Status()
+		if status == nil {
+			status = &Status{Tables: []TableStatus{}}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	})
+}
+
+// RegisterHandlers serves /healthz and /readyz
+func (c *Checker) RegisterHandlers(mux *http.ServeMux) {
+	mux.Handle("/healthz", c.LivenessHandler())
+	mux.Handle("/readyz", c.ReadinessHandler())
+}
+
+// Close stops the background checks
+func (c *Checker) Close() error {
+	close(c.done)
+	c.wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/assert"
+)
+
+type deniedDescribeTable struct {
+	DynamoDBAPI
+}
+
+func (d *deniedDescribeTable) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized to perform: dynamodb:DescribeTable"}
+}
+
+func TestChecker(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	svc := dynamodbfake.New(nil)
+
+	spans := Table{Name: "jaeger.spans", TTLAttribute: setup.SpansTimeToLiveAttribute, Indexes: []string{"SpanSearchIndex"}}
+	checker := NewChecker(hclog.NewNullLogger(), svc, &Options{Tables: []Table{spans}})
+
+	readiness := httptest.NewRecorder()
+	checker.ReadinessHandler().ServeHTTP(readiness, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(http.StatusServiceUnavailable, readiness.Code)
+
+	status := checker.Check(ctx)
+	assert.False(status.Ready)
+	assert.Equal([]string{"table doesn't exist"}, status.Tables[0].Errors)
+
+	assert.NoError(setup.RecreateSpanStoreTables(ctx, svc, &setup.SetupSpanOptions{
+		SpansTable:      "jaeger.spans",
+		ServicesTable:   "jaeger.services",
+		OperationsTable: "jaeger.operations",
+	}))
+
+	status = checker.Check(ctx)
+	assert.True(status.Ready)
+	assert.Equal("ACTIVE", status.Tables[0].Status)
+	assert.Empty(status.Tables[0].Errors)
+	assert.Empty(status.Tables[0].Warnings)
+
+	readiness = httptest.NewRecorder()
+	checker.ReadinessHandler().ServeHTTP(readiness, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(http.StatusOK, readiness.Code)
+	response := &Status{}
+	assert.NoError(json.Unmarshal(readiness.Body.Bytes(), response))
+	assert.True(response.Ready)
+
+	// The spans table is created without a stream and other tables have no search index
+	checker = NewChecker(hclog.NewNullLogger(), svc, &Options{Tables: []Table{
+		{Name: "jaeger.spans", Stream: true},
+		{Name: "jaeger.services", TTLAttribute: setup.TimeToLiveAttribute, Indexes: []string{"SpanSearchIndex"}},
+	}})
+	status = checker.Check(ctx)
+	assert.False(status.Ready)
+	assert.Equal([]string{"stream is disabled"}, status.Tables[0].Errors)
+	assert.Equal([]string{"index SpanSearchIndex doesn't exist"}, status.Tables[1].Errors)
+	assert.Equal([]string{"ttl uses attribute ExpiresAfter instead of ExpireTime, items won't expire"}, status.Tables[1].Warnings)
+
+	checker = NewChecker(hclog.NewNullLogger(), &deniedDescribeTable{DynamoDBAPI: svc}, &Options{Tables: []Table{spans}})
+	status = checker.Check(ctx)
+	assert.False(status.Ready)
+	assert.Equal([]string{"missing permission dynamodb:DescribeTable on table jaeger.spans, not authorized to perform: dynamodb:DescribeTable"}, status.Tables[0].Errors)
+
+	// Liveness doesn't depend on the tables
+	liveness := httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(liveness, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(http.StatusOK, liveness.Code)
+}
+
+func TestCheckerStart(t *testing.T) {
+	assert := assert.New(t)
+
+	checker := NewChecker(hclog.NewNullLogger(), dynamodbfake.New(nil), &Options{Tables: []Table{{Name: "jaeger.spans"}}})
+	checker.Start()
+	assert.NoError(checker.Close())
+
+	// The first check runs immediately
+	assert.NotNil(checker.Status())
+	assert.False(checker.Status().Ready)
+}
@@ -1,34 +1,198 @@
 package plugin
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamosamplingstore"
 	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamospanstore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/encryption"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/wal"
 
+	"github.com/jaegertracing/jaeger/pkg/distributedlock"
+	"github.com/jaegertracing/jaeger/storage"
 	"github.com/jaegertracing/jaeger/storage/dependencystore"
+	"github.com/jaegertracing/jaeger/storage/samplingstore"
 	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/uber/jaeger-lib/metrics"
 )
 
-func NewDynamoDBPlugin(logger hclog.Logger, svc *dynamodb.Client, spansTable, servicesTable, operationsTable, dependenciesTable string) (*DynamoDBPlugin, error) {
-	spanWriter, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable)
+// DynamoDBAPI is the subset of the DynamoDB API used by the span, dependency and sampling stores
+type DynamoDBAPI interface {
+	dynamospanstore.DynamoDBAPI
+	dynamospanstore.DynamoDBReaderAPI
+	dynamodependencystore.DynamoDBReaderAPI
+	dynamodependencystore.DynamoDBAPI
+	dynamosamplingstore.DynamoDBAPI
+}
+
+// ErrAdaptiveSamplingNotConfigured is returned by the sampling store factory without adaptive sampling options
+var ErrAdaptiveSamplingNotConfigured = errors.New("adaptive sampling storage was not configured")
+
+// The gRPC storage plugin can't serve sampling stores yet, but it can be used by an embedding collector
+var _ storage.SamplingStoreFactory = (*DynamoDBPlugin)(nil)
+
+type AdaptiveSamplingOptions struct {
+	SamplingTable string
+	LeasesTable   string
+	// Has to be unique per plugin instance, defaults to the hostname
+	LockOwner string
+	Store     dynamosamplingstore.Options
+}
+
+type Options struct {
+	Tenancy *tenancy.Manager
+	// Enables the streaming span writer when set
+	StreamingSpanWriter *dynamospanstore.StreamingWriterOptions
+	// Buffers failed span writes on disk when set
+	WriteAheadLog  *wal.Options
+	BufferedWriter *dynamospanstore.BufferedWriterOptions
+	// Adapts the write rate per table to throttling when set
+	RateLimiter *dynamospanstore.RateLimiterOptions
+	// Only stores sampled spans when set, archived spans are always stored
+	Sampler *dynamospanstore.SamplerOptions
+	// Removes sensitive data from all written spans when set
+	Scrubber *dynamospanstore.ScrubberOptions
+	// Encrypts the span bodies when set
+	Encryptor *encryption.Encryptor
+	// Tags which stay searchable in encrypted spans
+	SearchableTags []string
+	// Size of the buckets dependencies are aggregated in, defaults to an hour
+	DependenciesBucketSize time.Duration
+	// Aggregates the dependencies of written spans when set, instead of the dependency lambda
+	DependencyAggregator *dynamodependencystore.AggregatorOptions
+	// Provides the adaptive sampling store and lock when set
+	AdaptiveSampling *AdaptiveSamplingOptions
+	MetricsFactory   metrics.Factory
+}
+
+func NewDynamoDBPlugin(logger hclog.Logger, svc DynamoDBAPI, spansTable, servicesTable, operationsTable, dependenciesTable string, options *Options) (*DynamoDBPlugin, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	writerOptions := []dynamospanstore.WriterOption{
+		dynamospanstore.WithWriterTenancy(options.Tenancy),
+	}
+	if options.RateLimiter != nil {
+		rateLimiter, err := dynamospanstore.NewRateLimiter(options.MetricsFactory, options.RateLimiter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rate limiter, %v", err)
+		}
+		writerOptions = append(writerOptions, dynamospanstore.WithRateLimiter(rateLimiter))
+	}
+	if options.Scrubber != nil {
+		scrubber, err := dynamospanstore.NewScrubber(options.MetricsFactory, options.Scrubber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create scrubber, %v", err)
+		}
+		writerOptions = append(writerOptions, dynamospanstore.WithScrubber(scrubber))
+	}
+	if options.Encryptor != nil {
+		writerOptions = append(writerOptions, dynamospanstore.WithEncryption(options.Encryptor, options.SearchableTags))
+	}
+	readerOptions := []dynamospanstore.ReaderOption{
+		dynamospanstore.WithReaderTenancy(options.Tenancy),
+		dynamospanstore.WithReaderEncryption(options.Encryptor),
+	}
+	dependencyReaderOptions := []dynamodependencystore.ReaderOption{
+		dynamodependencystore.WithReaderTenancy(options.Tenancy),
+	}
+	if options.DependenciesBucketSize > 0 {
+		dependencyReaderOptions = append(dependencyReaderOptions, dynamodependencystore.WithBucketSize(options.DependenciesBucketSize))
+	}
+
+	spanWriterOptions := writerOptions
+	if options.Sampler != nil {
+		sampler, err := dynamospanstore.NewSampler(options.MetricsFactory, options.Sampler)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create sampler, %v", err)
+		}
+		spanWriterOptions = append(append([]dynamospanstore.WriterOption{}, writerOptions...), dynamospanstore.WithSampler(sampler))
+	}
+	// Archived spans were already counted when they were written
+	var aggregator *dynamodependencystore.Aggregator
+	if options.DependencyAggregator != nil {
+		aggregatorOptions := *options.DependencyAggregator
+		aggregatorOptions.Tenancy = options.Tenancy
+		aggregatorOptions.BucketSize = options.DependenciesBucketSize
+		var err error
+		aggregator, err = dynamodependencystore.NewAggregator(logger, svc, dependenciesTable, options.MetricsFactory, &aggregatorOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dependency aggregator, %v", err)
+		}
+		spanWriterOptions = append(append([]dynamospanstore.WriterOption{}, spanWriterOptions...), dynamospanstore.WithDependencyAggregator(aggregator))
+	}
+
+	var spanWriter spanstore.Writer
+	spanWriter, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable, spanWriterOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create span writer, %v", err)
 	}
 
-	archiveSpanWriter, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable)
+	var bufferedWriter *dynamospanstore.BufferedWriter
+	if options.WriteAheadLog != nil {
+		log, err := wal.Open(options.WriteAheadLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open write-ahead log, %v", err)
+		}
+
+		bufferedWriterOptions := &dynamospanstore.BufferedWriterOptions{}
+		if options.BufferedWriter != nil {
+			*bufferedWriterOptions = *options.BufferedWriter
+		}
+		bufferedWriterOptions.Tenancy = options.Tenancy
+
+		bufferedWriter, err = dynamospanstore.NewBufferedWriter(logger, spanWriter, log, options.MetricsFactory, bufferedWriterOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create buffered span writer, %v", err)
+		}
+		spanWriter = bufferedWriter
+	}
+
+	archiveSpanWriter, err := dynamospanstore.NewWriter(logger, svc, spansTable, servicesTable, operationsTable, writerOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create archive span writer, %v", err)
 	}
 
+	var streamingSpanWriter *dynamospanstore.StreamingWriter
+	if options.StreamingSpanWriter != nil {
+		streamingSpanWriter, err = dynamospanstore.NewStreamingWriter(logger, spanWriter, options.MetricsFactory, options.StreamingSpanWriter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create streaming span writer, %v", err)
+		}
+	}
+
+	var samplingStore *dynamosamplingstore.Store
+	var lock *dynamosamplingstore.Lock
+	if options.AdaptiveSampling != nil {
+		owner := options.AdaptiveSampling.LockOwner
+		if owner == "" {
+			if owner, err = os.Hostname(); err != nil {
+				return nil, fmt.Errorf("failed to get lock owner, %v", err)
+			}
+		}
+		samplingStore = dynamosamplingstore.NewStore(logger, svc, options.AdaptiveSampling.SamplingTable, &options.AdaptiveSampling.Store)
+		lock = dynamosamplingstore.NewLock(svc, options.AdaptiveSampling.LeasesTable, owner)
+	}
+
 	return &DynamoDBPlugin{
-		spanWriter:        spanWriter,
-		spanReader:        dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable),
-		archiveSpanWriter: archiveSpanWriter,
-		archiveSpanReader: dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable),
-		dependencyReader:  dynamodependencystore.NewReader(logger, svc, dependenciesTable),
+		spanWriter:          spanWriter,
+		streamingSpanWriter: streamingSpanWriter,
+		bufferedSpanWriter:  bufferedWriter,
+		aggregator:          aggregator,
+		spanReader:          dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable, readerOptions...),
+		archiveSpanWriter:   archiveSpanWriter,
+		archiveSpanReader:   dynamospanstore.NewReader(logger, svc, spansTable, servicesTable, operationsTable, readerOptions...),
+		dependencyReader:    dynamodependencystore.NewReader(logger, svc, dependenciesTable, dependencyReaderOptions...),
+		samplingStore:       samplingStore,
+		lock:                lock,
 
 		logger: logger,
 		svc:    svc,
@@ -36,20 +200,32 @@ func NewDynamoDBPlugin(logger hclog.Logger, svc *dynamodb.Client, spansTable, se
 }
 
 type DynamoDBPlugin struct {
-	spanWriter        *dynamospanstore.Writer
-	spanReader        *dynamospanstore.Reader
-	archiveSpanWriter *dynamospanstore.Writer
-	archiveSpanReader *dynamospanstore.Reader
-	dependencyReader  *dynamodependencystore.Reader
+	spanWriter          spanstore.Writer
+	streamingSpanWriter *dynamospanstore.StreamingWriter
+	bufferedSpanWriter  *dynamospanstore.BufferedWriter
+	aggregator          *dynamodependencystore.Aggregator
+	spanReader          *dynamospanstore.Reader
+	archiveSpanWriter   *dynamospanstore.Writer
+	archiveSpanReader   *dynamospanstore.Reader
+	dependencyReader    *dynamodependencystore.Reader
+	samplingStore       *dynamosamplingstore.Store
+	lock                *dynamosamplingstore.Lock
 
 	logger hclog.Logger
-	svc    *dynamodb.Client
+	svc    DynamoDBAPI
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 func (h *DynamoDBPlugin) SpanWriter() spanstore.Writer {
 	return h.spanWriter
 }
 
+func (h *DynamoDBPlugin) StreamingSpanWriter() spanstore.Writer {
+	return h.streamingSpanWriter
+}
+
 func (h *DynamoDBPlugin) SpanReader() spanstore.Reader {
 	return h.spanReader
 }
@@ -65,3 +241,43 @@ func (h *DynamoDBPlugin) ArchiveSpanReader() spanstore.Reader {
 func (h *DynamoDBPlugin) DependencyReader() dependencystore.Reader {
 	return h.dependencyReader
 }
+
+func (h *DynamoDBPlugin) CreateLock() (distributedlock.Lock, error) {
+	if h.lock == nil {
+		return nil, ErrAdaptiveSamplingNotConfigured
+	}
+	return h.lock, nil
+}
+
+// CreateSamplingStore ignores maxBuckets, as old throughput expires with the configured TTL
+func (h *DynamoDBPlugin) CreateSamplingStore(maxBuckets int) (samplingstore.Store, error) {
+	if h.samplingStore == nil {
+		return nil, ErrAdaptiveSamplingNotConfigured
+	}
+	return h.samplingStore, nil
+}
+
+// Close writes the queued spans, syncs the write-ahead log and writes the aggregated dependency calls, it can be
+// called repeatedly
+func (h *DynamoDBPlugin) Close() error {
+	h.closeOnce.Do(func() {
+		// Queued spans are written to the buffered writer, so it's closed afterwards
+		if h.streamingSpanWriter != nil {
+			if err := h.streamingSpanWriter.Close(); err != nil {
+				h.closeErr = fmt.Errorf("failed to close streaming span writer, %v", err)
+			}
+		}
+		if h.bufferedSpanWriter != nil {
+			if err := h.bufferedSpanWriter.Close(); err != nil && h.closeErr == nil {
+				h.closeErr = fmt.Errorf("failed to close buffered span writer, %v", err)
+			}
+		}
+		// The span writers count the calls of the spans they write, so the aggregator is closed last
+		if h.aggregator != nil {
+			if err := h.aggregator.Close(); err != nil && h.closeErr == nil {
+				h.closeErr = fmt.Errorf("failed to close dependency aggregator, %v", err)
+			}
+		}
+	})
+	return h.closeErr
+}
@@ -0,0 +1,331 @@
+// Package storagetest contains conformance tests for the span and dependency stores, which can run against
+// the in-memory fake as well as DynamoDB.
+package storagetest
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/dependencystore"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Stores struct {
+	SpanWriter       spanstore.Writer
+	SpanReader       spanstore.Reader
+	DependencyReader dependencystore.Reader
+	// WriteDependencies stores dependency links calculated at the given time, like the dependency lambda
+	WriteDependencies func(ctx context.Context, ts time.Time, links []model.DependencyLink) error
+	// Used for all requests, e.g. to select a tenant
+	Context context.Context
+}
+
+// Run runs all conformance tests, newStores is called for every test and must return stores without any data
+func Run(t *testing.T, newStores func(t *testing.T) *Stores) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, stores *Stores)
+	}{
+		{"GetTrace", testGetTrace},
+		{"GetTraceNotFound", testGetTraceNotFound},
+		{"GetServices", testGetServices},
+		{"GetOperations", testGetOperations},
+		{"FindTracesTimeRange", testFindTracesTimeRange},
+		{"FindTracesTags", testFindTracesTags},
+		{"FindTracesOperationAndDuration", testFindTracesOperationAndDuration},
+		{"FindTracesLimit", testFindTracesLimit},
+		{"GetDependencies", testGetDependencies},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			stores := newStores(t)
+			if stores.Context == nil {
+				stores.Context = context.Background()
+			}
+			test.test(t, stores)
+		})
+	}
+}
+
+var baseTime = time.Date(2022, 3, 14, 12, 30, 15, 123456789, time.UTC)
+
+func newSpan(traceID model.TraceID, spanID model.SpanID, serviceName, operationName string, startTime time.Time) *model.Span {
+	return &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: operationName,
+		StartTime:     startTime,
+		Duration:      100 * time.Millisecond,
+		Process:       &model.Process{ServiceName: serviceName},
+	}
+}
+
+func writeSpans(t *testing.T, stores *Stores, spans ...*model.Span) {
+	for _, span := range spans {
+		require.NoError(t, stores.SpanWriter.WriteSpan(stores.Context, span))
+	}
+}
+
+// normalizeTrace makes traces comparable, the order of spans within a trace isn't guaranteed
+func normalizeTrace(trace *model.Trace) *model.Trace {
+	spans := make([]*model.Span, len(trace.Spans))
+	for i, span := range trace.Spans {
+		normalized := *span
+		normalized.StartTime = span.StartTime.UTC()
+		normalized.Logs = make([]model.Log, len(span.Logs))
+		for j, log := range span.Logs {
+			normalized.Logs[j] = model.Log{Timestamp: log.Timestamp.UTC(), Fields: log.Fields}
+		}
+		spans[i] = &normalized
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].SpanID < spans[j].SpanID
+	})
+	return &model.Trace{Spans: spans}
+}
+
+func traceIDs(traces []*model.Trace) []model.TraceID {
+	ids := []model.TraceID{}
+	for _, trace := range traces {
+		ids = append(ids, trace.Spans[0].TraceID)
+	}
+	return ids
+}
+
+func testGetTrace(t *testing.T, stores *Stores) {
+	assert := assert.New(t)
+
+	traceID := model.NewTraceID(0x1122334455667788, 0x99aabbccddeeff00)
+	root := newSpan(traceID, model.NewSpanID(1), "frontend", "GET /checkout", baseTime)
+	root.Flags = model.Flags(1)
+	root.Tags = []model.KeyValue{
+		model.String("span.kind", "server"),
+		model.String("http.url", "https://example.com/checkout?id=1"),
+		model.Bool("sampled", true),
+		model.Bool("cached", false),
+		model.Int64("http.status_code", 200),
+		model.Int64("negative", -9223372036854775807),
+		model.Float64("ratio", 0.1234567890123),
+		model.Binary("payload", []byte{0, 1, 2, 255}),
+	}
+	root.Process.Tags = []model.KeyValue{
+		model.String("hostname", "frontend-1"),
+		model.Int64("pid", 42),
+	}
+	root.ProcessID = "p1"
+	root.Warnings = []string{"clock skew adjustment disabled"}
+	// Logs keep the order they were written in, even if their timestamps aren't ordered
+	root.Logs = []model.Log{
+		{Timestamp: baseTime.Add(20 * time.Millisecond), Fields: []model.KeyValue{model.String("event", "second")}},
+		{Timestamp: baseTime.Add(10 * time.Millisecond), Fields: []model.KeyValue{model.String("event", "first"), model.Int64("size", 3)}},
+		{Timestamp: baseTime.Add(30 * time.Millisecond), Fields: []model.KeyValue{model.Bool("error", true)}},
+	}
+	root.References = []model.SpanRef{
+		model.NewFollowsFromRef(model.NewTraceID(0, 7), model.NewSpanID(8)),
+	}
+
+	child := newSpan(traceID, model.NewSpanID(2), "checkout", "charge", baseTime.Add(5*time.Millisecond))
+	child.References = []model.SpanRef{model.NewChildOfRef(traceID, root.SpanID)}
+	child.Tags = []model.KeyValue{model.String("span.kind", "client")}
+	child.Logs = []model.Log{{Timestamp: baseTime.Add(6 * time.Millisecond), Fields: []model.KeyValue{model.String("event", "charged")}}}
+	child.Process.Tags = []model.KeyValue{}
+	child.Warnings = []string{}
+
+	writeSpans(t, stores, child, root)
+
+	trace, err := stores.SpanReader.GetTrace(stores.Context, traceID)
+	require.NoError(t, err)
+	assert.Equal(normalizeTrace(&model.Trace{Spans: []*model.Span{root, child}}), normalizeTrace(trace))
+}
+
+func testGetTraceNotFound(t *testing.T, stores *Stores) {
+	_, err := stores.SpanReader.GetTrace(stores.Context, model.NewTraceID(0, 404))
+	assert.ErrorIs(t, err, spanstore.ErrTraceNotFound)
+}
+
+func testGetServices(t *testing.T, stores *Stores) {
+	writeSpans(t, stores,
+		newSpan(model.NewTraceID(0, 1), model.NewSpanID(1), "frontend", "GET /", baseTime),
+		newSpan(model.NewTraceID(0, 1), model.NewSpanID(2), "checkout", "charge", baseTime),
+		newSpan(model.NewTraceID(0, 2), model.NewSpanID(3), "frontend", "GET /cart", baseTime),
+	)
+
+	services, err := stores.SpanReader.GetServices(stores.Context)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"frontend", "checkout"}, services)
+}
+
+func testGetOperations(t *testing.T, stores *Stores) {
+	server := newSpan(model.NewTraceID(0, 1), model.NewSpanID(1), "frontend", "GET /", baseTime)
+	server.Tags = []model.KeyValue{model.String("span.kind", "server")}
+	client := newSpan(model.NewTraceID(0, 1), model.NewSpanID(2), "frontend", "GET /api", baseTime)
+	client.Tags = []model.KeyValue{model.String("span.kind", "client")}
+	other := newSpan(model.NewTraceID(0, 1), model.NewSpanID(3), "checkout", "charge", baseTime)
+	writeSpans(t, stores, server, client, other)
+
+	operations, err := stores.SpanReader.GetOperations(stores.Context, spanstore.OperationQueryParameters{ServiceName: "frontend"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []spanstore.Operation{
+		{Name: "GET /", SpanKind: "server"},
+		{Name: "GET /api", SpanKind: "client"},
+	}, operations)
+
+	operations, err = stores.SpanReader.GetOperations(stores.Context, spanstore.OperationQueryParameters{ServiceName: "frontend", SpanKind: "server"})
+	require.NoError(t, err)
+	assert.Equal(t, []spanstore.Operation{{Name: "GET /", SpanKind: "server"}}, operations)
+
+	operations, err = stores.SpanReader.GetOperations(stores.Context, spanstore.OperationQueryParameters{ServiceName: "unknown"})
+	require.NoError(t, err)
+	assert.Empty(t, operations)
+}
+
+func testFindTracesTimeRange(t *testing.T, stores *Stores) {
+	assert := assert.New(t)
+
+	for i := 0; i < 3; i++ {
+		writeSpans(t, stores, newSpan(model.NewTraceID(0, uint64(i+1)), model.NewSpanID(1), "frontend", "GET /", baseTime.Add(time.Duration(i)*time.Minute)))
+	}
+
+	find := func(min, max time.Time) []model.TraceID {
+		traces, err := stores.SpanReader.FindTraces(stores.Context, &spanstore.TraceQueryParameters{
+			ServiceName:  "frontend",
+			StartTimeMin: min,
+			StartTimeMax: max,
+			NumTraces:    20,
+		})
+		require.NoError(t, err)
+		return traceIDs(traces)
+	}
+
+	// Both boundaries are inclusive
+	assert.ElementsMatch([]model.TraceID{model.NewTraceID(0, 2), model.NewTraceID(0, 3)}, find(baseTime.Add(time.Minute), baseTime.Add(2*time.Minute)))
+	assert.Empty(find(baseTime.Add(time.Minute+time.Nanosecond), baseTime.Add(2*time.Minute-time.Nanosecond)))
+	assert.Len(find(baseTime.Add(-time.Hour), baseTime.Add(time.Hour)), 3)
+
+	traces, err := stores.SpanReader.FindTraces(stores.Context, &spanstore.TraceQueryParameters{
+		ServiceName:  "checkout",
+		StartTimeMin: baseTime.Add(-time.Hour),
+		StartTimeMax: baseTime.Add(time.Hour),
+		NumTraces:    20,
+	})
+	require.NoError(t, err)
+	assert.Empty(traces)
+}
+
+func testFindTracesTags(t *testing.T, stores *Stores) {
+	assert := assert.New(t)
+
+	spanTag := newSpan(model.NewTraceID(0, 1), model.NewSpanID(1), "frontend", "GET /", baseTime)
+	spanTag.Tags = []model.KeyValue{model.Int64("http.status_code", 500), model.Bool("error", true)}
+	processTag := newSpan(model.NewTraceID(0, 2), model.NewSpanID(2), "frontend", "GET /", baseTime)
+	processTag.Process.Tags = []model.KeyValue{model.String("hostname", "frontend-2")}
+	logField := newSpan(model.NewTraceID(0, 3), model.NewSpanID(3), "frontend", "GET /", baseTime)
+	logField.Logs = []model.Log{{Timestamp: baseTime, Fields: []model.KeyValue{model.String("event", "retry")}}}
+	writeSpans(t, stores, spanTag, processTag, logField)
+
+	find := func(tags map[string]string) []model.TraceID {
+		traces, err := stores.SpanReader.FindTraces(stores.Context, &spanstore.TraceQueryParameters{
+			ServiceName:  "frontend",
+			Tags:         tags,
+			StartTimeMin: baseTime.Add(-time.Hour),
+			StartTimeMax: baseTime.Add(time.Hour),
+			NumTraces:    20,
+		})
+		require.NoError(t, err)
+		return traceIDs(traces)
+	}
+
+	assert.Equal([]model.TraceID{model.NewTraceID(0, 1)}, find(map[string]string{"error": "true"}))
+	assert.Equal([]model.TraceID{model.NewTraceID(0, 1)}, find(map[string]string{"error": "true", "http.status_code": "500"}))
+	assert.Empty(find(map[string]string{"error": "true", "http.status_code": "200"}))
+	assert.Equal([]model.TraceID{model.NewTraceID(0, 2)}, find(map[string]string{"hostname": "frontend-2"}))
+	assert.Equal([]model.TraceID{model.NewTraceID(0, 3)}, find(map[string]string{"event": "retry"}))
+}
+
+func testFindTracesOperationAndDuration(t *testing.T, stores *Stores) {
+	assert := assert.New(t)
+
+	fast := newSpan(model.NewTraceID(0, 1), model.NewSpanID(1), "frontend", "GET /", baseTime)
+	fast.Duration = 10 * time.Millisecond
+	slow := newSpan(model.NewTraceID(0, 2), model.NewSpanID(2), "frontend", "GET /", baseTime)
+	slow.Duration = 2 * time.Second
+	other := newSpan(model.NewTraceID(0, 3), model.NewSpanID(3), "frontend", "POST /", baseTime)
+	other.Duration = 2 * time.Second
+	writeSpans(t, stores, fast, slow, other)
+
+	find := func(query *spanstore.TraceQueryParameters) []model.TraceID {
+		query.ServiceName = "frontend"
+		query.StartTimeMin = baseTime.Add(-time.Hour)
+		query.StartTimeMax = baseTime.Add(time.Hour)
+		query.NumTraces = 20
+
+		traces, err := stores.SpanReader.FindTraces(stores.Context, query)
+		require.NoError(t, err)
+		return traceIDs(traces)
+	}
+
+	assert.ElementsMatch([]model.TraceID{model.NewTraceID(0, 1), model.NewTraceID(0, 2)}, find(&spanstore.TraceQueryParameters{OperationName: "GET /"}))
+	assert.ElementsMatch([]model.TraceID{model.NewTraceID(0, 2), model.NewTraceID(0, 3)}, find(&spanstore.TraceQueryParameters{DurationMin: time.Second}))
+	assert.Equal([]model.TraceID{model.NewTraceID(0, 1)}, find(&spanstore.TraceQueryParameters{DurationMax: 10 * time.Millisecond}))
+	assert.Equal([]model.TraceID{model.NewTraceID(0, 2)}, find(&spanstore.TraceQueryParameters{OperationName: "GET /", DurationMin: time.Second, DurationMax: 2 * time.Second}))
+}
+
+func testFindTracesLimit(t *testing.T, stores *Stores) {
+	assert := assert.New(t)
+
+	for i := 0; i < 5; i++ {
+		traceID := model.NewTraceID(0, uint64(i+1))
+		root := newSpan(traceID, model.NewSpanID(1), "frontend", "GET /", baseTime.Add(time.Duration(i)*time.Second))
+		child := newSpan(traceID, model.NewSpanID(2), "checkout", "charge", baseTime.Add(time.Duration(i)*time.Second))
+		child.References = []model.SpanRef{model.NewChildOfRef(traceID, root.SpanID)}
+		writeSpans(t, stores, root, child)
+	}
+
+	traces, err := stores.SpanReader.FindTraces(stores.Context, &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: baseTime.Add(-time.Hour),
+		StartTimeMax: baseTime.Add(time.Hour),
+		NumTraces:    3,
+	})
+	require.NoError(t, err)
+	assert.Len(traces, 3)
+
+	// Traces are returned as a whole, including spans of other services
+	for _, trace := range traces {
+		assert.Len(trace.Spans, 2)
+	}
+}
+
+func testGetDependencies(t *testing.T, stores *Stores) {
+	if stores.WriteDependencies == nil {
+		t.Skip("writing dependencies isn't supported")
+	}
+
+	endTs := time.Date(2022, 3, 14, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, stores.WriteDependencies(stores.Context, endTs.Add(-20*time.Minute), []model.DependencyLink{
+		{Parent: "frontend", Child: "checkout", CallCount: 2},
+	}))
+	require.NoError(t, stores.WriteDependencies(stores.Context, endTs.Add(-10*time.Minute), []model.DependencyLink{
+		{Parent: "frontend", Child: "checkout", CallCount: 3},
+		{Parent: "checkout", Child: "payment", CallCount: 1},
+	}))
+	// Outside of the lookback
+	require.NoError(t, stores.WriteDependencies(stores.Context, endTs.Add(-4*time.Hour), []model.DependencyLink{
+		{Parent: "frontend", Child: "checkout", CallCount: 5},
+		{Parent: "frontend", Child: "legacy", CallCount: 1},
+	}))
+
+	dependencies, err := stores.DependencyReader.GetDependencies(stores.Context, endTs, time.Hour)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []model.DependencyLink{
+		{Parent: "frontend", Child: "checkout", CallCount: 5},
+		{Parent: "checkout", Child: "payment", CallCount: 1},
+	}, dependencies)
+}
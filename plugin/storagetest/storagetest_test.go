@@ -0,0 +1,127 @@
+package storagetest_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodbfake"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/storagetest"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/tenancy"
+	"github.com/johanneswuerbach/jaeger-dynamodb/setup"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	spansTable        = "jaeger.spans"
+	servicesTable     = "jaeger.services"
+	operationsTable   = "jaeger.operations"
+	dependenciesTable = "jaeger.dependencies"
+)
+
+type testDynamoDBAPI interface {
+	plugin.DynamoDBAPI
+	dynamodependencystore.DynamoDBAPI
+	setup.DynamoDBAPI
+}
+
+// createDynamoDBSvc uses DynamoDB at DYNAMODB_URL when set and the in-memory fake otherwise
+func createDynamoDBSvc(t *testing.T, ctx context.Context) testDynamoDBAPI {
+	var svc testDynamoDBAPI = dynamodbfake.New(nil)
+	if dynamodbURL := os.Getenv("DYNAMODB_URL"); dynamodbURL != "" {
+		cfg, err := config.LoadDefaultConfig(ctx, func(lo *config.LoadOptions) error {
+			lo.Credentials = credentials.NewStaticCredentialsProvider("TEST_ONLY", "TEST_ONLY", "TEST_ONLY")
+			lo.Region = "us-east-1"
+			lo.EndpointResolver = aws.EndpointResolverFunc(
+				func(service, region string) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: dynamodbURL, Source: aws.EndpointSourceCustom}, nil
+				})
+			return nil
+		})
+		require.NoError(t, err)
+
+		svc = dynamodb.NewFromConfig(cfg)
+	}
+
+	require.NoError(t, setup.PollUntilReady(ctx, svc))
+	require.NoError(t, setup.RecreateSpanStoreTables(ctx, svc, &setup.SetupSpanOptions{
+		SpansTable:      spansTable,
+		ServicesTable:   servicesTable,
+		OperationsTable: operationsTable,
+	}))
+	require.NoError(t, setup.RecreateDependencyStoreTables(ctx, svc, &setup.SetupDependencyOptions{
+		DependenciesTable: dependenciesTable,
+	}))
+
+	return svc
+}
+
+func newStores(tenancyOptions *tenancy.Options, tenant string) func(t *testing.T) *storagetest.Stores {
+	return func(t *testing.T) *storagetest.Stores {
+		ctx := context.Background()
+		if tenant != "" {
+			ctx = tenancy.WithTenant(ctx, tenant)
+		}
+
+		manager, err := tenancy.NewManager(tenancyOptions)
+		require.NoError(t, err)
+
+		logger := hclog.New(&hclog.LoggerOptions{Level: hclog.Warn, Name: "jaeger-dynamodb"})
+		svc := createDynamoDBSvc(t, ctx)
+
+		// Data of another tenant must not be visible
+		if tenant != "" {
+			otherPlugin, err := plugin.NewDynamoDBPlugin(logger, svc, spansTable, servicesTable, operationsTable, dependenciesTable, &plugin.Options{Tenancy: manager})
+			require.NoError(t, err)
+			otherSpan := &model.Span{
+				TraceID:       model.NewTraceID(0, 1),
+				SpanID:        model.NewSpanID(1),
+				OperationName: "GET /",
+				StartTime:     time.Date(2022, 3, 14, 12, 30, 15, 0, time.UTC),
+				Process:       &model.Process{ServiceName: "frontend"},
+			}
+			require.NoError(t, otherPlugin.SpanWriter().WriteSpan(tenancy.WithTenant(ctx, "other"), otherSpan))
+		}
+
+		dynamodbPlugin, err := plugin.NewDynamoDBPlugin(logger, svc, spansTable, servicesTable, operationsTable, dependenciesTable, &plugin.Options{Tenancy: manager})
+		require.NoError(t, err)
+
+		return &storagetest.Stores{
+			SpanWriter:       dynamodbPlugin.SpanWriter(),
+			SpanReader:       dynamodbPlugin.SpanReader(),
+			DependencyReader: dynamodbPlugin.DependencyReader(),
+			WriteDependencies: func(ctx context.Context, ts time.Time, links []model.DependencyLink) error {
+				for _, link := range links {
+					if err := dynamodependencystore.WriteDependencyItem(ctx, svc, dependenciesTable, &dynamodependencystore.DependencyItem{
+						Key:            dynamodependencystore.DependencyKey(manager.KeyPrefix(tenant), link.Parent, link.Child),
+						Parent:         link.Parent,
+						Child:          link.Child,
+						CallCount:      link.CallCount,
+						CallTimeBucket: dynamodependencystore.TimeToBucket(ts),
+					}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Context: ctx,
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, newStores(&tenancy.Options{}, ""))
+}
+
+func TestConformanceKeyTenancy(t *testing.T) {
+	storagetest.Run(t, newStores(&tenancy.Options{Enabled: true, Mode: tenancy.ModeKey}, "acme"))
+}
@@ -0,0 +1,193 @@
+package telemetry
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Sum of the capacity units consumed by the call
+	consumedCapacityUnitsKey = attribute.Key("aws.dynamodb.consumed_capacity_units")
+	// Number of items written or read by batch and single item calls
+	itemCountKey = attribute.Key("aws.dynamodb.item_count")
+	// Number of items DynamoDB didn't process in a batch call
+	unprocessedCountKey = attribute.Key("aws.dynamodb.unprocessed_count")
+	hasMorePagesKey     = attribute.Key("aws.dynamodb.has_more_pages")
+)
+
+// WithDynamoDBTracing starts a client span for every DynamoDB call, so each page of a Query or Scan is traced
+// separately. The consumed capacity is requested for all calls which support it.
+func WithDynamoDBTracing(tracerProvider trace.TracerProvider) func(*dynamodb.Options) {
+	tracer := tracerProvider.Tracer(instrumentationName)
+
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OpenTelemetryTracing", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				operation := awsmiddleware.GetOperationName(ctx)
+
+				var attributes []attribute.KeyValue
+				in.Parameters, attributes = requestAttributes(in.Parameters)
+				attributes = append(attributes, semconv.DBSystemDynamoDB, semconv.RPCSystemKey.String("aws-api"), semconv.RPCServiceKey.String("DynamoDB"), semconv.RPCMethodKey.String(operation))
+
+				ctx, span := tracer.Start(ctx, "DynamoDB."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+				defer span.End()
+
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+					return out, metadata, err
+				}
+
+				span.SetAttributes(responseAttributes(out.Result)...)
+				return out, metadata, nil
+			}), middleware.After)
+		})
+	}
+}
+
+// requestAttributes returns a copy of the input requesting the consumed capacity and describes the request
+func requestAttributes(params interface{}) (interface{}, []attribute.KeyValue) {
+	switch input := params.(type) {
+	case *dynamodb.QueryInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		attributes := []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)})}
+		if input.IndexName != nil {
+			attributes = append(attributes, semconv.AWSDynamoDBIndexNameKey.String(*input.IndexName))
+		}
+		if input.Limit != nil {
+			attributes = append(attributes, semconv.AWSDynamoDBLimitKey.Int64(int64(*input.Limit)))
+		}
+		if input.ScanIndexForward != nil {
+			attributes = append(attributes, semconv.AWSDynamoDBScanForwardKey.Bool(*input.ScanIndexForward))
+		}
+		return &copied, attributes
+	case *dynamodb.ScanInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		attributes := []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)})}
+		if input.IndexName != nil {
+			attributes = append(attributes, semconv.AWSDynamoDBIndexNameKey.String(*input.IndexName))
+		}
+		if input.Limit != nil {
+			attributes = append(attributes, semconv.AWSDynamoDBLimitKey.Int64(int64(*input.Limit)))
+		}
+		if input.TotalSegments != nil {
+			attributes = append(attributes, semconv.AWSDynamoDBTotalSegmentsKey.Int64(int64(*input.TotalSegments)), semconv.AWSDynamoDBSegmentKey.Int64(int64(aws.ToInt32(input.Segment))))
+		}
+		return &copied, attributes
+	case *dynamodb.PutItemInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		return &copied, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)}), itemCountKey.Int(1)}
+	case *dynamodb.GetItemInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		return &copied, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)})}
+	case *dynamodb.UpdateItemInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		return &copied, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)}), itemCountKey.Int(1)}
+	case *dynamodb.DeleteItemInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		return &copied, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)}), itemCountKey.Int(1)}
+	case *dynamodb.BatchWriteItemInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		tables := []string{}
+		items := 0
+		for table, requests := range input.RequestItems {
+			tables = append(tables, table)
+			items += len(requests)
+		}
+		sort.Strings(tables)
+		return &copied, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice(tables), itemCountKey.Int(items)}
+	case *dynamodb.BatchGetItemInput:
+		copied := *input
+		copied.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		tables := []string{}
+		for table := range input.RequestItems {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		return &copied, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice(tables)}
+	case *dynamodb.DescribeTableInput:
+		return params, []attribute.KeyValue{semconv.AWSDynamoDBTableNamesKey.StringSlice([]string{aws.ToString(input.TableName)})}
+	}
+	return params, nil
+}
+
+func responseAttributes(result interface{}) []attribute.KeyValue {
+	switch output := result.(type) {
+	case *dynamodb.QueryOutput:
+		return []attribute.KeyValue{
+			semconv.AWSDynamoDBCountKey.Int64(int64(output.Count)),
+			semconv.AWSDynamoDBScannedCountKey.Int64(int64(output.ScannedCount)),
+			hasMorePagesKey.Bool(output.LastEvaluatedKey != nil),
+			consumedCapacityUnitsKey.Float64(itemCapacityUnits(output.ConsumedCapacity)),
+		}
+	case *dynamodb.ScanOutput:
+		return []attribute.KeyValue{
+			semconv.AWSDynamoDBCountKey.Int64(int64(output.Count)),
+			semconv.AWSDynamoDBScannedCountKey.Int64(int64(output.ScannedCount)),
+			hasMorePagesKey.Bool(output.LastEvaluatedKey != nil),
+			consumedCapacityUnitsKey.Float64(itemCapacityUnits(output.ConsumedCapacity)),
+		}
+	case *dynamodb.PutItemOutput:
+		return []attribute.KeyValue{consumedCapacityUnitsKey.Float64(itemCapacityUnits(output.ConsumedCapacity))}
+	case *dynamodb.GetItemOutput:
+		count := 0
+		if output.Item != nil {
+			count = 1
+		}
+		return []attribute.KeyValue{itemCountKey.Int(count), consumedCapacityUnitsKey.Float64(itemCapacityUnits(output.ConsumedCapacity))}
+	case *dynamodb.UpdateItemOutput:
+		return []attribute.KeyValue{consumedCapacityUnitsKey.Float64(itemCapacityUnits(output.ConsumedCapacity))}
+	case *dynamodb.DeleteItemOutput:
+		return []attribute.KeyValue{consumedCapacityUnitsKey.Float64(itemCapacityUnits(output.ConsumedCapacity))}
+	case *dynamodb.BatchWriteItemOutput:
+		unprocessed := 0
+		for _, requests := range output.UnprocessedItems {
+			unprocessed += len(requests)
+		}
+		return []attribute.KeyValue{unprocessedCountKey.Int(unprocessed), consumedCapacityUnitsKey.Float64(capacityUnits(output.ConsumedCapacity))}
+	case *dynamodb.BatchGetItemOutput:
+		items := 0
+		for _, responses := range output.Responses {
+			items += len(responses)
+		}
+		unprocessed := 0
+		for _, keys := range output.UnprocessedKeys {
+			unprocessed += len(keys.Keys)
+		}
+		return []attribute.KeyValue{itemCountKey.Int(items), unprocessedCountKey.Int(unprocessed), consumedCapacityUnitsKey.Float64(capacityUnits(output.ConsumedCapacity))}
+	}
+	return nil
+}
+
+func itemCapacityUnits(consumed *types.ConsumedCapacity) float64 {
+	if consumed == nil {
+		return 0
+	}
+	return aws.ToFloat64(consumed.CapacityUnits)
+}
+
+func capacityUnits(consumed []types.ConsumedCapacity) float64 {
+	units := 0.0
+	for _, capacity := range consumed {
+		units += aws.ToFloat64(capacity.CapacityUnits)
+	}
+	return units
+}
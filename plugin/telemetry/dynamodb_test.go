@@ -0,0 +1,120 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestWithDynamoDBTracing(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	requests := []map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := map[string]interface{}{}
+		assert.NoError(json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.Query":
+			if _, ok := request["ExclusiveStartKey"]; !ok {
+				_, _ = w.Write([]byte(`{"Count":2,"ScannedCount":3,"Items":[{},{}],"LastEvaluatedKey":{"TraceID":{"S":"1"}},"ConsumedCapacity":{"TableName":"jaeger.spans","CapacityUnits":1.5}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"Count":1,"ScannedCount":1,"Items":[{}],"ConsumedCapacity":{"TableName":"jaeger.spans","CapacityUnits":0.5}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	svc := dynamodb.New(dynamodb.Options{
+		Region:           "eu-west-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		EndpointResolver: dynamodb.EndpointResolverFromURL(server.URL),
+		Retryer:          aws.NopRetryer{},
+	}, WithDynamoDBTracing(tracerProvider))
+
+	ctx, parent := tracerProvider.Tracer("test").Start(ctx, "FindTraces")
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String("jaeger.spans"),
+		IndexName:              aws.String("SpanSearchIndex"),
+		KeyConditionExpression: aws.String("ServiceNameBucket = :bucket"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":bucket": &types.AttributeValueMemberS{Value: "frontend-0"},
+		},
+	}
+	paginator := dynamodb.NewQueryPaginator(svc, input)
+	for paginator.HasMorePages() {
+		_, err := paginator.NextPage(ctx)
+		assert.NoError(err)
+	}
+	_, err := svc.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String("jaeger.missing"), Item: map[string]types.AttributeValue{}})
+	assert.Error(err)
+	parent.End()
+
+	// The caller's input isn't modified
+	assert.Empty(input.ReturnConsumedCapacity)
+	assert.Equal("TOTAL", requests[0]["ReturnConsumedCapacity"])
+
+	spans := recorder.Ended()
+	assert.Len(spans, 4)
+	for i, page := range spans[:2] {
+		assert.Equal("DynamoDB.Query", page.Name())
+		assert.Equal(parent.SpanContext().SpanID(), page.Parent().SpanID())
+
+		attributes := spanAttributes(page)
+		assert.Equal([]string{"jaeger.spans"}, attributes["aws.dynamodb.table_names"].AsStringSlice())
+		assert.Equal("SpanSearchIndex", attributes["aws.dynamodb.index_name"].AsString())
+		assert.Equal([]int64{2, 1}[i], attributes["aws.dynamodb.count"].AsInt64())
+		assert.Equal([]int64{3, 1}[i], attributes["aws.dynamodb.scanned_count"].AsInt64())
+		assert.Equal([]float64{1.5, 0.5}[i], attributes["aws.dynamodb.consumed_capacity_units"].AsFloat64())
+		assert.Equal(i == 0, attributes["aws.dynamodb.has_more_pages"].AsBool())
+	}
+
+	put := spans[2]
+	assert.Equal("DynamoDB.PutItem", put.Name())
+	assert.Equal(codes.Error, put.Status().Code)
+	assert.Equal([]string{"jaeger.missing"}, spanAttributes(put)["aws.dynamodb.table_names"].AsStringSlice())
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	tracerProvider, err := NewTracerProvider(ctx, &Options{Exporter: ExporterStdout})
+	assert.NoError(err)
+	assert.NoError(tracerProvider.Shutdown(ctx))
+
+	tracerProvider, err = NewTracerProvider(ctx, &Options{Exporter: ExporterOTLP, Endpoint: "localhost:4317", Insecure: true})
+	assert.NoError(err)
+	assert.NoError(tracerProvider.Shutdown(ctx))
+
+	_, err = NewTracerProvider(ctx, &Options{Exporter: "zipkin"})
+	assert.Error(err)
+}
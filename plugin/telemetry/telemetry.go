@@ -0,0 +1,79 @@
+// Package telemetry traces the plugin itself with OpenTelemetry, including every DynamoDB call.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+
+	defaultServiceName  = "jaeger-dynamodb"
+	instrumentationName = "github.com/johanneswuerbach/jaeger-dynamodb"
+)
+
+type Options struct {
+	// Either "otlp" or "stdout"
+	Exporter string
+	// Address of the OTLP gRPC receiver, defaults to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317
+	Endpoint string
+	// Disables TLS of the OTLP exporter
+	Insecure bool
+	// Share of traces to record between 0 and 1, defaults to 1
+	SampleRatio float64
+	ServiceName string
+}
+
+// NewTracerProvider creates a provider exporting spans in batches, it has to be shut down to flush them
+func NewTracerProvider(ctx context.Context, options *Options) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	switch options.Exporter {
+	case ExporterOTLP:
+		exporterOptions := []otlptracegrpc.Option{}
+		if options.Endpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracegrpc.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+		}
+
+		var err error
+		exporter, err = otlptracegrpc.New(ctx, exporterOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create otlp exporter, %v", err)
+		}
+	case ExporterStdout:
+		// Stdout is used by the plugin handshake with Jaeger
+		var err error
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter, %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", options.Exporter)
+	}
+
+	sampleRatio := options.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+	serviceName := options.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	), nil
+}
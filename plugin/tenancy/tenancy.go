@@ -0,0 +1,182 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// ModeTable stores every tenant in its own set of tables, named "<tenant>.<table>"
+	ModeTable = "table"
+	// ModeKey stores all tenants in the shared tables and prefixes the partition keys with "<tenant>/"
+	ModeKey = "key"
+
+	DefaultHeader = "x-tenant"
+
+	tableSeparator = "."
+	keySeparator   = "/"
+)
+
+var (
+	ErrMissingTenant = errors.New("missing tenant")
+	ErrInvalidTenant = errors.New("invalid tenant")
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// Tables are only created and checked for configured tenants
+	ErrMissingTenants = errors.New("the table mode requires configured tenants")
+
+	// Tenants end up in table names and key prefixes, so the separators of both must be excluded
+	validTenant = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+type tenantContextKey struct{}
+
+// WithTenant attaches a tenant to the context, taking precedence over the gRPC metadata
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+type Options struct {
+	Enabled bool
+	Header  string
+	Mode    string
+	// Allow list, required in the table mode. All valid tenants are accepted in the key mode when empty.
+	Tenants []string
+}
+
+type Manager struct {
+	header  string
+	mode    string
+	tenants map[string]struct{}
+}
+
+// NewManager returns nil when tenancy is disabled, all methods of a nil manager behave as if tenancy is disabled
+func NewManager(options *Options) (*Manager, error) {
+	if options == nil || !options.Enabled {
+		return nil, nil
+	}
+
+	mode := options.Mode
+	if mode == "" {
+		mode = ModeTable
+	}
+	if mode != ModeTable && mode != ModeKey {
+		return nil, fmt.Errorf("unsupported tenancy mode %q", options.Mode)
+	}
+
+	header := options.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	tenants := map[string]struct{}{}
+	for _, tenant := range options.Tenants {
+		if !validTenant.MatchString(tenant) {
+			return nil, fmt.Errorf("%w %q", ErrInvalidTenant, tenant)
+		}
+		tenants[tenant] = struct{}{}
+	}
+	if mode == ModeTable && len(tenants) == 0 {
+		return nil, ErrMissingTenants
+	}
+
+	return &Manager{
+		header:  strings.ToLower(header),
+		mode:    mode,
+		tenants: tenants,
+	}, nil
+}
+
+func (m *Manager) Enabled() bool {
+	return m != nil
+}
+
+func (m *Manager) Mode() string {
+	if m == nil {
+		return ""
+	}
+	return m.mode
+}
+
+// Tenants returns the configured allow list
+func (m *Manager) Tenants() []string {
+	if m == nil {
+		return nil
+	}
+
+	tenants := []string{}
+	for tenant := range m.tenants {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// TenantFromContext returns the validated tenant of a request, or an empty tenant when tenancy is disabled
+func (m *Manager) TenantFromContext(ctx context.Context) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	if !ok {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(m.header); len(values) > 0 {
+				tenant = values[0]
+			}
+		}
+	}
+
+	if tenant == "" {
+		return "", ErrMissingTenant
+	}
+	if !validTenant.MatchString(tenant) {
+		return "", fmt.Errorf("%w %q", ErrInvalidTenant, tenant)
+	}
+	if len(m.tenants) > 0 {
+		if _, ok := m.tenants[tenant]; !ok {
+			return "", fmt.Errorf("%w %q", ErrUnknownTenant, tenant)
+		}
+	}
+
+	return tenant, nil
+}
+
+// Table returns the table of a tenant
+func (m *Manager) Table(tenant, table string) string {
+	if m == nil || m.mode != ModeTable || tenant == "" {
+		return table
+	}
+	return tenant + tableSeparator + table
+}
+
+// KeyPrefix returns the prefix of all partition keys of a tenant
+func (m *Manager) KeyPrefix(tenant string) string {
+	if m == nil || m.mode != ModeKey || tenant == "" {
+		return ""
+	}
+	return tenant + keySeparator
+}
+
+// SplitTable splits a table of a tenant into the table prefix and the table, tables without a valid tenant prefix
+// are returned as they are
+func SplitTable(table string) (string, string) {
+	parts := strings.SplitN(table, tableSeparator, 2)
+	if len(parts) != 2 || !validTenant.MatchString(parts[0]) {
+		return "", table
+	}
+	return parts[0] + tableSeparator, parts[1]
+}
+
+// SplitKey splits a tenant prefixed key into the key prefix and the key, it is only unambiguous for keys
+// which can't contain the separator themselves, like trace ids
+func SplitKey(key string) (string, string) {
+	parts := strings.SplitN(key, keySeparator, 2)
+	if len(parts) != 2 || !validTenant.MatchString(parts[0]) {
+		return "", key
+	}
+	return parts[0] + keySeparator, parts[1]
+}
@@ -0,0 +1,118 @@
+package tenancy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTenantFromContext(t *testing.T) {
+	assert := assert.New(t)
+
+	manager, err := NewManager(&Options{Enabled: true, Mode: ModeKey})
+	assert.NoError(err)
+
+	type test struct {
+		ctx    context.Context
+		tenant string
+		err    error
+	}
+
+	tests := []test{
+		{ctx: context.Background(), err: ErrMissingTenant},
+		{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant", "acme")), tenant: "acme"},
+		{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-Tenant", "acme")), tenant: "acme"},
+		{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-other", "acme")), err: ErrMissingTenant},
+		{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant", "acme/other")), err: ErrInvalidTenant},
+		{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant", "acme.other")), err: ErrInvalidTenant},
+		{ctx: WithTenant(metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant", "acme")), "other"), tenant: "other"},
+	}
+
+	for _, tc := range tests {
+		tenant, err := manager.TenantFromContext(tc.ctx)
+		if tc.err != nil {
+			assert.ErrorIs(err, tc.err)
+		} else {
+			assert.NoError(err)
+		}
+		assert.Equal(tc.tenant, tenant)
+	}
+}
+
+func TestTenantFromContextAllowList(t *testing.T) {
+	assert := assert.New(t)
+
+	manager, err := NewManager(&Options{Enabled: true, Header: "x-scope", Tenants: []string{"acme"}})
+	assert.NoError(err)
+
+	tenant, err := manager.TenantFromContext(metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-scope", "acme")))
+	assert.NoError(err)
+	assert.Equal("acme", tenant)
+
+	_, err = manager.TenantFromContext(metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-scope", "other")))
+	assert.ErrorIs(err, ErrUnknownTenant)
+
+	_, err = NewManager(&Options{Enabled: true, Tenants: []string{"acme/other"}})
+	assert.ErrorIs(err, ErrInvalidTenant)
+}
+
+func TestDisabledManager(t *testing.T) {
+	assert := assert.New(t)
+
+	manager, err := NewManager(&Options{Enabled: false})
+	assert.NoError(err)
+	assert.False(manager.Enabled())
+
+	tenant, err := manager.TenantFromContext(metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant", "acme")))
+	assert.NoError(err)
+	assert.Equal("", tenant)
+	assert.Equal("jaeger.spans", manager.Table("acme", "jaeger.spans"))
+	assert.Equal("", manager.KeyPrefix("acme"))
+}
+
+func TestScoping(t *testing.T) {
+	assert := assert.New(t)
+
+	tableManager, err := NewManager(&Options{Enabled: true, Mode: ModeTable, Tenants: []string{"acme"}})
+	assert.NoError(err)
+	assert.Equal("acme.jaeger.spans", tableManager.Table("acme", "jaeger.spans"))
+	assert.Equal("", tableManager.KeyPrefix("acme"))
+
+	keyManager, err := NewManager(&Options{Enabled: true, Mode: ModeKey})
+	assert.NoError(err)
+	assert.Equal("jaeger.spans", keyManager.Table("acme", "jaeger.spans"))
+	assert.Equal("acme/", keyManager.KeyPrefix("acme"))
+
+	_, err = NewManager(&Options{Enabled: true, Mode: "column"})
+	assert.Error(err)
+
+	// Tables couldn't be created or checked for unknown tenants
+	_, err = NewManager(&Options{Enabled: true, Mode: ModeTable})
+	assert.ErrorIs(err, ErrMissingTenants)
+}
+
+func TestSplitKey(t *testing.T) {
+	assert := assert.New(t)
+
+	keyPrefix, key := SplitKey("acme/2568637b984048f9")
+	assert.Equal("acme/", keyPrefix)
+	assert.Equal("2568637b984048f9", key)
+
+	keyPrefix, key = SplitKey("2568637b984048f9")
+	assert.Equal("", keyPrefix)
+	assert.Equal("2568637b984048f9", key)
+}
+
+func TestSplitTable(t *testing.T) {
+	assert := assert.New(t)
+
+	tablePrefix, table := SplitTable("acme.jaeger.spans")
+	assert.Equal("acme.", tablePrefix)
+	assert.Equal("jaeger.spans", table)
+
+	tablePrefix, table = SplitTable("jaeger-spans")
+	assert.Equal("", tablePrefix)
+	assert.Equal("jaeger-spans", table)
+}
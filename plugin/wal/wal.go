@@ -0,0 +1,353 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentSuffix = ".wal"
+	// Every record is prefixed with its length and crc32 checksum
+	headerSize = 8
+
+	defaultSegmentSize = 16 * 1024 * 1024
+	defaultMaxSize     = 1024 * 1024 * 1024
+)
+
+var (
+	ErrFull   = errors.New("write-ahead log is full")
+	ErrClosed = errors.New("write-ahead log is closed")
+)
+
+type Options struct {
+	Directory string
+	// Segments are rotated once they exceed this size in bytes
+	SegmentSize int64
+	// Appends are rejected once all segments together exceed this size in bytes
+	MaxSize int64
+	// Sync every append to disk instead of only on rotation
+	SyncWrites bool
+}
+
+type segment struct {
+	id       uint64
+	size     int64
+	records  int64
+	replayed int64
+}
+
+// WAL is an append only log of records, split into segment files which are removed once replayed
+type WAL struct {
+	options *Options
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	writer   *bufio.Writer
+	size     int64
+	records  int64
+	closed   bool
+
+	// Only a single replay can run at a time
+	replayMu sync.Mutex
+}
+
+func Open(options *Options) (*WAL, error) {
+	if options.Directory == "" {
+		return nil, fmt.Errorf("write-ahead log directory is required")
+	}
+	if options.SegmentSize == 0 {
+		options.SegmentSize = defaultSegmentSize
+	}
+	if options.MaxSize == 0 {
+		options.MaxSize = defaultMaxSize
+	}
+
+	if err := os.MkdirAll(options.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory, %v", err)
+	}
+
+	w := &WAL{options: options}
+	if err := w.recover(); err != nil {
+		return nil, fmt.Errorf("failed to recover segments, %v", err)
+	}
+
+	return w, nil
+}
+
+func (w *WAL) segmentPath(id uint64) string {
+	return filepath.Join(w.options.Directory, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+// recover loads all existing segments and truncates partially written records at their end
+func (w *WAL) recover() error {
+	entries, err := os.ReadDir(w.options.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to list directory, %v", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		records, size, err := scanSegment(w.segmentPath(id), nil)
+		if err != nil {
+			return fmt.Errorf("failed to scan segment %s, %v", name, err)
+		}
+		if err := os.Truncate(w.segmentPath(id), size); err != nil {
+			return fmt.Errorf("failed to truncate segment %s, %v", name, err)
+		}
+		if records == 0 {
+			if err := os.Remove(w.segmentPath(id)); err != nil {
+				return fmt.Errorf("failed to remove empty segment %s, %v", name, err)
+			}
+			continue
+		}
+
+		w.segments = append(w.segments, &segment{id: id, size: size, records: records})
+		w.size += size
+		w.records += records
+	}
+
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].id < w.segments[j].id
+	})
+
+	return nil
+}
+
+// scanSegment calls fn for every valid record and returns the number and total size of the valid records
+func scanSegment(path string, fn func(data []byte) error) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+	var (
+		records int64
+		size    int64
+	)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			// A partially written header is treated like the end of the segment
+			return records, size, nil
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return records, size, nil
+		}
+		if crc32.ChecksumIEEE(data) != checksum {
+			return records, size, nil
+		}
+
+		if fn != nil {
+			if err := fn(data); err != nil {
+				return records, size, err
+			}
+		}
+
+		records++
+		size += headerSize + int64(length)
+	}
+}
+
+func (w *WAL) activeSegment() *segment {
+	if w.active == nil {
+		return nil
+	}
+	return w.segments[len(w.segments)-1]
+}
+
+func (w *WAL) openSegment() error {
+	var id uint64
+	if len(w.segments) > 0 {
+		id = w.segments[len(w.segments)-1].id + 1
+	}
+
+	f, err := os.OpenFile(w.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create segment, %v", err)
+	}
+
+	w.active = f
+	w.writer = bufio.NewWriter(f)
+	w.segments = append(w.segments, &segment{id: id})
+	return nil
+}
+
+// closeSegment closes the active segment, after which it can be replayed
+func (w *WAL) closeSegment() error {
+	if w.active == nil {
+		return nil
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush segment, %v", err)
+	}
+	if err := w.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment, %v", err)
+	}
+	if err := w.active.Close(); err != nil {
+		return fmt.Errorf("failed to close segment, %v", err)
+	}
+
+	w.active = nil
+	w.writer = nil
+	return nil
+}
+
+func (w *WAL) Append(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	recordSize := headerSize + int64(len(data))
+	if w.size+recordSize > w.options.MaxSize {
+		return ErrFull
+	}
+
+	if active := w.activeSegment(); active != nil && active.size+recordSize > w.options.SegmentSize {
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+	}
+	if w.active == nil {
+		if err := w.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(data))
+	if _, err := w.writer.Write(header); err != nil {
+		return fmt.Errorf("failed to write record header, %v", err)
+	}
+	if _, err := w.writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write record, %v", err)
+	}
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush record, %v", err)
+	}
+	if w.options.SyncWrites {
+		if err := w.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync record, %v", err)
+		}
+	}
+
+	active := w.activeSegment()
+	active.size += recordSize
+	active.records++
+	w.size += recordSize
+	w.records++
+	return nil
+}
+
+// Replay calls fn for all records in the order they were appended. Fully replayed segments are removed,
+// replay stops at the first error and the failed record is replayed again by the next call. Records of a
+// partially replayed segment are replayed again after a restart.
+func (w *WAL) Replay(fn func(data []byte) error) error {
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	if err := w.closeSegment(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	segments := append([]*segment{}, w.segments...)
+	w.mu.Unlock()
+
+	for _, s := range segments {
+		// Skip records already replayed by a previous, failed replay
+		var position int64
+		_, _, err := scanSegment(w.segmentPath(s.id), func(data []byte) error {
+			position++
+			if position <= s.replayed {
+				return nil
+			}
+
+			if err := fn(data); err != nil {
+				return err
+			}
+
+			w.mu.Lock()
+			s.replayed++
+			w.records--
+			w.mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		w.mu.Lock()
+		if err := os.Remove(w.segmentPath(s.id)); err != nil {
+			w.mu.Unlock()
+			return fmt.Errorf("failed to remove replayed segment, %v", err)
+		}
+		w.size -= s.size
+		w.segments = w.segments[1:]
+		w.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Size returns the size of all records waiting to be replayed in bytes
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.size
+}
+
+// Len returns the number of records waiting to be replayed
+func (w *WAL) Len() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.records
+}
+
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.closeSegment()
+}
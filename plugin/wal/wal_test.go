@@ -0,0 +1,116 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func replayAll(assert *assert.Assertions, w *WAL) []string {
+	records := []string{}
+	assert.NoError(w.Replay(func(data []byte) error {
+		records = append(records, string(data))
+		return nil
+	}))
+	return records
+}
+
+func TestAppendReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	w, err := Open(&Options{Directory: t.TempDir(), SegmentSize: 32})
+	assert.NoError(err)
+
+	expected := []string{}
+	for i := 0; i < 10; i++ {
+		record := fmt.Sprintf("record-%d", i)
+		expected = append(expected, record)
+		assert.NoError(w.Append([]byte(record)))
+	}
+	assert.Equal(int64(10), w.Len())
+	assert.Equal(int64(10*(headerSize+8)), w.Size())
+
+	assert.Equal(expected, replayAll(assert, w))
+	assert.Equal(int64(0), w.Len())
+	assert.Equal(int64(0), w.Size())
+
+	// Appends after a replay start a new segment
+	assert.NoError(w.Append([]byte("record-10")))
+	assert.Equal([]string{"record-10"}, replayAll(assert, w))
+	assert.NoError(w.Close())
+}
+
+func TestReplayResumesAfterError(t *testing.T) {
+	assert := assert.New(t)
+
+	w, err := Open(&Options{Directory: t.TempDir()})
+	assert.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(w.Append([]byte(fmt.Sprintf("record-%d", i))))
+	}
+
+	errUnavailable := errors.New("unavailable")
+	replayed := []string{}
+	assert.ErrorIs(w.Replay(func(data []byte) error {
+		if string(data) == "record-3" {
+			return errUnavailable
+		}
+		replayed = append(replayed, string(data))
+		return nil
+	}), errUnavailable)
+	assert.Equal([]string{"record-0", "record-1", "record-2"}, replayed)
+	assert.Equal(int64(2), w.Len())
+
+	assert.Equal([]string{"record-3", "record-4"}, replayAll(assert, w))
+	assert.NoError(w.Close())
+}
+
+func TestRecover(t *testing.T) {
+	assert := assert.New(t)
+
+	directory := t.TempDir()
+	w, err := Open(&Options{Directory: directory, SegmentSize: 32})
+	assert.NoError(err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(w.Append([]byte(fmt.Sprintf("record-%d", i))))
+	}
+	assert.NoError(w.Close())
+	assert.ErrorIs(w.Append([]byte("record-3")), ErrClosed)
+
+	// Simulate a crash in the middle of writing a record
+	segments, err := filepath.Glob(filepath.Join(directory, "*"+segmentSuffix))
+	assert.NoError(err)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(err)
+	_, err = f.Write([]byte{0, 0, 0, 42, 1})
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	w, err = Open(&Options{Directory: directory, SegmentSize: 32})
+	assert.NoError(err)
+	assert.Equal(int64(3), w.Len())
+
+	assert.NoError(w.Append([]byte("record-3")))
+	assert.Equal([]string{"record-0", "record-1", "record-2", "record-3"}, replayAll(assert, w))
+	assert.NoError(w.Close())
+}
+
+func TestMaxSize(t *testing.T) {
+	assert := assert.New(t)
+
+	w, err := Open(&Options{Directory: t.TempDir(), MaxSize: 2 * (headerSize + 8)})
+	assert.NoError(err)
+
+	assert.NoError(w.Append([]byte("record-0")))
+	assert.NoError(w.Append([]byte("record-1")))
+	assert.ErrorIs(w.Append([]byte("record-2")), ErrFull)
+
+	assert.Equal([]string{"record-0", "record-1"}, replayAll(assert, w))
+	assert.NoError(w.Append([]byte("record-2")))
+	assert.NoError(w.Close())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/maintenance"
+	"github.com/ory/viper"
+	"github.com/spf13/pflag"
+)
+
+// runRebuildIndexes regenerates the services and operations tables from the spans table
+func runRebuildIndexes(ctx context.Context, logger hclog.Logger, args []string) error {
+	flags := pflag.NewFlagSet("rebuild-indexes", pflag.ContinueOnError)
+	configPath := flags.String("config", "", "A path to the dynamodb plugin's configuration file")
+	tenant := flags.String("tenant", "", "Tenant to rebuild the tables of in the table mode, defaults to all configured tenants")
+	segments := flags.Int("segments", 4, "Number of segments of the spans table scanned in parallel")
+	maxReadRate := flags.Float64("max-read-rate", 0, "Read capacity units consumed per second, unlimited when zero")
+	maxWriteRate := flags.Float64("max-write-rate", 100, "Service and operation writes per second, unlimited when zero")
+	progressInterval := flags.Duration("progress-interval", 10*time.Second, "Interval of progress reports")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	configuration, err := readConfiguration(viper.New(), *configPath)
+	if err != nil {
+		return err
+	}
+	svc, err := newDynamoDBClient(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		return err
+	}
+
+	tenants, err := selectTenants(tenancyManager, *tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		spansTableName := tenancyManager.Table(t, spansTable)
+		report, err := maintenance.RebuildIndexes(ctx, logger, svc, &maintenance.RebuildOptions{
+			SpansTable:      spansTableName,
+			ServicesTable:   tenancyManager.Table(t, servicesTable),
+			OperationsTable: tenancyManager.Table(t, operationsTable),
+			Tenancy:         tenancyManager,
+			Segments:        *segments,
+			MaxReadRate:     *maxReadRate,
+			MaxWriteRate:    *maxWriteRate,
+			Progress: func(report maintenance.RebuildReport) {
+				printRebuildReport(spansTableName, report)
+			},
+			ProgressInterval: *progressInterval,
+		})
+		if report != nil {
+			printRebuildReport(spansTableName, *report)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printRebuildReport(table string, report maintenance.RebuildReport) {
+	progress := ""
+	if report.TotalSpans > 0 {
+		progress = fmt.Sprintf(" (~%.0f%%)", float64(report.ScannedSpans)/float64(report.TotalSpans)*100)
+	}
+	fmt.Fprintf(os.Stderr, "%s: scanned %d of ~%d spans%s, %.0f read units, wrote %d services and %d operations\n",
+		table, report.ScannedSpans, report.TotalSpans, progress, report.ConsumedReadUnits, report.Services, report.Operations)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/johanneswuerbach/jaeger-dynamodb/maintenance"
+	"github.com/johanneswuerbach/jaeger-dynamodb/plugin/dynamodependencystore"
+	"github.com/ory/viper"
+	"github.com/spf13/pflag"
+)
+
+// runRecomputeDependencies replaces the dependencies of a time range with the calls between the stored spans
+func runRecomputeDependencies(ctx context.Context, logger hclog.Logger, args []string) error {
+	flags := pflag.NewFlagSet("recompute-dependencies", pflag.ContinueOnError)
+	configPath := flags.String("config", "", "A path to the dynamodb plugin's configuration file")
+	tenant := flags.String("tenant", "", "Tenant to recompute the dependencies of in the table mode, defaults to all configured tenants")
+	start := flags.String("start", "", "Start of the time range in RFC 3339 format")
+	end := flags.String("end", "", "End of the time range in RFC 3339 format, defaults to the start of the current bucket")
+	parentLookback := flags.Duration("parent-lookback", time.Hour, "Spans starting up to this long before the range are read as parents")
+	segments := flags.Int("segments", 4, "Number of segments of the spans table scanned in parallel")
+	maxReadRate := flags.Float64("max-read-rate", 0, "Read capacity units consumed per second, unlimited when zero")
+	maxWriteRate := flags.Float64("max-write-rate", 100, "Dependency writes and deletes per second, unlimited when zero")
+	dryRun := flags.Bool("dry-run", false, "Only report the dependencies which would be written and deleted")
+	progressInterval := flags.Duration("progress-interval", 10*time.Second, "Interval of progress reports")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *start == "" {
+		return errors.New("--start is required")
+	}
+	startTime, err := time.Parse(time.RFC3339, *start)
+	if err != nil {
+		return fmt.Errorf("invalid start, %v", err)
+	}
+
+	configuration, err := readConfiguration(viper.New(), *configPath)
+	if err != nil {
+		return err
+	}
+	svc, err := newDynamoDBClient(ctx, configuration)
+	if err != nil {
+		return err
+	}
+	tenancyManager, err := newTenancyManager(configuration)
+	if err != nil {
+		return err
+	}
+
+	// The current bucket is still being written by the lambda
+	bucketSize := configuration.Dependencies.BucketSize
+	if bucketSize <= 0 {
+		bucketSize = dynamodependencystore.DefaultBucketSize
+	}
+	endTime := time.Now().Truncate(bucketSize)
+	if *end != "" {
+		if endTime, err = time.Parse(time.RFC3339, *end); err != nil {
+			return fmt.Errorf("invalid end, %v", err)
+		}
+	}
+
+	tenants, err := selectTenants(tenancyManager, *tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		dependenciesTableName := tenancyManager.Table(t, dependenciesTable)
+		report, err := maintenance.RecomputeDependencies(ctx, logger, svc, &maintenance.RecomputeDependenciesOptions{
+			SpansTable:        tenancyManager.Table(t, spansTable),
+			DependenciesTable: dependenciesTableName,
+			Start:             startTime,
+			End:               endTime,
+			BucketSize:        bucketSize,
+			ParentLookback:    *parentLookback,
+			Segments:          *segments,
+			MaxReadRate:       *maxReadRate,
+			MaxWriteRate:      *maxWriteRate,
+			DryRun:            *dryRun,
+			Progress: func(report maintenance.RecomputeDependenciesReport) {
+				printRecomputeReport(dependenciesTableName, report, *dryRun)
+			},
+			ProgressInterval: *progressInterval,
+		})
+		if report != nil {
+			printRecomputeReport(dependenciesTableName, *report, *dryRun)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printRecomputeReport(table string, report maintenance.RecomputeDependenciesReport, dryRun bool) {
+	action := "wrote %d and deleted %d"
+	if dryRun {
+		action = "would write %d and delete %d"
+	}
+	fmt.Fprintf(os.Stderr, "%s: scanned %d of ~%d spans, %.0f read units, counted %d calls with %d unresolved parents, "+action+" dependencies\n",
+		table, report.ScannedSpans, report.TotalSpans, report.ConsumedReadUnits, report.Calls, report.UnresolvedParents, report.Written, report.Deleted)
+}
@@ -12,9 +12,23 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-const timeToLiveAttributeName = "ExpireTime"
+const (
+	// SpansTimeToLiveAttribute is the expiry of span, service and operation items
+	SpansTimeToLiveAttribute = "ExpiresAfter"
+	// TimeToLiveAttribute is the expiry of dependency checkpoints, sampling items and leases
+	TimeToLiveAttribute = "ExpireTime"
+)
+
+// DynamoDBAPI is the subset of the DynamoDB API used to manage tables
+type DynamoDBAPI interface {
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	ListTables(ctx context.Context, params *dynamodb.ListTablesInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ListTablesOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
 
-func recreateTable(ctx context.Context, svc *dynamodb.Client, input *dynamodb.CreateTableInput) error {
+func recreateTable(ctx context.Context, svc DynamoDBAPI, input *dynamodb.CreateTableInput, timeToLiveAttribute string) error {
 	_, err := svc.DeleteTable(ctx, &dynamodb.DeleteTableInput{
 		TableName: input.TableName,
 	})
@@ -43,7 +57,7 @@ func recreateTable(ctx context.Context, svc *dynamodb.Client, input *dynamodb.Cr
 	_, err = svc.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
 		TableName: input.TableName,
 		TimeToLiveSpecification: &types.TimeToLiveSpecification{
-			AttributeName: aws.String(timeToLiveAttributeName),
+			AttributeName: aws.String(timeToLiveAttribute),
 			Enabled:       aws.Bool(true),
 		},
 	})
@@ -54,7 +68,7 @@ func recreateTable(ctx context.Context, svc *dynamodb.Client, input *dynamodb.Cr
 	return nil
 }
 
-func ensureSpansTable(ctx context.Context, svc *dynamodb.Client, tableName string) error {
+func ensureSpansTable(ctx context.Context, svc DynamoDBAPI, tableName string) error {
 	var (
 		traceIDKey = "TraceID"
 		spanIDKey  = "SpanID"
@@ -91,10 +105,10 @@ func ensureSpansTable(ctx context.Context, svc *dynamodb.Client, tableName strin
 				},
 			},
 		},
-	})
+	}, SpansTimeToLiveAttribute)
 }
 
-func ensureServicesTable(ctx context.Context, svc *dynamodb.Client, tableName string) error {
+func ensureServicesTable(ctx context.Context, svc DynamoDBAPI, tableName string) error {
 	var (
 		serviceIDKey = "Name"
 	)
@@ -107,10 +121,10 @@ func ensureServicesTable(ctx context.Context, svc *dynamodb.Client, tableName st
 		KeySchema: []types.KeySchemaElement{
 			{AttributeName: &serviceIDKey, KeyType: types.KeyTypeHash},
 		},
-	})
+	}, SpansTimeToLiveAttribute)
 }
 
-func ensureOperationsTable(ctx context.Context, svc *dynamodb.Client, tableName string) error {
+func ensureOperationsTable(ctx context.Context, svc DynamoDBAPI, tableName string) error {
 	var (
 		operationIDKey    = "ServiceName"
 		operationRangeKey = "Name"
@@ -127,10 +141,10 @@ func ensureOperationsTable(ctx context.Context, svc *dynamodb.Client, tableName
 			{AttributeName: &operationIDKey, KeyType: types.KeyTypeHash},
 			{AttributeName: &operationRangeKey, KeyType: types.KeyTypeRange},
 		},
-	})
+	}, SpansTimeToLiveAttribute)
 }
 
-func ensureDependenciesTable(ctx context.Context, svc *dynamodb.Client, tableName string) error {
+func ensureDependenciesTable(ctx context.Context, svc DynamoDBAPI, tableName string) error {
 	var (
 		operationIDKey    = "Key"
 		operationRangeKey = "CallTimeBucket"
@@ -147,7 +161,35 @@ func ensureDependenciesTable(ctx context.Context, svc *dynamodb.Client, tableNam
 			{AttributeName: &operationIDKey, KeyType: types.KeyTypeHash},
 			{AttributeName: &operationRangeKey, KeyType: types.KeyTypeRange},
 		},
-	})
+	}, TimeToLiveAttribute)
+}
+
+func ensureSamplingTable(ctx context.Context, svc DynamoDBAPI, tableName string) error {
+	return recreateTable(ctx, svc, &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("Key"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("Timestamp"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		TableName:   &tableName,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("Key"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("Timestamp"), KeyType: types.KeyTypeRange},
+		},
+	}, TimeToLiveAttribute)
+}
+
+func ensureLeasesTable(ctx context.Context, svc DynamoDBAPI, tableName string) error {
+	return recreateTable(ctx, svc, &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("Name"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+		TableName:   &tableName,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("Name"), KeyType: types.KeyTypeHash},
+		},
+	}, TimeToLiveAttribute)
 }
 
 type SetupSpanOptions struct {
@@ -156,7 +198,7 @@ type SetupSpanOptions struct {
 	OperationsTable string
 }
 
-func PollUntilReady(ctx context.Context, svc *dynamodb.Client) error {
+func PollUntilReady(ctx context.Context, svc DynamoDBAPI) error {
 	var err error
 	for i := 0; i < 30; i++ {
 		_, err = svc.ListTables(ctx, &dynamodb.ListTablesInput{})
@@ -169,7 +211,7 @@ func PollUntilReady(ctx context.Context, svc *dynamodb.Client) error {
 	return err
 }
 
-func RecreateSpanStoreTables(ctx context.Context, svc *dynamodb.Client, options *SetupSpanOptions) error {
+func RecreateSpanStoreTables(ctx context.Context, svc DynamoDBAPI, options *SetupSpanOptions) error {
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		if err := ensureSpansTable(ctx, svc, options.SpansTable); err != nil {
@@ -201,7 +243,7 @@ type SetupDependencyOptions struct {
 	DependenciesTable string
 }
 
-func RecreateDependencyStoreTables(ctx context.Context, svc *dynamodb.Client, options *SetupDependencyOptions) error {
+func RecreateDependencyStoreTables(ctx context.Context, svc DynamoDBAPI, options *SetupDependencyOptions) error {
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		if err := ensureDependenciesTable(ctx, svc, options.DependenciesTable); err != nil {
@@ -216,3 +258,26 @@ func RecreateDependencyStoreTables(ctx context.Context, svc *dynamodb.Client, op
 
 	return nil
 }
+
+type SetupSamplingOptions struct {
+	SamplingTable string
+	LeasesTable   string
+}
+
+func RecreateSamplingStoreTables(ctx context.Context, svc DynamoDBAPI, options *SetupSamplingOptions) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		if err := ensureSamplingTable(ctx, svc, options.SamplingTable); err != nil {
+			return fmt.Errorf("failed to ensure sampling table, %v", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		if err := ensureLeasesTable(ctx, svc, options.LeasesTable); err != nil {
+			return fmt.Errorf("failed to ensure leases table, %v", err)
+		}
+		return nil
+	})
+
+	return g.Wait()
+}
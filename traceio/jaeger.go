@@ -0,0 +1,190 @@
+package traceio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/jaegertracing/jaeger/model"
+	jsonconv "github.com/jaegertracing/jaeger/model/converter/json"
+	uimodel "github.com/jaegertracing/jaeger/model/json"
+)
+
+type jaegerTraces struct {
+	Data []*uimodel.Trace `json:"data"`
+}
+
+// WriteJaegerJSON encodes traces like the Jaeger query API
+func WriteJaegerJSON(w io.Writer, traces []*model.Trace) error {
+	output := &jaegerTraces{Data: make([]*uimodel.Trace, 0, len(traces))}
+	for _, trace := range traces {
+		output.Data = append(output.Data, jsonconv.FromDomain(trace))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("failed to encode traces, %v", err)
+	}
+	return nil
+}
+
+// ReadJaegerJSON decodes responses of the Jaeger query API, lists of traces and single traces as downloaded from the Jaeger UI
+func ReadJaegerJSON(r io.Reader) ([]*model.Span, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read traces, %v", err)
+	}
+
+	var traces []*uimodel.Trace
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		err = unmarshalJSON(body, &traces)
+	} else {
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(body, &fields); err == nil {
+			if data, ok := fields["data"]; ok {
+				err = unmarshalJSON(data, &traces)
+			} else {
+				trace := &uimodel.Trace{}
+				err = unmarshalJSON(body, trace)
+				traces = []*uimodel.Trace{trace}
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode traces, %v", err)
+	}
+
+	spans := []*model.Span{}
+	for _, trace := range traces {
+		for i := range trace.Spans {
+			span, err := fromJaegerSpan(&trace.Spans[i], trace.Processes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert span %s, %v", trace.Spans[i].SpanID, err)
+			}
+			spans = append(spans, span)
+		}
+	}
+	return spans, nil
+}
+
+// unmarshalJSON keeps numbers as json.Number, so 64 bit integer tags don't lose precision
+func unmarshalJSON(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func fromJaegerSpan(s *uimodel.Span, processes map[uimodel.ProcessID]uimodel.Process) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(string(s.TraceID))
+	if err != nil {
+		return nil, err
+	}
+	spanID, err := model.SpanIDFromString(string(s.SpanID))
+	if err != nil {
+		return nil, err
+	}
+
+	process := s.Process
+	if process == nil {
+		p, ok := processes[s.ProcessID]
+		if !ok {
+			return nil, fmt.Errorf("unknown process %q", s.ProcessID)
+		}
+		process = &p
+	}
+	processTags, err := fromJaegerKeyValues(process.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.OperationName,
+		Flags:         model.Flags(s.Flags),
+		StartTime:     model.EpochMicrosecondsAsTime(s.StartTime),
+		Duration:      model.MicrosecondsAsDuration(s.Duration),
+		Process:       &model.Process{ServiceName: process.ServiceName, Tags: processTags},
+		Warnings:      s.Warnings,
+	}
+
+	for _, ref := range s.References {
+		refTraceID, err := model.TraceIDFromString(string(ref.TraceID))
+		if err != nil {
+			return nil, err
+		}
+		refSpanID, err := model.SpanIDFromString(string(ref.SpanID))
+		if err != nil {
+			return nil, err
+		}
+		refType := model.ChildOf
+		if ref.RefType == uimodel.FollowsFrom {
+			refType = model.FollowsFrom
+		}
+		span.References = append(span.References, model.SpanRef{TraceID: refTraceID, SpanID: refSpanID, RefType: refType})
+	}
+	if s.ParentSpanID != "" {
+		parentSpanID, err := model.SpanIDFromString(string(s.ParentSpanID))
+		if err != nil {
+			return nil, err
+		}
+		span.References = model.MaybeAddParentSpanID(traceID, parentSpanID, span.References)
+	}
+
+	if span.Tags, err = fromJaegerKeyValues(s.Tags); err != nil {
+		return nil, err
+	}
+	for _, log := range s.Logs {
+		fields, err := fromJaegerKeyValues(log.Fields)
+		if err != nil {
+			return nil, err
+		}
+		span.Logs = append(span.Logs, model.Log{Timestamp: model.EpochMicrosecondsAsTime(log.Timestamp), Fields: fields})
+	}
+
+	return span, nil
+}
+
+func fromJaegerKeyValues(kvs []uimodel.KeyValue) ([]model.KeyValue, error) {
+	out := make([]model.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		value := fmt.Sprint(kv.Value)
+		switch kv.Type {
+		case uimodel.BoolType:
+			b, ok := kv.Value.(bool)
+			if !ok {
+				var err error
+				if b, err = strconv.ParseBool(value); err != nil {
+					return nil, fmt.Errorf("invalid bool tag %s, %v", kv.Key, err)
+				}
+			}
+			out = append(out, model.Bool(kv.Key, b))
+		case uimodel.Int64Type:
+			i, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid int64 tag %s, %v", kv.Key, err)
+			}
+			out = append(out, model.Int64(kv.Key, i))
+		case uimodel.Float64Type:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid float64 tag %s, %v", kv.Key, err)
+			}
+			out = append(out, model.Float64(kv.Key, f))
+		case uimodel.BinaryType:
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid binary tag %s, %v", kv.Key, err)
+			}
+			out = append(out, model.Binary(kv.Key, b))
+		default:
+			out = append(out, model.String(kv.Key, value))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,283 @@
+package traceio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// Tags carrying OTLP span fields, which Jaeger stores as regular tags
+const (
+	tagSpanKind          = "span.kind"
+	tagError             = "error"
+	tagStatusCode        = "otel.status_code"
+	tagStatusDescription = "otel.status_description"
+	tagLibraryName       = "otel.library.name"
+	tagLibraryVersion    = "otel.library.version"
+	tagScopeName         = "otel.scope.name"
+	tagScopeVersion      = "otel.scope.version"
+	tagTraceState        = "w3c.tracestate"
+	tagServiceName       = "service.name"
+	fieldEvent           = "event"
+)
+
+// Span kinds and status codes as defined by the OTLP protobuf enums
+const (
+	otlpSpanKindUnspecified = 0
+	otlpSpanKindInternal    = 1
+	otlpSpanKindServer      = 2
+	otlpSpanKindClient      = 3
+	otlpSpanKindProducer    = 4
+	otlpSpanKindConsumer    = 5
+
+	otlpStatusCodeUnset = 0
+	otlpStatusCodeOk    = 1
+	otlpStatusCodeError = 2
+)
+
+var otlpSpanKinds = map[string]int{
+	"internal": otlpSpanKindInternal,
+	"server":   otlpSpanKindServer,
+	"client":   otlpSpanKindClient,
+	"producer": otlpSpanKindProducer,
+	"consumer": otlpSpanKindConsumer,
+}
+
+type otlpTraces struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+
+	process *model.Process
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name,omitempty"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue encodes 64 bit integers as strings and bytes as base64 like the OTLP/JSON specification requires
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BytesValue  []byte   `json:"bytesValue,omitempty"`
+}
+
+// WriteOTLPJSON encodes traces as a single OTLP/JSON request, grouping spans by process and instrumentation scope
+func WriteOTLPJSON(w io.Writer, traces []*model.Trace) error {
+	output := &otlpTraces{ResourceSpans: []*otlpResourceSpans{}}
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			scopeSpans := output.scopeSpans(span)
+			scopeSpans.Spans = append(scopeSpans.Spans, toOTLPSpan(span))
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("failed to encode traces, %v", err)
+	}
+	return nil
+}
+
+func (t *otlpTraces) scopeSpans(span *model.Span) *otlpScopeSpans {
+	process := span.Process
+	if process == nil {
+		process = &model.Process{}
+	}
+
+	var resourceSpans *otlpResourceSpans
+	for _, rs := range t.ResourceSpans {
+		if rs.process.Equal(process) {
+			resourceSpans = rs
+			break
+		}
+	}
+	if resourceSpans == nil {
+		attributes := []otlpKeyValue{{Key: tagServiceName, Value: stringValue(process.ServiceName)}}
+		resourceSpans = &otlpResourceSpans{
+			Resource: otlpResource{Attributes: append(attributes, toOTLPAttributes(process.Tags)...)},
+			process:  process,
+		}
+		t.ResourceSpans = append(t.ResourceSpans, resourceSpans)
+	}
+
+	scope := otlpScope{}
+	for _, tag := range span.Tags {
+		switch tag.Key {
+		case tagLibraryName, tagScopeName:
+			scope.Name = tag.AsString()
+		case tagLibraryVersion, tagScopeVersion:
+			scope.Version = tag.AsString()
+		}
+	}
+	for _, ss := range resourceSpans.ScopeSpans {
+		if ss.Scope == scope {
+			return ss
+		}
+	}
+	scopeSpans := &otlpScopeSpans{Scope: scope}
+	resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, scopeSpans)
+	return scopeSpans
+}
+
+func toOTLPSpan(span *model.Span) *otlpSpan {
+	output := &otlpSpan{
+		TraceID:           otlpTraceID(span.TraceID),
+		SpanID:            otlpSpanID(span.SpanID),
+		Name:              span.OperationName,
+		Kind:              otlpSpanKindUnspecified,
+		StartTimeUnixNano: otlpTime(span.StartTime.UnixNano()),
+		EndTimeUnixNano:   otlpTime(span.StartTime.Add(span.Duration).UnixNano()),
+	}
+
+	parentSpanID := span.ParentSpanID()
+	if parentSpanID != 0 {
+		output.ParentSpanID = otlpSpanID(parentSpanID)
+	}
+	for _, ref := range span.References {
+		if ref.TraceID == span.TraceID && ref.SpanID == parentSpanID && ref.RefType == model.ChildOf {
+			continue
+		}
+		output.Links = append(output.Links, otlpLink{TraceID: otlpTraceID(ref.TraceID), SpanID: otlpSpanID(ref.SpanID)})
+	}
+
+	tags := model.KeyValues{}
+	for _, tag := range span.Tags {
+		switch tag.Key {
+		case tagSpanKind:
+			output.Kind = otlpSpanKinds[tag.AsString()]
+		case tagStatusCode:
+			switch tag.AsString() {
+			case "OK":
+				output.Status.Code = otlpStatusCodeOk
+			case "ERROR":
+				output.Status.Code = otlpStatusCodeError
+			}
+		case tagStatusDescription:
+			output.Status.Message = tag.AsString()
+		case tagError:
+			if tag.AsString() == "true" {
+				output.Status.Code = otlpStatusCodeError
+			} else {
+				tags = append(tags, tag)
+			}
+		case tagTraceState:
+			output.TraceState = tag.AsString()
+		case tagLibraryName, tagLibraryVersion, tagScopeName, tagScopeVersion:
+		default:
+			tags = append(tags, tag)
+		}
+	}
+	output.Attributes = toOTLPAttributes(tags)
+
+	for _, log := range span.Logs {
+		event := otlpEvent{TimeUnixNano: otlpTime(log.Timestamp.UnixNano())}
+		fields := model.KeyValues{}
+		for _, field := range log.Fields {
+			if field.Key == fieldEvent && field.VType == model.StringType && event.Name == "" {
+				event.Name = field.VStr
+				continue
+			}
+			fields = append(fields, field)
+		}
+		event.Attributes = toOTLPAttributes(fields)
+		output.Events = append(output.Events, event)
+	}
+
+	return output
+}
+
+func toOTLPAttributes(tags model.KeyValues) []otlpKeyValue {
+	attributes := make([]otlpKeyValue, 0, len(tags))
+	for _, tag := range tags {
+		value := otlpAnyValue{}
+		switch tag.VType {
+		case model.BoolType:
+			b := tag.Bool()
+			value.BoolValue = &b
+		case model.Int64Type:
+			i := strconv.FormatInt(tag.Int64(), 10)
+			value.IntValue = &i
+		case model.Float64Type:
+			f := tag.Float64()
+			value.DoubleValue = &f
+		case model.BinaryType:
+			value.BytesValue = tag.Binary()
+		default:
+			value = stringValue(tag.VStr)
+		}
+		attributes = append(attributes, otlpKeyValue{Key: tag.Key, Value: value})
+	}
+	return attributes
+}
+
+func stringValue(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpTraceID(traceID model.TraceID) string {
+	return fmt.Sprintf("%016x%016x", traceID.High, traceID.Low)
+}
+
+func otlpSpanID(spanID model.SpanID) string {
+	return fmt.Sprintf("%016x", uint64(spanID))
+}
+
+func otlpTime(unixNano int64) string {
+	return strconv.FormatInt(unixNano, 10)
+}
@@ -0,0 +1,279 @@
+package traceio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// ReadOTLPProto decodes an ExportTraceServiceRequest in the binary protobuf encoding
+func ReadOTLPProto(r io.Reader) ([]*model.Span, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read traces, %v", err)
+	}
+
+	request := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		return nil, fmt.Errorf("failed to decode traces, %v", err)
+	}
+	return fromOTLP(request.ResourceSpans)
+}
+
+// ReadOTLPJSON decodes an ExportTraceServiceRequest in the OTLP/JSON encoding. Unlike the protobuf JSON mapping,
+// OTLP/JSON encodes trace and span IDs as hex, so they are converted to base64 before decoding with protojson.
+func ReadOTLPJSON(r io.Reader) ([]*model.Span, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var request map[string]interface{}
+	if err := decoder.Decode(&request); err != nil {
+		return nil, fmt.Errorf("failed to decode traces, %v", err)
+	}
+
+	for _, resourceSpans := range objects(request["resourceSpans"]) {
+		for _, key := range []string{"scopeSpans", "instrumentationLibrarySpans"} {
+			for _, scopeSpans := range objects(resourceSpans[key]) {
+				for _, span := range objects(scopeSpans["spans"]) {
+					if err := hexToBase64(span, "traceId", "spanId", "parentSpanId"); err != nil {
+						return nil, err
+					}
+					for _, link := range objects(span["links"]) {
+						if err := hexToBase64(link, "traceId", "spanId"); err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode traces, %v", err)
+	}
+	output := &coltracepb.ExportTraceServiceRequest{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, output); err != nil {
+		return nil, fmt.Errorf("failed to decode traces, %v", err)
+	}
+	return fromOTLP(output.ResourceSpans)
+}
+
+func objects(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	objects := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if object, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func hexToBase64(object map[string]interface{}, keys ...string) error {
+	for _, key := range keys {
+		s, ok := object[key].(string)
+		if !ok || s == "" {
+			continue
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q, %v", key, s, err)
+		}
+		object[key] = base64.StdEncoding.EncodeToString(b)
+	}
+	return nil
+}
+
+func fromOTLP(resourceSpans []*tracepb.ResourceSpans) ([]*model.Span, error) {
+	spans := []*model.Span{}
+	for _, rs := range resourceSpans {
+		process := &model.Process{}
+		for _, kv := range rs.GetResource().GetAttributes() {
+			if kv.Key == tagServiceName {
+				process.ServiceName = kv.GetValue().GetStringValue()
+				continue
+			}
+			process.Tags = append(process.Tags, fromOTLPAttribute(kv))
+		}
+
+		scopeTags := func(name, version string) []model.KeyValue {
+			tags := []model.KeyValue{}
+			if name != "" {
+				tags = append(tags, model.String(tagLibraryName, name))
+			}
+			if version != "" {
+				tags = append(tags, model.String(tagLibraryVersion, version))
+			}
+			return tags
+		}
+
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				s, err := fromOTLPSpan(span, process, scopeTags(ss.GetScope().GetName(), ss.GetScope().GetVersion()))
+				if err != nil {
+					return nil, err
+				}
+				spans = append(spans, s)
+			}
+		}
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, span := range ils.Spans {
+				s, err := fromOTLPSpan(span, process, scopeTags(ils.GetInstrumentationLibrary().GetName(), ils.GetInstrumentationLibrary().GetVersion()))
+				if err != nil {
+					return nil, err
+				}
+				spans = append(spans, s)
+			}
+		}
+	}
+	return spans, nil
+}
+
+func fromOTLPSpan(span *tracepb.Span, process *model.Process, scopeTags []model.KeyValue) (*model.Span, error) {
+	traceID, err := fromOTLPTraceID(span.TraceId)
+	if err != nil {
+		return nil, err
+	}
+	spanID, err := fromOTLPSpanID(span.SpanId)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: span.Name,
+		StartTime:     time.Unix(0, int64(span.StartTimeUnixNano)).UTC(),
+		Duration:      time.Duration(span.EndTimeUnixNano - span.StartTimeUnixNano),
+		Process:       process,
+	}
+
+	if len(span.ParentSpanId) > 0 {
+		parentSpanID, err := fromOTLPSpanID(span.ParentSpanId)
+		if err != nil {
+			return nil, err
+		}
+		output.References = append(output.References, model.NewChildOfRef(traceID, parentSpanID))
+	}
+	for _, link := range span.Links {
+		linkTraceID, err := fromOTLPTraceID(link.TraceId)
+		if err != nil {
+			return nil, err
+		}
+		linkSpanID, err := fromOTLPSpanID(link.SpanId)
+		if err != nil {
+			return nil, err
+		}
+		output.References = append(output.References, model.NewFollowsFromRef(linkTraceID, linkSpanID))
+	}
+
+	for kind, otlpKind := range otlpSpanKinds {
+		if int(span.Kind) == otlpKind {
+			output.Tags = append(output.Tags, model.String(tagSpanKind, kind))
+		}
+	}
+	output.Tags = append(output.Tags, scopeTags...)
+	for _, kv := range span.Attributes {
+		output.Tags = append(output.Tags, fromOTLPAttribute(kv))
+	}
+	switch span.GetStatus().GetCode() {
+	case tracepb.Status_STATUS_CODE_OK:
+		output.Tags = append(output.Tags, model.String(tagStatusCode, "OK"))
+	case tracepb.Status_STATUS_CODE_ERROR:
+		output.Tags = append(output.Tags, model.String(tagStatusCode, "ERROR"), model.Bool(tagError, true))
+	}
+	if message := span.GetStatus().GetMessage(); message != "" {
+		output.Tags = append(output.Tags, model.String(tagStatusDescription, message))
+	}
+	if span.TraceState != "" {
+		output.Tags = append(output.Tags, model.String(tagTraceState, span.TraceState))
+	}
+
+	for _, event := range span.Events {
+		log := model.Log{Timestamp: time.Unix(0, int64(event.TimeUnixNano)).UTC()}
+		if event.Name != "" {
+			log.Fields = append(log.Fields, model.String(fieldEvent, event.Name))
+		}
+		for _, kv := range event.Attributes {
+			log.Fields = append(log.Fields, fromOTLPAttribute(kv))
+		}
+		output.Logs = append(output.Logs, log)
+	}
+
+	return output, nil
+}
+
+func fromOTLPTraceID(b []byte) (model.TraceID, error) {
+	if len(b) != 16 {
+		return model.TraceID{}, fmt.Errorf("invalid trace ID %x", b)
+	}
+	return model.NewTraceID(binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])), nil
+}
+
+func fromOTLPSpanID(b []byte) (model.SpanID, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("invalid span ID %x", b)
+	}
+	return model.NewSpanID(binary.BigEndian.Uint64(b)), nil
+}
+
+// fromOTLPAttribute stores arrays and maps, which have no Jaeger equivalent, as JSON strings
+func fromOTLPAttribute(kv *commonpb.KeyValue) model.KeyValue {
+	switch v := kv.GetValue().GetValue().(type) {
+	case *commonpb.AnyValue_BoolValue:
+		return model.Bool(kv.Key, v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return model.Int64(kv.Key, v.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return model.Float64(kv.Key, v.DoubleValue)
+	case *commonpb.AnyValue_BytesValue:
+		return model.Binary(kv.Key, v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue:
+		var b bytes.Buffer
+		_ = json.NewEncoder(&b).Encode(fromOTLPValue(kv.GetValue()))
+		return model.String(kv.Key, string(bytes.TrimSpace(b.Bytes())))
+	default:
+		return model.String(kv.Key, kv.GetValue().GetStringValue())
+	}
+}
+
+func fromOTLPValue(value *commonpb.AnyValue) interface{} {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return v.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		values := []interface{}{}
+		for _, item := range v.ArrayValue.Values {
+			values = append(values, fromOTLPValue(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := map[string]interface{}{}
+		for _, item := range v.KvlistValue.Values {
+			values[item.Key] = fromOTLPValue(item.Value)
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package traceio
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// FormatJaeger is the JSON format of the Jaeger UI, which can be loaded using "JSON File" in the search view
+	FormatJaeger = "jaeger"
+	// FormatOTLP is the OTLP/JSON encoding of an ExportTraceServiceRequest
+	FormatOTLP = "otlp"
+	// FormatOTLPProto is the binary protobuf encoding of an ExportTraceServiceRequest, it can only be read
+	FormatOTLPProto = "otlp-proto"
+	// FormatZipkin is the Zipkin v2 JSON format, it can only be read
+	FormatZipkin = "zipkin"
+)
+
+// Write encodes traces in the given format
+func Write(w io.Writer, format string, traces []*model.Trace) error {
+	switch format {
+	case FormatJaeger:
+		return WriteJaegerJSON(w, traces)
+	case FormatOTLP:
+		return WriteOTLPJSON(w, traces)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Read decodes all spans in the given format
+func Read(r io.Reader, format string) ([]*model.Span, error) {
+	switch format {
+	case FormatJaeger:
+		return ReadJaegerJSON(r)
+	case FormatOTLP:
+		return ReadOTLPJSON(r)
+	case FormatOTLPProto:
+		return ReadOTLPProto(r)
+	case FormatZipkin:
+		return ReadZipkinJSON(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
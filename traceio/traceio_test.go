@@ -0,0 +1,258 @@
+package traceio
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func testTrace() *model.Trace {
+	traceID := model.NewTraceID(1, 2)
+	startTime := time.Date(2022, 3, 14, 12, 30, 15, 0, time.UTC)
+	process := &model.Process{ServiceName: "frontend", Tags: []model.KeyValue{model.String("hostname", "web-1")}}
+
+	return &model.Trace{
+		Spans: []*model.Span{
+			{
+				TraceID:       traceID,
+				SpanID:        model.NewSpanID(1),
+				OperationName: "GET /",
+				StartTime:     startTime,
+				Duration:      time.Second,
+				Tags: []model.KeyValue{
+					model.String("span.kind", "server"),
+					model.Int64("http.status_code", 500),
+					model.Bool("error", true),
+					model.String("otel.library.name", "net/http"),
+				},
+				Logs: []model.Log{
+					{Timestamp: startTime.Add(time.Millisecond), Fields: []model.KeyValue{model.String("event", "retry"), model.Float64("backoff", 0.5)}},
+				},
+				Process: process,
+			},
+			{
+				TraceID:       traceID,
+				SpanID:        model.NewSpanID(2),
+				OperationName: "checkout",
+				StartTime:     startTime.Add(time.Millisecond),
+				Duration:      time.Millisecond,
+				References: []model.SpanRef{
+					model.NewChildOfRef(traceID, model.NewSpanID(1)),
+					model.NewFollowsFromRef(model.NewTraceID(0, 3), model.NewSpanID(4)),
+				},
+				Tags:    []model.KeyValue{model.Binary("payload", []byte("ok"))},
+				Process: &model.Process{ServiceName: "frontend", Tags: []model.KeyValue{model.String("hostname", "web-1")}},
+			},
+		},
+	}
+}
+
+func TestWriteJaegerJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	var b bytes.Buffer
+	assert.NoError(Write(&b, FormatJaeger, []*model.Trace{testTrace()}))
+
+	var output map[string][]struct {
+		TraceID   string
+		Spans     []struct{ SpanID string }
+		Processes map[string]struct{ ServiceName string }
+	}
+	assert.NoError(json.Unmarshal(b.Bytes(), &output))
+	assert.Len(output["data"], 1)
+	assert.Equal("00000000000000010000000000000002", output["data"][0].TraceID)
+	assert.Len(output["data"][0].Spans, 2)
+	assert.Len(output["data"][0].Processes, 1)
+}
+
+func TestWriteOTLPJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	var b bytes.Buffer
+	assert.NoError(Write(&b, FormatOTLP, []*model.Trace{testTrace()}))
+
+	output := &otlpTraces{}
+	assert.NoError(json.Unmarshal(b.Bytes(), output))
+
+	// Spans of equal processes share a resource, spans are grouped by scope
+	assert.Len(output.ResourceSpans, 1)
+	assert.Equal([]otlpKeyValue{
+		{Key: "service.name", Value: stringValue("frontend")},
+		{Key: "hostname", Value: stringValue("web-1")},
+	}, output.ResourceSpans[0].Resource.Attributes)
+	assert.Len(output.ResourceSpans[0].ScopeSpans, 2)
+
+	server := output.ResourceSpans[0].ScopeSpans[0]
+	assert.Equal(otlpScope{Name: "net/http"}, server.Scope)
+	statusCode := "500"
+	backoff := 0.5
+	assert.Equal(&otlpSpan{
+		TraceID:           "00000000000000010000000000000002",
+		SpanID:            "0000000000000001",
+		Name:              "GET /",
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: "1647261015000000000",
+		EndTimeUnixNano:   "1647261016000000000",
+		Attributes:        []otlpKeyValue{{Key: "http.status_code", Value: otlpAnyValue{IntValue: &statusCode}}},
+		Events: []otlpEvent{{
+			TimeUnixNano: "1647261015001000000",
+			Name:         "retry",
+			Attributes:   []otlpKeyValue{{Key: "backoff", Value: otlpAnyValue{DoubleValue: &backoff}}},
+		}},
+		Status: otlpStatus{Code: otlpStatusCodeError},
+	}, server.Spans[0])
+
+	child := output.ResourceSpans[0].ScopeSpans[1].Spans[0]
+	assert.Equal("0000000000000001", child.ParentSpanID)
+	assert.Equal([]otlpLink{{TraceID: "00000000000000000000000000000003", SpanID: "0000000000000004"}}, child.Links)
+	assert.Equal([]otlpKeyValue{{Key: "payload", Value: otlpAnyValue{BytesValue: []byte("ok")}}}, child.Attributes)
+
+	assert.Error(Write(&b, "zipkin", nil))
+}
+
+func TestReadJaegerJSON(t *testing.T) {
+	assert := assert.New(t)
+	trace := testTrace()
+
+	var b bytes.Buffer
+	assert.NoError(WriteJaegerJSON(&b, []*model.Trace{trace}))
+
+	spans, err := Read(&b, FormatJaeger)
+	assert.NoError(err)
+	assert.Len(spans, 2)
+	for i, span := range spans {
+		span.ProcessID = ""
+		assert.Equal(trace.Spans[i], span)
+	}
+
+	// Single traces as downloaded from the Jaeger UI
+	spans, err = ReadJaegerJSON(bytes.NewBufferString(`{"traceID":"2","spans":[{"traceID":"2","spanID":"1","startTime":1,"processID":"p1"}],"processes":{"p1":{"serviceName":"frontend"}}}`))
+	assert.NoError(err)
+	assert.Len(spans, 1)
+	assert.Equal("frontend", spans[0].Process.ServiceName)
+}
+
+func TestReadOTLPJSON(t *testing.T) {
+	assert := assert.New(t)
+	trace := testTrace()
+
+	var b bytes.Buffer
+	assert.NoError(WriteOTLPJSON(&b, []*model.Trace{trace}))
+
+	spans, err := Read(&b, FormatOTLP)
+	assert.NoError(err)
+	assert.Len(spans, 2)
+
+	server := spans[0]
+	assert.Equal(trace.Spans[0].TraceID, server.TraceID)
+	assert.Equal(trace.Spans[0].StartTime, server.StartTime)
+	assert.Equal(trace.Spans[0].Duration, server.Duration)
+	assert.Equal(trace.Spans[0].Process, server.Process)
+	assert.Equal(model.KeyValues{
+		model.String("span.kind", "server"),
+		model.String("otel.library.name", "net/http"),
+		model.Int64("http.status_code", 500),
+		model.String("otel.status_code", "ERROR"),
+		model.Bool("error", true),
+	}, model.KeyValues(server.Tags))
+	assert.Equal(trace.Spans[0].Logs, server.Logs)
+
+	// Links become follows from references
+	assert.Equal(trace.Spans[1].References, spans[1].References)
+	assert.Equal(trace.Spans[1].Tags, spans[1].Tags)
+}
+
+func TestReadOTLPProto(t *testing.T) {
+	assert := assert.New(t)
+
+	request := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "checkout"}}},
+			}},
+			ScopeSpans: []*tracepb.ScopeSpans{{
+				Spans: []*tracepb.Span{{
+					TraceId:           []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2},
+					SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, 3},
+					Name:              "pay",
+					Kind:              tracepb.Span_SPAN_KIND_CLIENT,
+					StartTimeUnixNano: 1647261015000000000,
+					EndTimeUnixNano:   1647261016000000000,
+					Attributes: []*commonpb.KeyValue{
+						{Key: "items", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: []*commonpb.AnyValue{
+							{Value: &commonpb.AnyValue_IntValue{IntValue: 1}},
+							{Value: &commonpb.AnyValue_StringValue{StringValue: "b"}},
+						}}}}},
+					},
+				}},
+			}},
+		}},
+	}
+	body, err := proto.Marshal(request)
+	assert.NoError(err)
+
+	spans, err := Read(bytes.NewReader(body), FormatOTLPProto)
+	assert.NoError(err)
+	assert.Equal([]*model.Span{{
+		TraceID:       model.NewTraceID(1, 2),
+		SpanID:        model.NewSpanID(3),
+		OperationName: "pay",
+		StartTime:     time.Date(2022, 3, 14, 12, 30, 15, 0, time.UTC),
+		Duration:      time.Second,
+		Tags:          []model.KeyValue{model.String("span.kind", "client"), model.String("items", `[1,"b"]`)},
+		Process:       &model.Process{ServiceName: "checkout"},
+	}}, spans)
+}
+
+func TestReadZipkinJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	spans, err := Read(bytes.NewBufferString(`[{
+		"traceId": "00000000000000010000000000000002",
+		"id": "0000000000000003",
+		"parentId": "0000000000000001",
+		"name": "get /cart",
+		"kind": "CLIENT",
+		"timestamp": 1647261015000000,
+		"duration": 1500,
+		"localEndpoint": {"serviceName": "frontend", "ipv4": "10.0.0.1"},
+		"remoteEndpoint": {"serviceName": "cart", "port": 8080},
+		"annotations": [{"timestamp": 1647261015000100, "value": "ws"}],
+		"tags": {"http.path": "/cart", "error": "timeout"}
+	}]`), FormatZipkin)
+	assert.NoError(err)
+	assert.Equal([]*model.Span{{
+		TraceID:       model.NewTraceID(1, 2),
+		SpanID:        model.NewSpanID(3),
+		OperationName: "get /cart",
+		References:    []model.SpanRef{model.NewChildOfRef(model.NewTraceID(1, 2), model.NewSpanID(1))},
+		Flags:         model.SampledFlag,
+		StartTime:     time.Date(2022, 3, 14, 12, 30, 15, 0, time.UTC),
+		Duration:      1500 * time.Microsecond,
+		Tags: []model.KeyValue{
+			model.String("span.kind", "client"),
+			model.String("peer.service", "cart"),
+			model.Int64("peer.port", 8080),
+			model.Bool("error", true),
+			model.String("error.message", "timeout"),
+			model.String("http.path", "/cart"),
+		},
+		Logs: []model.Log{{
+			Timestamp: time.Date(2022, 3, 14, 12, 30, 15, 100000, time.UTC),
+			Fields:    []model.KeyValue{model.String("event", "ws")},
+		}},
+		Process: &model.Process{ServiceName: "frontend", Tags: []model.KeyValue{model.String("ip", "10.0.0.1")}},
+	}}, spans)
+
+	_, err = Read(bytes.NewBufferString(`{}`), "unknown")
+	assert.Error(err)
+}
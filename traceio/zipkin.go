@@ -0,0 +1,142 @@
+package traceio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int64  `json:"port"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`
+	Timestamp      int64              `json:"timestamp"`
+	Duration       int64              `json:"duration"`
+	Debug          bool               `json:"debug"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint"`
+	Annotations    []zipkinAnnotation `json:"annotations"`
+	Tags           map[string]string  `json:"tags"`
+}
+
+// ReadZipkinJSON decodes a list of spans in the Zipkin v2 JSON format, mapping endpoints and annotations like the Jaeger collector
+func ReadZipkinJSON(r io.Reader) ([]*model.Span, error) {
+	var input []*zipkinSpan
+	if err := json.NewDecoder(r).Decode(&input); err != nil {
+		return nil, fmt.Errorf("failed to decode spans, %v", err)
+	}
+
+	spans := make([]*model.Span, 0, len(input))
+	for _, s := range input {
+		span, err := fromZipkinSpan(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert span %s, %v", s.ID, err)
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
+
+func fromZipkinSpan(s *zipkinSpan) (*model.Span, error) {
+	traceID, err := model.TraceIDFromString(s.TraceID)
+	if err != nil {
+		return nil, err
+	}
+	spanID, err := model.SpanIDFromString(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &model.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: s.Name,
+		StartTime:     time.UnixMicro(s.Timestamp).UTC(),
+		Duration:      time.Duration(s.Duration) * time.Microsecond,
+		Flags:         model.SampledFlag,
+		Process:       &model.Process{},
+	}
+	if s.Debug {
+		span.Flags |= model.DebugFlag
+	}
+	if s.ParentID != "" {
+		parentSpanID, err := model.SpanIDFromString(s.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		span.References = append(span.References, model.NewChildOfRef(traceID, parentSpanID))
+	}
+
+	if e := s.LocalEndpoint; e != nil {
+		span.Process.ServiceName = e.ServiceName
+		if e.IPv4 != "" {
+			span.Process.Tags = append(span.Process.Tags, model.String("ip", e.IPv4))
+		} else if e.IPv6 != "" {
+			span.Process.Tags = append(span.Process.Tags, model.String("ip", e.IPv6))
+		}
+	}
+
+	if s.Kind != "" {
+		span.Tags = append(span.Tags, model.String(tagSpanKind, strings.ToLower(s.Kind)))
+	}
+	if e := s.RemoteEndpoint; e != nil {
+		if e.ServiceName != "" {
+			span.Tags = append(span.Tags, model.String("peer.service", e.ServiceName))
+		}
+		if e.IPv4 != "" {
+			span.Tags = append(span.Tags, model.String("peer.ipv4", e.IPv4))
+		}
+		if e.IPv6 != "" {
+			span.Tags = append(span.Tags, model.String("peer.ipv6", e.IPv6))
+		}
+		if e.Port != 0 {
+			span.Tags = append(span.Tags, model.Int64("peer.port", e.Port))
+		}
+	}
+
+	keys := make([]string, 0, len(s.Tags))
+	for key := range s.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := s.Tags[key]
+		// Zipkin stores the error message in the error tag, Jaeger marks failed spans with error=true
+		if key == tagError {
+			span.Tags = append(span.Tags, model.Bool(tagError, true))
+			if value != "" && value != "true" {
+				span.Tags = append(span.Tags, model.String("error.message", value))
+			}
+			continue
+		}
+		span.Tags = append(span.Tags, model.String(key, value))
+	}
+
+	for _, annotation := range s.Annotations {
+		span.Logs = append(span.Logs, model.Log{
+			Timestamp: time.UnixMicro(annotation.Timestamp).UTC(),
+			Fields:    []model.KeyValue{model.String(fieldEvent, annotation.Value)},
+		})
+	}
+
+	return span, nil
+}